@@ -0,0 +1,228 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/swesdek/gotorrent-client/session"
+)
+
+// Адрес API по умолчанию: только локальные соединения
+const DefaultAddr = "127.0.0.1:9080"
+
+// Префикс адреса unix-сокета
+const unixPrefix = "unix:"
+
+// Наибольший размер тела запроса
+const maxBodySize = 16 << 20
+
+// HTTP API управления сессией
+type Server struct {
+	session *session.Session
+	token   string // Токен доступа (пустой - без авторизации)
+	mux     *http.ServeMux
+}
+
+// Создание API для сессии s. Если token не пустой, запросы должны
+// содержать заголовок "Authorization: Bearer <token>", иначе принимаются
+// только запросы с заголовком Host localhost или loopback-адреса. Кроме собственного
+// API по пути /transmission/rpc доступен совместимый с Transmission RPC
+func New(s *session.Session, token string) *Server {
+	srv := &Server{session: s, token: token, mux: http.NewServeMux()}
+
+	srv.mux.HandleFunc("GET /api/session", srv.getSession)
+	srv.mux.HandleFunc("PATCH /api/session", srv.patchSession)
+	srv.mux.HandleFunc("GET /api/torrents", srv.listTorrents)
+	srv.mux.HandleFunc("POST /api/torrents", srv.addTorrent)
+	srv.mux.HandleFunc("GET /api/torrents/{hash}", srv.getTorrent)
+	srv.mux.HandleFunc("PATCH /api/torrents/{hash}", srv.patchTorrent)
+	srv.mux.HandleFunc("DELETE /api/torrents/{hash}", srv.removeTorrent)
+	srv.mux.HandleFunc("POST /api/torrents/{hash}/pause", srv.action(s.Pause))
+	srv.mux.HandleFunc("POST /api/torrents/{hash}/resume", srv.action(s.Resume))
+	srv.mux.HandleFunc("POST /api/torrents/{hash}/recheck", srv.action(s.Recheck))
+	srv.mux.HandleFunc("GET /api/torrents/{hash}/peers", srv.getPeers)
+	srv.mux.HandleFunc("GET /api/torrents/{hash}/pieces", srv.getPieces)
+	srv.mux.Handle("/transmission/rpc", newTransmission(s))
+	return srv
+}
+
+func (srv *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	err := checkCrossSite(r)
+	if err == nil && srv.token == "" {
+		err = checkHost(r)
+	}
+	if err != nil {
+		writeError(w, http.StatusForbidden, err)
+		return
+	}
+	if !srv.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="gotorrent-client"`)
+		writeError(w, http.StatusUnauthorized, errors.New("Invalid or missing API token"))
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+	srv.mux.ServeHTTP(w, r)
+}
+
+// Проверка токена: "Bearer <token>" или Basic-авторизация с токеном
+// в качестве пароля (ее используют клиенты Transmission RPC)
+func (srv *Server) authorized(r *http.Request) bool {
+	if srv.token == "" {
+		return true
+	}
+	if _, password, ok := r.BasicAuth(); ok {
+		return subtle.ConstantTimeCompare([]byte(password), []byte(srv.token)) == 1
+	}
+	auth := r.Header.Get("Authorization")
+	return subtle.ConstantTimeCompare([]byte(auth), []byte("Bearer "+srv.token)) == 1
+}
+
+// Защита от подделки запросов со страниц других сайтов (CSRF). Без
+// предварительного CORS-запроса браузер отправляет на чужой адрес только
+// простые запросы: без собственных заголовков и с телом text/plain или
+// формы. Поэтому изменяющие запросы собственного API должны иметь
+// Content-Type: application/json (или application/x-bittorrent при
+// добавлении торрента), а запросы с заголовком Origin другого сайта
+// отклоняются. Transmission RPC защищен заголовком X-Transmission-Session-Id
+func checkCrossSite(r *http.Request) error {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+		return nil
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		if err != nil || u.Host != r.Host {
+			return fmt.Errorf("Cross-origin request from %s refused", origin)
+		}
+	}
+	if strings.HasPrefix(r.URL.Path, "/api/") {
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if mediaType != "application/json" && mediaType != "application/x-bittorrent" {
+			return errors.New("Requests that change state must have Content-Type: application/json")
+		}
+	}
+	return nil
+}
+
+// Защита API без токена от перепривязки DNS (DNS rebinding, CVE-2018-5702):
+// страница сайта, имя которого стало указывать на 127.0.0.1, обращается
+// к API как к своему сайту, но с чужим заголовком Host. Поэтому без токена
+// принимаются только запросы к localhost и loopback-адресам, а также любые
+// запросы через unix-сокет, недоступный браузеру
+func checkHost(r *http.Request) error {
+	if _, ok := r.Context().Value(http.LocalAddrContextKey).(*net.UnixAddr); ok {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host // Адрес без порта
+	}
+	if isLoopback(strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")) {
+		return nil
+	}
+	return fmt.Errorf("Request for host %q refused: API without a token only answers to localhost", r.Host)
+}
+
+// Открытие адреса API. Адрес вида "unix:/path" - unix-сокет;
+// без токена API можно открыть только на loopback-адресе
+func Listen(addr, token string) (net.Listener, error) {
+	if path, ok := strings.CutPrefix(addr, unixPrefix); ok {
+		err := os.Remove(path) // Сокет, оставшийся от прошлого запуска
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		return net.Listen("unix", path)
+	}
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if token == "" && !isLoopback(host) {
+		return nil, fmt.Errorf("Refusing to serve API on non-loopback address %s without a token", addr)
+	}
+	return net.Listen("tcp", addr)
+}
+
+// Имя localhost или loopback IP-адрес
+func isLoopback(host string) bool {
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Ответ с телом в формате JSON
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// Ответ с описанием ошибки
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// Код ответа для ошибки сессии
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, session.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, session.ErrExists):
+		return http.StatusConflict
+	case errors.Is(err, session.ErrNoMetadata):
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusInternalServerError
+}
+
+// Разбор хеша торрента из пути запроса
+func parseHash(r *http.Request) ([20]byte, error) {
+	var hash [20]byte
+	s := r.PathValue("hash")
+	if len(s) != hex.EncodedLen(len(hash)) {
+		return hash, fmt.Errorf("Malformed info hash %q", s)
+	}
+	_, err := hex.Decode(hash[:], []byte(s))
+	if err != nil {
+		return hash, fmt.Errorf("Malformed info hash %q", s)
+	}
+	return hash, nil
+}
+
+// Торрент из пути запроса. При ошибке ответ уже записан
+func (srv *Server) lookup(w http.ResponseWriter, r *http.Request) (*session.Torrent, bool) {
+	hash, err := parseHash(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return nil, false
+	}
+	t, ok := srv.session.Get(hash)
+	if !ok {
+		writeError(w, http.StatusNotFound, session.ErrNotFound)
+		return nil, false
+	}
+	return t, true
+}
+
+// Чтение тела запроса в формате JSON. При ошибке ответ уже записан
+func readJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	err := dec.Decode(v)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("Malformed request body: %v", err))
+		return false
+	}
+	return true
+}
@@ -0,0 +1,279 @@
+package api
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/swesdek/gotorrent-client/session"
+	"github.com/swesdek/gotorrent-client/torrentfile"
+)
+
+// Сессия во временной директории и HTTP сервер с ее API
+func newTestServer(t *testing.T, token string) (*httptest.Server, *session.Session) {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir) // Список блокировки берется из директории пользователя
+	t.Setenv("HOME", dir)
+	s, err := session.New(session.Config{
+		DataDir:     filepath.Join(dir, "state"),
+		DownloadDir: filepath.Join(dir, "downloads"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(New(s, token))
+	t.Cleanup(func() {
+		ts.Close()
+		s.Close()
+	})
+	return ts, s
+}
+
+// Содержимое .torrent файла для небольшого файла. Трекер недоступен,
+// поэтому торрент не скачивается
+func testTorrent(t *testing.T, name string) ([]byte, torrentfile.TorrentFile) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	err := os.WriteFile(path, bytes.Repeat([]byte(name), 10000), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	tf, err := torrentfile.Create(torrentfile.CreateOptions{Path: path, Announce: "http://127.0.0.1:1/announce"}, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes(), tf
+}
+
+// Запрос к API с телом body (nil - без тела) в формате JSON
+func do(t *testing.T, ts *httptest.Server, method, path string, body any) *http.Response {
+	t.Helper()
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, ts.URL+path, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { res.Body.Close() })
+	return res
+}
+
+// Проверка кода ответа и разбор тела в v (nil - тело не разбирается)
+func expect(t *testing.T, res *http.Response, status int, v any) {
+	t.Helper()
+	if res.StatusCode != status {
+		body, _ := io.ReadAll(res.Body)
+		t.Fatalf("%s %s: status %d, want %d: %s", res.Request.Method, res.Request.URL.Path, res.StatusCode, status, body)
+	}
+	if v != nil {
+		err := json.NewDecoder(res.Body).Decode(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestTorrentLifecycle(t *testing.T) {
+	ts, _ := newTestServer(t, "")
+	data, tf := testTorrent(t, "file.bin")
+	hash := "/api/torrents/" + hex.EncodeToString(tf.InfoHash[:])
+
+	var added torrentJSON
+	expect(t, do(t, ts, "POST", "/api/torrents", addJSON{Torrent: data}), http.StatusCreated, &added)
+	if added.Name != "file.bin" || added.Size != tf.Length || added.Pieces != tf.NumPieces() {
+		t.Errorf("added torrent %+v does not match metadata", added)
+	}
+	expect(t, do(t, ts, "POST", "/api/torrents", addJSON{Torrent: data}), http.StatusConflict, nil)
+
+	var list []torrentJSON
+	expect(t, do(t, ts, "GET", "/api/torrents", nil), http.StatusOK, &list)
+	if len(list) != 1 || list[0].InfoHash != hex.EncodeToString(tf.InfoHash[:]) {
+		t.Fatalf("list = %+v", list)
+	}
+
+	var patched torrentJSON
+	limit := int64(4096)
+	expect(t, do(t, ts, "PATCH", hash, limitsJSON{DownloadLimit: &limit}), http.StatusOK, &patched)
+	if patched.DownloadLimit != limit {
+		t.Errorf("download_limit = %d, want %d", patched.DownloadLimit, limit)
+	}
+
+	expect(t, do(t, ts, "POST", hash+"/pause", nil), http.StatusNoContent, nil)
+	var got torrentJSON
+	expect(t, do(t, ts, "GET", hash, nil), http.StatusOK, &got)
+	if got.Status != "paused" {
+		t.Errorf("status after pause = %q", got.Status)
+	}
+
+	var peers []peerJSON
+	expect(t, do(t, ts, "GET", hash+"/peers", nil), http.StatusOK, &peers)
+	var pieces piecesJSON
+	expect(t, do(t, ts, "GET", hash+"/pieces", nil), http.StatusOK, &pieces)
+	if pieces.Pieces != tf.NumPieces() {
+		t.Errorf("pieces = %d, want %d", pieces.Pieces, tf.NumPieces())
+	}
+
+	expect(t, do(t, ts, "DELETE", hash+"?delete_data=true", nil), http.StatusNoContent, nil)
+	expect(t, do(t, ts, "GET", hash, nil), http.StatusNotFound, nil)
+	expect(t, do(t, ts, "DELETE", hash, nil), http.StatusNotFound, nil)
+}
+
+func TestBadRequests(t *testing.T) {
+	ts, _ := newTestServer(t, "")
+	expect(t, do(t, ts, "GET", "/api/torrents/xyz", nil), http.StatusBadRequest, nil)
+	expect(t, do(t, ts, "GET", "/api/torrents/"+strings.Repeat("0", 40), nil), http.StatusNotFound, nil)
+	expect(t, do(t, ts, "POST", "/api/torrents", addJSON{}), http.StatusBadRequest, nil)
+	expect(t, do(t, ts, "POST", "/api/torrents", addJSON{Torrent: []byte("not bencode")}), http.StatusBadRequest, nil)
+	expect(t, do(t, ts, "POST", "/api/torrents", map[string]string{"unknown": "x"}), http.StatusBadRequest, nil)
+	expect(t, do(t, ts, "DELETE", "/api/torrents/"+strings.Repeat("0", 40)+"?delete_data=maybe", nil), http.StatusBadRequest, nil)
+}
+
+func TestSessionLimits(t *testing.T) {
+	ts, s := newTestServer(t, "")
+	down, up := int64(1000), int64(2000)
+	var res sessionJSON
+	expect(t, do(t, ts, "PATCH", "/api/session", limitsJSON{DownloadLimit: &down, UploadLimit: &up}), http.StatusOK, &res)
+	if res.DownloadLimit != down || res.UploadLimit != up {
+		t.Errorf("limits = %d/%d, want %d/%d", res.DownloadLimit, res.UploadLimit, down, up)
+	}
+	if stats := s.Stats(); stats.DownloadLimit != down || stats.UploadLimit != up {
+		t.Errorf("session limits = %d/%d", stats.DownloadLimit, stats.UploadLimit)
+	}
+}
+
+func TestToken(t *testing.T) {
+	ts, _ := newTestServer(t, "secret")
+	res, err := ts.Client().Get(ts.URL + "/api/session")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("without token: status %d", res.StatusCode)
+	}
+
+	for _, auth := range []string{"Bearer secret", "Basic " + basic("user", "secret")} {
+		req, _ := http.NewRequest("GET", ts.URL+"/api/session", nil)
+		req.Header.Set("Authorization", auth)
+		res, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			t.Errorf("with %q: status %d", auth, res.StatusCode)
+		}
+	}
+}
+
+func basic(user, password string) string {
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.SetBasicAuth(user, password)
+	return strings.TrimPrefix(req.Header.Get("Authorization"), "Basic ")
+}
+
+func TestCrossSiteRequests(t *testing.T) {
+	ts, s := newTestServer(t, "")
+	data, tf := testTorrent(t, "csrf.bin")
+	body, _ := json.Marshal(addJSON{Torrent: data})
+
+	send := func(method, path, contentType, origin string, body []byte) int {
+		t.Helper()
+		req, _ := http.NewRequest(method, ts.URL+path, bytes.NewReader(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		res, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	// Простые запросы, которые браузер отправит с любого сайта
+	for _, contentType := range []string{"text/plain", "application/x-www-form-urlencoded", "multipart/form-data", ""} {
+		if status := send("POST", "/api/torrents", contentType, "", body); status != http.StatusForbidden {
+			t.Errorf("POST with Content-Type %q: status %d", contentType, status)
+		}
+	}
+	if len(s.Torrents()) != 0 {
+		t.Fatal("torrent added by a simple request")
+	}
+	if status := send("POST", "/api/torrents", "application/json", "http://evil.example", body); status != http.StatusForbidden {
+		t.Errorf("POST from foreign origin: status %d", status)
+	}
+
+	origin := "http://" + strings.TrimPrefix(ts.URL, "http://")
+	if status := send("POST", "/api/torrents", "application/json", origin, body); status != http.StatusCreated {
+		t.Fatalf("POST from own origin: status %d", status)
+	}
+	hash := "/api/torrents/" + hex.EncodeToString(tf.InfoHash[:])
+	if status := send("POST", hash+"/pause", "", "", nil); status != http.StatusForbidden {
+		t.Errorf("body-less POST without Content-Type: status %d", status)
+	}
+	if status := send("DELETE", hash+"?delete_data=true", "", "http://evil.example", nil); status != http.StatusForbidden {
+		t.Errorf("DELETE from foreign origin: status %d", status)
+	}
+	if status := send("DELETE", hash, "application/json", "", nil); status != http.StatusNoContent {
+		t.Errorf("DELETE with JSON Content-Type: status %d", status)
+	}
+
+	// Перепривязка DNS: имя сайта указывает на 127.0.0.1, но Host остается чужим
+	get := func(ts *httptest.Server, host, auth string) int {
+		t.Helper()
+		req, _ := http.NewRequest("GET", ts.URL+"/api/session", nil)
+		req.Host = host
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		res, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+	_, port, _ := net.SplitHostPort(strings.TrimPrefix(ts.URL, "http://"))
+	for host, want := range map[string]int{
+		"evil.example:" + port: http.StatusForbidden,
+		"evil.example":         http.StatusForbidden,
+		"127.0.0.1.nip.io":     http.StatusForbidden,
+		"localhost:" + port:    http.StatusOK,
+		"LOCALHOST":            http.StatusOK,
+		"127.0.0.2:" + port:    http.StatusOK,
+		"[::1]:" + port:        http.StatusOK,
+	} {
+		if status := get(ts, host, ""); status != want {
+			t.Errorf("GET with Host %q: status %d, want %d", host, status, want)
+		}
+	}
+	withToken, _ := newTestServer(t, "secret")
+	if status := get(withToken, "evil.example", "Bearer secret"); status != http.StatusOK {
+		t.Errorf("GET with token and foreign Host: status %d", status)
+	}
+}
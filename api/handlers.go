@@ -0,0 +1,355 @@
+package api
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/swesdek/gotorrent-client/session"
+)
+
+// Сводка о сессии
+type sessionJSON struct {
+	Torrents      int       `json:"torrents"`
+	DownloadRate  int64     `json:"download_rate"`
+	UploadRate    int64     `json:"upload_rate"`
+	Downloaded    int64     `json:"downloaded"`
+	Uploaded      int64     `json:"uploaded"`
+	DownloadLimit int64     `json:"download_limit"`
+	UploadLimit   int64     `json:"upload_limit"`
+	Cache         cacheJSON `json:"cache"`
+	DHTNodes      int       `json:"dht_nodes"`
+}
+
+// Статистика кэша частей
+type cacheJSON struct {
+	ReadHits   int64 `json:"read_hits"`
+	ReadMisses int64 `json:"read_misses"`
+	Writes     int64 `json:"writes"`
+	Flushes    int64 `json:"flushes"`
+	Cached     int64 `json:"cached"`
+	Dirty      int64 `json:"dirty"`
+}
+
+// Изменение лимитов скорости. Отсутствующее поле не меняется
+type limitsJSON struct {
+	DownloadLimit *int64 `json:"download_limit"`
+	UploadLimit   *int64 `json:"upload_limit"`
+}
+
+// Сводка о торренте
+type torrentJSON struct {
+	InfoHash      string     `json:"info_hash"`
+	Name          string     `json:"name"`
+	Path          string     `json:"path"`
+	Status        string     `json:"status"`
+	Error         string     `json:"error,omitempty"`
+	Size          int        `json:"size"`
+	PieceLength   int        `json:"piece_length"`
+	Pieces        int        `json:"pieces"`
+	Completed     int        `json:"completed"`
+	Progress      float64    `json:"progress"`
+	Peers         int        `json:"peers"`
+	DownloadRate  int64      `json:"download_rate"`
+	UploadRate    int64      `json:"upload_rate"`
+	Downloaded    int64      `json:"downloaded"`
+	Uploaded      int64      `json:"uploaded"`
+	DownloadLimit int64      `json:"download_limit"`
+	UploadLimit   int64      `json:"upload_limit"`
+	Added         time.Time  `json:"added"`
+	Files         []fileJSON `json:"files,omitempty"` // Только в ответе о конкретном торренте
+}
+
+// Файл торрента
+type fileJSON struct {
+	Index    int    `json:"index"`
+	Path     string `json:"path"`
+	Length   int    `json:"length"`
+	Priority string `json:"priority"`
+}
+
+// Изменение параметров торрента. Отсутствующее поле не меняется
+type torrentPatchJSON struct {
+	limitsJSON
+	Files map[int]string `json:"files"` // Номер файла -> приоритет
+}
+
+// Добавление торрента: заполняется ровно одно из полей
+type addJSON struct {
+	Torrent []byte `json:"torrent"` // Содержимое .torrent файла в base64
+	Magnet  string `json:"magnet"`
+	URL     string `json:"url"`
+}
+
+// Подключенный пир
+type peerJSON struct {
+	Address   string    `json:"address"`
+	Source    string    `json:"source"`
+	Fast      bool      `json:"fast"`
+	Connected time.Time `json:"connected"`
+}
+
+// Поле частей торрента
+type piecesJSON struct {
+	Pieces    int     `json:"pieces"`
+	Completed int     `json:"completed"`
+	Have      *string `json:"have"` // Строка из 0 и 1 по частям (null - данные еще не проверены)
+}
+
+func newTorrentJSON(t *session.Torrent) torrentJSON {
+	stats := t.Stats()
+	return torrentJSON{
+		InfoHash:      hex.EncodeToString(stats.InfoHash[:]),
+		Name:          stats.Name,
+		Path:          stats.Path,
+		Status:        stats.Status.String(),
+		Error:         stats.Error,
+		Size:          stats.Length,
+		PieceLength:   stats.PieceLength,
+		Pieces:        stats.Pieces,
+		Completed:     stats.Completed,
+		Progress:      stats.Progress(),
+		Peers:         stats.Peers,
+		DownloadRate:  stats.DownloadRate,
+		UploadRate:    stats.UploadRate,
+		Downloaded:    stats.Downloaded,
+		Uploaded:      stats.Uploaded,
+		DownloadLimit: stats.DownloadLimit,
+		UploadLimit:   stats.UploadLimit,
+		Added:         stats.Added,
+	}
+}
+
+func (srv *Server) getSession(w http.ResponseWriter, r *http.Request) {
+	stats := srv.session.Stats()
+	writeJSON(w, http.StatusOK, sessionJSON{
+		Torrents:      stats.Torrents,
+		DownloadRate:  stats.DownloadRate,
+		UploadRate:    stats.UploadRate,
+		Downloaded:    stats.Downloaded,
+		Uploaded:      stats.Uploaded,
+		DownloadLimit: stats.DownloadLimit,
+		UploadLimit:   stats.UploadLimit,
+		Cache:         cacheJSON(stats.Cache),
+		DHTNodes:      stats.DHTNodes,
+	})
+}
+
+func (srv *Server) patchSession(w http.ResponseWriter, r *http.Request) {
+	var req limitsJSON
+	if !readJSON(w, r, &req) {
+		return
+	}
+	stats := srv.session.Stats()
+	download, upload := stats.DownloadLimit, stats.UploadLimit
+	if req.DownloadLimit != nil {
+		download = *req.DownloadLimit
+	}
+	if req.UploadLimit != nil {
+		upload = *req.UploadLimit
+	}
+	srv.session.SetRateLimits(download, upload)
+	srv.getSession(w, r)
+}
+
+func (srv *Server) listTorrents(w http.ResponseWriter, r *http.Request) {
+	list := []torrentJSON{}
+	for _, t := range srv.session.Torrents() {
+		list = append(list, newTorrentJSON(t))
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// Добавление торрента: тело application/x-bittorrent или JSON addJSON
+func (srv *Server) addTorrent(w http.ResponseWriter, r *http.Request) {
+	var t *session.Torrent
+	var err error
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-bittorrent") {
+		data, readErr := io.ReadAll(r.Body)
+		if readErr != nil {
+			writeError(w, http.StatusBadRequest, readErr)
+			return
+		}
+		t, err = srv.session.Add(data)
+	} else {
+		var req addJSON
+		if !readJSON(w, r, &req) {
+			return
+		}
+		switch {
+		case req.Torrent != nil && req.Magnet == "" && req.URL == "":
+			t, err = srv.session.Add(req.Torrent)
+		case req.Torrent == nil && req.Magnet != "" && req.URL == "":
+			t, err = srv.session.AddMagnet(r.Context(), req.Magnet)
+		case req.Torrent == nil && req.Magnet == "" && req.URL != "":
+			t, err = srv.session.AddURL(r.Context(), req.URL)
+		default:
+			writeError(w, http.StatusBadRequest, errors.New("Exactly one of torrent, magnet and url must be set"))
+			return
+		}
+	}
+
+	if err != nil {
+		status := errorStatus(err)
+		if status == http.StatusInternalServerError { // Ошибки разбора и скачивания метаданных
+			status = http.StatusBadRequest
+		}
+		writeError(w, status, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, newTorrentJSON(t))
+}
+
+func (srv *Server) getTorrent(w http.ResponseWriter, r *http.Request) {
+	t, ok := srv.lookup(w, r)
+	if !ok {
+		return
+	}
+	res := newTorrentJSON(t)
+	priorities := t.FilePriorities()
+	for i, f := range t.Meta().Files {
+		if f.Padding {
+			continue
+		}
+		res.Files = append(res.Files, fileJSON{
+			Index:    i,
+			Path:     strings.Join(f.Path, "/"),
+			Length:   f.Length,
+			Priority: priorities[i].String(),
+		})
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (srv *Server) patchTorrent(w http.ResponseWriter, r *http.Request) {
+	t, ok := srv.lookup(w, r)
+	if !ok {
+		return
+	}
+	var req torrentPatchJSON
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	priorities := make(map[int]session.Priority)
+	for i, name := range req.Files {
+		p, err := session.ParsePriority(name)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		priorities[i] = p
+	}
+	if len(priorities) > 0 {
+		err := srv.session.SetFilePriorities(t.InfoHash(), priorities)
+		if err != nil {
+			status := errorStatus(err)
+			if status == http.StatusInternalServerError { // Неверный номер файла
+				status = http.StatusBadRequest
+			}
+			writeError(w, status, err)
+			return
+		}
+	}
+
+	if req.DownloadLimit != nil || req.UploadLimit != nil {
+		stats := t.Stats()
+		download, upload := stats.DownloadLimit, stats.UploadLimit
+		if req.DownloadLimit != nil {
+			download = *req.DownloadLimit
+		}
+		if req.UploadLimit != nil {
+			upload = *req.UploadLimit
+		}
+		err := srv.session.SetTorrentRateLimits(t.InfoHash(), download, upload)
+		if err != nil {
+			writeError(w, errorStatus(err), err)
+			return
+		}
+	}
+	srv.getTorrent(w, r)
+}
+
+// Удаление торрента; с параметром delete_data=true удаляются и данные
+func (srv *Server) removeTorrent(w http.ResponseWriter, r *http.Request) {
+	hash, err := parseHash(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	deleteData := false
+	if value := r.URL.Query().Get("delete_data"); value != "" {
+		deleteData, err = strconv.ParseBool(value)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("Malformed delete_data %q", value))
+			return
+		}
+	}
+	err = srv.session.Remove(hash, deleteData)
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Обработчик действия сессии над торрентом из пути запроса
+func (srv *Server) action(fn func(hash [20]byte) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		hash, err := parseHash(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		err = fn(hash)
+		if err != nil {
+			writeError(w, errorStatus(err), err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (srv *Server) getPeers(w http.ResponseWriter, r *http.Request) {
+	t, ok := srv.lookup(w, r)
+	if !ok {
+		return
+	}
+	list := []peerJSON{}
+	for _, peer := range t.Peers() {
+		list = append(list, peerJSON{
+			Address:   peer.Address,
+			Source:    peer.Source.String(),
+			Fast:      peer.Fast,
+			Connected: peer.Connected,
+		})
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (srv *Server) getPieces(w http.ResponseWriter, r *http.Request) {
+	t, ok := srv.lookup(w, r)
+	if !ok {
+		return
+	}
+	res := piecesJSON{Pieces: t.Meta().NumPieces()}
+	if have := t.Have(); have != nil {
+		var b strings.Builder
+		for index := 0; index < res.Pieces; index++ {
+			if have.HasPiece(index) {
+				res.Completed++
+				b.WriteByte('1')
+			} else {
+				b.WriteByte('0')
+			}
+		}
+		s := b.String()
+		res.Have = &s
+	}
+	writeJSON(w, http.StatusOK, res)
+}
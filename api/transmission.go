@@ -0,0 +1,558 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/swesdek/gotorrent-client/mse"
+	"github.com/swesdek/gotorrent-client/session"
+)
+
+// Заголовок с идентификатором сессии Transmission, защищающий от CSRF
+const sessionIDHeader = "X-Transmission-Session-Id"
+
+// Версия протокола Transmission RPC, на которую рассчитан слой совместимости
+const rpcVersion = 17
+
+// Килобайт в лимитах скорости Transmission
+const transmissionKB = 1000
+
+// Коды состояния торрента в Transmission RPC
+const (
+	trStopped  = 0
+	trCheck    = 2
+	trDownload = 4
+)
+
+// Код поля error торрента: ошибка на стороне клиента
+const trLocalError = 3
+
+// Запрос Transmission RPC
+type rpcRequest struct {
+	Method    string          `json:"method"`
+	Arguments json.RawMessage `json:"arguments"`
+	Tag       *int            `json:"tag,omitempty"`
+}
+
+// Ответ Transmission RPC
+type rpcResponse struct {
+	Result    string `json:"result"` // "success" или описание ошибки
+	Arguments any    `json:"arguments"`
+	Tag       *int   `json:"tag,omitempty"`
+}
+
+// Слой совместимости с Transmission RPC поверх сессии
+type transmission struct {
+	session   *session.Session
+	sessionID string
+
+	mu        sync.Mutex
+	ids       map[[20]byte]int // Числовые идентификаторы торрентов, как в Transmission
+	hashes    map[int][20]byte
+	nextID    int
+	downLimit int64 // Лимиты в КБ/с, которые сохраняются и при выключенном ограничении
+	upLimit   int64
+}
+
+func newTransmission(s *session.Session) *transmission {
+	var id [24]byte
+	rand.Read(id[:])
+	stats := s.Stats()
+	return &transmission{
+		session:   s,
+		sessionID: base64.RawURLEncoding.EncodeToString(id[:]),
+		ids:       make(map[[20]byte]int),
+		hashes:    make(map[int][20]byte),
+		nextID:    1,
+		downLimit: stats.DownloadLimit / transmissionKB,
+		upLimit:   stats.UploadLimit / transmissionKB,
+	}
+}
+
+func (tr *transmission) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get(sessionIDHeader) != tr.sessionID { // Клиент должен повторить запрос с полученным идентификатором
+		w.Header().Set(sessionIDHeader, tr.sessionID)
+		http.Error(w, "Missing or outdated "+sessionIDHeader, http.StatusConflict)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req rpcRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Malformed request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	if len(req.Arguments) == 0 {
+		req.Arguments = json.RawMessage("{}")
+	}
+
+	methods := map[string]func(*http.Request, json.RawMessage) (any, error){
+		"torrent-add":       tr.torrentAdd,
+		"torrent-get":       tr.torrentGet,
+		"torrent-start":     tr.torrentAction(tr.session.Resume),
+		"torrent-start-now": tr.torrentAction(tr.session.Resume),
+		"torrent-stop":      tr.torrentAction(tr.session.Pause),
+		"torrent-verify":    tr.torrentAction(tr.session.Recheck),
+		"torrent-remove":    tr.torrentRemove,
+		"session-get":       tr.sessionGet,
+		"session-set":       tr.sessionSet,
+		"session-stats":     tr.sessionStats,
+	}
+	res := rpcResponse{Result: "success", Arguments: struct{}{}, Tag: req.Tag}
+	method, ok := methods[req.Method]
+	if !ok {
+		res.Result = "method name not recognized"
+	} else {
+		args, err := method(r, req.Arguments)
+		if err != nil {
+			res.Result = err.Error()
+		} else if args != nil {
+			res.Arguments = args
+		}
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// Числовой идентификатор торрента, выдаваемый при первом обращении
+func (tr *transmission) id(hash [20]byte) int {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	if id, ok := tr.ids[hash]; ok {
+		return id
+	}
+	id := tr.nextID
+	tr.nextID++
+	tr.ids[hash] = id
+	tr.hashes[id] = hash
+	return id
+}
+
+// Торренты, выбранные аргументом ids: число, хеш, список из них или
+// "recently-active". Отсутствующий аргумент выбирает все торренты
+func (tr *transmission) selectTorrents(raw json.RawMessage) ([]*session.Torrent, error) {
+	all := tr.session.Torrents()
+	for _, t := range all {
+		tr.id(t.InfoHash()) // Идентификаторы выдаются в порядке добавления
+	}
+	if len(raw) == 0 {
+		return all, nil
+	}
+
+	var single any
+	err := json.Unmarshal(raw, &single)
+	if err != nil {
+		return nil, err
+	}
+	if single == "recently-active" {
+		return all, nil
+	}
+	list, ok := single.([]any)
+	if !ok {
+		list = []any{single}
+	}
+
+	var selected []*session.Torrent
+	for _, v := range list {
+		var hash [20]byte
+		switch v := v.(type) {
+		case float64:
+			tr.mu.Lock()
+			h, ok := tr.hashes[int(v)]
+			tr.mu.Unlock()
+			if !ok {
+				continue
+			}
+			hash = h
+		case string:
+			_, err := hex.Decode(hash[:], []byte(v))
+			if err != nil || len(v) != hex.EncodedLen(len(hash)) {
+				return nil, fmt.Errorf("invalid torrent id %q", v)
+			}
+		default:
+			return nil, fmt.Errorf("invalid torrent id %v", v)
+		}
+		if t, ok := tr.session.Get(hash); ok {
+			selected = append(selected, t)
+		}
+	}
+	return selected, nil
+}
+
+// Аргументы torrent-add
+type trAddArgs struct {
+	Filename    string `json:"filename"` // Ссылка на .torrent файл или magnet-ссылка
+	Metainfo    string `json:"metainfo"` // Содержимое .torrent файла в base64
+	Paused      bool   `json:"paused"`
+	DownloadDir string `json:"download-dir"`
+}
+
+func (tr *transmission) torrentAdd(r *http.Request, raw json.RawMessage) (any, error) {
+	var args trAddArgs
+	err := json.Unmarshal(raw, &args)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := session.AddOptions{Paused: args.Paused}
+	if args.DownloadDir != "" && !sameDir(args.DownloadDir, tr.session.Config().DownloadDir) {
+		if !filepath.IsAbs(args.DownloadDir) { // Относительный путь зависел бы от рабочей директории демона
+			return nil, fmt.Errorf("download-dir %q is not an absolute path", args.DownloadDir)
+		}
+		opts.DownloadDir = args.DownloadDir
+	}
+
+	var t *session.Torrent
+	switch {
+	case args.Metainfo != "":
+		data, decodeErr := base64.StdEncoding.DecodeString(args.Metainfo)
+		if decodeErr != nil {
+			return nil, errors.New("invalid or corrupt torrent file")
+		}
+		t, err = tr.session.AddWithOptions(data, opts)
+	case strings.HasPrefix(args.Filename, "magnet:"):
+		t, err = tr.session.AddMagnetWithOptions(r.Context(), args.Filename, opts)
+	case args.Filename != "":
+		t, err = tr.session.AddURLWithOptions(r.Context(), args.Filename, opts)
+	default:
+		return nil, errors.New("no filename or metainfo specified")
+	}
+
+	key := "torrent-added"
+	if errors.Is(err, session.ErrExists) {
+		key = "torrent-duplicate"
+	} else if err != nil {
+		return nil, err
+	}
+	hash := t.InfoHash()
+	return map[string]any{key: map[string]any{
+		"id":         tr.id(hash),
+		"name":       t.Meta().Name,
+		"hashString": hex.EncodeToString(hash[:]),
+	}}, nil
+}
+
+// Аргументы torrent-get
+type trGetArgs struct {
+	IDs    json.RawMessage `json:"ids"`
+	Fields []string        `json:"fields"`
+}
+
+func (tr *transmission) torrentGet(r *http.Request, raw json.RawMessage) (any, error) {
+	var args trGetArgs
+	err := json.Unmarshal(raw, &args)
+	if err != nil {
+		return nil, err
+	}
+	list, err := tr.selectTorrents(args.IDs)
+	if err != nil {
+		return nil, err
+	}
+
+	torrents := []map[string]any{}
+	for _, t := range list {
+		fields := tr.torrentFields(t)
+		selected := make(map[string]any)
+		for _, name := range args.Fields {
+			if v, ok := fields[name]; ok { // Неподдерживаемые поля пропускаются
+				selected[name] = v
+			}
+		}
+		torrents = append(torrents, selected)
+	}
+	return map[string]any{"torrents": torrents}, nil
+}
+
+// Поля torrent-get для торрента
+func (tr *transmission) torrentFields(t *session.Torrent) map[string]any {
+	stats := t.Stats()
+	meta := t.Meta()
+	have := t.Have()
+	priorities := t.FilePriorities()
+
+	status := trStopped
+	switch stats.Status {
+	case session.StatusChecking:
+		status = trCheck
+	case session.StatusDownloading:
+		status = trDownload
+	}
+	trError, errorString := 0, ""
+	if stats.Status == session.StatusError {
+		trError, errorString = trLocalError, stats.Error
+	}
+
+	// Размеры считаются по частям: часть нужна, если нужен хотя бы один ее файл
+	var sizeWhenDone, completed int64
+	for index := 0; index < stats.Pieces; index++ {
+		wanted := len(meta.Files) == 0
+		for _, i := range meta.PieceFiles(index) {
+			wanted = wanted || priorities[i] != session.PrioritySkip
+		}
+		if !wanted {
+			continue
+		}
+		_, length := meta.PieceSpan(index)
+		sizeWhenDone += int64(length)
+		if have.HasPiece(index) {
+			completed += int64(length)
+		}
+	}
+	sizeWhenDone = min(sizeWhenDone, int64(stats.Length)) // Файлы выравнивания не учитываются
+	completed = min(completed, sizeWhenDone)
+	percentDone := 1.0
+	if sizeWhenDone > 0 {
+		percentDone = float64(completed) / float64(sizeWhenDone)
+	}
+	eta := int64(-1)
+	if stats.DownloadRate > 0 {
+		eta = (sizeWhenDone - completed) / stats.DownloadRate
+	}
+
+	var files, fileStats []map[string]any
+	for i, f := range meta.Files {
+		if f.Padding {
+			continue
+		}
+		var done int64
+		for index := f.Offset / meta.PieceLength; index < stats.Pieces; index++ {
+			begin, length := meta.PieceSpan(index)
+			if begin >= f.Offset+f.Length {
+				break
+			}
+			if have.HasPiece(index) {
+				done += int64(min(begin+length, f.Offset+f.Length) - max(begin, f.Offset))
+			}
+		}
+		files = append(files, map[string]any{
+			"name":           strings.Join(append([]string{meta.Name}, f.Path...), "/"),
+			"length":         f.Length,
+			"bytesCompleted": done,
+		})
+		priority := int(priorities[i])
+		if priorities[i] == session.PrioritySkip { // В Transmission ненужный файл - отдельный флаг wanted
+			priority = int(session.PriorityNormal)
+		}
+		fileStats = append(fileStats, map[string]any{
+			"bytesCompleted": done,
+			"wanted":         priorities[i] != session.PrioritySkip,
+			"priority":       priority,
+		})
+	}
+	if len(meta.Files) == 0 {
+		files = []map[string]any{{"name": meta.Name, "length": meta.Length, "bytesCompleted": completed}}
+		fileStats = []map[string]any{{"bytesCompleted": completed, "wanted": true, "priority": 0}}
+	}
+
+	return map[string]any{
+		"id":             tr.id(stats.InfoHash),
+		"hashString":     hex.EncodeToString(stats.InfoHash[:]),
+		"name":           stats.Name,
+		"status":         status,
+		"error":          trError,
+		"errorString":    errorString,
+		"downloadDir":    filepath.Dir(stats.Path),
+		"totalSize":      stats.Length,
+		"sizeWhenDone":   sizeWhenDone,
+		"leftUntilDone":  sizeWhenDone - completed,
+		"haveValid":      completed,
+		"percentDone":    percentDone,
+		"isFinished":     stats.Status == session.StatusCompleted,
+		"rateDownload":   stats.DownloadRate,
+		"rateUpload":     stats.UploadRate,
+		"downloadedEver": stats.Downloaded,
+		"uploadedEver":   stats.Uploaded,
+		"uploadRatio":    -1, // Раздача не поддерживается
+		"eta":            eta,
+		"peersConnected": stats.Peers,
+		"addedDate":      stats.Added.Unix(),
+		"pieceCount":     stats.Pieces,
+		"pieceSize":      stats.PieceLength,
+		"isPrivate":      meta.Private,
+		"magnetLink":     meta.Magnet(),
+		"comment":        meta.Comment,
+		"creator":        meta.CreatedBy,
+		"downloadLimit":  stats.DownloadLimit / transmissionKB,
+		"uploadLimit":    stats.UploadLimit / transmissionKB,
+		"files":          files,
+		"fileStats":      fileStats,
+		"labels":         []string{},
+	}
+}
+
+// Аргументы методов над набором торрентов
+type trIDArgs struct {
+	IDs             json.RawMessage `json:"ids"`
+	DeleteLocalData bool            `json:"delete-local-data"`
+}
+
+// Метод, применяющий действие сессии к выбранным торрентам
+func (tr *transmission) torrentAction(fn func(hash [20]byte) error) func(*http.Request, json.RawMessage) (any, error) {
+	return func(r *http.Request, raw json.RawMessage) (any, error) {
+		var args trIDArgs
+		err := json.Unmarshal(raw, &args)
+		if err != nil {
+			return nil, err
+		}
+		list, err := tr.selectTorrents(args.IDs)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range list {
+			err = fn(t.InfoHash())
+			if err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	}
+}
+
+func (tr *transmission) torrentRemove(r *http.Request, raw json.RawMessage) (any, error) {
+	var args trIDArgs
+	err := json.Unmarshal(raw, &args)
+	if err != nil {
+		return nil, err
+	}
+	list, err := tr.selectTorrents(args.IDs)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range list {
+		err = tr.session.Remove(t.InfoHash(), args.DeleteLocalData)
+		if errors.Is(err, session.ErrNotFound) {
+			return nil, errors.New("torrent not found")
+		}
+		if err != nil {
+			return nil, err
+		}
+		tr.mu.Lock()
+		delete(tr.hashes, tr.ids[t.InfoHash()])
+		delete(tr.ids, t.InfoHash())
+		tr.mu.Unlock()
+	}
+	return nil, nil
+}
+
+// Совпадают ли пути к директориям. Клиенты Transmission передают в
+// download-dir директорию по умолчанию из session-get, ее торрент получает
+// как обычно (с учетом директории незавершенных скачиваний)
+func sameDir(a, b string) bool {
+	a, errA := filepath.Abs(a)
+	b, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && a == b
+}
+
+func (tr *transmission) sessionGet(r *http.Request, raw json.RawMessage) (any, error) {
+	cfg := tr.session.Config()
+	stats := tr.session.Stats()
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	downloadDir, err := filepath.Abs(cfg.DownloadDir)
+	if err != nil {
+		downloadDir = cfg.DownloadDir
+	}
+	encryption := cfg.Client.Encryption.String()
+	if cfg.Client.Encryption == mse.PolicyDisabled { // В Transmission шифрование нельзя выключить полностью
+		encryption = "tolerated"
+	}
+	return map[string]any{
+		"rpc-version":              rpcVersion,
+		"rpc-version-minimum":      14,
+		"version":                  "4.0.0 (gotorrent-client)", // Клиенты проверяют версию Transmission
+		"session-id":               tr.sessionID,
+		"download-dir":             downloadDir,
+		"peer-port":                cfg.Port,
+		"peer-limit-global":        cfg.MaxConnections,
+		"encryption":               encryption,
+		"speed-limit-down":         tr.downLimit,
+		"speed-limit-down-enabled": stats.DownloadLimit > 0,
+		"speed-limit-up":           tr.upLimit,
+		"speed-limit-up-enabled":   stats.UploadLimit > 0,
+		"alt-speed-enabled":        false,
+		"seedRatioLimit":           0,
+		"seedRatioLimited":         false,
+		"units": map[string]any{
+			"speed-units":  []string{"kB/s", "MB/s", "GB/s", "TB/s"},
+			"speed-bytes":  transmissionKB,
+			"size-units":   []string{"kB", "MB", "GB", "TB"},
+			"size-bytes":   transmissionKB,
+			"memory-units": []string{"KiB", "MiB", "GiB", "TiB"},
+			"memory-bytes": 1024,
+		},
+	}, nil
+}
+
+// Аргументы session-set. Отсутствующее поле не меняется
+type trSessionArgs struct {
+	SpeedLimitDown        *int64 `json:"speed-limit-down"`
+	SpeedLimitDownEnabled *bool  `json:"speed-limit-down-enabled"`
+	SpeedLimitUp          *int64 `json:"speed-limit-up"`
+	SpeedLimitUpEnabled   *bool  `json:"speed-limit-up-enabled"`
+}
+
+func (tr *transmission) sessionSet(r *http.Request, raw json.RawMessage) (any, error) {
+	var args trSessionArgs
+	err := json.Unmarshal(raw, &args)
+	if err != nil {
+		return nil, err
+	}
+	stats := tr.session.Stats()
+	downEnabled, upEnabled := stats.DownloadLimit > 0, stats.UploadLimit > 0
+
+	tr.mu.Lock()
+	if args.SpeedLimitDown != nil {
+		tr.downLimit = *args.SpeedLimitDown
+	}
+	if args.SpeedLimitUp != nil {
+		tr.upLimit = *args.SpeedLimitUp
+	}
+	if args.SpeedLimitDownEnabled != nil {
+		downEnabled = *args.SpeedLimitDownEnabled
+	}
+	if args.SpeedLimitUpEnabled != nil {
+		upEnabled = *args.SpeedLimitUpEnabled
+	}
+	var download, upload int64
+	if downEnabled {
+		download = tr.downLimit * transmissionKB
+	}
+	if upEnabled {
+		upload = tr.upLimit * transmissionKB
+	}
+	tr.mu.Unlock()
+
+	tr.session.SetRateLimits(download, upload)
+	return nil, nil
+}
+
+func (tr *transmission) sessionStats(r *http.Request, raw json.RawMessage) (any, error) {
+	stats := tr.session.Stats()
+	active, paused := 0, 0
+	for _, t := range tr.session.Torrents() {
+		switch t.Stats().Status {
+		case session.StatusChecking, session.StatusDownloading:
+			active++
+		default:
+			paused++
+		}
+	}
+	return map[string]any{
+		"torrentCount":       stats.Torrents,
+		"activeTorrentCount": active,
+		"pausedTorrentCount": paused,
+		"downloadSpeed":      stats.DownloadRate,
+		"uploadSpeed":        stats.UploadRate,
+	}, nil
+}
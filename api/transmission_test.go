@@ -0,0 +1,128 @@
+package api
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/swesdek/gotorrent-client/torrentfile"
+)
+
+// Вызов метода Transmission RPC с получением идентификатора сессии
+func rpc(t *testing.T, ts *httptest.Server, method string, args any) rpcResponse {
+	t.Helper()
+	body, err := json.Marshal(map[string]any{"method": method, "arguments": args})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := ""
+	for attempt := 0; attempt < 2; attempt++ {
+		req, _ := http.NewRequest("POST", ts.URL+"/transmission/rpc", bytes.NewReader(body))
+		req.Header.Set(sessionIDHeader, id)
+		res, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		if res.StatusCode == http.StatusConflict {
+			id = res.Header.Get(sessionIDHeader)
+			continue
+		}
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("%s: status %d", method, res.StatusCode)
+		}
+		var out rpcResponse
+		err = json.NewDecoder(res.Body).Decode(&out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+	t.Fatalf("%s: session id handshake failed", method)
+	return rpcResponse{}
+}
+
+// Торрент, трекер которого считает полученные анонсы
+func trackedTorrent(t *testing.T, name string) ([]byte, *atomic.Int32) {
+	t.Helper()
+	var announces atomic.Int32
+	tracker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		announces.Add(1)
+		w.Write([]byte("d8:intervali1800e5:peers0:e"))
+	}))
+	t.Cleanup(tracker.Close)
+
+	path := filepath.Join(t.TempDir(), name)
+	err := os.WriteFile(path, bytes.Repeat([]byte(name), 10000), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	_, err = torrentfile.Create(torrentfile.CreateOptions{Path: path, Announce: tracker.URL + "/announce"}, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes(), &announces
+}
+
+func TestTransmissionAddPaused(t *testing.T) {
+	ts, s := newTestServer(t, "")
+	paused, pausedAnnounces := trackedTorrent(t, "paused.bin")
+	started, startedAnnounces := trackedTorrent(t, "started.bin")
+
+	res := rpc(t, ts, "torrent-add", map[string]any{"metainfo": base64.StdEncoding.EncodeToString(paused), "paused": true})
+	if res.Result != "success" {
+		t.Fatalf("torrent-add: %s", res.Result)
+	}
+	res = rpc(t, ts, "torrent-add", map[string]any{"metainfo": base64.StdEncoding.EncodeToString(started)})
+	if res.Result != "success" {
+		t.Fatalf("torrent-add: %s", res.Result)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for startedAnnounces.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if startedAnnounces.Load() == 0 {
+		t.Fatal("started torrent never announced")
+	}
+	if n := pausedAnnounces.Load(); n != 0 {
+		t.Errorf("torrent added paused announced %d times", n)
+	}
+	for _, tor := range s.Torrents() {
+		if tor.Meta().Name == "paused.bin" && tor.Stats().Status.String() != "paused" {
+			t.Errorf("status of paused torrent = %s", tor.Stats().Status)
+		}
+	}
+}
+
+func TestTransmissionDownloadDir(t *testing.T) {
+	ts, s := newTestServer(t, "")
+	data, _ := trackedTorrent(t, "placed.bin")
+	metainfo := base64.StdEncoding.EncodeToString(data)
+
+	res := rpc(t, ts, "torrent-add", map[string]any{"metainfo": metainfo, "paused": true, "download-dir": "relative/dir"})
+	if res.Result == "success" {
+		t.Error("relative download-dir accepted")
+	}
+
+	dir := t.TempDir()
+	res = rpc(t, ts, "torrent-add", map[string]any{"metainfo": metainfo, "paused": true, "download-dir": dir})
+	if res.Result != "success" {
+		t.Fatalf("torrent-add: %s", res.Result)
+	}
+	list := s.Torrents()
+	if len(list) != 1 {
+		t.Fatalf("%d torrents in session", len(list))
+	}
+	if want := filepath.Join(dir, "placed.bin"); list[0].Stats().Path != want {
+		t.Errorf("path = %s, want %s", list[0].Stats().Path, want)
+	}
+}
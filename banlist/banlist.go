@@ -0,0 +1,128 @@
+package banlist
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Количество штрафных очков, после которого пир блокируется
+const BanThreshold = 3
+
+// Список штрафов и заблокированных пиров, сохраняемый между запусками
+type BanList struct {
+	mu        sync.Mutex
+	path      string
+	penalties map[string]int // Штрафные очки по IP пира
+}
+
+// Путь к файлу списка по умолчанию
+func DefaultPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "banned_peers.txt"
+	}
+	return filepath.Join(dir, "gotorrent-client", "banned_peers.txt")
+}
+
+// Загрузка списка из файла. Если файла еще нет, создается пустой список
+func Load(path string) (*BanList, error) {
+	b := &BanList{
+		path:      path,
+		penalties: make(map[string]int),
+	}
+
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return b, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	line := 0
+	for scanner.Scan() {
+		line++
+		fields := strings.Fields(scanner.Text()) // Формат строки: "IP штраф"
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 || net.ParseIP(fields[0]) == nil {
+			return nil, fmt.Errorf("Malformed ban list entry at %s:%d", path, line)
+		}
+		penalty, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("Malformed penalty at %s:%d: %v", path, line, err)
+		}
+		b.penalties[fields[0]] = penalty
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// Проверка, заблокирован ли пир
+func (b *BanList) IsBanned(ip net.IP) bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.penalties[ip.String()] >= BanThreshold
+}
+
+// Начисление штрафа пиру. Возвращает true, если пир оказался заблокирован
+func (b *BanList) Penalize(ip net.IP, points int) (bool, error) {
+	if b == nil {
+		return false, nil
+	}
+	b.mu.Lock()
+	key := ip.String()
+	b.penalties[key] += points
+	banned := b.penalties[key] >= BanThreshold
+	b.mu.Unlock()
+
+	return banned, b.Save()
+}
+
+// Сохранение списка на диск
+func (b *BanList) Save() error {
+	if b == nil || b.path == "" {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	err := os.MkdirAll(filepath.Dir(b.path), 0o755)
+	if err != nil {
+		return err
+	}
+
+	tmp := b.path + ".tmp" // Запись через временный файл, чтобы не повредить список при сбое
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(file)
+	for ip, penalty := range b.penalties {
+		fmt.Fprintf(w, "%s %d\n", ip, penalty)
+	}
+	if err := w.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, b.path)
+}
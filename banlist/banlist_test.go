@@ -0,0 +1,85 @@
+package banlist
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBanThreshold(t *testing.T) {
+	b, err := Load(filepath.Join(t.TempDir(), "banned_peers.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ip, other := net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2)
+	for i := 1; i < BanThreshold; i++ {
+		banned, err := b.Penalize(ip, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if banned || b.IsBanned(ip) {
+			t.Fatalf("banned after %d of %d points", i, BanThreshold)
+		}
+	}
+	banned, err := b.Penalize(ip, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !banned || !b.IsBanned(ip) {
+		t.Errorf("not banned at %d points", BanThreshold)
+	}
+	if b.IsBanned(other) {
+		t.Error("penalty applied to another peer")
+	}
+	if banned, _ = b.Penalize(other, BanThreshold); !banned {
+		t.Error("not banned by a single large penalty")
+	}
+
+	var disabled *BanList // Блокировка отключена
+	if banned, err = disabled.Penalize(ip, BanThreshold); banned || err != nil || disabled.IsBanned(ip) {
+		t.Errorf("nil ban list: banned %v, %v", banned, err)
+	}
+}
+
+func TestBanListPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config", "banned_peers.txt") // Директория создается при сохранении
+	b, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ip := net.ParseIP("2001:db8::1")
+	b.Penalize(ip, 1)
+	b.Penalize(ip, BanThreshold-1)
+	b.Penalize(net.IPv4(10, 0, 0, 1), 1)
+
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.IsBanned(ip) {
+		t.Error("ban lost after reload")
+	}
+	if loaded.IsBanned(net.IPv4(10, 0, 0, 1)) {
+		t.Error("peer below the threshold banned after reload")
+	}
+	if banned, _ := loaded.Penalize(net.IPv4(10, 0, 0, 1), BanThreshold-1); !banned {
+		t.Error("penalty points lost after reload")
+	}
+	if _, err = os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary file left: %v", err)
+	}
+}
+
+func TestLoadRejectsMalformed(t *testing.T) {
+	for _, content := range []string{"10.0.0.1\n", "not-an-ip 1\n", "10.0.0.1 many\n"} {
+		path := filepath.Join(t.TempDir(), "banned_peers.txt")
+		err := os.WriteFile(path, []byte("\n10.0.0.2 1\n"+content), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = Load(path); err == nil {
+			t.Errorf("Load accepted %q", content)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package bencode
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Ограничения декодера по умолчанию
+const (
+	DefaultMaxDepth        = 64       // Глубина вложенности списков и словарей
+	DefaultMaxStringLength = 64 << 20 // Длина строки в байтах
+)
+
+// Закодированное значение, сохраняемое без разбора в точном виде
+type RawMessage []byte
+
+var rawType = reflect.TypeOf(RawMessage(nil))
+
+// Ошибка разбора с позицией в исходных данных
+type SyntaxError struct {
+	Offset int64 // Смещение байта, на котором обнаружена ошибка
+	Msg    string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("Bencode syntax error at offset %d: %s", e.Offset, e.Msg)
+}
+
+// Ошибка несоответствия закодированного значения типу Go
+type UnmarshalTypeError struct {
+	Offset int64  // Смещение начала значения
+	Value  string // integer, string, list или dictionary
+	Type   reflect.Type
+}
+
+func (e *UnmarshalTypeError) Error() string {
+	return fmt.Sprintf("Cannot unmarshal bencode %s into Go value of type %s at offset %d", e.Value, e.Type, e.Offset)
+}
+
+// Поле структуры и его ключ в словаре
+type field struct {
+	name      string
+	index     int
+	omitEmpty bool
+}
+
+// Поля структуры с тегом `bencode:"ключ,omitempty"` в порядке сортировки ключей
+func structFields(t reflect.Type) []field {
+	var fields []field
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		tag := sf.Tag.Get("bencode")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = sf.Name
+		}
+		f := field{name: name, index: i}
+		for _, opt := range strings.Split(opts, ",") {
+			if opt == "omitempty" {
+				f.omitEmpty = true
+			}
+		}
+		fields = append(fields, f)
+	}
+	sort.Slice(fields, func(a, b int) bool { return fields[a].name < fields[b].name })
+	return fields
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
@@ -0,0 +1,233 @@
+package bencode
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type testFile struct {
+	Length int64    `bencode:"length"`
+	Path   []string `bencode:"path"`
+}
+
+type testInfo struct {
+	Name        string     `bencode:"name"`
+	PieceLength int        `bencode:"piece length"`
+	Pieces      []byte     `bencode:"pieces"`
+	Files       []testFile `bencode:"files,omitempty"`
+	Private     bool       `bencode:"private,omitempty"`
+	Hash        [4]byte    `bencode:"hash"`
+	Skipped     string     `bencode:"-"`
+}
+
+type testTorrent struct {
+	Announce string     `bencode:"announce"`
+	Info     RawMessage `bencode:"info"`
+	Comment  *string    `bencode:"comment,omitempty"`
+}
+
+func TestRoundTripStruct(t *testing.T) {
+	info := testInfo{
+		Name:        "dir",
+		PieceLength: 16384,
+		Pieces:      []byte{0, 1, 2, 'e', ':'},
+		Files:       []testFile{{Length: 1, Path: []string{"a", "b"}}, {Length: 1 << 40, Path: []string{"c"}}},
+		Private:     true,
+		Hash:        [4]byte{1, 2, 3, 4},
+		Skipped:     "not encoded",
+	}
+	data, err := Marshal(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "d5:filesld6:lengthi1e4:pathl1:a1:beed6:lengthi1099511627776e4:pathl1:ceee4:hash4:\x01\x02\x03\x04" +
+		"4:name3:dir12:piece lengthi16384e6:pieces5:\x00\x01\x02e:7:privatei1ee"
+	if string(data) != want {
+		t.Errorf("Marshal = %q\nwant %q", data, want)
+	}
+
+	var got testInfo
+	err = Unmarshal(data, &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info.Skipped = ""
+	if !reflect.DeepEqual(got, info) {
+		t.Errorf("round trip = %+v, want %+v", got, info)
+	}
+
+	// Словарь info сохраняется в точном виде для вычисления хеша
+	outer, err := Marshal(map[string]interface{}{"announce": "http://tracker", "info": RawMessage(data)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tor testTorrent
+	err = Unmarshal(outer, &tor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(tor.Info, data) || tor.Announce != "http://tracker" || tor.Comment != nil {
+		t.Errorf("torrent = %+v", tor)
+	}
+}
+
+func TestUnmarshalGeneric(t *testing.T) {
+	var v interface{}
+	err := Unmarshal([]byte("d1:ai-5e1:bl0:d1:xleee1:c3:abce"), &v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"a": int64(-5),
+		"b": []interface{}{"", map[string]interface{}{"x": []interface{}{}}},
+		"c": "abc",
+	}
+	if !reflect.DeepEqual(v, want) {
+		t.Errorf("got %#v", v)
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	tests := []struct {
+		data string
+		want string
+	}{
+		{"", "unexpected end"},
+		{"i01e", "malformed integer"},
+		{"i-0e", "malformed integer"},
+		{"ie", "malformed integer"},
+		{"i12", "unterminated integer"},
+		{"i99999999999999999999e", "out of range"},
+		{"-1:a", "unexpected byte"},
+		{"03:abc", "malformed string length"},
+		{"5:abc", "exceeds remaining data"},
+		{"l", "unterminated list"},
+		{"d1:a", "missing value"},
+		{"di1ei2ee", "key must be a string"},
+		{"d1:ai1e1:ai2ee", "duplicate dictionary key"},
+		{"i1ei2e", "trailing data"},
+		{strings.Repeat("l", DefaultMaxDepth+1) + strings.Repeat("e", DefaultMaxDepth+1), "nesting depth"},
+		{"x", "unexpected byte"},
+	}
+	for _, tt := range tests {
+		var v interface{}
+		err := Unmarshal([]byte(tt.data), &v)
+		var syntax *SyntaxError
+		if !errors.As(err, &syntax) {
+			t.Errorf("Unmarshal(%q) = %v, want SyntaxError", tt.data, err)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Unmarshal(%q) = %v, want %q", tt.data, err, tt.want)
+		}
+	}
+}
+
+func TestUnmarshalTypeErrors(t *testing.T) {
+	var info testInfo
+	err := Unmarshal([]byte("d4:namei1ee"), &info)
+	var typeErr *UnmarshalTypeError
+	if !errors.As(err, &typeErr) || typeErr.Offset != 7 {
+		t.Errorf("got %v, want type error at offset 7", err)
+	}
+	err = Unmarshal([]byte("d4:hash3:abce"), &info)
+	if err == nil {
+		t.Error("string of wrong length accepted into [4]byte")
+	}
+	var small int8
+	err = Unmarshal([]byte("i300e"), &small)
+	if err == nil {
+		t.Error("integer overflowing int8 accepted")
+	}
+	err = Unmarshal([]byte("i1e"), info)
+	if err == nil {
+		t.Error("non-pointer target accepted")
+	}
+}
+
+func TestDecoderStream(t *testing.T) {
+	d := NewDecoder(strings.NewReader("i1e3:abcd1:xi2eei4"))
+	var n int
+	var s string
+	var m map[string]int
+	for _, v := range []interface{}{&n, &s, &m} {
+		err := d.Decode(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n != 1 || s != "abc" || m["x"] != 2 {
+		t.Errorf("decoded %d, %q, %v", n, s, m)
+	}
+	err := d.Decode(&n)
+	var syntax *SyntaxError
+	if !errors.As(err, &syntax) || syntax.Offset != 18 {
+		t.Errorf("truncated value: %v, want SyntaxError at offset 18", err)
+	}
+
+	d = NewDecoder(strings.NewReader(""))
+	if err := d.Decode(&n); err != io.EOF {
+		t.Errorf("empty stream: %v, want io.EOF", err)
+	}
+
+	d = NewDecoder(strings.NewReader("100:abc"))
+	d.MaxStringLength = 10
+	if err := d.Decode(&s); err == nil {
+		t.Error("string over MaxStringLength accepted")
+	}
+}
+
+func TestMarshalErrors(t *testing.T) {
+	for _, v := range []interface{}{nil, map[int]string{1: "a"}, 1.5, RawMessage{}, (*string)(nil)} {
+		if _, err := Marshal(v); err == nil {
+			t.Errorf("Marshal(%#v) succeeded", v)
+		}
+	}
+}
+
+// Успешно разобранное значение кодируется однозначно: повторный разбор
+// дает то же значение, а потоковый декодер - тот же результат
+func FuzzUnmarshal(f *testing.F) {
+	for _, seed := range []string{
+		"i0e", "i-42e", "0:", "4:spam", "le", "de", "l4:spami42ee",
+		"d3:bar4:spam3:fooi42ee", "d4:infod6:lengthi10e4:name1:feee",
+		"d1:bi1e1:ai2ee", "i01e", "lle", "d",
+	} {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var v interface{}
+		if Unmarshal(data, &v) != nil {
+			return
+		}
+		encoded, err := Marshal(v)
+		if err != nil {
+			t.Fatalf("Marshal of decoded value: %v", err)
+		}
+		var again interface{}
+		err = Unmarshal(encoded, &again)
+		if err != nil {
+			t.Fatalf("Unmarshal of %q: %v", encoded, err)
+		}
+		if !reflect.DeepEqual(v, again) {
+			t.Fatalf("round trip changed value: %#v -> %#v", v, again)
+		}
+		reencoded, _ := Marshal(again)
+		if !bytes.Equal(encoded, reencoded) {
+			t.Fatalf("encoding is not stable: %q -> %q", encoded, reencoded)
+		}
+
+		var streamed interface{}
+		err = NewDecoder(bytes.NewReader(data)).Decode(&streamed)
+		if err != nil {
+			t.Fatalf("Decoder rejected %q accepted by Unmarshal: %v", data, err)
+		}
+		if !reflect.DeepEqual(v, streamed) {
+			t.Fatalf("Decoder = %#v, Unmarshal = %#v", streamed, v)
+		}
+	})
+}
@@ -0,0 +1,492 @@
+package bencode
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+)
+
+// Разбор закодированного значения data в v. Данные после значения считаются ошибкой
+func Unmarshal(data []byte, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return fmt.Errorf("Unmarshal target must be a non-nil pointer, got %T", v)
+	}
+
+	p := parser{data: data, maxDepth: DefaultMaxDepth, maxString: DefaultMaxStringLength}
+	err := p.decode(rv.Elem())
+	if err != nil {
+		return err
+	}
+	if p.pos != len(data) {
+		return p.errorf(p.pos, "trailing data after value")
+	}
+	return nil
+}
+
+// Потоковый декодер значений
+type Decoder struct {
+	r               *bufio.Reader
+	offset          int64 // Количество байт, считанных из потока
+	MaxDepth        int
+	MaxStringLength int
+}
+
+// Создание декодера с ограничениями по умолчанию
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{
+		r:               bufio.NewReader(r),
+		MaxDepth:        DefaultMaxDepth,
+		MaxStringLength: DefaultMaxStringLength,
+	}
+}
+
+// Считывание следующего значения из потока в v. В конце потока возвращается io.EOF
+func (d *Decoder) Decode(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return fmt.Errorf("Decode target must be a non-nil pointer, got %T", v)
+	}
+
+	var buf bytes.Buffer
+	base := d.offset
+	err := d.read(&buf, 0)
+	d.offset += int64(buf.Len())
+	if err != nil {
+		return err
+	}
+
+	p := parser{data: buf.Bytes(), base: base, maxDepth: d.MaxDepth, maxString: d.MaxStringLength}
+	return p.decode(rv.Elem())
+}
+
+func (d *Decoder) errorf(buf *bytes.Buffer, format string, args ...interface{}) error {
+	return &SyntaxError{Offset: d.offset + int64(buf.Len()), Msg: fmt.Sprintf(format, args...)}
+}
+
+// Считывание байта из потока с записью в buf
+func (d *Decoder) readByte(buf *bytes.Buffer) (byte, error) {
+	c, err := d.r.ReadByte()
+	if err == io.EOF && buf.Len() > 0 {
+		return 0, d.errorf(buf, "unexpected end of data")
+	}
+	if err != nil {
+		return 0, err
+	}
+	buf.WriteByte(c)
+	return c, nil
+}
+
+// Считывание байт одного значения в buf. Здесь проверяются только границы значения
+// и ограничения, чтобы не выделять память под заведомо неверные данные
+func (d *Decoder) read(buf *bytes.Buffer, depth int) error {
+	start := buf.Len()
+	c, err := d.readByte(buf)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case c == 'i':
+		for buf.Len()-start <= 21 { // Знак и 20 цифр
+			c, err = d.readByte(buf)
+			if err != nil {
+				return err
+			}
+			if c == 'e' {
+				return nil
+			}
+		}
+		return d.errorf(buf, "integer is too long")
+	case isDigit(c):
+		for c != ':' {
+			if buf.Len()-start > 20 {
+				return d.errorf(buf, "string length is too long")
+			}
+			c, err = d.readByte(buf)
+			if err != nil {
+				return err
+			}
+		}
+		length, err := strconv.ParseInt(string(buf.Bytes()[start:buf.Len()-1]), 10, 64)
+		if err != nil {
+			return &SyntaxError{Offset: d.offset + int64(start), Msg: "malformed string length"}
+		}
+		if length > int64(d.MaxStringLength) {
+			return &SyntaxError{Offset: d.offset + int64(start), Msg: fmt.Sprintf("string length exceeds limit %d", d.MaxStringLength)}
+		}
+		_, err = io.CopyN(buf, d.r, length)
+		if errors.Is(err, io.EOF) {
+			return d.errorf(buf, "unexpected end of data")
+		}
+		return err
+	case c == 'l' || c == 'd':
+		if depth >= d.MaxDepth {
+			return d.errorf(buf, "nesting depth exceeds %d", d.MaxDepth)
+		}
+		for {
+			next, err := d.r.Peek(1)
+			if err == io.EOF {
+				return d.errorf(buf, "unexpected end of data")
+			}
+			if err != nil {
+				return err
+			}
+			if next[0] == 'e' {
+				_, err = d.readByte(buf)
+				return err
+			}
+			err = d.read(buf, depth+1)
+			if err != nil {
+				return err
+			}
+		}
+	default:
+		return &SyntaxError{Offset: d.offset + int64(start), Msg: fmt.Sprintf("unexpected byte %q", c)}
+	}
+}
+
+// Разбор значения из памяти
+type parser struct {
+	data      []byte
+	pos       int
+	base      int64 // Смещение data во входном потоке
+	depth     int
+	maxDepth  int
+	maxString int
+}
+
+func (p *parser) errorf(pos int, format string, args ...interface{}) error {
+	return &SyntaxError{Offset: p.base + int64(pos), Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) typeError(pos int, value string, t reflect.Type) error {
+	return &UnmarshalTypeError{Offset: p.base + int64(pos), Value: value, Type: t}
+}
+
+// Проверка записи числа: без ведущих нулей и без "-0"
+func validInteger(b []byte) bool {
+	if len(b) > 0 && b[0] == '-' {
+		b = b[1:]
+		if len(b) > 0 && b[0] == '0' {
+			return false
+		}
+	}
+	if len(b) == 0 || (b[0] == '0' && len(b) > 1) {
+		return false
+	}
+	for _, c := range b {
+		if !isDigit(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// Считывание числа вида i<цифры>e
+func (p *parser) integer() (int64, error) {
+	start := p.pos
+	end := bytes.IndexByte(p.data[start:], 'e')
+	if end < 0 {
+		return 0, p.errorf(start, "unterminated integer")
+	}
+	digits := p.data[start+1 : start+end]
+	if !validInteger(digits) {
+		return 0, p.errorf(start, "malformed integer %q", digits)
+	}
+	n, err := strconv.ParseInt(string(digits), 10, 64)
+	if err != nil {
+		return 0, p.errorf(start, "integer %s is out of range", digits)
+	}
+	p.pos = start + end + 1
+	return n, nil
+}
+
+// Считывание строки вида <длина>:<байты>
+func (p *parser) string() ([]byte, error) {
+	start := p.pos
+	colon := bytes.IndexByte(p.data[start:], ':')
+	if colon < 0 {
+		return nil, p.errorf(start, "unterminated string length")
+	}
+	digits := p.data[start : start+colon]
+	if !validInteger(digits) || digits[0] == '-' {
+		return nil, p.errorf(start, "malformed string length %q", digits)
+	}
+	length, err := strconv.Atoi(string(digits))
+	if err != nil || length > p.maxString {
+		return nil, p.errorf(start, "string length exceeds limit %d", p.maxString)
+	}
+	begin := start + colon + 1
+	if length > len(p.data)-begin {
+		return nil, p.errorf(start, "string length %d exceeds remaining data", length)
+	}
+	p.pos = begin + length
+	return p.data[begin:p.pos], nil
+}
+
+// Вход в список или словарь с проверкой глубины вложенности
+func (p *parser) enter() error {
+	p.depth++
+	if p.depth > p.maxDepth {
+		return p.errorf(p.pos, "nesting depth exceeds %d", p.maxDepth)
+	}
+	p.pos++
+	return nil
+}
+
+// Обход элементов списка: fn считывает очередной элемент
+func (p *parser) list(fn func() error) error {
+	start := p.pos
+	err := p.enter()
+	if err != nil {
+		return err
+	}
+	for {
+		if p.pos >= len(p.data) {
+			return p.errorf(start, "unterminated list")
+		}
+		if p.data[p.pos] == 'e' {
+			p.pos++
+			p.depth--
+			return nil
+		}
+		err = fn()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Обход элементов словаря: fn считывает значение ключа key.
+// Ключи должны быть строками и не повторяться
+func (p *parser) dict(fn func(key []byte) error) error {
+	start := p.pos
+	err := p.enter()
+	if err != nil {
+		return err
+	}
+	seen := make(map[string]bool)
+	for {
+		if p.pos >= len(p.data) {
+			return p.errorf(start, "unterminated dictionary")
+		}
+		if p.data[p.pos] == 'e' {
+			p.pos++
+			p.depth--
+			return nil
+		}
+		keyPos := p.pos
+		if !isDigit(p.data[p.pos]) {
+			return p.errorf(keyPos, "dictionary key must be a string")
+		}
+		key, err := p.string()
+		if err != nil {
+			return err
+		}
+		if seen[string(key)] {
+			return p.errorf(keyPos, "duplicate dictionary key %q", key)
+		}
+		seen[string(key)] = true
+		if p.pos >= len(p.data) || p.data[p.pos] == 'e' {
+			return p.errorf(p.pos, "missing value for key %q", key)
+		}
+		err = fn(key)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Пропуск значения с проверкой его записи
+func (p *parser) skip() error {
+	if p.pos >= len(p.data) {
+		return p.errorf(p.pos, "unexpected end of data")
+	}
+	switch c := p.data[p.pos]; {
+	case c == 'i':
+		_, err := p.integer()
+		return err
+	case isDigit(c):
+		_, err := p.string()
+		return err
+	case c == 'l':
+		return p.list(p.skip)
+	case c == 'd':
+		return p.dict(func([]byte) error { return p.skip() })
+	default:
+		return p.errorf(p.pos, "unexpected byte %q", c)
+	}
+}
+
+// Разбор значения в обобщенное представление:
+// int64, string, []interface{} или map[string]interface{}
+func (p *parser) generic() (interface{}, error) {
+	if p.pos >= len(p.data) {
+		return nil, p.errorf(p.pos, "unexpected end of data")
+	}
+	switch c := p.data[p.pos]; {
+	case c == 'i':
+		return p.integer()
+	case isDigit(c):
+		s, err := p.string()
+		return string(s), err
+	case c == 'l':
+		list := []interface{}{}
+		err := p.list(func() error {
+			v, err := p.generic()
+			list = append(list, v)
+			return err
+		})
+		return list, err
+	case c == 'd':
+		m := map[string]interface{}{}
+		err := p.dict(func(key []byte) error {
+			v, err := p.generic()
+			m[string(key)] = v
+			return err
+		})
+		return m, err
+	default:
+		return nil, p.errorf(p.pos, "unexpected byte %q", c)
+	}
+}
+
+// Разбор значения в v
+func (p *parser) decode(v reflect.Value) error {
+	if p.pos >= len(p.data) {
+		return p.errorf(p.pos, "unexpected end of data")
+	}
+	start := p.pos
+
+	if v.Type() == rawType {
+		err := p.skip()
+		if err != nil {
+			return err
+		}
+		v.SetBytes(append(RawMessage(nil), p.data[start:p.pos]...))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return p.decode(v.Elem())
+	case reflect.Interface:
+		if v.NumMethod() > 0 {
+			return p.typeError(start, "value", v.Type())
+		}
+		value, err := p.generic()
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(value))
+		return nil
+	}
+
+	switch c := p.data[p.pos]; {
+	case c == 'i':
+		n, err := p.integer()
+		if err != nil {
+			return err
+		}
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if v.OverflowInt(n) {
+				return p.errorf(start, "integer %d overflows %s", n, v.Type())
+			}
+			v.SetInt(n)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			if n < 0 || v.OverflowUint(uint64(n)) {
+				return p.errorf(start, "integer %d overflows %s", n, v.Type())
+			}
+			v.SetUint(uint64(n))
+		case reflect.Bool:
+			v.SetBool(n != 0)
+		default:
+			return p.typeError(start, "integer", v.Type())
+		}
+		return nil
+	case isDigit(c):
+		s, err := p.string()
+		if err != nil {
+			return err
+		}
+		switch {
+		case v.Kind() == reflect.String:
+			v.SetString(string(s))
+		case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8:
+			v.SetBytes(append([]byte(nil), s...))
+		case v.Kind() == reflect.Array && v.Type().Elem().Kind() == reflect.Uint8:
+			if v.Len() != len(s) {
+				return p.errorf(start, "string of length %d does not fit %s", len(s), v.Type())
+			}
+			reflect.Copy(v, reflect.ValueOf(s))
+		default:
+			return p.typeError(start, "string", v.Type())
+		}
+		return nil
+	case c == 'l':
+		switch v.Kind() {
+		case reflect.Slice:
+			v.Set(reflect.MakeSlice(v.Type(), 0, 0))
+			return p.list(func() error {
+				elem := reflect.New(v.Type().Elem()).Elem()
+				err := p.decode(elem)
+				v.Set(reflect.Append(v, elem))
+				return err
+			})
+		case reflect.Array:
+			i := 0
+			return p.list(func() error {
+				i++
+				if i > v.Len() { // Лишние элементы пропускаются
+					return p.skip()
+				}
+				return p.decode(v.Index(i - 1))
+			})
+		default:
+			return p.typeError(start, "list", v.Type())
+		}
+	case c == 'd':
+		switch v.Kind() {
+		case reflect.Map:
+			if v.Type().Key().Kind() != reflect.String {
+				return p.typeError(start, "dictionary", v.Type())
+			}
+			if v.IsNil() {
+				v.Set(reflect.MakeMap(v.Type()))
+			}
+			return p.dict(func(key []byte) error {
+				elem := reflect.New(v.Type().Elem()).Elem()
+				err := p.decode(elem)
+				if err != nil {
+					return err
+				}
+				v.SetMapIndex(reflect.ValueOf(string(key)).Convert(v.Type().Key()), elem)
+				return nil
+			})
+		case reflect.Struct:
+			fields := make(map[string]int)
+			for _, f := range structFields(v.Type()) {
+				fields[f.name] = f.index
+			}
+			return p.dict(func(key []byte) error {
+				index, ok := fields[string(key)]
+				if !ok { // Неизвестные ключи пропускаются
+					return p.skip()
+				}
+				return p.decode(v.Field(index))
+			})
+		default:
+			return p.typeError(start, "dictionary", v.Type())
+		}
+	default:
+		return p.errorf(start, "unexpected byte %q", c)
+	}
+}
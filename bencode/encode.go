@@ -0,0 +1,136 @@
+package bencode
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+// Кодирование v. Ключи словарей записываются в порядке сортировки
+func Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	err := encode(&buf, reflect.ValueOf(v))
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Потоковый кодировщик значений
+type Encoder struct {
+	w io.Writer
+}
+
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Запись закодированного v в поток. При ошибке кодирования в поток ничего не пишется
+func (e *Encoder) Encode(v interface{}) error {
+	data, err := Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = e.w.Write(data)
+	return err
+}
+
+func writeString(buf *bytes.Buffer, s []byte) {
+	buf.WriteString(strconv.Itoa(len(s)))
+	buf.WriteByte(':')
+	buf.Write(s)
+}
+
+// Является ли значение пустым для omitempty
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map, reflect.String, reflect.Array:
+		return v.Len() == 0
+	case reflect.Pointer, reflect.Interface:
+		return v.IsNil()
+	}
+	return v.IsZero()
+}
+
+func encode(buf *bytes.Buffer, v reflect.Value) error {
+	if !v.IsValid() {
+		return fmt.Errorf("Cannot encode nil value")
+	}
+	if v.Type() == rawType {
+		if v.Len() == 0 {
+			return fmt.Errorf("Cannot encode empty RawMessage")
+		}
+		buf.Write(v.Bytes())
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return fmt.Errorf("Cannot encode nil %s", v.Type())
+		}
+		return encode(buf, v.Elem())
+	case reflect.Bool:
+		if v.Bool() {
+			buf.WriteString("i1e")
+		} else {
+			buf.WriteString("i0e")
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		fmt.Fprintf(buf, "i%de", v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		fmt.Fprintf(buf, "i%de", v.Uint())
+	case reflect.String:
+		writeString(buf, []byte(v.String()))
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 { // Байтовые срезы и массивы кодируются строкой
+			s := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(s), v)
+			writeString(buf, s)
+			return nil
+		}
+		buf.WriteByte('l')
+		for i := 0; i < v.Len(); i++ {
+			err := encode(buf, v.Index(i))
+			if err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('e')
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("Cannot encode map with %s keys", v.Type().Key())
+		}
+		keys := v.MapKeys()
+		sort.Slice(keys, func(a, b int) bool { return keys[a].String() < keys[b].String() })
+		buf.WriteByte('d')
+		for _, key := range keys {
+			writeString(buf, []byte(key.String()))
+			err := encode(buf, v.MapIndex(key))
+			if err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('e')
+	case reflect.Struct:
+		buf.WriteByte('d')
+		for _, f := range structFields(v.Type()) {
+			value := v.Field(f.index)
+			if f.omitEmpty && isEmpty(value) {
+				continue
+			}
+			writeString(buf, []byte(f.name))
+			err := encode(buf, value)
+			if err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('e')
+	default:
+		return fmt.Errorf("Cannot encode value of type %s", v.Type())
+	}
+	return nil
+}
@@ -2,14 +2,34 @@ package bitfields
 
 type Bitfield []byte
 
+// Создание пустого битового поля для numPieces частей
+func New(numPieces int) Bitfield {
+	return make(Bitfield, (numPieces+7)/8)
+}
+
+// Создание битового поля, в котором отмечены все numPieces частей
+func Full(numPieces int) Bitfield {
+	bf := New(numPieces)
+	for i := 0; i < numPieces; i++ {
+		bf.SetPiece(i)
+	}
+	return bf
+}
+
 func (bf Bitfield) HasPiece(index int) bool {
 	byteIndex := index / 8
 	offset := index % 8
+	if index < 0 || byteIndex >= len(bf) { // Части за пределами поля считаются отсутствующими
+		return false
+	}
 	return bf[byteIndex]>>(7-offset)&1 == 1 // Если бит по индексу == 1 возвращается true, иначе false
 }
 
 func (bf Bitfield) SetPiece(index int) {
 	byteIndex := index / 8
 	offset := index % 8
+	if index < 0 || byteIndex >= len(bf) {
+		return
+	}
 	bf[byteIndex] |= 1 << (7 - offset) // бит по индексу становится равным 1
 }
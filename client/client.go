@@ -9,22 +9,98 @@ import (
 	"github.com/swesdek/gotorrent-client/bitfields"
 	"github.com/swesdek/gotorrent-client/handshake"
 	"github.com/swesdek/gotorrent-client/message"
+	"github.com/swesdek/gotorrent-client/mse"
 	"github.com/swesdek/gotorrent-client/peers"
+	"github.com/swesdek/gotorrent-client/proxy"
+	"github.com/swesdek/gotorrent-client/ratelimit"
+	"github.com/swesdek/gotorrent-client/utp"
 )
 
+// Транспорт для соединений с пирами
+type Transport int
+
+const (
+	// Только TCP
+	TransportTCP Transport = iota
+
+	// uTP с откатом на TCP, если пир не отвечает по uTP
+	TransportPreferUTP
+
+	// Только uTP
+	TransportUTP
+)
+
+// Транспорт в виде строки
+func (t Transport) String() string {
+	switch t {
+	case TransportTCP:
+		return "tcp"
+	case TransportPreferUTP:
+		return "prefer-utp"
+	case TransportUTP:
+		return "utp"
+	}
+	return fmt.Sprintf("Transport(%d)", int(t))
+}
+
+// Разбор транспорта из строки, возвращаемой String
+func ParseTransport(s string) (Transport, error) {
+	for _, t := range []Transport{TransportTCP, TransportPreferUTP, TransportUTP} {
+		if t.String() == s {
+			return t, nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown transport %q", s)
+}
+
+// Таймауты по умолчанию
+const (
+	DefaultDialTimeout      = 3 * time.Second // Установление соединения
+	DefaultHandshakeTimeout = 3 * time.Second // Ожидание хендшейка пира
+	DefaultBitfieldTimeout  = 5 * time.Second // Ожидание сведений о частях пира
+)
+
+// Размер набора allowed fast, который получает пир с Fast Extension (BEP 6)
+const AllowedFastCount = 10
+
+// Параметры установления соединения с пиром
+type Options struct {
+	Encryption mse.Policy   // Режим шифрования соединения
+	Transport  Transport    // Транспорт соединения
+	Proxy      *proxy.Proxy // Прокси для соединения (nil - прямое соединение)
+
+	DownloadLimits []*ratelimit.Limiter // Ограничители скорости приема
+	UploadLimits   []*ratelimit.Limiter // Ограничители скорости отправки
+
+	DialTimeout      time.Duration // Таймауты соединения (0 - значения по умолчанию)
+	HandshakeTimeout time.Duration
+	BitfieldTimeout  time.Duration
+}
+
+// Значение d или def, если d не задано
+func orDefault(d, def time.Duration) time.Duration {
+	if d <= 0 {
+		return def
+	}
+	return d
+}
+
 type Client struct {
-	Conn     net.Conn
-	Choked   bool
-	Bitfield bitfields.Bitfield
-	peer     peers.Peer
-	InfoHash [20]byte
-	PeerID   [20]byte
+	Conn        net.Conn
+	Choked      bool
+	Bitfield    bitfields.Bitfield
+	Fast        bool         // Обе стороны поддерживают Fast Extension
+	AllowedFast map[int]bool // Части, которые пир разрешил запрашивать при блокировке
+	Suggested   []int        // Части, которые пир советует запросить
+	peer        peers.Peer
+	InfoHash    [20]byte
+	PeerID      [20]byte
 }
 
 // Выполнение рукопожатия с другими пирами
-func completeHandshake(conn net.Conn, infohash [20]byte, peerID [20]byte) (*handshake.Handshake, error) {
-	conn.SetDeadline(time.Now().Add(time.Second * 3)) // Дедлайн ожидания ответа от пира
-	defer conn.SetDeadline(time.Time{})               // Отмена дедлайна
+func completeHandshake(conn net.Conn, infohash [20]byte, peerID [20]byte, timeout time.Duration) (*handshake.Handshake, error) {
+	conn.SetDeadline(time.Now().Add(timeout)) // Дедлайн ожидания ответа от пира
+	defer conn.SetDeadline(time.Time{})       // Отмена дедлайна
 
 	req := handshake.New(infohash, peerID) // Создание объекта хендшейка
 	_, err := conn.Write(req.Serialize())  // Отправка пиру хендшейка
@@ -42,52 +118,234 @@ func completeHandshake(conn net.Conn, infohash [20]byte, peerID [20]byte) (*hand
 	return res, nil
 }
 
-// Функция получения информации об имеющихся у пира частях файла
-func getBitfield(conn net.Conn) (bitfields.Bitfield, error) {
-	conn.SetDeadline(time.Now().Add(time.Second * 5)) // Дедлайн ожидания ответа от пира
-	defer conn.SetDeadline(time.Now())                // Отмена дедлайна
+// Функция получения информации об имеющихся у пира частях файла.
+// Если первым пришло другое сообщение, возвращается пустое поле и это сообщение
+func getBitfield(conn net.Conn, numPieces int, fast bool, timeout time.Duration) (bitfields.Bitfield, *message.Message, error) {
+	conn.SetDeadline(time.Now().Add(timeout)) // Дедлайн ожидания ответа от пира
+	defer conn.SetDeadline(time.Time{})       // Отмена дедлайна
 
 	msg, err := message.Read(conn) // Считывание данных от пира
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	if msg == nil {
-		return nil, fmt.Errorf("Expected bitfield but got nothing")
+		return nil, nil, fmt.Errorf("Expected bitfield but got nothing")
 	}
-	if msg.ID != message.MsgBitfield {
-		return nil, fmt.Errorf("Expected bitfield ID, but got %d", msg.ID)
+
+	switch msg.ID {
+	case message.MsgBitfield:
+		return msg.Payload, nil, nil
+	case message.MsgHaveAll, message.MsgHaveNone:
+		if !fast {
+			return nil, nil, fmt.Errorf("Got fast extension message ID %d without negotiating it", msg.ID)
+		}
+		if msg.ID == message.MsgHaveAll {
+			return bitfields.Full(numPieces), nil, nil
+		}
+		return bitfields.New(numPieces), nil, nil
+	}
+	if fast {
+		return nil, nil, fmt.Errorf("Expected bitfield, have all or have none, but got ID %d", msg.ID)
 	}
 
-	return msg.Payload, nil
+	return bitfields.New(numPieces), msg, nil // Пир без частей может не присылать bitfield
+}
+
+// Установление uTP соединения напрямую или через SOCKS5 прокси
+func dialUTP(peer peers.Peer, p *proxy.Proxy, timeout time.Duration) (net.Conn, error) {
+	if p == nil {
+		return utp.Dial(peer.String(), timeout)
+	}
+	pc, err := p.ListenUDP()
+	if err != nil {
+		return nil, err
+	}
+	return utp.DialPacketConn(pc, peer.String(), timeout)
+}
+
+// Установление соединения с пиром по выбранному транспорту
+func dialTransport(peer peers.Peer, opts Options) (net.Conn, error) {
+	timeout := orDefault(opts.DialTimeout, DefaultDialTimeout)
+	transport := opts.Transport
+	if opts.Proxy != nil && !opts.Proxy.SupportsUDP() && transport == TransportPreferUTP {
+		transport = TransportTCP // HTTP прокси не передает UDP
+	}
+	if transport == TransportTCP {
+		return opts.Proxy.Dial("tcp", peer.String(), timeout) // Установление TCP соединения с клиентом
+	}
+
+	conn, err := dialUTP(peer, opts.Proxy, timeout)
+	if err != nil && transport == TransportPreferUTP {
+		return opts.Proxy.Dial("tcp", peer.String(), timeout)
+	}
+	return conn, err
+}
+
+// Установление соединения с пиром по выбранному транспорту с ограничением скорости
+func dialLimited(peer peers.Peer, opts Options) (net.Conn, error) {
+	conn, err := dialTransport(peer, opts)
+	if err != nil {
+		return nil, err
+	}
+	return ratelimit.Conn(conn, opts.DownloadLimits, opts.UploadLimits), nil
+}
+
+// Установление соединения с пиром с учетом режима шифрования
+func dial(peer peers.Peer, infoHash [20]byte, opts Options) (net.Conn, error) {
+	conn, err := dialLimited(peer, opts)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Encryption == mse.PolicyDisabled {
+		return conn, nil
+	}
+
+	encrypted, err := mse.Initiate(conn, infoHash, opts.Encryption.Provide())
+	if err == nil {
+		return encrypted, nil
+	}
+	conn.Close()
+	if opts.Encryption == mse.PolicyRequired {
+		return nil, err
+	}
+
+	// Пир не поддерживает шифрование - повторное соединение без него
+	return dialLimited(peer, opts)
 }
 
 // Инициализатор объекта клиента
-func New(peer peers.Peer, peerID, infoHash [20]byte) (*Client, error) {
-	conn, err := net.DialTimeout("tcp", peer.String(), 3*time.Second) // Установление TCP соединения с клиентом
+func New(peer peers.Peer, peerID, infoHash [20]byte, numPieces int, opts Options) (*Client, error) {
+	conn, err := dial(peer, infoHash, opts)
 	if err != nil {
 		return nil, err
 	}
 
-	_, err = completeHandshake(conn, infoHash, peerID) // Хендшейк
+	res, err := completeHandshake(conn, infoHash, peerID, orDefault(opts.HandshakeTimeout, DefaultHandshakeTimeout)) // Хендшейк
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return setup(conn, peer, res, peerID, infoHash, numPieces, opts)
+}
+
+// Прием входящего соединения. lookup возвращает количество частей торрента
+// с данным хешем или false, если такого торрента нет. skeys - хеши торрентов для MSE
+func Accept(conn net.Conn, peerID [20]byte, skeys [][20]byte, lookup func([20]byte) (int, bool), opts Options) (*Client, error) {
+	peer, err := peers.FromAddr(conn.RemoteAddr())
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn = ratelimit.Conn(conn, opts.DownloadLimits, opts.UploadLimits)
+
+	var skey [20]byte
+	if opts.Encryption != mse.PolicyDisabled {
+		encrypted, key, err := mse.Accept(conn, skeys, opts.Encryption.Provide())
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn, skey = encrypted, key
+	}
+
+	conn.SetDeadline(time.Now().Add(orDefault(opts.HandshakeTimeout, DefaultHandshakeTimeout)))
+	req, err := handshake.Read(conn)
 	if err != nil {
 		conn.Close()
 		return nil, err
 	}
+	numPieces, ok := lookup(req.Infohash)
+	if !ok || (skey != [20]byte{} && skey != req.Infohash) {
+		conn.Close()
+		return nil, fmt.Errorf("Peer %s requested unknown torrent %x", peer, req.Infohash)
+	}
+	_, err = conn.Write(handshake.New(req.Infohash, peerID).Serialize())
+	conn.SetDeadline(time.Time{})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return setup(conn, peer, req, peerID, req.Infohash, numPieces, opts)
+}
 
-	bf, err := getBitfield(conn) // Получение информации об имеющихся частях файла
+// Обмен сведениями о частях после хендшейка и создание объекта клиента
+func setup(conn net.Conn, peer peers.Peer, res *handshake.Handshake, peerID, infoHash [20]byte, numPieces int, opts Options) (*Client, error) {
+	fast := res.SupportsFast()
+	if fast { // С Fast Extension сообщение о своих частях обязательно
+		msg := message.Message{ID: message.MsgHaveNone}
+		buf := msg.Serialize()
+		// Части, которые пир может запрашивать и при блокировке
+		for _, index := range message.AllowedFastSet(peer.IP, infoHash, numPieces, AllowedFastCount) {
+			buf = append(buf, message.FormatAllowedFast(index).Serialize()...)
+		}
+		_, err := conn.Write(buf)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	bf, pending, err := getBitfield(conn, numPieces, fast, orDefault(opts.BitfieldTimeout, DefaultBitfieldTimeout)) // Получение информации об имеющихся частях файла
 	if err != nil {
 		conn.Close()
 		return nil, err
 	}
 
-	return &Client{
-		Conn:     conn,
-		Choked:   true,
-		Bitfield: bf,
-		peer:     peer,
-		InfoHash: infoHash,
-		PeerID:   peerID,
-	}, nil
+	c := &Client{
+		Conn:        conn,
+		Choked:      true,
+		Bitfield:    bf,
+		Fast:        fast,
+		AllowedFast: make(map[int]bool),
+		peer:        peer,
+		InfoHash:    infoHash,
+		PeerID:      peerID,
+	}
+	if pending != nil {
+		_, err = c.Apply(pending)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Обработка сообщений, меняющих состояние соединения.
+// Возвращает false, если сообщение не относится к состоянию и должно быть обработано вызывающим
+func (c *Client) Apply(msg *message.Message) (bool, error) {
+	switch msg.ID {
+	case message.MsgUnchoke:
+		c.Choked = false
+	case message.MsgChoke:
+		c.Choked = true
+	case message.MsgHave:
+		index, err := message.ParseHave(msg) // Считывание, какая часть файла есть у пира
+		if err != nil {
+			return true, err
+		}
+		c.Bitfield.SetPiece(index) // Запись в Bitfield о содержании пиром соответствующей части
+	case message.MsgAllowedFast:
+		index, err := message.ParseAllowedFast(msg)
+		if err != nil {
+			return true, err
+		}
+		c.AllowedFast[index] = true
+	case message.MsgSuggest:
+		index, err := message.ParseSuggest(msg)
+		if err != nil {
+			return true, err
+		}
+		c.Suggested = append(c.Suggested, index)
+	default:
+		return false, nil
+	}
+	return true, nil
+}
+
+// Пир, с которым установлено соединение
+func (c *Client) Peer() peers.Peer {
+	return c.peer
 }
 
 // Функция считывания информации с соединения
@@ -103,6 +361,13 @@ func (c *Client) SendRequest(index, begin, length int) error {
 	return err
 }
 
+// Функция отправки отказа на запрос блока (только с Fast Extension)
+func (c *Client) SendReject(index, begin, length int) error {
+	req := message.FormatReject(index, begin, length)
+	_, err := c.Conn.Write(req.Serialize())
+	return err
+}
+
 // Функция для отправки сообщения о разблокировке соединения
 func (c *Client) SendUnchoke() error {
 	msg := message.Message{ID: message.MsgUnchoke}
@@ -0,0 +1,79 @@
+package client
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/swesdek/gotorrent-client/handshake"
+	"github.com/swesdek/gotorrent-client/message"
+	"github.com/swesdek/gotorrent-client/peers"
+)
+
+// Сообщения, отправленные клиентом до ответа пира, и ответ пира reply
+func peerSide(conn net.Conn, count int, reply *message.Message) <-chan []*message.Message {
+	got := make(chan []*message.Message, 1)
+	go func() {
+		var msgs []*message.Message
+		for len(msgs) < count {
+			msg, err := message.Read(conn)
+			if err != nil {
+				break
+			}
+			msgs = append(msgs, msg)
+		}
+		conn.Write(reply.Serialize())
+		got <- msgs
+	}()
+	return got
+}
+
+func TestSetupSendsAllowedFast(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+	peer := peers.Peer{IP: net.IPv4(80, 4, 4, 200), Port: 6881}
+	infoHash := [20]byte{1, 2, 3}
+	got := peerSide(remote, 1+AllowedFastCount, &message.Message{ID: message.MsgHaveAll})
+
+	c, err := setup(local, peer, handshake.New(infoHash, [20]byte{}), [20]byte{}, infoHash, 100, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Conn.Close()
+	if !c.Fast || c.Bitfield == nil || !c.Bitfield.HasPiece(99) {
+		t.Errorf("fast %v, bitfield %v after have all", c.Fast, c.Bitfield)
+	}
+
+	msgs := <-got
+	if len(msgs) == 0 || msgs[0].ID != message.MsgHaveNone {
+		t.Fatalf("first message %+v, want have none", msgs)
+	}
+	var indexes []int
+	for _, msg := range msgs[1:] {
+		index, err := message.ParseAllowedFast(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		indexes = append(indexes, index)
+	}
+	if want := message.AllowedFastSet(peer.IP, infoHash, 100, AllowedFastCount); !reflect.DeepEqual(indexes, want) {
+		t.Errorf("allowed fast %v, want %v", indexes, want)
+	}
+}
+
+func TestSetupWithoutFast(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+	got := peerSide(remote, 0, &message.Message{ID: message.MsgBitfield, Payload: []byte{0x80}})
+
+	res := &handshake.Handshake{Pstr: "BitTorrent protocol"} // Пир без Fast Extension
+	c, err := setup(local, peers.Peer{IP: net.IPv4(10, 0, 0, 1)}, res, [20]byte{}, [20]byte{}, 8, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Conn.Close()
+	<-got
+	if c.Fast || !c.Bitfield.HasPiece(0) {
+		t.Errorf("fast %v, bitfield %v", c.Fast, c.Bitfield)
+	}
+}
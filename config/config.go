@@ -0,0 +1,491 @@
+// Пакет config: настройки клиента из файла конфигурации и переменных окружения.
+//
+// Файл записывается в подмножестве TOML:
+//
+//	[network]
+//	port = 6881
+//	encryption = "required"
+//
+//	[limits]
+//	download_rate = "2M"
+//
+// Каждый ключ можно переопределить переменной окружения GOTORRENT_<SECTION>_<KEY>,
+// например GOTORRENT_LIMITS_DOWNLOAD_RATE=512K
+package config
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/swesdek/gotorrent-client/api"
+	"github.com/swesdek/gotorrent-client/client"
+	"github.com/swesdek/gotorrent-client/download"
+	"github.com/swesdek/gotorrent-client/mse"
+	"github.com/swesdek/gotorrent-client/proxy"
+	"github.com/swesdek/gotorrent-client/session"
+	"github.com/swesdek/gotorrent-client/storage"
+	"github.com/swesdek/gotorrent-client/torrentfile"
+)
+
+// Префикс PeerID по умолчанию в стиле Azureus: клиент GT, версия 0001
+const DefaultPeerIDPrefix = "-GT0001-"
+
+// Префикс переменных окружения, переопределяющих ключи файла
+const EnvPrefix = "GOTORRENT_"
+
+// Настройки клиента
+type Config struct {
+	Network  Network
+	Limits   Limits
+	Download Download
+	Timeouts Timeouts
+	Session  Session
+}
+
+// Секция [network]: соединения с пирами и трекерами
+type Network struct {
+	Port           uint16           // port: порт, сообщаемый трекерам
+	PeerIDPrefix   string           // peer_id_prefix: начало PeerID, остальное случайно
+	Encryption     mse.Policy       // encryption: disabled, preferred или required
+	Transport      client.Transport // transport: tcp, prefer-utp или utp
+	Proxy          *proxy.Proxy     // proxy: socks5://host:port или http://host:port
+	MaxConnections int              // max_connections: лимит соединений (0 - без лимита)
+	DHT            bool             // dht: узел DHT в serve (не используется с proxy)
+}
+
+// Секция [limits]: ограничения скорости в байтах в секунду (0 - без лимита)
+type Limits struct {
+	DownloadRate int64 // download_rate
+	UploadRate   int64 // upload_rate
+}
+
+// Секция [download]: запросы блоков у пиров
+type Download struct {
+	MaxBacklog  int              // max_backlog: неудовлетворенных запросов к пиру
+	BlockSize   int              // block_size: длина запрашиваемого блока
+	HashWorkers int              // hash_workers: горутин проверки хешей частей (0 - по числу процессоров)
+	Storage     string           // storage: file или mmap (многофайловые торренты все равно хранятся в файлах)
+	Prealloc    storage.Prealloc // prealloc: выделение места под файлы - none, sparse или full
+	CacheSize   int64            // cache_size: кэш записи и чтения частей в памяти (0 - без кэша)
+}
+
+// Секция [timeouts]: таймауты в формате 3s, 1m30s
+type Timeouts struct {
+	Dial      time.Duration // dial: установление соединения с пиром
+	Handshake time.Duration // handshake: рукопожатие с пиром
+	Bitfield  time.Duration // bitfield: ожидание битового поля пира
+	Piece     time.Duration // piece: ожидание части от пира
+	Tracker   time.Duration // tracker: HTTP запрос к трекеру
+}
+
+// Секция [session]: команды serve и daemon
+type Session struct {
+	DataDir       string // data_dir: директория состояния
+	DownloadDir   string // download_dir: директория для скачанных торрентов
+	IncompleteDir string // incomplete_dir: директория незавершенных скачиваний (пустой - сразу download_dir)
+	OnComplete    string // on_complete: команда, запускаемая по завершении скачивания
+	Webhook       string // webhook: адрес, на который отправляются сведения о завершенном скачивании
+	API           string // api: адрес HTTP API (пустой - API выключен)
+	APIToken      string // api_token: токен HTTP API
+	Watch         string // watch: директория, из которой добавляются .torrent и .magnet файлы
+	WatchArchive  string // watch_archive: куда переносятся добавленные файлы (пустой - <watch>/added)
+}
+
+// Настройки по умолчанию
+func Default() *Config {
+	return &Config{
+		Network: Network{
+			Port:           torrentfile.Port,
+			PeerIDPrefix:   DefaultPeerIDPrefix,
+			Encryption:     torrentfile.Encryption,
+			Transport:      torrentfile.Transport,
+			MaxConnections: 50,
+			DHT:            true,
+		},
+		Download: Download{
+			MaxBacklog: download.MaxBacklog,
+			BlockSize:  download.MaxBlockSize,
+			Storage:    "file",
+			CacheSize:  64 << 20,
+		},
+		Timeouts: Timeouts{
+			Dial:      client.DefaultDialTimeout,
+			Handshake: client.DefaultHandshakeTimeout,
+			Bitfield:  client.DefaultBitfieldTimeout,
+			Piece:     download.DefaultPieceTimeout,
+			Tracker:   torrentfile.DefaultTrackerTimeout,
+		},
+		Session: Session{
+			DataDir:     session.DefaultDataDir(),
+			DownloadDir: ".",
+			API:         api.DefaultAddr,
+		},
+	}
+}
+
+// Путь к файлу конфигурации по умолчанию: config.toml в директории состояния
+func DefaultPath() string {
+	return filepath.Join(session.DefaultDataDir(), "config.toml")
+}
+
+// Загрузка настроек: значения по умолчанию, прокси из ALL_PROXY, файл path
+// (пустой путь - без файла) и переменные окружения GOTORRENT_*, каждый
+// следующий источник переопределяет предыдущие. Результат проверяется Validate
+func Load(path string) (*Config, error) {
+	c := Default()
+	var err error
+	c.Network.Proxy, err = proxy.FromEnvironment()
+	if err != nil {
+		return nil, err
+	}
+
+	if path != "" {
+		err = c.loadFile(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = c.loadEnv()
+	if err != nil {
+		return nil, err
+	}
+	return c, c.Validate()
+}
+
+// Применение ключей из файла
+func (c *Config) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	entries, err := parse(f)
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	for _, e := range entries {
+		k := lookup(e.key)
+		if k == nil {
+			return fmt.Errorf("%s: line %d: unknown key %s", path, e.line, e.key)
+		}
+		err = k.set(c, e.value)
+		if err != nil {
+			return fmt.Errorf("%s: line %d: %s: %v", path, e.line, e.key, err)
+		}
+	}
+	return nil
+}
+
+// Применение переменных окружения
+func (c *Config) loadEnv() error {
+	for _, k := range keys {
+		name := EnvName(k.name)
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		err := k.set(c, value)
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// Имя переменной окружения для ключа вида section.key
+func EnvName(key string) string {
+	return EnvPrefix + strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(key))
+}
+
+// Проверка согласованности значений
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, key, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: "+format, append([]any{key}, args...)...))
+		}
+	}
+	check(c.Network.Port != 0, "network.port", "must be between 1 and 65535")
+	check(len(c.Network.PeerIDPrefix) <= 20, "network.peer_id_prefix", "%q is longer than 20 bytes", c.Network.PeerIDPrefix)
+	check(c.Network.MaxConnections >= 0, "network.max_connections", "must not be negative")
+	check(c.Limits.DownloadRate >= 0, "limits.download_rate", "must not be negative")
+	check(c.Limits.UploadRate >= 0, "limits.upload_rate", "must not be negative")
+	check(c.Download.MaxBacklog > 0, "download.max_backlog", "must be positive")
+	check(c.Download.Storage == "file" || c.Download.Storage == "mmap", "download.storage", "expected file or mmap, got %q", c.Download.Storage)
+	check(c.Download.HashWorkers >= 0, "download.hash_workers", "must not be negative")
+	check(c.Download.CacheSize >= 0, "download.cache_size", "must not be negative")
+	check(c.Download.BlockSize > 0 && c.Download.BlockSize <= download.MaxBlockSize,
+		"download.block_size", "must be between 1 and %d", download.MaxBlockSize)
+	for _, t := range []struct {
+		key string
+		d   time.Duration
+	}{
+		{"timeouts.dial", c.Timeouts.Dial},
+		{"timeouts.handshake", c.Timeouts.Handshake},
+		{"timeouts.bitfield", c.Timeouts.Bitfield},
+		{"timeouts.piece", c.Timeouts.Piece},
+		{"timeouts.tracker", c.Timeouts.Tracker},
+	} {
+		check(t.d > 0, t.key, "must be positive")
+	}
+	check(c.Session.DataDir != "", "session.data_dir", "must not be empty")
+	check(c.Session.DownloadDir != "", "session.download_dir", "must not be empty")
+	check(c.Session.IncompleteDir == "" || c.Download.Storage == "file", "session.incomplete_dir", "requires download.storage = \"file\"")
+	check(c.Session.Webhook == "" || strings.HasPrefix(c.Session.Webhook, "http://") || strings.HasPrefix(c.Session.Webhook, "https://"),
+		"session.webhook", "expected http:// or https:// URL, got %q", c.Session.Webhook)
+	check(c.Session.WatchArchive == "" || c.Session.Watch != "", "session.watch_archive", "requires session.watch")
+	return errors.Join(errs...)
+}
+
+// Директория архива наблюдения
+func (c *Config) WatchArchiveDir() string {
+	if c.Session.WatchArchive != "" {
+		return c.Session.WatchArchive
+	}
+	return filepath.Join(c.Session.Watch, "added")
+}
+
+// Хранилище данных торрентов в директории dir
+func (c *Config) NewStorage(dir string) storage.Storage {
+	file := &storage.FileStorage{Dir: dir, Prealloc: c.Download.Prealloc}
+	if c.Download.Storage != "mmap" {
+		return file
+	}
+	mmap := storage.NewMmap(dir)
+	return storage.Selector(func(info storage.Info) storage.Storage {
+		if len(info.Files) > 0 { // Mmap хранит только однофайловые торренты
+			return file
+		}
+		return mmap
+	})
+}
+
+// Обработчики завершения скачивания
+func (c *Config) Hooks() session.Hooks {
+	return session.Hooks{Command: c.Session.OnComplete, Webhook: c.Session.Webhook}
+}
+
+// PeerID из префикса и случайных байт
+func (c *Config) PeerID() ([20]byte, error) {
+	var id [20]byte
+	n := copy(id[:], c.Network.PeerIDPrefix)
+	_, err := rand.Read(id[n:])
+	return id, err
+}
+
+// Параметры соединений с пирами (без ограничителей скорости)
+func (c *Config) ClientOptions() client.Options {
+	return client.Options{
+		Encryption:       c.Network.Encryption,
+		Transport:        c.Network.Transport,
+		Proxy:            c.Network.Proxy,
+		DialTimeout:      c.Timeouts.Dial,
+		HandshakeTimeout: c.Timeouts.Handshake,
+		BitfieldTimeout:  c.Timeouts.Bitfield,
+	}
+}
+
+// Параметры скачивания одного торрента с PeerID peerID
+func (c *Config) DownloadOptions(peerID [20]byte) torrentfile.DownloadOptions {
+	return torrentfile.DownloadOptions{
+		Tracker: torrentfile.TrackerOptions{
+			PeerID:  peerID,
+			Port:    c.Network.Port,
+			Proxy:   c.Network.Proxy,
+			Timeout: c.Timeouts.Tracker,
+		},
+		Client:         c.ClientOptions(),
+		MaxConnections: c.Network.MaxConnections,
+		MaxBacklog:     c.Download.MaxBacklog,
+		BlockSize:      c.Download.BlockSize,
+		PieceTimeout:   c.Timeouts.Piece,
+		HashWorkers:    c.Download.HashWorkers,
+	}
+}
+
+// Параметры сессии с PeerID peerID
+func (c *Config) SessionConfig(peerID [20]byte) session.Config {
+	var st storage.Storage // Файлы по умолчанию хранятся по путям из состояния сессии
+	if c.Download.Storage != "file" {
+		st = c.NewStorage(c.Session.DownloadDir)
+	}
+	return session.Config{
+		DataDir:        c.Session.DataDir,
+		DownloadDir:    c.Session.DownloadDir,
+		IncompleteDir:  c.Session.IncompleteDir,
+		Hooks:          c.Hooks(),
+		Port:           c.Network.Port,
+		DHT:            c.Network.DHT && c.Network.Proxy == nil, // Пакеты DHT не идут через прокси
+		MaxConnections: c.Network.MaxConnections,
+		DownloadRate:   c.Limits.DownloadRate,
+		UploadRate:     c.Limits.UploadRate,
+		Client:         c.ClientOptions(),
+		TrackerTimeout: c.Timeouts.Tracker,
+		MaxBacklog:     c.Download.MaxBacklog,
+		BlockSize:      c.Download.BlockSize,
+		PieceTimeout:   c.Timeouts.Piece,
+		HashWorkers:    c.Download.HashWorkers,
+		Storage:        st,
+		Prealloc:       c.Download.Prealloc,
+		CacheSize:      c.Download.CacheSize,
+		PeerID:         peerID,
+	}
+}
+
+// Ключ файла конфигурации
+type key struct {
+	name string
+	set  func(c *Config, value string) error
+}
+
+// Все ключи в порядке секций
+var keys = []key{
+	{"network.port", func(c *Config, v string) error {
+		n, err := strconv.ParseUint(v, 10, 16)
+		if err != nil || n == 0 {
+			return fmt.Errorf("expected a port between 1 and 65535, got %q", v)
+		}
+		c.Network.Port = uint16(n)
+		return nil
+	}},
+	{"network.peer_id_prefix", func(c *Config, v string) error {
+		c.Network.PeerIDPrefix = v
+		return nil
+	}},
+	{"network.encryption", func(c *Config, v string) (err error) {
+		c.Network.Encryption, err = mse.ParsePolicy(v)
+		return err
+	}},
+	{"network.transport", func(c *Config, v string) (err error) {
+		c.Network.Transport, err = client.ParseTransport(v)
+		return err
+	}},
+	{"network.proxy", func(c *Config, v string) (err error) {
+		c.Network.Proxy = nil
+		if v != "" {
+			c.Network.Proxy, err = proxy.Parse(v)
+		}
+		return err
+	}},
+	{"network.max_connections", intKey(func(c *Config) *int { return &c.Network.MaxConnections })},
+	{"network.dht", boolKey(func(c *Config) *bool { return &c.Network.DHT })},
+	{"limits.download_rate", sizeKey(func(c *Config) *int64 { return &c.Limits.DownloadRate })},
+	{"limits.upload_rate", sizeKey(func(c *Config) *int64 { return &c.Limits.UploadRate })},
+	{"download.max_backlog", intKey(func(c *Config) *int { return &c.Download.MaxBacklog })},
+	{"download.block_size", func(c *Config, v string) error {
+		n, err := ParseSize(v)
+		c.Download.BlockSize = int(n)
+		return err
+	}},
+	{"download.storage", stringKey(func(c *Config) *string { return &c.Download.Storage })},
+	{"download.prealloc", func(c *Config, v string) (err error) {
+		c.Download.Prealloc, err = storage.ParsePrealloc(v)
+		return err
+	}},
+	{"download.hash_workers", intKey(func(c *Config) *int { return &c.Download.HashWorkers })},
+	{"download.cache_size", sizeKey(func(c *Config) *int64 { return &c.Download.CacheSize })},
+	{"timeouts.dial", durationKey(func(c *Config) *time.Duration { return &c.Timeouts.Dial })},
+	{"timeouts.handshake", durationKey(func(c *Config) *time.Duration { return &c.Timeouts.Handshake })},
+	{"timeouts.bitfield", durationKey(func(c *Config) *time.Duration { return &c.Timeouts.Bitfield })},
+	{"timeouts.piece", durationKey(func(c *Config) *time.Duration { return &c.Timeouts.Piece })},
+	{"timeouts.tracker", durationKey(func(c *Config) *time.Duration { return &c.Timeouts.Tracker })},
+	{"session.data_dir", stringKey(func(c *Config) *string { return &c.Session.DataDir })},
+	{"session.download_dir", stringKey(func(c *Config) *string { return &c.Session.DownloadDir })},
+	{"session.incomplete_dir", stringKey(func(c *Config) *string { return &c.Session.IncompleteDir })},
+	{"session.on_complete", stringKey(func(c *Config) *string { return &c.Session.OnComplete })},
+	{"session.webhook", stringKey(func(c *Config) *string { return &c.Session.Webhook })},
+	{"session.api", stringKey(func(c *Config) *string { return &c.Session.API })},
+	{"session.api_token", stringKey(func(c *Config) *string { return &c.Session.APIToken })},
+	{"session.watch", stringKey(func(c *Config) *string { return &c.Session.Watch })},
+	{"session.watch_archive", stringKey(func(c *Config) *string { return &c.Session.WatchArchive })},
+}
+
+// Поиск ключа по имени
+func lookup(name string) *key {
+	for i := range keys {
+		if keys[i].name == name {
+			return &keys[i]
+		}
+	}
+	return nil
+}
+
+func stringKey(field func(c *Config) *string) func(c *Config, v string) error {
+	return func(c *Config, v string) error {
+		*field(c) = v
+		return nil
+	}
+}
+
+func intKey(field func(c *Config) *int) func(c *Config, v string) error {
+	return func(c *Config, v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("expected an integer, got %q", v)
+		}
+		*field(c) = n
+		return nil
+	}
+}
+
+func boolKey(field func(c *Config) *bool) func(c *Config, v string) error {
+	return func(c *Config, v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("expected true or false, got %q", v)
+		}
+		*field(c) = b
+		return nil
+	}
+}
+
+func sizeKey(field func(c *Config) *int64) func(c *Config, v string) error {
+	return func(c *Config, v string) (err error) {
+		*field(c), err = ParseSize(v)
+		return err
+	}
+}
+
+func durationKey(field func(c *Config) *time.Duration) func(c *Config, v string) error {
+	return func(c *Config, v string) error {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("expected a duration like 30s, got %q", v)
+		}
+		*field(c) = d
+		return nil
+	}
+}
+
+// Разбор количества байт: число с необязательным суффиксом K, M или G (степени 1024)
+func ParseSize(value string) (int64, error) {
+	s := value
+	multiplier := int64(1)
+	switch {
+	case strings.HasSuffix(s, "K"):
+		multiplier = 1 << 10
+	case strings.HasSuffix(s, "M"):
+		multiplier = 1 << 20
+	case strings.HasSuffix(s, "G"):
+		multiplier = 1 << 30
+	}
+	if multiplier > 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("expected a non-negative size like 512K, got %q", value)
+	}
+	if n > math.MaxInt64/multiplier {
+		return 0, fmt.Errorf("size %q does not fit in 64 bits", value)
+	}
+	return n * multiplier, nil
+}
@@ -0,0 +1,197 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/swesdek/gotorrent-client/mse"
+	"github.com/swesdek/gotorrent-client/storage"
+)
+
+// Файл конфигурации с содержимым text во временной директории. Переменные
+// окружения GOTORRENT_* и прокси сбрасываются на время теста
+func writeConfig(t *testing.T, text string) string {
+	t.Helper()
+	for _, env := range os.Environ() {
+		name, _, _ := strings.Cut(env, "=")
+		if strings.HasPrefix(name, EnvPrefix) {
+			t.Setenv(name, "")
+			os.Unsetenv(name)
+		}
+	}
+	t.Setenv("ALL_PROXY", "")
+	t.Setenv("all_proxy", "")
+	path := filepath.Join(t.TempDir(), "config.toml")
+	err := os.WriteFile(path, []byte(text), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadFile(t *testing.T) {
+	path := writeConfig(t, `
+# Комментарий
+[network]
+port = 51413
+encryption = "required"   # Комментарий после значения
+peer_id_prefix = "-XX#1-"
+
+[limits]
+download_rate = "2M"
+upload_rate = 512K
+
+[download]
+prealloc = "sparse"
+storage = "mmap"
+
+[timeouts]
+piece = "1m30s"
+
+[session]
+webhook = "https://example.com/hook"
+`)
+	c, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Network.Port != 51413 || c.Network.Encryption != mse.PolicyRequired || c.Network.PeerIDPrefix != "-XX#1-" {
+		t.Errorf("network %+v", c.Network)
+	}
+	if c.Limits.DownloadRate != 2<<20 || c.Limits.UploadRate != 512<<10 {
+		t.Errorf("limits %+v", c.Limits)
+	}
+	if c.Download.Prealloc != storage.PreallocSparse || c.Download.Storage != "mmap" {
+		t.Errorf("download %+v", c.Download)
+	}
+	if c.Timeouts.Piece != 90*time.Second || c.Timeouts.Dial != Default().Timeouts.Dial {
+		t.Errorf("timeouts %+v", c.Timeouts)
+	}
+	if c.Session.Webhook != "https://example.com/hook" || c.Session.DownloadDir != "." {
+		t.Errorf("session %+v", c.Session)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"port = 1", "line 1: unknown key port"}, // Ключи есть только в секциях
+		{"[network]\nports = 1", "line 2: unknown key network.ports"},
+		{"[network\nport = 1", "line 1: unterminated section header"},
+		{"[net work]", "invalid section name"},
+		{"[network]\nport", "line 2: expected key = value"},
+		{"[network]\nport =", "missing value"},
+		{"[session]\napi = localhost:1", ""}, // Строка без кавычек допускается, если в ней нет пробелов
+		{"[session]\napi = local host", "strings must be quoted"},
+		{"[session]\napi = \"unterminated", "invalid string"},
+		{"[network]\nport = 1\nport = 2", "line 3: network.port is already set on line 2"},
+		{"[network]\nport = 70000", "line 2: network.port: expected a port"},
+		{"[network]\ndht = maybe", "expected true or false"},
+		{"[timeouts]\ndial = 5", "expected a duration"},
+		{"[limits]\nupload_rate = 1T", "expected a non-negative size"},
+	}
+	for _, tt := range tests {
+		_, err := Load(writeConfig(t, tt.text))
+		if tt.want == "" {
+			if err != nil {
+				t.Errorf("%q: %v", tt.text, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%q: error %v, want %q", tt.text, err, tt.want)
+		}
+	}
+}
+
+func TestLoadEnv(t *testing.T) {
+	path := writeConfig(t, "[limits]\ndownload_rate = 1M\n\n[network]\ndht = true\n")
+	t.Setenv(EnvName("limits.download_rate"), "256K") // Окружение важнее файла
+	t.Setenv(EnvName("network.dht"), "false")
+	t.Setenv(EnvName("session.api_token"), "secret")
+	c, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Limits.DownloadRate != 256<<10 || c.Network.DHT || c.Session.APIToken != "secret" {
+		t.Errorf("limits %+v, dht %v, token %q", c.Limits, c.Network.DHT, c.Session.APIToken)
+	}
+
+	t.Setenv(EnvName("download.max_backlog"), "many")
+	if _, err = Load(path); err == nil || !strings.Contains(err.Error(), "GOTORRENT_DOWNLOAD_MAX_BACKLOG") {
+		t.Errorf("invalid variable: %v", err)
+	}
+	if name := EnvName("network.peer_id_prefix"); name != "GOTORRENT_NETWORK_PEER_ID_PREFIX" {
+		t.Errorf("EnvName = %s", name)
+	}
+}
+
+func TestValidateJoinsErrors(t *testing.T) {
+	c := Default()
+	if err := c.Validate(); err != nil {
+		t.Fatalf("defaults: %v", err)
+	}
+	c.Network.MaxConnections = -1
+	c.Download.BlockSize = 1 << 20
+	c.Download.Storage = "tape"
+	c.Timeouts.Tracker = 0
+	c.Session.Webhook = "ftp://example.com"
+	c.Session.WatchArchive = "archive"
+
+	err := c.Validate()
+	var joined interface{ Unwrap() []error }
+	if !errors.As(err, &joined) {
+		t.Fatalf("Validate returned %v, want joined errors", err)
+	}
+	want := []string{
+		"network.max_connections",
+		"download.storage",
+		"download.block_size",
+		"timeouts.tracker",
+		"session.webhook",
+		"session.watch_archive",
+	}
+	var got []string
+	for _, e := range joined.Unwrap() {
+		key, _, _ := strings.Cut(e.Error(), ":")
+		got = append(got, key)
+	}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("errors for %v, want %v", got, want)
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int64
+		ok    bool
+	}{
+		{"0", 0, true},
+		{"1024", 1024, true},
+		{"512K", 512 << 10, true},
+		{"2M", 2 << 20, true},
+		{"8G", 8 << 30, true},
+		{"9223372036854775807", 1<<63 - 1, true},
+		{"8589934591G", 8589934591 << 30, true}, // Наибольшее число гигабайт
+		{"8589934592G", 0, false},               // 2^63 байт
+		{"9007199254740992M", 0, false},
+		{"99999999999999999K", 0, false},
+		{"-1K", 0, false},
+		{"K", 0, false},
+		{"1.5M", 0, false},
+		{"1m", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseSize(tt.value)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("ParseSize(%q) = %d, %v", tt.value, got, err)
+		}
+	}
+}
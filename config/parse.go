@@ -0,0 +1,115 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Значение из файла конфигурации
+type entry struct {
+	line  int    // Номер строки для сообщений об ошибках
+	key   string // Полное имя вида section.key
+	value string // Значение без кавычек
+}
+
+// Разбор подмножества TOML: секции [name], пары key = value, строки в двойных
+// кавычках, числа, true/false и комментарии после #
+func parse(r io.Reader) ([]entry, error) {
+	var entries []entry
+	seen := make(map[string]int)
+	section := ""
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(stripComment(scanner.Text()))
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				return nil, fmt.Errorf("line %d: unterminated section header", n)
+			}
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			if !isName(section) {
+				return nil, fmt.Errorf("line %d: invalid section name %q", n, section)
+			}
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key = value", n)
+		}
+		key = strings.TrimSpace(key)
+		if !isName(key) {
+			return nil, fmt.Errorf("line %d: invalid key %q", n, key)
+		}
+		if section != "" {
+			key = section + "." + key
+		}
+		value, err := parseValue(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s: %v", n, key, err)
+		}
+		if prev, ok := seen[key]; ok {
+			return nil, fmt.Errorf("line %d: %s is already set on line %d", n, key, prev)
+		}
+		seen[key] = n
+		entries = append(entries, entry{line: n, key: key, value: value})
+	}
+	return entries, scanner.Err()
+}
+
+// Строка без комментария. Символ # внутри кавычек комментарием не считается
+func stripComment(line string) string {
+	quoted := false
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			if quoted {
+				i++
+			}
+		case '"':
+			quoted = !quoted
+		case '#':
+			if !quoted {
+				return line[:i]
+			}
+		}
+	}
+	return line
+}
+
+// Значение: строка в кавычках или число/логическое значение без них
+func parseValue(value string) (string, error) {
+	if value == "" {
+		return "", fmt.Errorf("missing value")
+	}
+	if strings.HasPrefix(value, `"`) {
+		s, err := strconv.Unquote(value)
+		if err != nil {
+			return "", fmt.Errorf("invalid string %s", value)
+		}
+		return s, nil
+	}
+	if strings.ContainsAny(value, " \t\"'[]{}") {
+		return "", fmt.Errorf("unsupported value %s (strings must be quoted)", value)
+	}
+	return value, nil
+}
+
+// Допустимое имя секции или ключа
+func isName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/swesdek/gotorrent-client/torrentfile"
+)
+
+// Команда create: создание .torrent файла из файла или директории
+func create(args []string) error {
+	fs := flag.NewFlagSet("create", flag.ExitOnError)
+	output := fs.String("o", "", "path of the .torrent file to write (default: <name>.torrent next to the data)")
+	comment := fs.String("c", "", "comment")
+	createdBy := fs.String("created-by", "gotorrent-client", "value of the \"created by\" field")
+	pieceLength := fs.Int("l", 0, "piece length in bytes (default: chosen automatically)")
+	private := fs.Bool("p", false, "mark the torrent as private")
+	var trackers, webSeeds stringList
+	fs.Var(&trackers, "a", "tracker URL; repeat for several tiers, separate trackers of one tier with commas")
+	fs.Var(&webSeeds, "w", "web seed URL; may be repeated")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: gotorrent-client create [flags] path")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return usageError(fs, "Expected exactly one path")
+	}
+
+	opts := torrentfile.CreateOptions{
+		Path:        fs.Arg(0),
+		Comment:     *comment,
+		CreatedBy:   *createdBy,
+		PieceLength: *pieceLength,
+		Private:     *private,
+		WebSeeds:    webSeeds,
+	}
+	for _, tier := range trackers {
+		opts.AnnounceList = append(opts.AnnounceList, strings.Split(tier, ","))
+	}
+	if len(opts.AnnounceList) == 1 && len(opts.AnnounceList[0]) == 1 {
+		opts.Announce = opts.AnnounceList[0][0] // Одного трекера достаточно в поле announce
+		opts.AnnounceList = nil
+	}
+
+	outPath := *output
+	if outPath == "" { // Рядом с данными, а не внутри директории: для "." это ../<имя>.torrent
+		path, err := filepath.Abs(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		outPath = path + ".torrent"
+	}
+
+	tf, err := torrentfile.CreateFile(opts, outPath)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Created %s (info hash %x, %d pieces)\n", outPath, tf.InfoHash, len(tf.PieceHashes))
+	return nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+)
+
+// Команда daemon: запуск serve в фоновом процессе. Вывод процесса пишется
+// в daemon.log, а его PID - в daemon.pid в директории состояния
+func daemon(args []string) error {
+	fs := flag.NewFlagSet("daemon", flag.ExitOnError)
+	sf := addSessionFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: gotorrent-client daemon [flags] [file.torrent...]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	err := sf.validate(fs)
+	if err != nil {
+		return err
+	}
+	cfg, err := sf.load(fs)
+	if err != nil {
+		return err
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(cfg.Session.DataDir, 0o755)
+	if err != nil {
+		return err
+	}
+	logFile, err := os.OpenFile(filepath.Join(cfg.Session.DataDir, "daemon.log"), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+
+	cmd := exec.Command(exe, append([]string{"serve"}, args...)...)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.SysProcAttr = detachedProcAttr() // Процесс не завершается вместе с терминалом
+	err = cmd.Start()
+	if err != nil {
+		return err
+	}
+
+	pidPath := filepath.Join(cfg.Session.DataDir, "daemon.pid")
+	err = os.WriteFile(pidPath, []byte(strconv.Itoa(cmd.Process.Pid)+"\n"), 0o644)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Started daemon with PID %d, log in %s\n", cmd.Process.Pid, logFile.Name())
+	return cmd.Process.Release()
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "syscall"
+
+// Атрибуты фонового процесса: на системах без setsid используются значения по умолчанию
+func detachedProcAttr() *syscall.SysProcAttr {
+	return nil
+}
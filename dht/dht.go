@@ -0,0 +1,350 @@
+// Пакет dht: узел сети DHT (BEP 5) для поиска пиров торрентов без трекера.
+// Узел отвечает на запросы других узлов, хранит анонсированных у него
+// пиров и выполняет итеративный поиск get_peers с анонсом announce_peer
+package dht
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/swesdek/gotorrent-client/bencode"
+)
+
+// Начальные узлы по умолчанию
+var DefaultBootstrap = []string{
+	"router.bittorrent.com:6881",
+	"router.utorrent.com:6881",
+	"dht.transmissionbt.com:6881",
+}
+
+// Ожидание ответа на запрос по умолчанию
+const DefaultTimeout = 2 * time.Second
+
+const (
+	tokenInterval = 5 * time.Minute  // Смена секрета токенов announce_peer
+	peerTTL       = 30 * time.Minute // Хранение анонсированного пира
+	maxPeers      = 100              // Пиров одного торрента в ответе get_peers
+)
+
+// Ошибка запроса к закрытому узлу
+var ErrClosed = errors.New("DHT node is closed")
+
+// Параметры узла
+type Options struct {
+	ID        [20]byte      // Идентификатор узла (нулевой - случайный)
+	Bootstrap []string      // Адреса host:port начальных узлов (nil - DefaultBootstrap)
+	Timeout   time.Duration // Ожидание ответа на запрос (0 - DefaultTimeout)
+}
+
+// Запрос, ожидающий ответа
+type transaction struct {
+	addr  string // Адрес узла, от которого ожидается ответ
+	reply chan *message
+}
+
+// Анонсированный у узла пир
+type storedPeer struct {
+	peer    string // Компактный формат
+	expires time.Time
+}
+
+// Узел DHT на UDP сокете
+type Server struct {
+	pc        net.PacketConn
+	id        [20]byte
+	bootstrap []string
+	timeout   time.Duration
+
+	mu      sync.Mutex
+	table   table
+	pending map[string]transaction // Ожидающие ответа запросы по идентификатору транзакции
+	nextTx  uint16
+	peers   map[[20]byte]map[string]storedPeer // Анонсированные пиры по хешу торрента
+	secrets [2][8]byte                         // Текущий и предыдущий секреты токенов
+	rotated time.Time
+
+	closed chan struct{}
+	once   sync.Once
+}
+
+// Запуск узла на сокете pc. Сокет закрывается вместе с узлом
+func New(pc net.PacketConn, opts Options) (*Server, error) {
+	s := &Server{
+		pc:        pc,
+		id:        opts.ID,
+		bootstrap: opts.Bootstrap,
+		timeout:   opts.Timeout,
+		pending:   make(map[string]transaction),
+		peers:     make(map[[20]byte]map[string]storedPeer),
+		rotated:   time.Now(),
+		closed:    make(chan struct{}),
+	}
+	if s.bootstrap == nil {
+		s.bootstrap = DefaultBootstrap
+	}
+	if s.timeout == 0 {
+		s.timeout = DefaultTimeout
+	}
+	if s.id == [20]byte{} {
+		_, err := rand.Read(s.id[:])
+		if err != nil {
+			return nil, err
+		}
+	}
+	_, err := rand.Read(s.secrets[0][:])
+	if err != nil {
+		return nil, err
+	}
+	s.secrets[1] = s.secrets[0]
+	s.table.own = s.id
+	go s.readLoop()
+	return s, nil
+}
+
+// Идентификатор узла
+func (s *Server) ID() [20]byte {
+	return s.id
+}
+
+// Адрес, на котором узел принимает запросы
+func (s *Server) Addr() net.Addr {
+	return s.pc.LocalAddr()
+}
+
+// Количество узлов в таблице маршрутизации
+func (s *Server) Nodes() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.table.len()
+}
+
+// Остановка узла
+func (s *Server) Close() error {
+	var err error
+	s.once.Do(func() {
+		close(s.closed)
+		err = s.pc.Close()
+	})
+	return err
+}
+
+// Чтение и обработка входящих сообщений
+func (s *Server) readLoop() {
+	buf := make([]byte, 65536)
+	for {
+		n, addr, err := s.pc.ReadFrom(buf)
+		if err != nil {
+			s.Close()
+			return
+		}
+		udpAddr, ok := addr.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+		var msg message
+		if bencode.Unmarshal(buf[:n], &msg) != nil {
+			continue // Посторонние и поврежденные пакеты игнорируются
+		}
+		switch msg.Y {
+		case "q":
+			s.handleQuery(udpAddr, &msg)
+		case "r", "e":
+			s.mu.Lock()
+			tx, ok := s.pending[msg.T]
+			if ok && tx.addr == udpAddr.String() { // Ответ с чужого адреса не принимается
+				delete(s.pending, msg.T)
+			} else {
+				ok = false
+			}
+			s.mu.Unlock()
+			if ok {
+				tx.reply <- &msg
+			}
+		}
+	}
+}
+
+// Отправка сообщения
+func (s *Server) send(addr *net.UDPAddr, msg *message) error {
+	data, err := bencode.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = s.pc.WriteTo(data, addr)
+	return err
+}
+
+// Запрос q к узлу по адресу addr с ожиданием ответа. Ответивший узел
+// добавляется в таблицу маршрутизации
+func (s *Server) query(ctx context.Context, addr *net.UDPAddr, q string, args *arguments) (*response, error) {
+	args.ID = string(s.id[:])
+	reply := make(chan *message, 1)
+	s.mu.Lock()
+	s.nextTx++
+	tx := string(binary.BigEndian.AppendUint16(nil, s.nextTx))
+	s.pending[tx] = transaction{addr.String(), reply}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.pending, tx)
+		s.mu.Unlock()
+	}()
+
+	err := s.send(addr, &message{T: tx, Y: "q", Q: q, A: args})
+	if err != nil {
+		return nil, err
+	}
+	timer := time.NewTimer(s.timeout)
+	defer timer.Stop()
+	select {
+	case msg := <-reply:
+		if msg.Y == "e" {
+			return nil, parseError(msg.E)
+		}
+		if msg.R == nil || len(msg.R.ID) != 20 {
+			return nil, &Error{Code: errProtocol, Message: "malformed response"}
+		}
+		var n node
+		copy(n.id[:], msg.R.ID)
+		n.addr = addr
+		s.mu.Lock()
+		s.table.insert(n, time.Now())
+		s.mu.Unlock()
+		return msg.R, nil
+	case <-timer.C:
+		return nil, context.DeadlineExceeded
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-s.closed:
+		return nil, ErrClosed
+	}
+}
+
+// Ответ на запрос другого узла
+func (s *Server) handleQuery(addr *net.UDPAddr, msg *message) {
+	fail := func(code int, text string) {
+		s.send(addr, &message{T: msg.T, Y: "e", E: []interface{}{code, text}})
+	}
+	if msg.A == nil || len(msg.A.ID) != 20 {
+		fail(errProtocol, "missing id")
+		return
+	}
+	var n node
+	copy(n.id[:], msg.A.ID)
+	n.addr = addr
+
+	r := &response{ID: string(s.id[:])}
+	switch msg.Q {
+	case "ping":
+	case "find_node":
+		if len(msg.A.Target) != 20 {
+			fail(errProtocol, "malformed target")
+			return
+		}
+		r.Nodes = s.closestNodes(msg.A.Target)
+	case "get_peers":
+		if len(msg.A.InfoHash) != 20 {
+			fail(errProtocol, "malformed info_hash")
+			return
+		}
+		r.Token = s.token(addr.IP, 0)
+		r.Values = s.storedPeers([20]byte([]byte(msg.A.InfoHash)))
+		if len(r.Values) == 0 {
+			r.Nodes = s.closestNodes(msg.A.InfoHash)
+		}
+	case "announce_peer":
+		if len(msg.A.InfoHash) != 20 {
+			fail(errProtocol, "malformed info_hash")
+			return
+		}
+		if msg.A.Token != s.token(addr.IP, 0) && msg.A.Token != s.token(addr.IP, 1) {
+			fail(errProtocol, "bad token")
+			return
+		}
+		port := msg.A.Port
+		if msg.A.ImpliedPort != 0 {
+			port = addr.Port
+		}
+		if port <= 0 || port > 65535 {
+			fail(errProtocol, "bad port")
+			return
+		}
+		s.storePeer([20]byte([]byte(msg.A.InfoHash)), addr.IP, port)
+	default:
+		fail(errMethod, "method unknown")
+		return
+	}
+
+	s.mu.Lock()
+	s.table.insert(n, time.Now())
+	s.mu.Unlock()
+	s.send(addr, &message{T: msg.T, Y: "r", R: r})
+}
+
+// Ближайшие к target узлы в компактном формате
+func (s *Server) closestNodes(target string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return encodeNodes(s.table.closest([20]byte([]byte(target)), bucketSize))
+}
+
+// Токен для announce_peer с адреса ip на секрете which (0 - текущий,
+// 1 - предыдущий). Секрет периодически меняется, и токен действует
+// от одной до двух смен
+func (s *Server) token(ip net.IP, which int) string {
+	s.mu.Lock()
+	if time.Since(s.rotated) > tokenInterval {
+		s.secrets[1] = s.secrets[0]
+		rand.Read(s.secrets[0][:])
+		s.rotated = time.Now()
+	}
+	secret := s.secrets[which]
+	s.mu.Unlock()
+	h := sha1.New()
+	h.Write(secret[:])
+	h.Write(ip.To16())
+	return string(h.Sum(nil)[:8])
+}
+
+// Сохранение анонсированного пира
+func (s *Server) storePeer(infoHash [20]byte, ip net.IP, port int) {
+	peer, ok := encodePeer(ip, port)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored := s.peers[infoHash]
+	if stored == nil {
+		stored = make(map[string]storedPeer)
+		s.peers[infoHash] = stored
+	}
+	stored[peer] = storedPeer{peer, time.Now().Add(peerTTL)}
+}
+
+// Не более maxPeers анонсированных пиров торрента. Устаревшие удаляются
+func (s *Server) storedPeers(infoHash [20]byte) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	var values []string
+	for key, p := range s.peers[infoHash] {
+		if now.After(p.expires) {
+			delete(s.peers[infoHash], key)
+			continue
+		}
+		if len(values) < maxPeers {
+			values = append(values, p.peer)
+		}
+	}
+	if len(s.peers[infoHash]) == 0 {
+		delete(s.peers, infoHash)
+	}
+	return values
+}
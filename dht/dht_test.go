@@ -0,0 +1,171 @@
+package dht
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// Узел на случайном порту 127.0.0.1 с начальными узлами bootstrap
+func newTestServer(t *testing.T, bootstrap ...string) *Server {
+	t.Helper()
+	pc, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bootstrap == nil {
+		bootstrap = []string{} // Без обращения к внешним узлам
+	}
+	s, err := New(pc, Options{Bootstrap: bootstrap, Timeout: 500 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// Сеть из n узлов, загрузившихся через первый
+func testNetwork(t *testing.T, n int) []*Server {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	servers := []*Server{newTestServer(t)}
+	for i := 1; i < n; i++ {
+		s := newTestServer(t, servers[0].Addr().String())
+		err := s.Bootstrap(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		servers = append(servers, s)
+	}
+	return servers
+}
+
+func TestAnnounceAndGetPeers(t *testing.T) {
+	servers := testNetwork(t, 10)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	hash := [20]byte{1, 2, 3}
+
+	found, err := servers[3].Announce(ctx, hash, 6881)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 0 {
+		t.Errorf("peers %v before any announce", found)
+	}
+
+	found, err = servers[8].GetPeers(ctx, hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 || found[0].String() != "127.0.0.1:6881" {
+		t.Fatalf("found %v, want 127.0.0.1:6881", found)
+	}
+	if found[0].Source.String() != "dht" {
+		t.Errorf("source %v", found[0].Source)
+	}
+	for i, s := range servers {
+		if s.Nodes() == 0 {
+			t.Errorf("node %d has an empty routing table", i)
+		}
+	}
+}
+
+func TestQueryErrors(t *testing.T) {
+	a, b := newTestServer(t), newTestServer(t)
+	ctx := context.Background()
+	addr := b.Addr().(*net.UDPAddr)
+
+	_, err := a.query(ctx, addr, "vote", &arguments{})
+	var dhtErr *Error
+	if !errors.As(err, &dhtErr) || dhtErr.Code != errMethod {
+		t.Errorf("unknown method: %v", err)
+	}
+
+	hash := string(make([]byte, 20))
+	_, err = a.query(ctx, addr, "announce_peer", &arguments{InfoHash: hash, Port: 1, Token: "forged"})
+	if !errors.As(err, &dhtErr) || dhtErr.Code != errProtocol {
+		t.Errorf("forged token: %v", err)
+	}
+
+	r, err := a.query(ctx, addr, "get_peers", &arguments{InfoHash: hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = a.query(ctx, addr, "announce_peer", &arguments{InfoHash: hash, ImpliedPort: 1, Token: r.Token})
+	if err != nil {
+		t.Errorf("announce with token: %v", err)
+	}
+	if peers := decodePeers(b.storedPeers([20]byte{})); len(peers) != 1 || int(peers[0].Port) != a.Addr().(*net.UDPAddr).Port {
+		t.Errorf("implied port announce stored %v", peers)
+	}
+
+	b.Close()
+	_, err = a.query(ctx, addr, "ping", &arguments{})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("closed node: %v", err)
+	}
+	if _, err = a.GetPeers(ctx, [20]byte{}); !errors.Is(err, ErrNoNodes) {
+		t.Errorf("lookup without nodes: %v", err)
+	}
+}
+
+func TestTable(t *testing.T) {
+	tab := table{own: [20]byte{0x80}}
+	now := time.Now()
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}
+	for i := 0; i < bucketSize+2; i++ { // Все узлы попадают в корзину 0
+		tab.insert(node{id: [20]byte{0, byte(i)}, addr: addr}, now)
+	}
+	if n := tab.len(); n != bucketSize {
+		t.Fatalf("%d nodes in a full bucket, want %d", n, bucketSize)
+	}
+	tab.insert(node{id: [20]byte{0x80}, addr: addr}, now) // Собственный идентификатор
+	tab.insert(node{id: [20]byte{0xc0}, addr: addr}, now)
+	if n := tab.len(); n != bucketSize+1 {
+		t.Errorf("%d nodes, want %d", n, bucketSize+1)
+	}
+
+	// Давно молчащий узел заменяется новым
+	tab.insert(node{id: [20]byte{0, 0}, addr: addr}, now.Add(-time.Hour))
+	tab.insert(node{id: [20]byte{0, 200}, addr: addr}, now)
+	closest := tab.closest([20]byte{0, 200}, 2)
+	if len(closest) != 2 || closest[0].id != [20]byte{0, 200} {
+		t.Errorf("closest = %v", closest)
+	}
+	for _, n := range tab.closest([20]byte{}, bucketSize+1) {
+		if n.id == [20]byte{0, 0} {
+			t.Error("stale node kept in a full bucket")
+		}
+	}
+
+	tab.remove([20]byte{0xc0})
+	if n := tab.len(); n != bucketSize {
+		t.Errorf("%d nodes after remove", n)
+	}
+}
+
+func TestCompactNodes(t *testing.T) {
+	nodes := []node{
+		{id: [20]byte{1}, addr: &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 6881}},
+		{id: [20]byte{2}, addr: &net.UDPAddr{IP: net.ParseIP("::1"), Port: 6881}}, // IPv6 пропускается
+		{id: [20]byte{3}, addr: &net.UDPAddr{IP: net.IPv4(192, 168, 1, 2), Port: 51413}},
+	}
+	encoded := encodeNodes(nodes)
+	if len(encoded) != 2*compactNodeSize {
+		t.Fatalf("encoded length %d", len(encoded))
+	}
+	decoded, err := decodeNodes(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != 2 || decoded[0].id != nodes[0].id || decoded[1].addr.String() != "192.168.1.2:51413" {
+		t.Errorf("decoded %v", decoded)
+	}
+	if _, err = decodeNodes(encoded[1:]); err == nil {
+		t.Error("truncated nodes accepted")
+	}
+}
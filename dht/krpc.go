@@ -0,0 +1,142 @@
+package dht
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+
+	"github.com/swesdek/gotorrent-client/peers"
+)
+
+// Коды ошибок KRPC
+const (
+	errGeneric  = 201
+	errProtocol = 203
+	errMethod   = 204
+)
+
+// Сообщение KRPC: запрос (y=q), ответ (y=r) или ошибка (y=e)
+type message struct {
+	T string        `bencode:"t"`
+	Y string        `bencode:"y"`
+	Q string        `bencode:"q,omitempty"`
+	A *arguments    `bencode:"a,omitempty"`
+	R *response     `bencode:"r,omitempty"`
+	E []interface{} `bencode:"e,omitempty"` // Код и текст ошибки
+}
+
+// Аргументы запроса
+type arguments struct {
+	ID          string `bencode:"id"`
+	Target      string `bencode:"target,omitempty"`    // find_node
+	InfoHash    string `bencode:"info_hash,omitempty"` // get_peers, announce_peer
+	Port        int    `bencode:"port,omitempty"`
+	ImpliedPort int    `bencode:"implied_port,omitempty"` // 1 - порт пира равен порту отправителя
+	Token       string `bencode:"token,omitempty"`
+}
+
+// Ответ на запрос
+type response struct {
+	ID     string   `bencode:"id"`
+	Nodes  string   `bencode:"nodes,omitempty"`  // Узлы в компактном формате
+	Values []string `bencode:"values,omitempty"` // Пиры в компактном формате
+	Token  string   `bencode:"token,omitempty"`
+}
+
+// Ошибка, полученная от узла
+type Error struct {
+	Code    int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("DHT error %d: %s", e.Code, e.Message)
+}
+
+// Разбор ошибки из поля e сообщения
+func parseError(e []interface{}) *Error {
+	err := &Error{Code: errGeneric}
+	if len(e) > 0 {
+		if code, ok := e[0].(int64); ok {
+			err.Code = int(code)
+		}
+	}
+	if len(e) > 1 {
+		if msg, ok := e[1].(string); ok {
+			err.Message = msg
+		}
+	}
+	return err
+}
+
+// Узел сети DHT
+type node struct {
+	id   [20]byte
+	addr *net.UDPAddr
+}
+
+// Размер узла в компактном формате: идентификатор, IPv4 адрес и порт
+const compactNodeSize = 26
+
+// Узлы в компактном формате. Узлы без IPv4 адреса пропускаются
+func encodeNodes(nodes []node) string {
+	buf := make([]byte, 0, len(nodes)*compactNodeSize)
+	for _, n := range nodes {
+		ip := n.addr.IP.To4()
+		if ip == nil {
+			continue
+		}
+		buf = append(buf, n.id[:]...)
+		buf = append(buf, ip...)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(n.addr.Port))
+	}
+	return string(buf)
+}
+
+// Разбор узлов в компактном формате
+func decodeNodes(s string) ([]node, error) {
+	if len(s)%compactNodeSize != 0 {
+		return nil, fmt.Errorf("Malformed compact node info of length %d", len(s))
+	}
+	nodes := make([]node, 0, len(s)/compactNodeSize)
+	for i := 0; i < len(s); i += compactNodeSize {
+		var n node
+		copy(n.id[:], s[i:i+20])
+		n.addr = &net.UDPAddr{
+			IP:   net.IP([]byte(s[i+20 : i+24])),
+			Port: int(binary.BigEndian.Uint16([]byte(s[i+24 : i+26]))),
+		}
+		if n.addr.Port == 0 {
+			continue
+		}
+		nodes = append(nodes, n)
+	}
+	return nodes, nil
+}
+
+// Пир в компактном формате (только IPv4)
+func encodePeer(ip net.IP, port int) (string, bool) {
+	ip4 := ip.To4()
+	if ip4 == nil {
+		return "", false
+	}
+	return string(binary.BigEndian.AppendUint16(append([]byte(nil), ip4...), uint16(port))), true
+}
+
+// Разбор пиров из поля values. Значения неверной длины пропускаются
+func decodePeers(values []string) []peers.Peer {
+	var found []peers.Peer
+	for _, v := range values {
+		list, err := peers.Unmarshal([]byte(v))
+		if err != nil {
+			continue
+		}
+		for _, p := range list {
+			if p.Port != 0 {
+				p.Source = peers.SourceDHT
+				found = append(found, p)
+			}
+		}
+	}
+	return found
+}
@@ -0,0 +1,220 @@
+package dht
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"sort"
+
+	"github.com/swesdek/gotorrent-client/peers"
+)
+
+// Одновременных запросов при итеративном поиске
+const alpha = 3
+
+// Ошибка поиска, на который не ответил ни один узел
+var ErrNoNodes = errors.New("No DHT nodes responded")
+
+// Состояние кандидата итеративного поиска
+const (
+	candidateNew = iota
+	candidateWaiting
+	candidateReplied
+	candidateFailed
+)
+
+// Узел, который опрашивается при поиске
+type candidate struct {
+	node
+	boot  bool // Начальный узел, идентификатор которого еще неизвестен
+	state int
+	token string // Токен для announce_peer из ответа get_peers
+}
+
+// Итеративный поиск узлов, ближайших к target. С getPeers узлы опрашиваются
+// запросом get_peers, и вместе с узлами возвращаются найденные пиры, включая
+// анонсированных у самого узла.
+// Возвращаются ответившие узлы, ближайшие к target
+func (s *Server) lookup(ctx context.Context, target [20]byte, getPeers bool) ([]peers.Peer, []*candidate, error) {
+	s.mu.Lock()
+	known := s.table.closest(target, bucketSize)
+	s.mu.Unlock()
+
+	var cands []*candidate
+	seen := make(map[string]bool)
+	add := func(n node, boot bool) {
+		key := n.addr.String()
+		if seen[key] || (!boot && n.id == s.id) {
+			return
+		}
+		seen[key] = true
+		cands = append(cands, &candidate{node: n, boot: boot})
+	}
+	for _, n := range known {
+		add(n, false)
+	}
+	if len(known) < bucketSize { // Таблица почти пуста: поиск начинается с начальных узлов
+		for _, addr := range s.bootstrap {
+			udpAddr, err := net.ResolveUDPAddr("udp4", addr)
+			if err == nil {
+				add(node{addr: udpAddr}, true)
+			}
+		}
+	}
+
+	type result struct {
+		c   *candidate
+		r   *response
+		err error
+	}
+	results := make(chan result)
+	inFlight := 0
+	found := make(map[string]peers.Peer)
+	if getPeers { // Пиры, анонсированные у самого узла
+		for _, p := range decodePeers(s.storedPeers(target)) {
+			found[p.String()] = p
+		}
+	}
+	for {
+		sortCandidates(cands, target)
+		pending := false // Среди ближайших есть неопрошенные или ожидающие ответа
+		considered := 0
+		for _, c := range cands {
+			if considered == bucketSize {
+				break
+			}
+			if c.state == candidateFailed {
+				continue
+			}
+			considered++
+			switch c.state {
+			case candidateWaiting:
+				pending = true
+			case candidateNew:
+				pending = true
+				if inFlight == alpha || ctx.Err() != nil {
+					continue
+				}
+				c.state = candidateWaiting
+				inFlight++
+				go func(c *candidate) {
+					args := &arguments{Target: string(target[:])}
+					q := "find_node"
+					if getPeers {
+						args = &arguments{InfoHash: string(target[:])}
+						q = "get_peers"
+					}
+					r, err := s.query(ctx, c.addr, q, args)
+					results <- result{c, r, err}
+				}(c)
+			}
+		}
+		if inFlight == 0 {
+			if !pending || ctx.Err() != nil {
+				break
+			}
+			continue
+		}
+
+		res := <-results
+		inFlight--
+		c := res.c
+		if res.err != nil {
+			c.state = candidateFailed
+			if !c.boot {
+				s.mu.Lock()
+				s.table.remove(c.id)
+				s.mu.Unlock()
+			}
+			continue
+		}
+		c.state = candidateReplied
+		copy(c.id[:], res.r.ID)
+		c.boot = false
+		c.token = res.r.Token
+		nodes, err := decodeNodes(res.r.Nodes)
+		if err == nil {
+			for _, n := range nodes {
+				add(n, false)
+			}
+		}
+		for _, p := range decodePeers(res.r.Values) {
+			found[p.String()] = p
+		}
+	}
+
+	var replied []*candidate
+	for _, c := range cands {
+		if c.state == candidateReplied {
+			replied = append(replied, c)
+		}
+	}
+	if len(replied) == 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+		select {
+		case <-s.closed:
+			return nil, nil, ErrClosed
+		default:
+		}
+		return nil, nil, ErrNoNodes
+	}
+	sortCandidates(replied, target)
+	if len(replied) > bucketSize {
+		replied = replied[:bucketSize]
+	}
+	var list []peers.Peer
+	for _, p := range found {
+		list = append(list, p)
+	}
+	return list, replied, nil
+}
+
+// Сортировка кандидатов по расстоянию до target. Начальные узлы
+// с неизвестным идентификатором идут последними
+func sortCandidates(cands []*candidate, target [20]byte) {
+	sort.SliceStable(cands, func(a, b int) bool {
+		if cands[a].boot != cands[b].boot {
+			return !cands[a].boot
+		}
+		da, db := distance(cands[a].id, target), distance(cands[b].id, target)
+		return bytes.Compare(da[:], db[:]) < 0
+	})
+}
+
+// Заполнение таблицы маршрутизации поиском узлов, ближайших к собственному идентификатору
+func (s *Server) Bootstrap(ctx context.Context) error {
+	_, _, err := s.lookup(ctx, s.id, false)
+	return err
+}
+
+// Поиск пиров торрента
+func (s *Server) GetPeers(ctx context.Context, infoHash [20]byte) ([]peers.Peer, error) {
+	found, _, err := s.lookup(ctx, infoHash, true)
+	return found, err
+}
+
+// Поиск пиров торрента и анонс себя как пира на порту port у ближайших
+// к торренту узлов. Ошибки отдельных анонсов не возвращаются
+func (s *Server) Announce(ctx context.Context, infoHash [20]byte, port int) ([]peers.Peer, error) {
+	found, closest, err := s.lookup(ctx, infoHash, true)
+	if err != nil {
+		return nil, err
+	}
+	done := make(chan struct{})
+	for _, c := range closest {
+		go func(c *candidate) {
+			defer func() { done <- struct{}{} }()
+			if c.token == "" {
+				return
+			}
+			s.query(ctx, c.addr, "announce_peer", &arguments{InfoHash: string(infoHash[:]), Port: port, Token: c.token})
+		}(c)
+	}
+	for range closest {
+		<-done
+	}
+	return found, nil
+}
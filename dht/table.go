@@ -0,0 +1,121 @@
+package dht
+
+import (
+	"bytes"
+	"math/bits"
+	"sort"
+	"time"
+)
+
+// Количество узлов в корзине таблицы маршрутизации и в ответе find_node
+const bucketSize = 8
+
+// Узел, не отвечавший дольше этого времени, может быть заменен новым
+const staleAfter = 15 * time.Minute
+
+// Узел в таблице маршрутизации
+type entry struct {
+	node
+	seen time.Time // Последнее сообщение от узла
+}
+
+// Таблица маршрутизации: корзины узлов по длине общего префикса
+// идентификатора узла с собственным идентификатором
+type table struct {
+	own     [20]byte
+	buckets [160][]entry
+}
+
+// Расстояние между идентификаторами по метрике XOR
+func distance(a, b [20]byte) [20]byte {
+	var d [20]byte
+	for i := range d {
+		d[i] = a[i] ^ b[i]
+	}
+	return d
+}
+
+// Номер корзины для идентификатора id (-1 - собственный идентификатор)
+func (t *table) bucket(id [20]byte) int {
+	d := distance(t.own, id)
+	for i, b := range d {
+		if b != 0 {
+			return i*8 + bits.LeadingZeros8(b)
+		}
+	}
+	return -1
+}
+
+// Добавление узла или обновление времени последнего сообщения от него.
+// В заполненной корзине новый узел заменяет давно молчащий, иначе отбрасывается
+func (t *table) insert(n node, now time.Time) {
+	i := t.bucket(n.id)
+	if i < 0 {
+		return
+	}
+	b := t.buckets[i]
+	for j := range b {
+		if b[j].id == n.id {
+			b[j].node = n // Узел мог сменить адрес
+			b[j].seen = now
+			return
+		}
+	}
+	if len(b) < bucketSize {
+		t.buckets[i] = append(b, entry{n, now})
+		return
+	}
+	for j := range b {
+		if now.Sub(b[j].seen) > staleAfter {
+			b[j] = entry{n, now}
+			return
+		}
+	}
+}
+
+// Удаление узла, не ответившего на запрос
+func (t *table) remove(id [20]byte) {
+	i := t.bucket(id)
+	if i < 0 {
+		return
+	}
+	b := t.buckets[i]
+	for j := range b {
+		if b[j].id == id {
+			t.buckets[i] = append(b[:j], b[j+1:]...)
+			return
+		}
+	}
+}
+
+// Не более count узлов, ближайших к target
+func (t *table) closest(target [20]byte, count int) []node {
+	var all []node
+	for _, b := range t.buckets {
+		for _, e := range b {
+			all = append(all, e.node)
+		}
+	}
+	sortByDistance(all, target)
+	if len(all) > count {
+		all = all[:count]
+	}
+	return all
+}
+
+// Количество узлов в таблице
+func (t *table) len() int {
+	n := 0
+	for _, b := range t.buckets {
+		n += len(b)
+	}
+	return n
+}
+
+// Сортировка узлов по расстоянию до target
+func sortByDistance(nodes []node, target [20]byte) {
+	sort.Slice(nodes, func(a, b int) bool {
+		da, db := distance(nodes[a].id, target), distance(nodes[b].id, target)
+		return bytes.Compare(da[:], db[:]) < 0
+	})
+}
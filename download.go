@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"path/filepath"
+
+	"github.com/swesdek/gotorrent-client/ratelimit"
+	"github.com/swesdek/gotorrent-client/session"
+	"github.com/swesdek/gotorrent-client/storage"
+)
+
+// Команда download: скачивание торрента в директорию
+func downloadTorrent(args []string) error {
+	fs := flag.NewFlagSet("download", flag.ExitOnError)
+	outDir := fs.String("o", ".", "directory to download into")
+	tracker := fs.String("tracker", "", "announce URL to use instead of the trackers in the torrent")
+	pf := addPeerFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: gotorrent-client download [flags] file.torrent")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return usageError(fs, "Expected exactly one .torrent file")
+	}
+	err := pf.validate(fs)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := pf.load(fs)
+	if err != nil {
+		return err
+	}
+
+	tf, err := openTorrent(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	overrideTracker(&tf, *tracker)
+
+	peerID, err := cfg.PeerID()
+	if err != nil {
+		return err
+	}
+	opts := cfg.DownloadOptions(peerID)
+	opts.Verbose = pf.verbose
+	opts.Quiet = pf.quiet
+	opts.Client.DownloadLimits = []*ratelimit.Limiter{ratelimit.New(cfg.Limits.DownloadRate)}
+	opts.Client.UploadLimits = []*ratelimit.Limiter{ratelimit.New(cfg.Limits.UploadRate)}
+
+	dir := *outDir // Без incomplete_dir данные скачиваются сразу в outDir
+	if cfg.Session.IncompleteDir != "" {
+		dir = cfg.Session.IncompleteDir
+	}
+	st := cfg.NewStorage(dir)
+	if cfg.Download.CacheSize > 0 {
+		st = storage.NewCache(cfg.Download.CacheSize).Wrap(st)
+		opts.WriteBlocks = true
+	}
+	err = tf.DownloadToStorage(st, opts)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(*outDir, tf.Name)
+	if dir != *outDir {
+		err = storage.Move(filepath.Join(dir, tf.Name), path)
+		if err != nil {
+			return err
+		}
+	}
+	if !pf.quiet {
+		fmt.Printf("Downloaded %s\n", path)
+	}
+	return cfg.Hooks().Run(context.Background(), session.NewCompletion(&tf, path))
+}
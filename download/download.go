@@ -2,12 +2,19 @@ package download
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha1"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"sort"
 	"time"
 
 	"github.com/schollz/progressbar/v3"
+	"github.com/swesdek/gotorrent-client/banlist"
+	"github.com/swesdek/gotorrent-client/bitfields"
 	"github.com/swesdek/gotorrent-client/client"
+	"github.com/swesdek/gotorrent-client/merkle"
 	"github.com/swesdek/gotorrent-client/message"
 	"github.com/swesdek/gotorrent-client/peers"
 )
@@ -20,6 +27,38 @@ type Torrent struct {
 	PieceLength int
 	Length      int
 	Name        string
+	BanList     *banlist.BanList // Список заблокированных пиров (nil - блокировка отключена)
+	Client      client.Options   // Параметры соединений с пирами
+	PiecesV2    []PieceV2        // Части торрента только v2 (nil - проверка по PieceHashes)
+	WebSeeds    []string         // Ссылки на веб-сиды (BEP 19)
+	Files       []File           // Файлы многофайлового торрента без файлов выравнивания
+	Private     bool             // Приватный торрент (BEP 27): пиры только от трекеров
+	Verbose     bool             // Вывод сообщений о соединениях с пирами и веб-сидами
+
+	Done        bitfields.Bitfield    // Уже скачанные части, которые не запрашиваются (nil - нет таких)
+	NewPeers    <-chan peers.Peer     // Пиры, найденные во время скачивания (например, при повторном анонсе)
+	Incoming    <-chan *client.Client // Входящие соединения от пиров
+	Connections chan struct{}         // Общий лимит соединений: занятое место в канале - одно соединение
+	Priorities  []int                 // Приоритеты частей: больший скачивается раньше, отрицательный - не скачивается (nil - все равны)
+
+	MaxBacklog   int           // Неудовлетворенных запросов к пиру (0 - MaxBacklog)
+	BlockSize    int           // Длина запрашиваемого блока (0 - MaxBlockSize)
+	PieceTimeout time.Duration // Ожидание части от пира (0 - DefaultPieceTimeout)
+	HashWorkers  int           // Горутин проверки хешей частей (0 - по числу процессоров)
+	HashPool     *HashPool     // Общий пул проверки хешей (nil - свой пул из HashWorkers горутин на время Run)
+
+	OnPeer  func(c *client.Client, connected bool)     // Уведомление о подключении и отключении пира (nil - без уведомлений)
+	OnBlock func(index, begin int, block []byte) error // Запись блока, полученного от пира, до проверки части (nil - части передаются только в onPiece)
+
+	hashes *HashPool // Пул проверки частей: HashPool или созданный в Run
+}
+
+// Часть торрента BitTorrent v2. Части выровнены по началу файлов, поэтому
+// последняя часть каждого файла может быть короче PieceLength
+type PieceV2 struct {
+	Length int      // Длина данных части
+	Root   [32]byte // Корень дерева Меркла над блоками части
+	Leaves int      // Количество листьев дерева
 }
 
 // Объект части файла
@@ -27,6 +66,7 @@ type pieceWork struct {
 	index  int
 	hash   [20]byte
 	length int
+	v2     *PieceV2 // Данные для проверки части v2 (nil для v1)
 }
 
 // Скачанная часть файла
@@ -43,10 +83,18 @@ type pieceProgress struct {
 	downloaded int
 	requested  int
 	backlog    int
+	pending    map[int]int // Неудовлетворенные запросы: смещение блока -> длина
+	retry      []int       // Смещения блоков, запросы на которые были отклонены
+	blockSize  int         // Длина запрашиваемого блока
+	onBlock    func(index, begin int, block []byte) error
 }
 
-const MaxBacklog = 5       // Максимальное количество неудовлетворенных запросов
-const MaxBlockSize = 16384 // Максимальная длина блока данных
+// Ошибка скачивания, при котором не осталось ни одного пира или веб-сида
+var ErrNoPeers = errors.New("No peers or web seeds left to download from")
+
+const MaxBacklog = 5                         // Максимальное количество неудовлетворенных запросов
+const MaxBlockSize = 16384                   // Максимальная длина блока данных
+const DefaultPieceTimeout = 30 * time.Second // Ожидание части от пира
 
 // Считывание ответа пира
 func (state *pieceProgress) readMessage() error {
@@ -59,54 +107,123 @@ func (state *pieceProgress) readMessage() error {
 		return nil
 	}
 
-	switch msg.ID {
-	case message.MsgUnchoke:
-		state.client.Choked = false
-	case message.MsgChoke:
-		state.client.Choked = true
-	case message.MsgHave:
-		index, err := message.ParseHave(msg) // Считывание, какая часть файла есть у пира
-		if err != nil {
-			return err
+	handled, err := state.client.Apply(msg) // Сообщения о состоянии соединения
+	if err != nil {
+		return err
+	}
+	if handled {
+		if msg.ID == message.MsgChoke && !state.client.Fast {
+			state.dropPending() // Без Fast Extension блокировка отменяет все запросы
 		}
-		state.client.Bitfield.SetPiece(index) // Запись в Bitfield о содержании пиром соответствующей части
+		return nil
+	}
+
+	switch msg.ID {
 	case message.MsgPiece:
 		n, err := message.ParsePiece(state.index, state.buf, msg) // Запись части файла, пришедшей от пира
 		if err != nil {
 			return err
 		}
+		begin := int(binary.BigEndian.Uint32(msg.Payload[4:8]))
+		if _, ok := state.pending[begin]; !ok {
+			return nil // Блок, который не запрашивался или уже получен
+		}
+		delete(state.pending, begin)
+		if state.onBlock != nil {
+			err = state.onBlock(state.index, begin, state.buf[begin:begin+n])
+			if err != nil {
+				return err
+			}
+		}
 		state.downloaded += n
 		state.backlog--
+	case message.MsgReject:
+		index, begin, _, err := message.ParseRequest(msg)
+		if err != nil {
+			return err
+		}
+		if _, ok := state.pending[begin]; !ok || index != state.index {
+			return nil
+		}
+		delete(state.pending, begin) // Блок будет запрошен повторно
+		state.backlog--
+		state.retry = append(state.retry, begin)
+	case message.MsgHashRequest:
+		req, err := message.ParseHashRequest(msg) // Хеши дерева Меркла пирам не раздаются
+		if err != nil {
+			return err
+		}
+		reject := message.FormatHashReject(req)
+		_, err = state.client.Conn.Write(reject.Serialize())
+		return err
+	case message.MsgRequest:
+		if state.client.Fast { // Раздача не поддерживается, поэтому запросы пира отклоняются
+			index, begin, length, err := message.ParseRequest(msg)
+			if err != nil {
+				return err
+			}
+			return state.client.SendReject(index, begin, length)
+		}
 	}
 	return nil
 }
 
+// Отмена всех неудовлетворенных запросов с их последующим повтором
+func (state *pieceProgress) dropPending() {
+	for begin := range state.pending {
+		state.retry = append(state.retry, begin)
+	}
+	state.pending = make(map[int]int)
+	state.backlog = 0
+}
+
+// Отправка следующего запроса на блок части файла
+func (state *pieceProgress) requestNext(length int) error {
+	begin := state.requested
+	if len(state.retry) > 0 { // Сначала повторяются отклоненные запросы
+		begin = state.retry[len(state.retry)-1]
+		state.retry = state.retry[:len(state.retry)-1]
+	} else {
+		state.requested += state.blockSize
+	}
+
+	blockSize := state.blockSize
+	if length-begin < blockSize {
+		blockSize = length - begin
+	}
+
+	err := state.client.SendRequest(state.index, begin, blockSize) // Отправка запроса на пиры
+	if err != nil {
+		return err
+	}
+	state.pending[begin] = blockSize
+	state.backlog += 1
+	return nil
+}
+
 // Функция для отправки запроса на получение части файла
-func tryDownloadPiece(c *client.Client, pw *pieceWork) ([]byte, error) {
+func (t *Torrent) tryDownloadPiece(c *client.Client, pw *pieceWork) ([]byte, error) {
 	state := pieceProgress{
-		index:  pw.index,
-		client: c,
-		buf:    make([]byte, pw.length),
+		index:     pw.index,
+		client:    c,
+		buf:       make([]byte, pw.length),
+		pending:   make(map[int]int),
+		blockSize: orDefault(t.BlockSize, MaxBlockSize),
+		onBlock:   t.OnBlock,
 	}
+	maxBacklog := orDefault(t.MaxBacklog, MaxBacklog)
 
-	c.Conn.SetDeadline(time.Now().Add(time.Second * 30)) // Установка дедлайна на ответ от пира
+	c.Conn.SetDeadline(time.Now().Add(orDefault(t.PieceTimeout, DefaultPieceTimeout))) // Установка дедлайна на ответ от пира
 	defer c.Conn.SetDeadline(time.Now())
 
 	for state.downloaded < pw.length {
-		if !state.client.Choked {
-			for state.backlog < MaxBacklog && state.requested < pw.length {
-				blockSize := MaxBlockSize
-
-				if pw.length-state.requested < blockSize {
-					blockSize = pw.length - state.requested
-				}
-
-				err := c.SendRequest(state.index, state.requested, blockSize) // Отправка запроса на пиры
+		// Части из набора allowed fast можно запрашивать и при блокировке
+		if !state.client.Choked || state.client.AllowedFast[pw.index] {
+			for state.backlog < maxBacklog && (state.requested < pw.length || len(state.retry) > 0) {
+				err := state.requestNext(pw.length)
 				if err != nil {
 					return nil, err
 				}
-				state.backlog += 1
-				state.requested += blockSize
 			}
 		}
 
@@ -121,6 +238,13 @@ func tryDownloadPiece(c *client.Client, pw *pieceWork) ([]byte, error) {
 
 // Проверка части файла на цельность и соответствие запрошенному
 func checkIntegrity(pw *pieceWork, buf []byte) error {
+	if pw.v2 != nil { // Проверка блоков по дереву Меркла части
+		if merkle.DataRoot(buf, pw.v2.Leaves) != pw.v2.Root {
+			return fmt.Errorf("Piece %d failed to pass merkle integrity check\n", pw.index)
+		}
+		return nil
+	}
+
 	hash := sha1.Sum(buf)                  // Вычисление хеша полученной части
 	if !bytes.Equal(hash[:], pw.hash[:]) { // Сравнение хешей
 		return fmt.Errorf("Piece %d failed to pass integrity check\n", pw.index)
@@ -128,52 +252,231 @@ func checkIntegrity(pw *pieceWork, buf []byte) error {
 	return nil
 }
 
+// Штраф пиру, приславшему часть, не прошедшую проверку. Часть целиком
+// скачивается у одного пира, поэтому штрафуется всегда он.
+// Возвращает true, если пир после этого оказался заблокирован
+func (t *Torrent) penalize(peer peers.Peer) bool {
+	banned, err := t.BanList.Penalize(peer.IP, 1)
+	if err != nil {
+		fmt.Printf("Couldnt save ban list: %v\n", err)
+	}
+	if banned {
+		t.logf("Peer %s was banned for sending corrupt data\n", peer.IP)
+	}
+	return banned
+}
+
+// Занятие места в общем лимите соединений. Возвращает функцию освобождения места
+func (t *Torrent) acquireConnection(ctx context.Context, wait bool) (func(), bool) {
+	if t.Connections == nil {
+		return func() {}, true
+	}
+	if !wait {
+		select {
+		case t.Connections <- struct{}{}:
+			return func() { <-t.Connections }, true
+		default:
+			return nil, false
+		}
+	}
+	select {
+	case t.Connections <- struct{}{}:
+		return func() { <-t.Connections }, true
+	case <-ctx.Done():
+		return nil, false
+	}
+}
+
 // Установление соединения с пиром и запуск скачивания
-func (t *Torrent) startDownloadWorker(peer peers.Peer, workQueue chan *pieceWork, results chan *pieceResult) {
-	c, err := client.New(peer, t.PeerID, t.InfoHash) // Создание обьекта клиента
+func (t *Torrent) startDownloadWorker(ctx context.Context, peer peers.Peer, workQueue chan *pieceWork, results chan *pieceResult) {
+	if t.BanList.IsBanned(peer.IP) { // С заблокированными пирами соединение не устанавливается
+		return
+	}
+	release, ok := t.acquireConnection(ctx, true)
+	if !ok {
+		return
+	}
+	defer release()
+
+	c, err := client.New(peer, t.PeerID, t.InfoHash, t.numPieces(), t.Client) // Создание обьекта клиента
 	if err != nil {
-		fmt.Printf("Handshake with %s was unsuccessful. Shutting down worker\n", peer.IP)
+		t.logf("Handshake with %s was unsuccessful. Shutting down worker\n", peer.IP)
+
+		return
+	}
+	t.runWorker(ctx, c, workQueue, results)
+}
 
+// Скачивание через входящее соединение, если для него есть место в лимите
+func (t *Torrent) startIncomingWorker(ctx context.Context, c *client.Client, workQueue chan *pieceWork, results chan *pieceResult) {
+	if t.BanList.IsBanned(c.Peer().IP) {
+		c.Conn.Close()
 		return
 	}
+	release, ok := t.acquireConnection(ctx, false)
+	if !ok {
+		c.Conn.Close()
+		return
+	}
+	defer release()
+	t.runWorker(ctx, c, workQueue, results)
+}
+
+// Скачивание частей из очереди через установленное соединение
+func (t *Torrent) runWorker(ctx context.Context, c *client.Client, workQueue chan *pieceWork, results chan *pieceResult) {
 	defer c.Conn.Close()
+	stop := context.AfterFunc(ctx, func() { c.Conn.Close() }) // Отмена скачивания прерывает чтение из соединения
+	defer stop()
+	peer := c.Peer()
+	if t.OnPeer != nil {
+		t.OnPeer(c, true)
+		defer t.OnPeer(c, false)
+	}
 
 	c.SendUnchoke()    // Сообщение о разблокировке
 	c.SendInterested() // Сообщение о заинтересованности в получении данных
 
-	for pw := range workQueue {
+	// Скачанные части проверяются в пуле, а результаты возвращаются в replies
+	replies := make(chan hashResult, maxPiecesInFlight)
+	inFlight := 0
+	handle := func(res hashResult) bool { // false - воркер должен завершиться
+		inFlight--
+		if res.err != nil {
+			t.logf("%v", res.err)
+			workQueue <- res.pw
+			return !t.penalize(peer) // Заблокированный пир больше не используется
+		}
+		c.SendHave(res.pw.index) // Сообщение пирам о завершении скачивания части файла
+		select {                 // Помещение части файла в канал
+		case results <- &pieceResult{res.pw.index, res.buf}:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	defer func() { // Части, оставшиеся на проверке, обрабатываются до закрытия соединения
+		for inFlight > 0 {
+			select {
+			case res := <-replies:
+				handle(res)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for {
+		if inFlight >= maxPiecesInFlight { // Следующая часть скачивается после проверки одной из предыдущих
+			select {
+			case res := <-replies:
+				if !handle(res) {
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
+
+		var pw *pieceWork
+		select {
+		case pw = <-workQueue:
+		case res := <-replies:
+			if !handle(res) {
+				return
+			}
+			continue
+		case <-ctx.Done():
+			return
+		}
+
 		if !c.Bitfield.HasPiece(pw.index) { // Если у пира нет нужной части данных, то она помещается обратно в очередь
 			workQueue <- pw
 			continue
 		}
 
-		buf, err := tryDownloadPiece(c, pw) // Скачивание данных
+		buf, err := t.tryDownloadPiece(c, pw) // Скачивание данных
 		if err != nil {
-			fmt.Println("Couldnt download piece from this peer. Exiting")
+			t.logf("Couldnt download piece from this peer. Exiting\n")
 			workQueue <- pw
 			return
 		}
 
-		err = checkIntegrity(pw, buf) // Проверка на цельность
-		if err != nil {
-			fmt.Println(err)
-			workQueue <- pw
+		if !t.hashes.submit(ctx, hashJob{pw, buf, replies}) { // Проверка на цельность
 			return
 		}
+		inFlight++
+	}
+}
+
+// Вывод сообщения о работе воркеров, если включен подробный вывод
+func (t *Torrent) logf(format string, args ...any) {
+	if t.Verbose {
+		fmt.Printf(format, args...)
+	}
+}
+
+// Количество частей торрента
+func (t *Torrent) numPieces() int {
+	if t.PiecesV2 != nil {
+		return len(t.PiecesV2)
+	}
+	return len(t.PieceHashes)
+}
+
+// Значение v или def, если v не задано
+func orDefault[T int | time.Duration](v, def T) T {
+	if v <= 0 {
+		return def
+	}
+	return v
+}
 
-		c.SendHave(pw.index)                   // Сообщение пирам о завершении скачивания части файла
-		results <- &pieceResult{pw.index, buf} // Помещение части файла в канал
+// Приоритет части
+func (t *Torrent) priority(index int) int {
+	if t.Priorities == nil {
+		return 0
 	}
+	return t.Priorities[index]
 }
 
-// Скачивание всего файла
+// Скачивание всего файла в память
 func (t *Torrent) Download() ([]byte, error) {
 	fmt.Printf("Starting download for %s\n", t.Name)
 
-	workQueue := make(chan *pieceWork, len(t.PieceHashes)) // Очередь с данными о частях для скачивания
-	results := make(chan *pieceResult)                     // Канал с готовыми для записи в файл частями
+	bar := progressbar.Default(int64(t.numPieces())) // Создание индикатора загрузки
+	buf := make([]byte, t.Length)                    // Итоговый буфер
+	err := t.Run(context.Background(), func(index int, piece []byte) error {
+		begin := index * t.PieceLength
+		copy(buf[begin:], piece)
+		bar.Add(1)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// Скачивание недостающих частей. Каждая проверенная часть передается в onPiece;
+// ошибка onPiece или отмена ctx останавливает скачивание
+func (t *Torrent) Run(ctx context.Context, onPiece func(index int, buf []byte) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel() // Остановка воркеров по завершении
+	t.hashes = t.HashPool
+	if t.hashes == nil {
+		t.hashes = NewHashPool(t.HashWorkers)
+		defer t.hashes.Close()
+	}
+
+	workQueue := make(chan *pieceWork, t.numPieces()) // Очередь с данными о частях для скачивания
+	results := make(chan *pieceResult)                // Канал с готовыми для записи в файл частями
 
 	// Заполнение очереди данными
+	var pending []*pieceWork
+	for index := range t.PiecesV2 {
+		pending = append(pending, &pieceWork{index: index, length: t.PiecesV2[index].Length, v2: &t.PiecesV2[index]})
+	}
 	for index, hash := range t.PieceHashes {
 		begin := index * t.PieceLength
 		end := begin + t.PieceLength
@@ -184,35 +487,67 @@ func (t *Torrent) Download() ([]byte, error) {
 
 		length := end - begin
 
-		workQueue <- &pieceWork{index, hash, length}
+		pending = append(pending, &pieceWork{index, hash, length, nil})
+	}
+	sort.SliceStable(pending, func(a, b int) bool { // Части с большим приоритетом запрашиваются раньше
+		return t.priority(pending[a].index) > t.priority(pending[b].index)
+	})
+	for _, pw := range pending {
+		if !t.Done.HasPiece(pw.index) && t.priority(pw.index) >= 0 {
+			workQueue <- pw
+		}
 	}
+	remaining := len(workQueue)
 
 	// Запуск многопоточного скачивания
+	workers := 0                  // Количество работающих воркеров
+	exited := make(chan struct{}) // Сигналы о завершении воркеров
+	spawn := func(worker func()) {
+		workers++
+		go func() {
+			worker()
+			select {
+			case exited <- struct{}{}:
+			case <-ctx.Done():
+			}
+		}()
+	}
+	connected := make(map[string]bool) // Пиры, к которым уже запущен воркер
+	connect := func(peer peers.Peer) {
+		if connected[peer.String()] || (t.Private && !peer.Source.AllowedForPrivate()) {
+			return
+		}
+		connected[peer.String()] = true
+		spawn(func() { t.startDownloadWorker(ctx, peer, workQueue, results) })
+	}
 	for _, peer := range t.Peers {
-		go t.startDownloadWorker(peer, workQueue, results)
+		connect(peer)
 	}
+	for _, seed := range t.WebSeeds {
+		spawn(func() { t.startWebSeedWorker(ctx, seed, workQueue, results) })
+	}
+	noSources := t.NewPeers == nil && t.Incoming == nil // Новых пиров взять неоткуда
 
-	// Создание индикатора загрузки
-	bar := progressbar.Default(int64(len(t.PieceHashes)))
-
-	// Запись в итоговый буфер
-	buf := make([]byte, t.Length)
-	donePieces := 0
-	for donePieces < len(t.PieceHashes) {
-		res := <-results
-		begin := res.index * t.PieceLength
-		end := begin + t.PieceLength
-
-		if end > t.Length {
-			end = t.Length
+	for remaining > 0 {
+		if workers == 0 && noSources {
+			return ErrNoPeers
+		}
+		select {
+		case res := <-results:
+			err := onPiece(res.index, res.buf)
+			if err != nil {
+				return err
+			}
+			remaining--
+		case <-exited:
+			workers--
+		case peer := <-t.NewPeers:
+			connect(peer)
+		case c := <-t.Incoming:
+			spawn(func() { t.startIncomingWorker(ctx, c, workQueue, results) })
+		case <-ctx.Done():
+			return ctx.Err()
 		}
-
-		copy(buf[begin:end], res.buf)
-		donePieces += 1
-		bar.Add(1)
 	}
-
-	close(workQueue) // Закрытие канала с очередью
-
-	return buf, nil
+	return nil
 }
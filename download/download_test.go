@@ -0,0 +1,156 @@
+package download
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/swesdek/gotorrent-client/banlist"
+	"github.com/swesdek/gotorrent-client/bitfields"
+	"github.com/swesdek/gotorrent-client/client"
+	"github.com/swesdek/gotorrent-client/message"
+	"github.com/swesdek/gotorrent-client/peers"
+)
+
+// Клиент с Fast Extension, соединенный по TCP с тестовым пиром
+func fastClient(t *testing.T) (*client.Client, net.Conn) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	local, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		local.Close()
+		remote.Close()
+	})
+	c := &client.Client{
+		Conn:        local,
+		Bitfield:    bitfields.Full(4),
+		Fast:        true,
+		AllowedFast: make(map[int]bool),
+	}
+	return c, remote
+}
+
+// Отправка сообщения пиром
+func sendMessage(t *testing.T, conn net.Conn, msg *message.Message) {
+	t.Helper()
+	_, err := conn.Write(msg.Serialize())
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRejectClearsPendingRequest(t *testing.T) {
+	c, peer := fastClient(t)
+	state := pieceProgress{
+		index:     1,
+		client:    c,
+		buf:       make([]byte, 2*MaxBlockSize),
+		pending:   map[int]int{0: MaxBlockSize, MaxBlockSize: MaxBlockSize},
+		backlog:   2,
+		blockSize: MaxBlockSize,
+	}
+
+	sendMessage(t, peer, message.FormatReject(0, MaxBlockSize, MaxBlockSize)) // Другая часть
+	sendMessage(t, peer, message.FormatReject(1, MaxBlockSize, MaxBlockSize))
+	for i := 0; i < 2; i++ {
+		err := state.readMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, ok := state.pending[MaxBlockSize]; ok || state.backlog != 1 {
+		t.Errorf("pending %v, backlog %d after reject", state.pending, state.backlog)
+	}
+	if _, ok := state.pending[0]; !ok {
+		t.Error("reject for another piece cleared a request")
+	}
+	if len(state.retry) != 1 || state.retry[0] != MaxBlockSize {
+		t.Errorf("retry %v, want [%d]", state.retry, MaxBlockSize)
+	}
+
+	// Повторный отказ на тот же блок уже не уменьшает счетчик запросов
+	sendMessage(t, peer, message.FormatReject(1, MaxBlockSize, MaxBlockSize))
+	if err := state.readMessage(); err != nil {
+		t.Fatal(err)
+	}
+	if state.backlog != 1 || len(state.retry) != 1 {
+		t.Errorf("duplicate reject: backlog %d, retry %v", state.backlog, state.retry)
+	}
+}
+
+func TestRejectedBlockIsRequestedAgain(t *testing.T) {
+	c, peer := fastClient(t)
+	c.Choked = false
+	data := bytes.Repeat([]byte("block"), 2*MaxBlockSize/5+1)[:2*MaxBlockSize]
+	served := make(chan []int, 1)
+	go func() { // Пир отказывает в первом запросе каждого блока
+		var begins []int
+		rejected := make(map[int]bool)
+		for len(begins) < 4 {
+			msg, err := message.Read(peer)
+			if err != nil {
+				break
+			}
+			index, begin, length, err := message.ParseRequest(msg)
+			if err != nil {
+				break
+			}
+			begins = append(begins, begin)
+			reply := message.FormatReject(index, begin, length)
+			if rejected[begin] {
+				payload := binary.BigEndian.AppendUint32(nil, uint32(index))
+				payload = binary.BigEndian.AppendUint32(payload, uint32(begin))
+				reply = &message.Message{ID: message.MsgPiece, Payload: append(payload, data[begin:begin+length]...)}
+			}
+			rejected[begin] = true
+			peer.Write(reply.Serialize())
+		}
+		served <- begins
+	}()
+
+	tor := Torrent{PieceTimeout: 5 * time.Second}
+	buf, err := tor.tryDownloadPiece(c, &pieceWork{index: 2, length: len(data)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, data) {
+		t.Errorf("downloaded %d bytes differ from the piece", len(buf))
+	}
+	if begins := <-served; len(begins) != 4 {
+		t.Errorf("requests %v, want each of 2 blocks twice", begins)
+	}
+}
+
+func TestPenalizeBansPeer(t *testing.T) {
+	bans, err := banlist.Load(filepath.Join(t.TempDir(), "banned_peers.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tor := Torrent{BanList: bans}
+	peer := peers.Peer{IP: net.IPv4(10, 0, 0, 1), Port: 6881}
+	for i := 1; i < banlist.BanThreshold; i++ {
+		if tor.penalize(peer) {
+			t.Fatalf("peer banned after %d corrupt pieces", i)
+		}
+	}
+	if !tor.penalize(peer) || !bans.IsBanned(peer.IP) {
+		t.Error("peer not banned after the threshold")
+	}
+	if (&Torrent{}).penalize(peer) { // Без списка блокировки пиры не блокируются
+		t.Error("peer banned without a ban list")
+	}
+}
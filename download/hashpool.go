@@ -0,0 +1,97 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+)
+
+// Частей одного пира, одновременно ожидающих проверки. Пока часть
+// проверяется, воркер уже скачивает следующую
+const maxPiecesInFlight = 2
+
+// Скачанная часть, отправленная на проверку
+type hashJob struct {
+	pw    *pieceWork
+	buf   []byte
+	reply chan<- hashResult // Канал воркера, в него всегда есть место
+}
+
+// Результат проверки части
+type hashResult struct {
+	pw  *pieceWork
+	buf []byte
+	err error // nil - часть прошла проверку
+}
+
+// Ошибка проверки части после закрытия пула
+var errHashPoolClosed = errors.New("Hash pool is closed")
+
+// Пул горутин, проверяющих хеши частей отдельно от чтения из соединений.
+// Один пул может быть общим для нескольких торрентов. Очередь не длиннее
+// числа горутин, поэтому при насыщении пула отправитель ждет, а не
+// накапливает части в памяти
+type HashPool struct {
+	jobs   chan hashJob
+	closed chan struct{}
+	once   sync.Once
+}
+
+// Запуск пула из workers горутин (0 - по числу процессоров), работающих до Close
+func NewHashPool(workers int) *HashPool {
+	workers = orDefault(workers, runtime.NumCPU())
+	p := &HashPool{jobs: make(chan hashJob, workers), closed: make(chan struct{})}
+	for i := 0; i < workers; i++ {
+		go p.run()
+	}
+	return p
+}
+
+// Остановка горутин пула. Части, поставленные в очередь позже, не проверяются
+func (p *HashPool) Close() {
+	p.once.Do(func() { close(p.closed) })
+}
+
+func (p *HashPool) run() {
+	for {
+		select {
+		case job := <-p.jobs:
+			job.reply <- hashResult{job.pw, job.buf, checkIntegrity(job.pw, job.buf)}
+		case <-p.closed:
+			return
+		}
+	}
+}
+
+// Постановка части в очередь проверки. Ждет места в очереди;
+// возвращает false, если скачивание отменено или пул закрыт
+func (p *HashPool) submit(ctx context.Context, job hashJob) bool {
+	select {
+	case p.jobs <- job:
+		return true
+	case <-ctx.Done():
+		return false
+	case <-p.closed:
+		return false
+	}
+}
+
+// Проверка части с ожиданием результата
+func (p *HashPool) verify(ctx context.Context, pw *pieceWork, buf []byte) error {
+	reply := make(chan hashResult, 1)
+	if !p.submit(ctx, hashJob{pw: pw, buf: buf, reply: reply}) {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return errHashPoolClosed
+	}
+	select {
+	case res := <-reply:
+		return res.err
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-p.closed:
+		return errHashPoolClosed
+	}
+}
@@ -0,0 +1,72 @@
+package download
+
+import (
+	"context"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"runtime"
+	"testing"
+)
+
+// Часть длиной length с верным хешем
+func testPiece(index, length int) (*pieceWork, []byte) {
+	buf := make([]byte, length)
+	for i := range buf {
+		buf[i] = byte(i * (index + 1))
+	}
+	return &pieceWork{index: index, hash: sha1.Sum(buf), length: length}, buf
+}
+
+func TestHashPool(t *testing.T) {
+	p := NewHashPool(2)
+	ctx := context.Background()
+
+	pw, buf := testPiece(0, 1000)
+	if err := p.verify(ctx, pw, buf); err != nil {
+		t.Errorf("valid piece: %v", err)
+	}
+	buf[10]++
+	if err := p.verify(ctx, pw, buf); err == nil {
+		t.Error("corrupted piece passed")
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := p.verify(canceled, pw, buf); !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled context: %v", err)
+	}
+
+	p.Close()
+	p.Close()
+	if err := p.verify(ctx, pw, buf); err == nil {
+		t.Error("piece verified by a closed pool")
+	}
+}
+
+// Проверка частей одним пулом из нескольких горутин, как у торрентов сессии
+func BenchmarkHashPool(b *testing.B) {
+	const pieceLength = 256 << 10
+	counts := []int{1}
+	if runtime.NumCPU() > 1 {
+		counts = append(counts, runtime.NumCPU())
+	}
+	for _, workers := range counts {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			p := NewHashPool(workers)
+			defer p.Close()
+			ctx := context.Background()
+			b.SetBytes(pieceLength)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				pw, buf := testPiece(0, pieceLength)
+				for pb.Next() {
+					if err := p.verify(ctx, pw, buf); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
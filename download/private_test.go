@@ -0,0 +1,72 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/swesdek/gotorrent-client/client"
+	"github.com/swesdek/gotorrent-client/peers"
+)
+
+// Запуск скачивания единственной части у одного пира из источника source.
+// Возвращает, подключался ли клиент к пиру
+func dialedPeer(t *testing.T, private bool, source peers.Source) bool {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	accepted := make(chan struct{}, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		accepted <- struct{}{}
+		conn.Close() // Хендшейк не состоится, воркер завершится
+	}()
+
+	addr := l.Addr().(*net.TCPAddr)
+	tor := Torrent{
+		Peers:       []peers.Peer{{IP: addr.IP, Port: uint16(addr.Port), Source: source}},
+		PieceHashes: make([][20]byte, 1),
+		PieceLength: 16384,
+		Length:      16384,
+		Private:     private,
+		Client:      client.Options{DialTimeout: time.Second, HandshakeTimeout: time.Second},
+		HashWorkers: 1,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err = tor.Run(ctx, func(int, []byte) error { return nil })
+	if !errors.Is(err, ErrNoPeers) {
+		t.Fatalf("Run returned %v, want ErrNoPeers", err)
+	}
+	select {
+	case <-accepted:
+		return true
+	case <-time.After(100 * time.Millisecond):
+		return false
+	}
+}
+
+func TestPrivateTorrentRefusesNonTrackerPeers(t *testing.T) {
+	for _, source := range []peers.Source{peers.SourceMagnet, peers.SourceUnknown} {
+		if dialedPeer(t, true, source) {
+			t.Errorf("private torrent connected to a peer from %s", source)
+		}
+	}
+}
+
+func TestPrivateTorrentUsesTrackerPeers(t *testing.T) {
+	if !dialedPeer(t, true, peers.SourceTracker) {
+		t.Error("private torrent did not connect to a tracker peer")
+	}
+	if !dialedPeer(t, false, peers.SourceMagnet) {
+		t.Error("public torrent did not connect to a magnet peer")
+	}
+}
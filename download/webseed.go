@@ -0,0 +1,133 @@
+package download
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Файл в общем потоке данных торрента, который раздают веб-сиды
+type File struct {
+	Path   []string // Путь относительно корневой директории торрента
+	Offset int
+	Length int
+}
+
+const (
+	maxWebSeedFailures = 5                // Количество ошибок подряд, после которого веб-сид отключается
+	webSeedBackoff     = time.Second      // Начальная пауза после ошибки
+	maxWebSeedBackoff  = 60 * time.Second // Наибольшая пауза после ошибки
+)
+
+// Ссылка на файл у веб-сида (BEP 19). Для многофайлового торрента к ссылке
+// добавляются имя торрента и путь файла
+func fileURL(seed, name string, path []string) string {
+	if path == nil {
+		if strings.HasSuffix(seed, "/") { // Ссылка на директорию с файлом
+			return seed + url.PathEscape(name)
+		}
+		return seed
+	}
+
+	u := strings.TrimSuffix(seed, "/") + "/" + url.PathEscape(name)
+	for _, part := range path {
+		u += "/" + url.PathEscape(part)
+	}
+	return u
+}
+
+// Скачивание диапазона файла, начинающегося со смещения offset, в buf
+func fetchRange(ctx context.Context, c *http.Client, fileURL string, offset int, buf []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fileURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+len(buf)-1))
+
+	res, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusPartialContent:
+	case res.StatusCode == http.StatusOK && offset == 0: // Сервер без поддержки Range отдает файл целиком
+	default:
+		return fmt.Errorf("Web seed %s responded with %s", fileURL, res.Status)
+	}
+
+	_, err = io.ReadFull(res.Body, buf)
+	return err
+}
+
+// Скачивание части торрента у веб-сида. Промежутки между файлами (выравнивание) заполняются нулями
+func (t *Torrent) fetchPiece(ctx context.Context, c *http.Client, seed string, pw *pieceWork) ([]byte, error) {
+	buf := make([]byte, pw.length)
+	begin := pw.index * t.PieceLength
+	end := begin + pw.length
+
+	files := t.Files
+	if len(files) == 0 { // Однофайловый торрент
+		files = []File{{Length: t.Length}}
+	}
+	for _, f := range files {
+		from := max(begin, f.Offset)
+		to := min(end, f.Offset+f.Length)
+		if from >= to {
+			continue
+		}
+		err := fetchRange(ctx, c, fileURL(seed, t.Name, f.Path), from-f.Offset, buf[from-begin:to-begin])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+// Скачивание частей у веб-сида, который используется как виртуальный пир
+func (t *Torrent) startWebSeedWorker(ctx context.Context, seed string, workQueue chan *pieceWork, results chan *pieceResult) {
+	c := &http.Client{Timeout: 60 * time.Second, Transport: t.Client.Proxy.Transport()}
+
+	failures := 0
+	for {
+		var pw *pieceWork
+		select {
+		case pw = <-workQueue:
+		case <-ctx.Done():
+			return
+		}
+
+		buf, err := t.fetchPiece(ctx, c, seed, pw)
+		if err == nil {
+			err = t.hashes.verify(ctx, pw, buf) // Данные веб-сида проверяются так же, как данные пиров
+		}
+		if err != nil {
+			workQueue <- pw
+			failures++
+			if failures >= maxWebSeedFailures {
+				t.logf("Web seed %s failed %d times in a row. Shutting down worker\n", seed, failures)
+				return
+			}
+			backoff := min(webSeedBackoff<<(failures-1), maxWebSeedBackoff)
+			t.logf("Web seed %s: %s. Retrying in %s\n", seed, strings.TrimSpace(err.Error()), backoff)
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
+
+		failures = 0
+		select {
+		case results <- &pieceResult{pw.index, buf}:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFileURL(t *testing.T) {
+	tests := []struct {
+		seed string
+		name string
+		path []string
+		want string
+	}{
+		{"http://seed/file.iso", "file.iso", nil, "http://seed/file.iso"},
+		{"http://seed/pub/", "my file.iso", nil, "http://seed/pub/my%20file.iso"},
+		{"http://seed/pub", "dir", []string{"sub", "a#b"}, "http://seed/pub/dir/sub/a%23b"},
+		{"http://seed/pub/", "dir", []string{"a"}, "http://seed/pub/dir/a"},
+	}
+	for _, tt := range tests {
+		if got := fileURL(tt.seed, tt.name, tt.path); got != tt.want {
+			t.Errorf("fileURL(%q, %q, %q) = %q, want %q", tt.seed, tt.name, tt.path, got, tt.want)
+		}
+	}
+}
+
+// Торрент из файлов с заданными длинами, данные которого лежат в root/name
+func webSeedTorrent(t *testing.T, root, name string, lengths []int) (Torrent, []byte) {
+	t.Helper()
+	tor := Torrent{Name: name, PieceLength: 16384, HashWorkers: 1}
+	var data []byte
+	for i, length := range lengths {
+		content := bytes.Repeat([]byte{byte('a' + i)}, length)
+		path := filepath.Join(root, name)
+		if len(lengths) > 1 {
+			tor.Files = append(tor.Files, File{Path: []string{string(rune('a' + i))}, Offset: len(data), Length: length})
+			path = filepath.Join(path, string(rune('a'+i)))
+		}
+		err := os.MkdirAll(filepath.Dir(path), 0o755)
+		if err == nil {
+			err = os.WriteFile(path, content, 0o644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data = append(data, content...)
+	}
+	tor.Length = len(data)
+	for begin := 0; begin < len(data); begin += tor.PieceLength {
+		tor.PieceHashes = append(tor.PieceHashes, sha1.Sum(data[begin:min(begin+tor.PieceLength, len(data))]))
+	}
+	return tor, data
+}
+
+// Скачивание только у веб-сидов. Возвращает собранные данные торрента
+func runWebSeeds(t *testing.T, tor Torrent) []byte {
+	t.Helper()
+	got := make([]byte, tor.Length)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err := tor.Run(ctx, func(index int, buf []byte) error {
+		copy(got[index*tor.PieceLength:], buf)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+func TestWebSeedDownload(t *testing.T) {
+	root := t.TempDir()
+	var ranges atomic.Int32
+	files := http.FileServer(http.Dir(root))
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "" {
+			ranges.Add(1)
+		}
+		files.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	// Части на границах файлов собираются из нескольких запросов
+	tor, want := webSeedTorrent(t, root, "dir", []int{20000, 5000, 30000})
+	tor.WebSeeds = []string{ts.URL + "/"}
+	if got := runWebSeeds(t, tor); !bytes.Equal(got, want) {
+		t.Error("multi-file data does not match")
+	}
+	if n := ranges.Load(); n < int32(len(tor.PieceHashes)) {
+		t.Errorf("%d range requests for %d pieces", n, len(tor.PieceHashes))
+	}
+
+	tor, want = webSeedTorrent(t, root, "single.bin", []int{40000})
+	tor.WebSeeds = []string{ts.URL + "/", ts.URL + "/single.bin"}
+	if got := runWebSeeds(t, tor); !bytes.Equal(got, want) {
+		t.Error("single-file data does not match")
+	}
+}
+
+func TestFetchRange(t *testing.T) {
+	data := []byte(strings.Repeat("0123456789", 10))
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ranges":
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+		case "/whole": // Сервер без поддержки Range
+			w.Write(data)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+	c := ts.Client()
+	ctx := context.Background()
+
+	buf := make([]byte, 5)
+	err := fetchRange(ctx, c, ts.URL+"/ranges", 23, buf)
+	if err != nil || string(buf) != "34567" {
+		t.Errorf("range at 23: %q, %v", buf, err)
+	}
+	err = fetchRange(ctx, c, ts.URL+"/whole", 0, buf)
+	if err != nil || string(buf) != "01234" {
+		t.Errorf("whole file from offset 0: %q, %v", buf, err)
+	}
+	if err = fetchRange(ctx, c, ts.URL+"/whole", 23, buf); err == nil {
+		t.Error("whole file accepted for a range at offset 23")
+	}
+	if err = fetchRange(ctx, c, ts.URL+"/missing", 0, buf); err == nil {
+		t.Error("404 response accepted")
+	}
+	if err = fetchRange(ctx, c, ts.URL+"/ranges", 98, buf); err == nil {
+		t.Error("short range accepted")
+	}
+}
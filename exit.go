@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/swesdek/gotorrent-client/bencode"
+	"github.com/swesdek/gotorrent-client/download"
+	"github.com/swesdek/gotorrent-client/torrentfile"
+)
+
+// Коды завершения
+const (
+	exitOK       = 0
+	exitError    = 1 // Прочие ошибки, в том числе ошибки ввода-вывода
+	exitUsage    = 2 // Неверные аргументы командной строки
+	exitInvalid  = 3 // Некорректный .torrent файл
+	exitNetwork  = 4 // Трекер, пиры или HTTP сервер недоступны
+	exitMismatch = 5 // Данные на диске не совпадают с торрентом
+)
+
+// Ошибка с кодом завершения
+type exitCodeError struct {
+	code int
+	err  error
+}
+
+func (e *exitCodeError) Error() string {
+	return e.err.Error()
+}
+
+func (e *exitCodeError) Unwrap() error {
+	return e.err
+}
+
+// Вывод справки по команде и ошибка неверных аргументов
+func usageError(fs *flag.FlagSet, format string, args ...any) error {
+	fs.Usage()
+	return &exitCodeError{exitUsage, fmt.Errorf(format, args...)}
+}
+
+// Код завершения для ошибки команды
+func exitCode(err error) int {
+	var codeErr *exitCodeError
+	var trackerErr *torrentfile.TrackerError
+	var syntaxErr *bencode.SyntaxError
+	var typeErr *bencode.UnmarshalTypeError
+	var netErr net.Error
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.As(err, &codeErr):
+		return codeErr.code
+	case errors.As(err, &syntaxErr), errors.As(err, &typeErr):
+		return exitInvalid
+	case errors.As(err, &trackerErr), errors.As(err, &netErr), errors.Is(err, download.ErrNoPeers):
+		return exitNetwork
+	}
+	return exitError
+}
+
+// Открытие .torrent файла. Ошибки разбора получают код exitInvalid
+func openTorrent(path string) (torrentfile.TorrentFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return torrentfile.TorrentFile{}, err
+	}
+	tf, err := torrentfile.Parse(data)
+	if err != nil {
+		return torrentfile.TorrentFile{}, &exitCodeError{exitInvalid, fmt.Errorf("%s: %v", path, err)}
+	}
+	return tf, nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/swesdek/gotorrent-client/config"
+	"github.com/swesdek/gotorrent-client/torrentfile"
+)
+
+// Флаг, который можно указать несколько раз
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// Флаг с количеством байт: число с необязательным суффиксом K, M или G (степени 1024)
+type byteSize int64
+
+func (s *byteSize) String() string {
+	return strconv.FormatInt(int64(*s), 10)
+}
+
+func (s *byteSize) Set(value string) error {
+	n, err := config.ParseSize(value)
+	*s = byteSize(n)
+	return err
+}
+
+// Флаги соединений с пирами, общие для команд скачивания
+type peerFlags struct {
+	configPath   string
+	port         uint
+	peerIDPrefix string
+	downRate     byteSize
+	upRate       byteSize
+	maxConns     int
+	verbose      bool
+	quiet        bool
+}
+
+// Регистрация флагов соединений с пирами. Значения по умолчанию берутся
+// из config.Default, но действуют только явно указанные флаги
+func addPeerFlags(fs *flag.FlagSet) *peerFlags {
+	d := config.Default()
+	f := &peerFlags{}
+	fs.StringVar(&f.configPath, "config", "", fmt.Sprintf("configuration file (default %s if it exists)", config.DefaultPath()))
+	fs.UintVar(&f.port, "port", uint(d.Network.Port), "listen port reported to trackers")
+	fs.StringVar(&f.peerIDPrefix, "peer-id-prefix", d.Network.PeerIDPrefix, "prefix of the peer ID, the rest is random")
+	fs.Var(&f.downRate, "down-rate", "download limit in bytes per second, e.g. 512K (0 for no limit)")
+	fs.Var(&f.upRate, "up-rate", "upload limit in bytes per second (0 for no limit)")
+	fs.IntVar(&f.maxConns, "max-conns", d.Network.MaxConnections, "limit of peer connections (0 for no limit)")
+	fs.BoolVar(&f.verbose, "v", false, "print peer and web seed activity")
+	fs.BoolVar(&f.quiet, "q", false, "print only errors")
+	return f
+}
+
+// Проверка значений флагов
+func (f *peerFlags) validate(fs *flag.FlagSet) error {
+	if f.port == 0 || f.port > 65535 {
+		return usageError(fs, "Invalid port %d", f.port)
+	}
+	if len(f.peerIDPrefix) > 20 {
+		return usageError(fs, "Peer ID prefix %q is longer than 20 bytes", f.peerIDPrefix)
+	}
+	if f.maxConns < 0 {
+		return usageError(fs, "Invalid connection limit %d", f.maxConns)
+	}
+	if f.verbose && f.quiet {
+		return usageError(fs, "Flags -v and -q are mutually exclusive")
+	}
+	return nil
+}
+
+// Путь к файлу конфигурации: из флага -config или файл по умолчанию, если он есть
+func (f *peerFlags) configFile() string {
+	if f.configPath != "" {
+		return f.configPath
+	}
+	_, err := os.Stat(config.DefaultPath())
+	if err != nil {
+		return ""
+	}
+	return config.DefaultPath()
+}
+
+// Загрузка настроек. Явно указанные флаги переопределяют файл конфигурации
+// и переменные окружения
+func (f *peerFlags) load(fs *flag.FlagSet) (*config.Config, error) {
+	cfg, err := config.Load(f.configFile())
+	if err != nil {
+		return nil, err
+	}
+	fs.Visit(func(fl *flag.Flag) {
+		switch fl.Name {
+		case "port":
+			cfg.Network.Port = uint16(f.port)
+		case "peer-id-prefix":
+			cfg.Network.PeerIDPrefix = f.peerIDPrefix
+		case "down-rate":
+			cfg.Limits.DownloadRate = int64(f.downRate)
+		case "up-rate":
+			cfg.Limits.UploadRate = int64(f.upRate)
+		case "max-conns":
+			cfg.Network.MaxConnections = f.maxConns
+		}
+	})
+	return cfg, cfg.Validate()
+}
+
+// Замена трекеров торрента одним трекером url
+func overrideTracker(tf *torrentfile.TorrentFile, url string) {
+	if url == "" {
+		return
+	}
+	tf.Announce = url
+	tf.AnnounceList = nil
+}
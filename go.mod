@@ -2,11 +2,11 @@ module github.com/swesdek/gotorrent-client
 
 go 1.22.2
 
+require github.com/schollz/progressbar/v3 v3.14.6
+
 require (
-	github.com/jackpal/bencode-go v1.0.2 // indirect
 	github.com/mitchellh/colorstring v0.0.0-20190213212951-d06e56a500db // indirect
 	github.com/rivo/uniseg v0.4.7 // indirect
-	github.com/schollz/progressbar/v3 v3.14.6 // indirect
 	golang.org/x/sys v0.25.0 // indirect
 	golang.org/x/term v0.24.0 // indirect
 )
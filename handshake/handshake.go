@@ -5,21 +5,25 @@ import (
 	"io"
 )
 
+// Бит зарезервированных байт, сообщающий о поддержке Fast Extension (BEP 6)
+const fastExtensionBit = 0x04
+
 type Handshake struct {
 	Pstr     string
+	Reserved [8]byte // Флаги поддерживаемых расширений
 	Infohash [20]byte
 	PeerID   [20]byte
 }
 
 // Функция сериализации данных для передачи по сети
 func (h *Handshake) Serialize() []byte {
-	buf := make([]byte, len(h.Pstr)+49)       // Буфер, в который будет записываться вся информация для хендшейка
-	buf[0] = byte(len(h.Pstr))                // Первый параметр, записанный в буфер - длина названия протокола
-	curr := 1                                 // Переменная, с помощью которой буду идти по порядку данных для их записи
-	curr += copy(buf[curr:], h.Pstr)          // Записываю название протокола
-	curr += copy(buf[curr:], make([]byte, 8)) // 8 пустых байт - опциональные параметры, который не использую
-	curr += copy(buf[curr:], h.Infohash[:])   // Записываю хэш торрента
-	curr += copy(buf[curr:], h.PeerID[:])     // и ID своего пира
+	buf := make([]byte, len(h.Pstr)+49)     // Буфер, в который будет записываться вся информация для хендшейка
+	buf[0] = byte(len(h.Pstr))              // Первый параметр, записанный в буфер - длина названия протокола
+	curr := 1                               // Переменная, с помощью которой буду идти по порядку данных для их записи
+	curr += copy(buf[curr:], h.Pstr)        // Записываю название протокола
+	curr += copy(buf[curr:], h.Reserved[:]) // 8 байт флагов поддерживаемых расширений
+	curr += copy(buf[curr:], h.Infohash[:]) // Записываю хэш торрента
+	curr += copy(buf[curr:], h.PeerID[:])   // и ID своего пира
 	return buf
 }
 
@@ -43,12 +47,15 @@ func Read(r io.Reader) (*Handshake, error) {
 	}
 
 	var infoHash, peerID [20]byte // Переменные для извлечения параметров из буфера в итоговый объект
+	var reserved [8]byte
 
+	copy(reserved[:], handshakeBuf[pstrLen:pstrLen+8])
 	copy(infoHash[:], handshakeBuf[pstrLen+8:pstrLen+8+20]) // Копирование данных в итоговый обьект
 	copy(peerID[:], handshakeBuf[pstrLen+8+20:])
 
 	h := Handshake{
 		Pstr:     string(handshakeBuf[0:pstrLen]),
+		Reserved: reserved,
 		Infohash: infoHash,
 		PeerID:   peerID,
 	}
@@ -56,11 +63,18 @@ func Read(r io.Reader) (*Handshake, error) {
 	return &h, nil
 }
 
+// Поддерживает ли сторона хендшейка Fast Extension
+func (h *Handshake) SupportsFast() bool {
+	return h.Reserved[7]&fastExtensionBit != 0
+}
+
 // Инициализатор объекта хендшейка
 func New(infohash [20]byte, peerID [20]byte) *Handshake {
-	return &Handshake{
+	h := &Handshake{
 		Pstr:     "BitTorrent protocol",
 		Infohash: infohash,
 		PeerID:   peerID,
 	}
+	h.Reserved[7] |= fastExtensionBit
+	return h
 }
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/base32"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/swesdek/gotorrent-client/torrentfile"
+)
+
+// Описание файла в выводе команды info
+type infoFile struct {
+	Path   string `json:"path"`
+	Length int    `json:"length"`
+}
+
+// Содержимое торрента в выводе команды info
+type infoOutput struct {
+	Name           string     `json:"name"`
+	InfoHash       string     `json:"info_hash"`
+	InfoHashBase32 string     `json:"info_hash_base32"`
+	InfoHashV2     string     `json:"info_hash_v2,omitempty"`
+	MetaVersion    int        `json:"meta_version,omitempty"`
+	Size           int        `json:"size"`
+	PieceLength    int        `json:"piece_length"`
+	Pieces         int        `json:"pieces"`
+	Files          []infoFile `json:"files"`
+	Trackers       [][]string `json:"trackers"`
+	WebSeeds       []string   `json:"web_seeds"`
+	Private        bool       `json:"private"`
+	Comment        string     `json:"comment,omitempty"`
+	CreatedBy      string     `json:"created_by,omitempty"`
+	CreationDate   *time.Time `json:"creation_date,omitempty"`
+	Magnet         string     `json:"magnet"`
+}
+
+// Сборка описания торрента из TorrentFile
+func newInfoOutput(tf *torrentfile.TorrentFile) infoOutput {
+	out := infoOutput{
+		Name:           tf.Name,
+		InfoHash:       hex.EncodeToString(tf.InfoHash[:]),
+		InfoHashBase32: base32.StdEncoding.EncodeToString(tf.InfoHash[:]),
+		MetaVersion:    tf.MetaVersion,
+		Size:           tf.Length,
+		PieceLength:    tf.PieceLength,
+		Pieces:         tf.NumPieces(),
+		Files:          []infoFile{},
+		Trackers:       tf.Trackers(),
+		WebSeeds:       tf.WebSeeds,
+		Private:        tf.Private,
+		Comment:        tf.Comment,
+		CreatedBy:      tf.CreatedBy,
+		Magnet:         tf.Magnet(),
+	}
+	if tf.MetaVersion == 2 {
+		out.InfoHashV2 = hex.EncodeToString(tf.InfoHashV2[:])
+	}
+	if !tf.CreationDate.IsZero() {
+		out.CreationDate = &tf.CreationDate
+	}
+	if out.Trackers == nil {
+		out.Trackers = [][]string{}
+	}
+	if out.WebSeeds == nil {
+		out.WebSeeds = []string{}
+	}
+
+	size := 0 // Размер без файлов выравнивания
+	for _, f := range tf.Files {
+		if f.Padding {
+			continue
+		}
+		out.Files = append(out.Files, infoFile{Path: strings.Join(f.Path, "/"), Length: f.Length})
+		size += f.Length
+	}
+	if len(tf.Files) == 0 {
+		out.Files = append(out.Files, infoFile{Path: tf.Name, Length: tf.Length})
+	} else {
+		out.Size = size
+	}
+	return out
+}
+
+// Размер в удобочитаемом виде
+func formatSize(n int) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	value := float64(n)
+	suffixes := []string{"KiB", "MiB", "GiB", "TiB", "PiB"}
+	i := -1
+	for value >= unit && i < len(suffixes)-1 {
+		value /= unit
+		i++
+	}
+	return fmt.Sprintf("%.2f %s", value, suffixes[i])
+}
+
+// Узел дерева файлов для вывода
+type fileNode struct {
+	children map[string]*fileNode
+	length   int
+	isFile   bool
+}
+
+// Вывод дерева файлов с отступами, директории и файлы в порядке сортировки имен
+func printTree(node *fileNode, indent string) {
+	names := make([]string, 0, len(node.children))
+	for name := range node.children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		child := node.children[name]
+		if child.isFile {
+			fmt.Printf("%s%s (%s)\n", indent, name, formatSize(child.length))
+			continue
+		}
+		fmt.Printf("%s%s/\n", indent, name)
+		printTree(child, indent+"  ")
+	}
+}
+
+// Вывод описания торрента в текстовом виде
+func printInfo(out infoOutput) {
+	fmt.Printf("Name:         %s\n", out.Name)
+	fmt.Printf("Info hash:    %s\n", out.InfoHash)
+	fmt.Printf("              %s\n", out.InfoHashBase32)
+	if out.InfoHashV2 != "" {
+		fmt.Printf("Info hash v2: %s\n", out.InfoHashV2)
+	}
+	fmt.Printf("Size:         %s (%d bytes)\n", formatSize(out.Size), out.Size)
+	fmt.Printf("Pieces:       %d x %s\n", out.Pieces, formatSize(out.PieceLength))
+	fmt.Printf("Private:      %t\n", out.Private)
+	if out.Comment != "" {
+		fmt.Printf("Comment:      %s\n", out.Comment)
+	}
+	if out.CreatedBy != "" {
+		fmt.Printf("Created by:   %s\n", out.CreatedBy)
+	}
+	if out.CreationDate != nil {
+		fmt.Printf("Created on:   %s\n", out.CreationDate.Format(time.RFC1123))
+	}
+
+	if len(out.Trackers) > 0 {
+		fmt.Println("Trackers:")
+		for i, tier := range out.Trackers {
+			for _, tracker := range tier {
+				fmt.Printf("  [%d] %s\n", i, tracker)
+			}
+		}
+	}
+	if len(out.WebSeeds) > 0 {
+		fmt.Println("Web seeds:")
+		for _, seed := range out.WebSeeds {
+			fmt.Printf("  %s\n", seed)
+		}
+	}
+
+	fmt.Println("Files:")
+	root := &fileNode{children: map[string]*fileNode{}}
+	for _, f := range out.Files {
+		node := root
+		for _, part := range strings.Split(f.Path, "/") {
+			child, ok := node.children[part]
+			if !ok {
+				child = &fileNode{children: map[string]*fileNode{}}
+				node.children[part] = child
+			}
+			node = child
+		}
+		node.isFile = true
+		node.length = f.Length
+	}
+	printTree(root, "  ")
+
+	fmt.Printf("Magnet:       %s\n", out.Magnet)
+}
+
+// Команда info: вывод содержимого .torrent файла
+func info(args []string) error {
+	fs := flag.NewFlagSet("info", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print the description as JSON")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: gotorrent-client info [flags] file.torrent")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return usageError(fs, "Expected exactly one .torrent file")
+	}
+
+	tf, err := openTorrent(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	out := newInfoOutput(&tf)
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false) // Символы & в magnet-ссылке выводятся как есть
+		return enc.Encode(out)
+	}
+	printInfo(out)
+	return nil
+}
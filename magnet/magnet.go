@@ -0,0 +1,227 @@
+package magnet
+
+import (
+	"encoding/base32"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Префиксы идентификаторов в параметре xt
+const (
+	btihPrefix = "urn:btih:" // Info hash BitTorrent v1
+	btmhPrefix = "urn:btmh:" // Мультихеш info BitTorrent v2
+)
+
+// Мультихеш SHA-256: код функции 0x12 и длина 0x20
+const sha256Multihash = "1220"
+
+// Наибольший номер файла в параметре so
+const maxFileIndex = 1 << 20
+
+// Содержимое magnet-ссылки
+type Magnet struct {
+	InfoHash      [20]byte // xt=urn:btih
+	HasInfoHash   bool
+	InfoHashV2    [32]byte // xt=urn:btmh
+	HasInfoHashV2 bool
+	Name          string   // dn
+	Length        int64    // xl, 0 если не указана
+	Trackers      []string // tr
+	WebSeeds      []string // ws
+	Peers         []string // x.pe, адреса вида host:port
+	Sources       []string // xs, ссылки на .torrent файл
+	SelectOnly    []int    // so, номера выбранных файлов по возрастанию
+}
+
+// Имя параметра без числового суффикса вида "xt.1"
+func paramName(key string) string {
+	name, suffix, found := strings.Cut(key, ".")
+	if !found {
+		return key
+	}
+	if _, err := strconv.Atoi(suffix); err != nil { // x.pe и подобные имена с точкой
+		return key
+	}
+	return name
+}
+
+// Разбор info hash v1 в шестнадцатеричном (40 символов) или base32 (32 символа) виде
+func parseInfoHash(s string) ([20]byte, error) {
+	var hash [20]byte
+	var raw []byte
+	var err error
+	switch len(s) {
+	case 40:
+		raw, err = hex.DecodeString(s)
+	case 32:
+		raw, err = base32.StdEncoding.DecodeString(strings.ToUpper(s))
+	default:
+		return hash, fmt.Errorf("Info hash %q has invalid length %d", s, len(s))
+	}
+	if err != nil {
+		return hash, fmt.Errorf("Malformed info hash %q", s)
+	}
+	copy(hash[:], raw)
+	return hash, nil
+}
+
+// Разбор мультихеша SHA-256 info BitTorrent v2
+func parseMultihash(s string) ([32]byte, error) {
+	var hash [32]byte
+	if !strings.HasPrefix(s, sha256Multihash) || len(s) != len(sha256Multihash)+64 {
+		return hash, fmt.Errorf("Unsupported multihash %q", s)
+	}
+	raw, err := hex.DecodeString(s[len(sha256Multihash):])
+	if err != nil {
+		return hash, fmt.Errorf("Malformed multihash %q", s)
+	}
+	copy(hash[:], raw)
+	return hash, nil
+}
+
+// Разбор списка файлов вида "0,2,4-6"
+func parseSelectOnly(s string) ([]int, error) {
+	seen := make(map[int]bool)
+	var res []int
+	for _, part := range strings.Split(s, ",") {
+		first, last, isRange := strings.Cut(part, "-")
+		from, err := strconv.Atoi(first)
+		to := from
+		if err == nil && isRange {
+			to, err = strconv.Atoi(last)
+		}
+		if err != nil || from < 0 || to < from || to > maxFileIndex {
+			return nil, fmt.Errorf("Malformed file selection %q", part)
+		}
+		for i := from; i <= to; i++ {
+			if !seen[i] {
+				seen[i] = true
+				res = append(res, i)
+			}
+		}
+	}
+	sort.Ints(res)
+	return res, nil
+}
+
+// Разбор magnet-ссылки. Неизвестные параметры пропускаются
+func Parse(uri string) (Magnet, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return Magnet{}, err
+	}
+	if u.Scheme != "magnet" {
+		return Magnet{}, fmt.Errorf("Expected magnet URI, but got scheme %q", u.Scheme)
+	}
+
+	m := Magnet{}
+	for _, param := range strings.Split(u.RawQuery, "&") { // Параметры разбираются в порядке записи
+		if param == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(param, "=")
+		key, err = url.QueryUnescape(key)
+		if err != nil {
+			return Magnet{}, err
+		}
+		value, err = url.QueryUnescape(value)
+		if err != nil {
+			return Magnet{}, err
+		}
+
+		switch paramName(key) {
+		case "xt":
+			switch {
+			case strings.HasPrefix(value, btihPrefix):
+				m.InfoHash, err = parseInfoHash(value[len(btihPrefix):])
+				m.HasInfoHash = true
+			case strings.HasPrefix(value, btmhPrefix):
+				m.InfoHashV2, err = parseMultihash(value[len(btmhPrefix):])
+				m.HasInfoHashV2 = true
+			}
+		case "dn":
+			m.Name = value
+		case "xl":
+			m.Length, err = strconv.ParseInt(value, 10, 64)
+			if err != nil || m.Length < 0 {
+				err = fmt.Errorf("Malformed exact length %q", value)
+			}
+		case "tr":
+			m.Trackers = append(m.Trackers, value)
+		case "ws":
+			m.WebSeeds = append(m.WebSeeds, value)
+		case "x.pe":
+			m.Peers = append(m.Peers, value)
+		case "xs":
+			m.Sources = append(m.Sources, value)
+		case "so":
+			m.SelectOnly, err = parseSelectOnly(value)
+		}
+		if err != nil {
+			return Magnet{}, err
+		}
+	}
+
+	if !m.HasInfoHash && !m.HasInfoHashV2 {
+		return Magnet{}, fmt.Errorf("Magnet URI has no BitTorrent info hash")
+	}
+	return m, nil
+}
+
+// Запись списка файлов с объединением подряд идущих номеров в диапазоны
+func formatSelectOnly(files []int) string {
+	sorted := append([]int(nil), files...)
+	sort.Ints(sorted)
+
+	var parts []string
+	for i := 0; i < len(sorted); {
+		j := i
+		for j+1 < len(sorted) && sorted[j+1] <= sorted[j]+1 {
+			j++
+		}
+		if sorted[i] == sorted[j] {
+			parts = append(parts, strconv.Itoa(sorted[i]))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d-%d", sorted[i], sorted[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ",")
+}
+
+// Создание magnet-ссылки
+func (m Magnet) String() string {
+	var params []string
+	if m.HasInfoHash {
+		params = append(params, "xt="+btihPrefix+hex.EncodeToString(m.InfoHash[:]))
+	}
+	if m.HasInfoHashV2 {
+		params = append(params, "xt="+btmhPrefix+sha256Multihash+hex.EncodeToString(m.InfoHashV2[:]))
+	}
+	if m.Name != "" {
+		params = append(params, "dn="+url.QueryEscape(m.Name))
+	}
+	if m.Length > 0 {
+		params = append(params, "xl="+strconv.FormatInt(m.Length, 10))
+	}
+	for _, tracker := range m.Trackers {
+		params = append(params, "tr="+url.QueryEscape(tracker))
+	}
+	for _, seed := range m.WebSeeds {
+		params = append(params, "ws="+url.QueryEscape(seed))
+	}
+	for _, peer := range m.Peers {
+		params = append(params, "x.pe="+url.QueryEscape(peer))
+	}
+	for _, source := range m.Sources {
+		params = append(params, "xs="+url.QueryEscape(source))
+	}
+	if len(m.SelectOnly) > 0 {
+		params = append(params, "so="+formatSelectOnly(m.SelectOnly))
+	}
+	return "magnet:?" + strings.Join(params, "&")
+}
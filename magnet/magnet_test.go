@@ -0,0 +1,115 @@
+package magnet
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const (
+	hexHash    = "c12fe1c06bba254a9dc9f519b335aa7c1367a88a"
+	base32Hash = "YEX6DQDLXISUVHOJ6UM3GNNKPQJWPKEK" // Тот же хеш в base32
+	v2Hash     = "1220caf1e1c30e81cb361b9ee167c4aa64228a7fa4fa9f6105232b28ad099f3a302e"
+)
+
+var hash = [20]byte{0xc1, 0x2f, 0xe1, 0xc0, 0x6b, 0xba, 0x25, 0x4a, 0x9d, 0xc9, 0xf5, 0x19, 0xb3, 0x35, 0xaa, 0x7c, 0x13, 0x67, 0xa8, 0x8a}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		uri  string
+		want Magnet
+	}{
+		{"hex info hash", "magnet:?xt=urn:btih:" + hexHash, Magnet{InfoHash: hash, HasInfoHash: true}},
+		{"uppercase hex", "magnet:?xt=urn:btih:" + strings.ToUpper(hexHash), Magnet{InfoHash: hash, HasInfoHash: true}},
+		{"base32 info hash", "magnet:?xt=urn:btih:" + base32Hash, Magnet{InfoHash: hash, HasInfoHash: true}},
+		{"lowercase base32", "magnet:?xt=urn:btih:" + strings.ToLower(base32Hash), Magnet{InfoHash: hash, HasInfoHash: true}},
+		{"all parameters",
+			"magnet:?xt=urn:btih:" + hexHash + "&dn=My+File%21&xl=1024" +
+				"&tr=http%3A%2F%2Ft1%2Fannounce&tr.1=udp://t2:80&ws=http://seed/f" +
+				"&x.pe=1.2.3.4:6881&x.pe=[::1]:51413&xs=http://host/f.torrent&so=4-6,0,2,5&unknown=x",
+			Magnet{
+				InfoHash: hash, HasInfoHash: true,
+				Name:       "My File!",
+				Length:     1024,
+				Trackers:   []string{"http://t1/announce", "udp://t2:80"},
+				WebSeeds:   []string{"http://seed/f"},
+				Peers:      []string{"1.2.3.4:6881", "[::1]:51413"},
+				Sources:    []string{"http://host/f.torrent"},
+				SelectOnly: []int{0, 2, 4, 5, 6},
+			}},
+		{"v2 only", "magnet:?xt=urn:btmh:" + v2Hash, Magnet{
+			InfoHashV2: [32]byte{0xca, 0xf1, 0xe1, 0xc3, 0x0e, 0x81, 0xcb, 0x36, 0x1b, 0x9e, 0xe1, 0x67, 0xc4, 0xaa, 0x64, 0x22,
+				0x8a, 0x7f, 0xa4, 0xfa, 0x9f, 0x61, 0x05, 0x23, 0x2b, 0x28, 0xad, 0x09, 0x9f, 0x3a, 0x30, 0x2e},
+			HasInfoHashV2: true,
+		}},
+		{"empty parameters skipped", "magnet:?&xt=urn:btih:" + hexHash + "&&", Magnet{InfoHash: hash, HasInfoHash: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.uri)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		uri  string
+		want string
+	}{
+		{"http://example.com/?xt=urn:btih:" + hexHash, "scheme"},
+		{"magnet:?dn=name", "no BitTorrent info hash"},
+		{"magnet:?xt=urn:sha1:" + hexHash, "no BitTorrent info hash"},
+		{"magnet:?xt=urn:btih:abc", "invalid length"},
+		{"magnet:?xt=urn:btih:" + strings.Repeat("z", 40), "Malformed info hash"},
+		{"magnet:?xt=urn:btih:" + strings.Repeat("1", 32), "Malformed info hash"},
+		{"magnet:?xt=urn:btmh:1114" + strings.Repeat("0", 40), "Unsupported multihash"},
+		{"magnet:?xt=urn:btmh:1220" + strings.Repeat("x", 64), "Malformed multihash"},
+		{"magnet:?xt=urn:btih:" + hexHash + "&xl=-1", "exact length"},
+		{"magnet:?xt=urn:btih:" + hexHash + "&xl=big", "exact length"},
+		{"magnet:?xt=urn:btih:" + hexHash + "&so=3-1", "file selection"},
+		{"magnet:?xt=urn:btih:" + hexHash + "&so=-1", "file selection"},
+		{"magnet:?xt=urn:btih:" + hexHash + "&so=0-99999999", "file selection"},
+		{"magnet:?xt=urn:btih:" + hexHash + "&dn=%zz", "invalid URL escape"},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.uri)
+		if err == nil {
+			t.Errorf("Parse(%q) succeeded", tt.uri)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Parse(%q) = %v, want %q", tt.uri, err, tt.want)
+		}
+	}
+}
+
+func TestStringRoundTrip(t *testing.T) {
+	m := Magnet{
+		InfoHash: hash, HasInfoHash: true,
+		Name:       "a & b = c",
+		Length:     42,
+		Trackers:   []string{"http://t/announce?key=1&x=2"},
+		WebSeeds:   []string{"http://seed/"},
+		Peers:      []string{"10.0.0.1:6881"},
+		Sources:    []string{"http://host/f.torrent"},
+		SelectOnly: []int{0, 1, 2, 5, 7, 8},
+	}
+	uri := m.String()
+	if !strings.Contains(uri, "so=0-2,5,7-8") {
+		t.Errorf("file selection not compacted: %s", uri)
+	}
+	got, err := Parse(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, m) {
+		t.Errorf("round trip %s\ngot  %+v\nwant %+v", uri, got, m)
+	}
+}
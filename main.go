@@ -3,24 +3,61 @@ package main
 import (
 	"fmt"
 	"os"
-
-	"github.com/swesdek/gotorrent-client/torrentfile"
 )
 
-func main() {
-	fromPath := os.Args[1]
-	toPath := os.Args[2]
+// Подкоманда командной строки
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) error
+}
+
+// Подкоманды в порядке вывода в справке
+var commands = []command{
+	{"download", "download a torrent into a directory", downloadTorrent},
+	{"create", "create a .torrent file from a file or directory", create},
+	{"info", "show the contents of a .torrent file", info},
+	{"verify", "check downloaded data against a .torrent file", verify},
+	{"scrape", "ask the tracker for seeder and leecher counts", scrape},
+	{"serve", "run a session with the HTTP API in the foreground", serve},
+	{"daemon", "run serve in the background", daemon},
+}
 
-	tf, err := torrentfile.Open(fromPath) // Открытие .torrent и считывание данных
-	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+// Общая справка
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: gotorrent-client <command> [flags] [arguments]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Commands:")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-9s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, `Run "gotorrent-client <command> -h" for the flags of a command.`)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(exitUsage)
+	}
+	name := os.Args[1]
+	if name == "help" || name == "-h" || name == "-help" || name == "--help" {
+		usage()
+		return
 	}
 
-	err = tf.DownloadToFile(toPath) // Скачивание файла
-	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+	for _, c := range commands {
+		if c.name != name {
+			continue
+		}
+		err := c.run(os.Args[2:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "gotorrent-client %s: %v\n", name, err)
+		}
+		os.Exit(exitCode(err))
 	}
 
+	fmt.Fprintf(os.Stderr, "gotorrent-client: unknown command %q\n\n", name)
+	usage()
+	os.Exit(exitUsage)
 }
@@ -0,0 +1,99 @@
+package merkle
+
+import (
+	"crypto/sha256"
+)
+
+// Размер блока, хеши которого являются листьями дерева (BEP 52)
+const BlockSize = 16384
+
+// Хеш пары узлов дерева
+func hashPair(left, right [32]byte) [32]byte {
+	var buf [64]byte
+	copy(buf[:32], left[:])
+	copy(buf[32:], right[:])
+	return sha256.Sum256(buf[:])
+}
+
+// Наименьшая степень двойки, не меньшая n
+func NextPowerOfTwo(n int) int {
+	p := 1
+	for p < n {
+		p *= 2
+	}
+	return p
+}
+
+// Количество листьев дерева для данных длиной length (не меньше одного)
+func LeafCount(length int) int {
+	return NextPowerOfTwo((length + BlockSize - 1) / BlockSize)
+}
+
+// Хеши блоков данных по 16 КиБ (последний блок может быть короче)
+func BlockHashes(data []byte) [][32]byte {
+	hashes := make([][32]byte, 0, (len(data)+BlockSize-1)/BlockSize)
+	for begin := 0; begin < len(data); begin += BlockSize {
+		end := min(begin+BlockSize, len(data))
+		hashes = append(hashes, sha256.Sum256(data[begin:end]))
+	}
+	return hashes
+}
+
+// Корень поддерева из numLeaves нулевых листьев
+func PadHash(numLeaves int) [32]byte {
+	var h [32]byte
+	for n := numLeaves; n > 1; n /= 2 {
+		h = hashPair(h, h)
+	}
+	return h
+}
+
+// Корень дерева над уровнем layer, дополненным до степени двойки хешами pad
+func rootWithPad(layer [][32]byte, width int, pad [32]byte) [32]byte {
+	nodes := make([][32]byte, width)
+	copy(nodes, layer)
+	for i := len(layer); i < width; i++ {
+		nodes[i] = pad
+	}
+	for len(nodes) > 1 {
+		for i := 0; i < len(nodes)/2; i++ {
+			nodes[i] = hashPair(nodes[2*i], nodes[2*i+1])
+		}
+		nodes = nodes[:len(nodes)/2]
+	}
+	return nodes[0]
+}
+
+// Корень дерева над листьями, дополненными нулевыми хешами до numLeaves
+func Root(leaves [][32]byte, numLeaves int) [32]byte {
+	return rootWithPad(leaves, max(numLeaves, len(leaves), 1), [32]byte{})
+}
+
+// Корень дерева над данными с numLeaves листьями
+func DataRoot(data []byte, numLeaves int) [32]byte {
+	return Root(BlockHashes(data), numLeaves)
+}
+
+// Корень дерева файла по уровню частей, где каждый узел покрывает leavesPerNode листьев
+func RootFromLayer(layer [][32]byte, leavesPerNode int) [32]byte {
+	return rootWithPad(layer, NextPowerOfTwo(len(layer)), PadHash(leavesPerNode))
+}
+
+// Проверка хешей уровня дерева по доказательству (хешам соседних поддеревьев снизу вверх).
+// index - номер первого хеша на своем уровне, длина hashes - степень двойки
+func VerifyProof(hashes [][32]byte, index int, proof [][32]byte, root [32]byte) bool {
+	if len(hashes) == 0 || len(hashes) != NextPowerOfTwo(len(hashes)) || index%len(hashes) != 0 {
+		return false
+	}
+	h := Root(hashes, len(hashes))
+	pos := index / len(hashes) // Позиция поддерева на его уровне
+	for _, uncle := range proof {
+		if pos%2 == 0 {
+			h = hashPair(h, uncle)
+		} else {
+			h = hashPair(uncle, h)
+		}
+		pos /= 2
+	}
+	return pos == 0 && h == root
+}
@@ -0,0 +1,121 @@
+package merkle
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+// Хеш из шестнадцатеричной записи
+func mustHash(t *testing.T, s string) [32]byte {
+	t.Helper()
+	var h [32]byte
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != 32 {
+		t.Fatalf("bad test hash %q", s)
+	}
+	copy(h[:], b)
+	return h
+}
+
+// Данные из 5 блоков, последний короче BlockSize
+func testData() []byte {
+	data := make([]byte, 5*BlockSize-100)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	return data
+}
+
+func TestPadHash(t *testing.T) {
+	tests := []struct {
+		leaves int
+		want   string
+	}{
+		{1, "0000000000000000000000000000000000000000000000000000000000000000"},
+		{2, "f5a5fd42d16a20302798ef6ed309979b43003d2320d9f0e8ea9831a92759fb4b"},
+		{4, "db56114e00fdd4c1f85c892bf35ac9a89289aaecb1ebd0a96cde606a748b5d71"},
+	}
+	for _, tt := range tests {
+		if got := PadHash(tt.leaves); got != mustHash(t, tt.want) {
+			t.Errorf("PadHash(%d) = %x", tt.leaves, got)
+		}
+	}
+}
+
+func TestDataRoot(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   []byte
+		leaves int
+		want   string
+	}{
+		{"one short block", []byte("abc"), 1, "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+		{"five blocks", testData(), 8, "a1978092f50e0511dd2ea84db39af241e8bffbcd64d20974d31ab69a1a113601"},
+		{"five blocks padded to 16", testData(), 16, "9dc09a66579c530c6e10827e06889648514eefc8b4c5ffb74ffe403f9d8c3325"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DataRoot(tt.data, tt.leaves); got != mustHash(t, tt.want) {
+				t.Errorf("DataRoot = %x, want %s", got, tt.want)
+			}
+		})
+	}
+	if n := LeafCount(len(testData())); n != 8 {
+		t.Errorf("LeafCount = %d, want 8", n)
+	}
+}
+
+func TestRootFromLayer(t *testing.T) {
+	data := testData()
+	want := mustHash(t, "a1978092f50e0511dd2ea84db39af241e8bffbcd64d20974d31ab69a1a113601")
+
+	// Части по 2 блока: последняя часть из одного блока дополняется нулевым листом
+	var layer [][32]byte
+	for begin := 0; begin < len(data); begin += 2 * BlockSize {
+		end := min(begin+2*BlockSize, len(data))
+		layer = append(layer, DataRoot(data[begin:end], 2))
+	}
+	if got := RootFromLayer(layer, 2); got != want {
+		t.Errorf("RootFromLayer with 2 leaves per piece = %x", got)
+	}
+	if got := RootFromLayer(BlockHashes(data), 1); got != want {
+		t.Errorf("RootFromLayer over blocks = %x", got)
+	}
+	layer[2][0] ^= 1
+	if RootFromLayer(layer, 2) == want {
+		t.Error("corrupted layer converges to the root")
+	}
+}
+
+func TestVerifyProof(t *testing.T) {
+	leaves := BlockHashes(testData())[:4]
+	root := Root(leaves, 4)
+	left := hashPair(leaves[0], leaves[1])
+	right := hashPair(leaves[2], leaves[3])
+	tests := []struct {
+		name   string
+		hashes [][32]byte
+		index  int
+		proof  [][32]byte
+		want   bool
+	}{
+		{"leaf 2", leaves[2:3], 2, [][32]byte{leaves[3], left}, true},
+		{"leaf 1", leaves[1:2], 1, [][32]byte{leaves[0], right}, true},
+		{"right pair", leaves[2:4], 2, [][32]byte{left}, true},
+		{"whole tree", leaves, 0, nil, true},
+		{"wrong index", leaves[2:3], 3, [][32]byte{leaves[3], left}, false},
+		{"wrong uncle", leaves[2:3], 2, [][32]byte{leaves[0], left}, false},
+		{"proof too short", leaves[2:3], 2, [][32]byte{leaves[3]}, false},
+		{"misaligned pair", leaves[1:3], 1, [][32]byte{right}, false},
+		{"three hashes", leaves[:3], 0, [][32]byte{sha256.Sum256(nil)}, false},
+		{"no hashes", nil, 0, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := VerifyProof(tt.hashes, tt.index, tt.proof, root); got != tt.want {
+				t.Errorf("VerifyProof = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
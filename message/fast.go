@@ -0,0 +1,89 @@
+package message
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+// Создание сообщения с индексом части файла
+func formatIndex(id messageID, index int) *Message {
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint32(payload, uint32(index))
+	return &Message{
+		ID:      id,
+		Payload: payload,
+	}
+}
+
+// Создание MsgSuggest с советом запросить часть index
+func FormatSuggest(index int) *Message {
+	return formatIndex(MsgSuggest, index)
+}
+
+// Создание MsgAllowedFast, разрешающего запрашивать часть index при блокировке
+func FormatAllowedFast(index int) *Message {
+	return formatIndex(MsgAllowedFast, index)
+}
+
+// Создание MsgReject в ответ на запрос блока
+func FormatReject(index, begin, length int) *Message {
+	msg := FormatRequest(index, begin, length)
+	msg.ID = MsgReject
+	return msg
+}
+
+// Считывание индекса из MsgSuggest
+func ParseSuggest(msg *Message) (int, error) {
+	return parseIndex(MsgSuggest, msg)
+}
+
+// Считывание индекса из MsgAllowedFast
+func ParseAllowedFast(msg *Message) (int, error) {
+	return parseIndex(MsgAllowedFast, msg)
+}
+
+// Считывание параметров блока из MsgRequest или MsgReject
+func ParseRequest(msg *Message) (index, begin, length int, err error) {
+	if msg.ID != MsgRequest && msg.ID != MsgReject {
+		return 0, 0, 0, fmt.Errorf("Expected request or reject, but got ID %d", msg.ID)
+	}
+	if len(msg.Payload) != 12 {
+		return 0, 0, 0, fmt.Errorf("Expected payload length 12, but got length of %d", len(msg.Payload))
+	}
+	index = int(binary.BigEndian.Uint32(msg.Payload[0:4]))
+	begin = int(binary.BigEndian.Uint32(msg.Payload[4:8]))
+	length = int(binary.BigEndian.Uint32(msg.Payload[8:12]))
+	return index, begin, length, nil
+}
+
+// Вычисление набора allowed fast из k частей для пира с адресом ip (BEP 6)
+func AllowedFastSet(ip net.IP, infoHash [20]byte, numPieces, k int) []int {
+	if k > numPieces {
+		k = numPieces
+	}
+	ip4 := ip.To4()
+	if ip4 == nil || k <= 0 {
+		return nil // Алгоритм определен только для IPv4
+	}
+
+	x := make([]byte, 0, 24)
+	x = append(x, ip4[0], ip4[1], ip4[2], 0) // Учитываются только первые три байта адреса
+	x = append(x, infoHash[:]...)
+
+	set := make([]int, 0, k)
+	seen := make(map[int]bool, k)
+	for len(set) < k {
+		sum := sha1.Sum(x)
+		x = sum[:]
+		for i := 0; i < 5 && len(set) < k; i++ {
+			index := int(binary.BigEndian.Uint32(x[i*4:i*4+4]) % uint32(numPieces))
+			if !seen[index] {
+				seen[index] = true
+				set = append(set, index)
+			}
+		}
+	}
+	return set
+}
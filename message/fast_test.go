@@ -0,0 +1,96 @@
+package message
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"testing"
+)
+
+// Сообщение после сериализации и повторного чтения
+func roundTrip(t *testing.T, msg *Message) *Message {
+	t.Helper()
+	got, err := Read(bytes.NewReader(msg.Serialize()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == nil || got.ID != msg.ID {
+		t.Fatalf("read %+v, want ID %d", got, msg.ID)
+	}
+	return got
+}
+
+func TestFastMessages(t *testing.T) {
+	wire := FormatSuggest(7).Serialize()
+	if want := []byte{0, 0, 0, 5, 0x0D, 0, 0, 0, 7}; !bytes.Equal(wire, want) {
+		t.Errorf("suggest on the wire %v, want %v", wire, want)
+	}
+	if index, err := ParseSuggest(roundTrip(t, FormatSuggest(7))); err != nil || index != 7 {
+		t.Errorf("suggest: %d, %v", index, err)
+	}
+	if index, err := ParseAllowedFast(roundTrip(t, FormatAllowedFast(1313))); err != nil || index != 1313 {
+		t.Errorf("allowed fast: %d, %v", index, err)
+	}
+
+	index, begin, length, err := ParseRequest(roundTrip(t, FormatReject(3, 16384, 1000)))
+	if err != nil || index != 3 || begin != 16384 || length != 1000 {
+		t.Errorf("reject: %d, %d, %d, %v", index, begin, length, err)
+	}
+
+	for _, id := range []messageID{MsgHaveAll, MsgHaveNone} {
+		wire := (&Message{ID: id}).Serialize()
+		if want := []byte{0, 0, 0, 1, byte(id)}; !bytes.Equal(wire, want) {
+			t.Errorf("message %d on the wire %v, want %v", id, wire, want)
+		}
+		if msg := roundTrip(t, &Message{ID: id}); len(msg.Payload) != 0 {
+			t.Errorf("message %d has payload %v", id, msg.Payload)
+		}
+	}
+}
+
+func TestFastMessagesRejectMalformed(t *testing.T) {
+	if _, err := ParseAllowedFast(FormatSuggest(1)); err == nil {
+		t.Error("suggest parsed as allowed fast")
+	}
+	if _, err := ParseSuggest(&Message{ID: MsgSuggest, Payload: []byte{1, 2}}); err == nil {
+		t.Error("short suggest accepted")
+	}
+	if _, _, _, err := ParseRequest(FormatHave(1)); err == nil {
+		t.Error("have parsed as reject")
+	}
+	if _, _, _, err := ParseRequest(&Message{ID: MsgReject, Payload: make([]byte, 8)}); err == nil {
+		t.Error("short reject accepted")
+	}
+}
+
+func TestAllowedFastSet(t *testing.T) {
+	// Пример из BEP 6
+	ip := net.IPv4(80, 4, 4, 200)
+	var infoHash [20]byte
+	for i := range infoHash {
+		infoHash[i] = 0xaa
+	}
+	tests := []struct {
+		k    int
+		want []int
+	}{
+		{7, []int{1059, 431, 808, 1217, 287, 376, 1188}},
+		{9, []int{1059, 431, 808, 1217, 287, 376, 1188, 353, 508}},
+	}
+	for _, tt := range tests {
+		if got := AllowedFastSet(ip, infoHash, 1313, tt.k); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("k=%d: %v, want %v", tt.k, got, tt.want)
+		}
+	}
+
+	// Учитываются только первые три байта адреса
+	if got := AllowedFastSet(net.IPv4(80, 4, 4, 1), infoHash, 1313, 7); !reflect.DeepEqual(got, tests[0].want) {
+		t.Errorf("same /24 gives %v", got)
+	}
+	if got := AllowedFastSet(ip, infoHash, 3, 10); len(got) != 3 {
+		t.Errorf("set of %d pieces for a 3-piece torrent", len(got))
+	}
+	if got := AllowedFastSet(net.ParseIP("::2"), infoHash, 1313, 7); got != nil {
+		t.Errorf("set %v for IPv6 peer", got)
+	}
+}
@@ -35,6 +35,21 @@ const (
 
 	// Отмена запроса
 	MsgCancel messageID = 8
+
+	// Fast Extension (BEP 6): совет запросить определенную часть
+	MsgSuggest messageID = 0x0D
+
+	// Fast Extension: у пира есть все части файла
+	MsgHaveAll messageID = 0x0E
+
+	// Fast Extension: у пира нет ни одной части файла
+	MsgHaveNone messageID = 0x0F
+
+	// Fast Extension: отказ в выполнении запроса
+	MsgReject messageID = 0x10
+
+	// Fast Extension: часть, которую можно запрашивать даже при блокировке
+	MsgAllowedFast messageID = 0x11
 )
 
 type Message struct {
@@ -105,10 +120,10 @@ func FormatHave(index int) *Message {
 	}
 }
 
-// Считывание MsgHave и индекса куска файла от других пиров
-func ParseHave(msg *Message) (int, error) {
-	if msg.ID != MsgHave {
-		return 0, fmt.Errorf("Expected to get %d, but got %d", MsgHave, msg.ID)
+// Считывание индекса части файла из сообщения с ожидаемым ID
+func parseIndex(id messageID, msg *Message) (int, error) {
+	if msg.ID != id {
+		return 0, fmt.Errorf("Expected to get %d, but got %d", id, msg.ID)
 	}
 	if len(msg.Payload) != 4 {
 		return 0, fmt.Errorf("Expected payload length 4, but got length of %d", len(msg.Payload))
@@ -117,6 +132,11 @@ func ParseHave(msg *Message) (int, error) {
 	return index, nil
 }
 
+// Считывание MsgHave и индекса куска файла от других пиров
+func ParseHave(msg *Message) (int, error) {
+	return parseIndex(MsgHave, msg)
+}
+
 // Считывание части файла, отправленного пиром
 func ParsePiece(index int, buf []byte, msg *Message) (int, error) {
 	if msg.ID != MsgPiece {
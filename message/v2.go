@@ -0,0 +1,95 @@
+package message
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	// BitTorrent v2 (BEP 52): запрос хешей дерева Меркла
+	MsgHashRequest messageID = 21
+
+	// BitTorrent v2: хеши дерева Меркла в ответ на запрос
+	MsgHashes messageID = 22
+
+	// BitTorrent v2: отказ в выдаче хешей
+	MsgHashReject messageID = 23
+)
+
+// Параметры запроса хешей дерева Меркла
+type HashRequest struct {
+	PiecesRoot  [32]byte // Корень дерева файла
+	BaseLayer   int      // Уровень запрашиваемых хешей (0 - листья)
+	Index       int      // Номер первого хеша на уровне
+	Length      int      // Количество хешей
+	ProofLayers int      // Количество уровней доказательства
+}
+
+// Сериализация параметров запроса хешей
+func (r HashRequest) payload() []byte {
+	payload := make([]byte, 48)
+	copy(payload[0:32], r.PiecesRoot[:])
+	binary.BigEndian.PutUint32(payload[32:36], uint32(r.BaseLayer))
+	binary.BigEndian.PutUint32(payload[36:40], uint32(r.Index))
+	binary.BigEndian.PutUint32(payload[40:44], uint32(r.Length))
+	binary.BigEndian.PutUint32(payload[44:48], uint32(r.ProofLayers))
+	return payload
+}
+
+// Создание MsgHashRequest
+func FormatHashRequest(r HashRequest) *Message {
+	return &Message{ID: MsgHashRequest, Payload: r.payload()}
+}
+
+// Создание MsgHashReject в ответ на запрос хешей
+func FormatHashReject(r HashRequest) *Message {
+	return &Message{ID: MsgHashReject, Payload: r.payload()}
+}
+
+// Создание MsgHashes с запрошенными хешами и доказательством
+func FormatHashes(r HashRequest, hashes [][32]byte) *Message {
+	payload := r.payload()
+	for _, h := range hashes {
+		payload = append(payload, h[:]...)
+	}
+	return &Message{ID: MsgHashes, Payload: payload}
+}
+
+// Считывание параметров из MsgHashRequest, MsgHashReject или MsgHashes
+func ParseHashRequest(msg *Message) (HashRequest, error) {
+	if msg.ID != MsgHashRequest && msg.ID != MsgHashReject && msg.ID != MsgHashes {
+		return HashRequest{}, fmt.Errorf("Expected hash message, but got ID %d", msg.ID)
+	}
+	if len(msg.Payload) < 48 {
+		return HashRequest{}, fmt.Errorf("Payload is too short: %d < 48", len(msg.Payload))
+	}
+	r := HashRequest{
+		BaseLayer:   int(binary.BigEndian.Uint32(msg.Payload[32:36])),
+		Index:       int(binary.BigEndian.Uint32(msg.Payload[36:40])),
+		Length:      int(binary.BigEndian.Uint32(msg.Payload[40:44])),
+		ProofLayers: int(binary.BigEndian.Uint32(msg.Payload[44:48])),
+	}
+	copy(r.PiecesRoot[:], msg.Payload[0:32])
+	return r, nil
+}
+
+// Считывание MsgHashes: параметры запроса, запрошенные хеши и хеши доказательства
+func ParseHashes(msg *Message) (HashRequest, [][32]byte, [][32]byte, error) {
+	if msg.ID != MsgHashes {
+		return HashRequest{}, nil, nil, fmt.Errorf("Expected hashes (ID %d), but got ID %d", MsgHashes, msg.ID)
+	}
+	r, err := ParseHashRequest(msg)
+	if err != nil {
+		return HashRequest{}, nil, nil, err
+	}
+	data := msg.Payload[48:]
+	if len(data)%32 != 0 || len(data)/32 < r.Length {
+		return HashRequest{}, nil, nil, fmt.Errorf("Malformed hashes payload of length %d", len(data))
+	}
+
+	all := make([][32]byte, len(data)/32)
+	for i := range all {
+		copy(all[i][:], data[i*32:(i+1)*32])
+	}
+	return r, all[:r.Length], all[r.Length:], nil
+}
@@ -0,0 +1,397 @@
+package mse
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rc4"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"sync"
+	"time"
+)
+
+// Режим шифрования соединений
+type Policy int
+
+const (
+	// Шифрование не используется
+	PolicyDisabled Policy = iota
+
+	// Шифрование используется, если пир его поддерживает
+	PolicyPreferred
+
+	// Допускаются только зашифрованные соединения
+	PolicyRequired
+)
+
+// Методы шифрования потока, передаваемые в crypto_provide и crypto_select
+const (
+	CryptoPlaintext uint32 = 0x01
+	CryptoRC4       uint32 = 0x02
+)
+
+const (
+	keySize    = 96  // Длина открытого ключа Диффи-Хеллмана в байтах
+	maxPadSize = 512 // Максимальная длина случайного заполнения
+)
+
+// Простое число и генератор для обмена ключами Диффи-Хеллмана
+var (
+	prime, _  = new(big.Int).SetString("FFFFFFFFFFFFFFFFC90FDAA22168C234C4C6628B80DC1CD129024E088A67CC74020BBEA63B139B22514A08798E3404DDEF9519B3CD3A431B302B0A6DF25F14374FE1356D6D51C245E485B576625E7EC6F44C42E9A63A36210000000000090563", 16)
+	generator = big.NewInt(2)
+)
+
+// Проверочная константа: 8 нулевых байт
+var vc = make([]byte, 8)
+
+// Режим шифрования в виде строки
+func (p Policy) String() string {
+	switch p {
+	case PolicyDisabled:
+		return "disabled"
+	case PolicyPreferred:
+		return "preferred"
+	case PolicyRequired:
+		return "required"
+	}
+	return fmt.Sprintf("Policy(%d)", int(p))
+}
+
+// Разбор режима шифрования из строки
+func ParsePolicy(s string) (Policy, error) {
+	switch s {
+	case "disabled":
+		return PolicyDisabled, nil
+	case "preferred":
+		return PolicyPreferred, nil
+	case "required":
+		return PolicyRequired, nil
+	}
+	return 0, fmt.Errorf("Unknown encryption policy %q", s)
+}
+
+// Методы шифрования, которые предлагаются пиру при данном режиме
+func (p Policy) Provide() uint32 {
+	switch p {
+	case PolicyRequired:
+		return CryptoRC4
+	case PolicyPreferred:
+		return CryptoRC4 | CryptoPlaintext
+	}
+	return CryptoPlaintext
+}
+
+// Соединение, поток которого шифруется RC4
+type Conn struct {
+	net.Conn
+	prefix []byte // Уже расшифрованные данные, полученные во время рукопожатия
+	dec    *rc4.Cipher
+	enc    *rc4.Cipher
+	wmu    sync.Mutex
+}
+
+// Считывание и расшифровка данных
+func (c *Conn) Read(p []byte) (int, error) {
+	if len(c.prefix) > 0 {
+		n := copy(p, c.prefix)
+		c.prefix = c.prefix[n:]
+		return n, nil
+	}
+	n, err := c.Conn.Read(p)
+	if c.dec != nil {
+		c.dec.XORKeyStream(p[:n], p[:n])
+	}
+	return n, err
+}
+
+// Шифрование и отправка данных
+func (c *Conn) Write(p []byte) (int, error) {
+	if c.enc == nil {
+		return c.Conn.Write(p)
+	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	buf := make([]byte, len(p)) // Данные вызывающего не изменяются
+	c.enc.XORKeyStream(buf, p)
+	return c.Conn.Write(buf)
+}
+
+// Хеш SHA-1 от склеенных частей
+func hash(parts ...[]byte) []byte {
+	h := sha1.New()
+	for _, part := range parts {
+		h.Write(part)
+	}
+	return h.Sum(nil)
+}
+
+// Создание RC4 шифра с отброшенными первыми 1024 байтами потока
+func newCipher(name string, secret, skey []byte) *rc4.Cipher {
+	c, _ := rc4.NewCipher(hash([]byte(name), secret, skey)) // Ключ в 20 байт всегда допустим
+	discard := make([]byte, 1024)
+	c.XORKeyStream(discard, discard)
+	return c
+}
+
+// Генерация пары ключей Диффи-Хеллмана
+func generateKeys() (*big.Int, []byte, error) {
+	privBuf := make([]byte, 20)
+	_, err := rand.Read(privBuf)
+	if err != nil {
+		return nil, nil, err
+	}
+	priv := new(big.Int).SetBytes(privBuf)
+	pub := new(big.Int).Exp(generator, priv, prime)
+	return priv, pub.FillBytes(make([]byte, keySize)), nil
+}
+
+// Вычисление общего секрета по открытому ключу пира
+func sharedSecret(priv *big.Int, peerPub []byte) []byte {
+	y := new(big.Int).SetBytes(peerPub)
+	return new(big.Int).Exp(y, priv, prime).FillBytes(make([]byte, keySize))
+}
+
+// Случайное заполнение длиной от 0 до maxPadSize байт
+func randomPad() ([]byte, error) {
+	var n [2]byte
+	_, err := rand.Read(n[:])
+	if err != nil {
+		return nil, err
+	}
+	pad := make([]byte, int(binary.BigEndian.Uint16(n[:]))%(maxPadSize+1))
+	_, err = rand.Read(pad)
+	return pad, err
+}
+
+// Поиск последовательности в потоке после случайного заполнения
+func synchronize(r io.Reader, pattern []byte, maxSkip int) error {
+	buf := make([]byte, 0, maxSkip+len(pattern))
+	b := make([]byte, 1)
+	for len(buf) < cap(buf) {
+		_, err := io.ReadFull(r, b)
+		if err != nil {
+			return err
+		}
+		buf = append(buf, b[0])
+		if bytes.HasSuffix(buf, pattern) {
+			return nil
+		}
+	}
+	return fmt.Errorf("Couldnt synchronize encrypted stream")
+}
+
+// Выбор метода шифрования из предложенных пиром
+func selectCrypto(provided, allowed uint32) (uint32, error) {
+	switch {
+	case provided&allowed&CryptoRC4 != 0:
+		return CryptoRC4, nil
+	case provided&allowed&CryptoPlaintext != 0:
+		return CryptoPlaintext, nil
+	}
+	return 0, fmt.Errorf("No common crypto method: peer provides %#x, allowed %#x", provided, allowed)
+}
+
+// Рукопожатие MSE со стороны инициатора соединения. skey - хеш торрента
+func Initiate(conn net.Conn, skey [20]byte, provide uint32) (net.Conn, error) {
+	conn.SetDeadline(time.Now().Add(time.Second * 10)) // Дедлайн на всё рукопожатие
+	defer conn.SetDeadline(time.Time{})
+
+	priv, pub, err := generateKeys()
+	if err != nil {
+		return nil, err
+	}
+	padA, err := randomPad()
+	if err != nil {
+		return nil, err
+	}
+	// Отправка Ya. PadA уходит вместе со следующим сообщением, чтобы
+	// рукопожатие не блокировалось на соединениях без буфера (net.Pipe)
+	_, err = conn.Write(pub)
+	if err != nil {
+		return nil, err
+	}
+
+	peerPub := make([]byte, keySize) // Получение Yb
+	_, err = io.ReadFull(conn, peerPub)
+	if err != nil {
+		return nil, err
+	}
+	secret := sharedSecret(priv, peerPub)
+
+	enc := newCipher("keyA", secret, skey[:])
+	dec := newCipher("keyB", secret, skey[:])
+
+	req2 := hash([]byte("req2"), skey[:])
+	req3 := hash([]byte("req3"), secret)
+	for i := range req2 {
+		req2[i] ^= req3[i]
+	}
+
+	header := make([]byte, 16) // VC, crypto_provide, len(PadC) = 0, len(IA) = 0
+	copy(header, vc)
+	binary.BigEndian.PutUint32(header[8:12], provide)
+	enc.XORKeyStream(header, header)
+
+	msg := append(padA, hash([]byte("req1"), secret)...)
+	msg = append(msg, req2...)
+	_, err = conn.Write(append(msg, header...))
+	if err != nil {
+		return nil, err
+	}
+
+	encryptedVC := make([]byte, len(vc)) // Так выглядит VC, зашифрованный пиром
+	dec.XORKeyStream(encryptedVC, vc)
+	err = synchronize(conn, encryptedVC, maxPadSize)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := make([]byte, 6) // crypto_select и len(PadD)
+	_, err = io.ReadFull(conn, resp)
+	if err != nil {
+		return nil, err
+	}
+	dec.XORKeyStream(resp, resp)
+	selected := binary.BigEndian.Uint32(resp[0:4])
+	padD := make([]byte, binary.BigEndian.Uint16(resp[4:6]))
+	if len(padD) > maxPadSize {
+		return nil, fmt.Errorf("PadD is too long: %d", len(padD))
+	}
+	_, err = io.ReadFull(conn, padD)
+	if err != nil {
+		return nil, err
+	}
+	dec.XORKeyStream(padD, padD)
+
+	switch {
+	case selected == CryptoRC4 && provide&CryptoRC4 != 0:
+		return &Conn{Conn: conn, enc: enc, dec: dec}, nil
+	case selected == CryptoPlaintext && provide&CryptoPlaintext != 0:
+		return conn, nil
+	}
+	return nil, fmt.Errorf("Peer selected unsupported crypto method %#x", selected)
+}
+
+// Рукопожатие MSE со стороны принимающего соединение. Если пир начал обычное
+// рукопожатие BitTorrent, то оно принимается при allowed, содержащем CryptoPlaintext.
+// Возвращает соединение и хеш торрента, к которому подключается пир (нулевой для открытого соединения)
+func Accept(conn net.Conn, skeys [][20]byte, allowed uint32) (net.Conn, [20]byte, error) {
+	conn.SetDeadline(time.Now().Add(time.Second * 10))
+	defer conn.SetDeadline(time.Time{})
+
+	peerPub := make([]byte, keySize)
+	_, err := io.ReadFull(conn, peerPub[:20])
+	if err != nil {
+		return nil, [20]byte{}, err
+	}
+	if peerPub[0] == 19 && string(peerPub[1:20]) == "BitTorrent protocol" { // Обычное рукопожатие
+		if allowed&CryptoPlaintext == 0 {
+			return nil, [20]byte{}, fmt.Errorf("Plaintext connections are not allowed")
+		}
+		return &Conn{Conn: conn, prefix: peerPub[:20]}, [20]byte{}, nil
+	}
+	_, err = io.ReadFull(conn, peerPub[20:]) // Остаток Ya
+	if err != nil {
+		return nil, [20]byte{}, err
+	}
+	priv, pub, err := generateKeys()
+	if err != nil {
+		return nil, [20]byte{}, err
+	}
+	padB, err := randomPad()
+	if err != nil {
+		return nil, [20]byte{}, err
+	}
+	_, err = conn.Write(pub) // Отправка Yb. PadB уходит вместе с ответом на рукопожатие
+	if err != nil {
+		return nil, [20]byte{}, err
+	}
+	secret := sharedSecret(priv, peerPub)
+
+	err = synchronize(conn, hash([]byte("req1"), secret), maxPadSize)
+	if err != nil {
+		return nil, [20]byte{}, err
+	}
+
+	obfuscated := make([]byte, 20) // HASH('req2', SKEY) xor HASH('req3', S)
+	_, err = io.ReadFull(conn, obfuscated)
+	if err != nil {
+		return nil, [20]byte{}, err
+	}
+	req3 := hash([]byte("req3"), secret)
+	var skey [20]byte
+	found := false
+	for _, candidate := range skeys {
+		req2 := hash([]byte("req2"), candidate[:])
+		match := true
+		for i := range req2 {
+			if req2[i]^req3[i] != obfuscated[i] {
+				match = false
+				break
+			}
+		}
+		if match {
+			skey = candidate
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, [20]byte{}, fmt.Errorf("Peer requested unknown torrent")
+	}
+
+	dec := newCipher("keyA", secret, skey[:])
+	enc := newCipher("keyB", secret, skey[:])
+
+	header := make([]byte, 14) // VC, crypto_provide, len(PadC)
+	_, err = io.ReadFull(conn, header)
+	if err != nil {
+		return nil, [20]byte{}, err
+	}
+	dec.XORKeyStream(header, header)
+	if !bytes.Equal(header[0:8], vc) {
+		return nil, [20]byte{}, fmt.Errorf("Invalid verification constant")
+	}
+	provided := binary.BigEndian.Uint32(header[8:12])
+	padC := make([]byte, binary.BigEndian.Uint16(header[12:14]))
+	if len(padC) > maxPadSize {
+		return nil, [20]byte{}, fmt.Errorf("PadC is too long: %d", len(padC))
+	}
+	lenIA := make([]byte, 2)
+	for _, buf := range [][]byte{padC, lenIA} {
+		_, err = io.ReadFull(conn, buf)
+		if err != nil {
+			return nil, [20]byte{}, err
+		}
+		dec.XORKeyStream(buf, buf)
+	}
+	ia := make([]byte, binary.BigEndian.Uint16(lenIA)) // Начальные данные, обычно рукопожатие BitTorrent
+	_, err = io.ReadFull(conn, ia)
+	if err != nil {
+		return nil, [20]byte{}, err
+	}
+	dec.XORKeyStream(ia, ia)
+
+	selected, err := selectCrypto(provided, allowed)
+	if err != nil {
+		return nil, [20]byte{}, err
+	}
+
+	resp := make([]byte, 14) // VC, crypto_select, len(PadD) = 0
+	copy(resp, vc)
+	binary.BigEndian.PutUint32(resp[8:12], selected)
+	enc.XORKeyStream(resp, resp)
+	_, err = conn.Write(append(padB, resp...))
+	if err != nil {
+		return nil, [20]byte{}, err
+	}
+
+	if selected == CryptoPlaintext {
+		return &Conn{Conn: conn, prefix: ia}, skey, nil
+	}
+	return &Conn{Conn: conn, prefix: ia, enc: enc, dec: dec}, skey, nil
+}
@@ -0,0 +1,154 @@
+package mse
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+)
+
+// Результат рукопожатия на принимающей стороне
+type accepted struct {
+	conn net.Conn
+	skey [20]byte
+	err  error
+}
+
+// Рукопожатие через net.Pipe. Принимающая сторона закрывает соединение при
+// ошибке, чтобы инициатор не ждал дедлайна
+func handshake(t *testing.T, skey [20]byte, provide uint32, skeys [][20]byte, allowed uint32) (net.Conn, accepted, error) {
+	t.Helper()
+	a, b := net.Pipe()
+	t.Cleanup(func() {
+		a.Close()
+		b.Close()
+	})
+	done := make(chan accepted, 1)
+	go func() {
+		conn, key, err := Accept(b, skeys, allowed)
+		if err != nil {
+			b.Close()
+		}
+		done <- accepted{conn, key, err}
+	}()
+	conn, err := Initiate(a, skey, provide)
+	if err != nil {
+		a.Close()
+	}
+	return conn, <-done, err
+}
+
+// Передача данных в обе стороны по установленным соединениям
+func exchange(t *testing.T, a, b net.Conn) {
+	t.Helper()
+	for _, dir := range [][2]net.Conn{{a, b}, {b, a}} {
+		msg := bytes.Repeat([]byte("piece data "), 100)
+		go dir[0].Write(msg)
+		got := make([]byte, len(msg))
+		_, err := io.ReadFull(dir[1], got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, msg) {
+			t.Fatal("data corrupted after handshake")
+		}
+	}
+}
+
+func TestHandshakeRC4(t *testing.T) {
+	skey := [20]byte{1, 2, 3}
+	other := [20]byte{9}
+	conn, acc, err := handshake(t, skey, PolicyRequired.Provide(), [][20]byte{other, skey}, CryptoRC4|CryptoPlaintext)
+	if err != nil || acc.err != nil {
+		t.Fatalf("handshake failed: %v, %v", err, acc.err)
+	}
+	if acc.skey != skey {
+		t.Errorf("accepted skey %x, want %x", acc.skey, skey)
+	}
+	if _, ok := conn.(*Conn); !ok {
+		t.Errorf("initiator got %T, want encrypted *Conn", conn)
+	}
+	exchange(t, conn, acc.conn)
+}
+
+func TestHandshakePlaintextSelected(t *testing.T) {
+	skey := [20]byte{4}
+	conn, acc, err := handshake(t, skey, PolicyPreferred.Provide(), [][20]byte{skey}, CryptoPlaintext)
+	if err != nil || acc.err != nil {
+		t.Fatalf("handshake failed: %v, %v", err, acc.err)
+	}
+	if _, ok := conn.(*Conn); ok {
+		t.Error("initiator got an encrypted connection after plaintext was selected")
+	}
+	exchange(t, conn, acc.conn)
+}
+
+func TestHandshakeFailures(t *testing.T) {
+	tests := []struct {
+		name    string
+		provide uint32
+		skeys   [][20]byte
+		allowed uint32
+	}{
+		{"unknown torrent", CryptoRC4, [][20]byte{{7}}, CryptoRC4},
+		{"no common method", CryptoRC4, [][20]byte{{5}}, CryptoPlaintext},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, acc, err := handshake(t, [20]byte{5}, tt.provide, tt.skeys, tt.allowed)
+			if acc.err == nil {
+				t.Error("Accept succeeded")
+			}
+			if err == nil {
+				t.Error("Initiate succeeded")
+			}
+		})
+	}
+}
+
+func TestAcceptPlainBitTorrent(t *testing.T) {
+	hello := append([]byte{19}, "BitTorrent protocol"...)
+	hello = append(hello, make([]byte, 48)...)
+
+	for _, allowed := range []uint32{CryptoRC4 | CryptoPlaintext, CryptoRC4} {
+		a, b := net.Pipe()
+		go a.Write(hello)
+		conn, skey, err := Accept(b, [][20]byte{{1}}, allowed)
+		if allowed&CryptoPlaintext == 0 {
+			if err == nil {
+				t.Error("plaintext handshake accepted when only RC4 is allowed")
+			}
+			a.Close()
+			b.Close()
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if skey != [20]byte{} {
+			t.Errorf("skey %x for plaintext connection", skey)
+		}
+		got := make([]byte, len(hello))
+		_, err = io.ReadFull(conn, got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, hello) {
+			t.Error("plaintext handshake not passed through")
+		}
+		a.Close()
+		b.Close()
+	}
+}
+
+func TestParsePolicy(t *testing.T) {
+	for _, p := range []Policy{PolicyDisabled, PolicyPreferred, PolicyRequired} {
+		got, err := ParsePolicy(p.String())
+		if err != nil || got != p {
+			t.Errorf("ParsePolicy(%q) = %v, %v", p, got, err)
+		}
+	}
+	if _, err := ParsePolicy("sometimes"); err == nil {
+		t.Error("unknown policy accepted")
+	}
+}
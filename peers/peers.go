@@ -7,12 +7,48 @@ import (
 	"strconv"
 )
 
+// Источник, из которого получен адрес пира
+type Source int
+
+const (
+	SourceUnknown  Source = iota // Источник не указан
+	SourceTracker                // Трекер из метаданных торрента
+	SourceMagnet                 // Параметр x.pe magnet-ссылки
+	SourceIncoming               // Пир сам подключился к клиенту
+	SourceDHT                    // Узлы DHT (BEP 5)
+)
+
+func (s Source) String() string {
+	switch s {
+	case SourceUnknown:
+		return "unknown"
+	case SourceTracker:
+		return "tracker"
+	case SourceMagnet:
+		return "magnet"
+	case SourceIncoming:
+		return "incoming"
+	case SourceDHT:
+		return "dht"
+	}
+	return fmt.Sprintf("source(%d)", int(s))
+}
+
+// Можно ли соединяться с пирами из этого источника в приватном торренте.
+// По BEP 27 приватный торрент получает пиров только от своих трекеров,
+// входящие соединения приходят от пиров, узнавших адрес у тех же трекеров
+func (s Source) AllowedForPrivate() bool {
+	return s == SourceTracker || s == SourceIncoming
+}
+
 type Peer struct {
-	IP   net.IP
-	Port uint16
+	IP     net.IP
+	Port   uint16
+	Source Source // Откуда получен адрес пира
 }
 
-// Конвертация байтового среза с пирами в объект Peer
+// Конвертация байтового среза с пирами в объект Peer. Источник пиров
+// указывает вызывающий
 func Unmarshal(peersBinary []byte) ([]Peer, error) {
 	const peerSize = 6 // Размер данных одного пира (4 на IP, 2 на порт)
 	numPeers := len(peersBinary) / peerSize
@@ -30,6 +66,36 @@ func Unmarshal(peersBinary []byte) ([]Peer, error) {
 	return peers, nil
 }
 
+// Пир по адресу входящего соединения
+func FromAddr(addr net.Addr) (Peer, error) {
+	host, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return Peer{}, err
+	}
+	n, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return Peer{}, err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return Peer{}, fmt.Errorf("Malformed peer address %s", addr)
+	}
+	return Peer{IP: ip, Port: uint16(n), Source: SourceIncoming}, nil
+}
+
+// Пир по адресу вида host:port, полученному из источника source.
+// Имя хоста разрешается в IP
+func ParseAddr(addr string, source Source) (Peer, error) {
+	tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil {
+		return Peer{}, err
+	}
+	if tcpAddr.IP == nil || tcpAddr.Port == 0 {
+		return Peer{}, fmt.Errorf("Malformed peer address %s", addr)
+	}
+	return Peer{IP: tcpAddr.IP, Port: uint16(tcpAddr.Port), Source: source}, nil
+}
+
 // Склеивание IP и порта в одну строку
 func (p Peer) String() string {
 	return net.JoinHostPort(p.IP.String(), strconv.Itoa(int(p.Port)))
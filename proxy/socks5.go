@@ -0,0 +1,275 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// Команды SOCKS5
+const (
+	socksConnect      byte = 0x01
+	socksUDPAssociate byte = 0x03
+)
+
+// Типы адресов SOCKS5
+const (
+	atypIPv4   byte = 0x01
+	atypDomain byte = 0x03
+	atypIPv6   byte = 0x04
+)
+
+// Кодирование адреса host:port в формат SOCKS5
+func encodeAddr(addr string) ([]byte, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf []byte
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			buf = append([]byte{atypIPv4}, ip4...)
+		} else {
+			buf = append([]byte{atypIPv6}, ip.To16()...)
+		}
+	} else {
+		if len(host) > 255 {
+			return nil, fmt.Errorf("Host name is too long: %s", host)
+		}
+		buf = append([]byte{atypDomain, byte(len(host))}, host...)
+	}
+	return binary.BigEndian.AppendUint16(buf, uint16(port)), nil
+}
+
+// Считывание адреса в формате SOCKS5
+func readAddr(r io.Reader) (string, error) {
+	atyp := make([]byte, 1)
+	_, err := io.ReadFull(r, atyp)
+	if err != nil {
+		return "", err
+	}
+
+	var host string
+	switch atyp[0] {
+	case atypIPv4, atypIPv6:
+		size := net.IPv4len
+		if atyp[0] == atypIPv6 {
+			size = net.IPv6len
+		}
+		ip := make([]byte, size)
+		_, err = io.ReadFull(r, ip)
+		host = net.IP(ip).String()
+	case atypDomain:
+		length := make([]byte, 1)
+		_, err = io.ReadFull(r, length)
+		if err != nil {
+			return "", err
+		}
+		name := make([]byte, length[0])
+		_, err = io.ReadFull(r, name)
+		host = string(name)
+	default:
+		return "", fmt.Errorf("Unknown SOCKS5 address type %d", atyp[0])
+	}
+	if err != nil {
+		return "", err
+	}
+
+	port := make([]byte, 2)
+	_, err = io.ReadFull(r, port)
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+// Приветствие и аутентификация на SOCKS5 сервере
+func (p *Proxy) socksAuth(conn net.Conn) error {
+	methods := []byte{0x00} // Без аутентификации
+	if p.user != "" {
+		methods = []byte{0x02} // Логин и пароль
+	}
+	_, err := conn.Write(append([]byte{0x05, byte(len(methods))}, methods...))
+	if err != nil {
+		return err
+	}
+
+	resp := make([]byte, 2)
+	_, err = io.ReadFull(conn, resp)
+	if err != nil {
+		return err
+	}
+	if resp[0] != 0x05 {
+		return fmt.Errorf("Proxy is not a SOCKS5 server")
+	}
+
+	switch resp[1] {
+	case 0x00:
+		return nil
+	case 0x02:
+		if len(p.user) > 255 || len(p.password) > 255 {
+			return fmt.Errorf("SOCKS5 credentials are too long")
+		}
+		req := append([]byte{0x01, byte(len(p.user))}, p.user...)
+		req = append(req, byte(len(p.password)))
+		req = append(req, p.password...)
+		_, err = conn.Write(req)
+		if err != nil {
+			return err
+		}
+		_, err = io.ReadFull(conn, resp)
+		if err != nil {
+			return err
+		}
+		if resp[1] != 0x00 {
+			return fmt.Errorf("SOCKS5 authentication failed")
+		}
+		return nil
+	}
+	return fmt.Errorf("SOCKS5 server rejected authentication methods")
+}
+
+// Выполнение команды SOCKS5. Возвращает адрес, выданный сервером
+func (p *Proxy) socksRequest(conn net.Conn, cmd byte, addr string) (string, error) {
+	err := p.socksAuth(conn)
+	if err != nil {
+		return "", err
+	}
+
+	encoded, err := encodeAddr(addr)
+	if err != nil {
+		return "", err
+	}
+	_, err = conn.Write(append([]byte{0x05, cmd, 0x00}, encoded...))
+	if err != nil {
+		return "", err
+	}
+
+	resp := make([]byte, 3)
+	_, err = io.ReadFull(conn, resp)
+	if err != nil {
+		return "", err
+	}
+	if resp[0] != 0x05 {
+		return "", fmt.Errorf("Malformed SOCKS5 reply")
+	}
+	if resp[1] != 0x00 {
+		return "", fmt.Errorf("SOCKS5 request to %s failed with code %d", addr, resp[1])
+	}
+	return readAddr(conn)
+}
+
+// Адрес host:port, имя в котором разрешает прокси-сервер, а не клиент.
+// Датаграммы на такой адрес не требуют локального DNS запроса
+type HostAddr string
+
+func (a HostAddr) Network() string {
+	return "udp"
+}
+
+func (a HostAddr) String() string {
+	return string(a)
+}
+
+// UDP сокет, пакеты которого идут через SOCKS5 UDP ASSOCIATE
+type udpConn struct {
+	net.PacketConn
+	control net.Conn // TCP соединение, пока открыто которое, действует ассоциация
+	relay   net.Addr
+}
+
+// Открытие UDP сокета через SOCKS5 прокси
+func (p *Proxy) ListenUDP() (net.PacketConn, error) {
+	if !p.SupportsUDP() {
+		return nil, fmt.Errorf("Proxy %s does not support UDP", p)
+	}
+
+	control, err := net.DialTimeout("tcp", p.url.Host, 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	control.SetDeadline(time.Now().Add(10 * time.Second))
+	relayAddr, err := p.socksRequest(control, socksUDPAssociate, "0.0.0.0:0")
+	if err != nil {
+		control.Close()
+		return nil, err
+	}
+	control.SetDeadline(time.Time{})
+
+	relay, err := net.ResolveUDPAddr("udp", relayAddr)
+	if err != nil {
+		control.Close()
+		return nil, err
+	}
+	if relay.IP.IsUnspecified() { // Сервер предлагает использовать свой адрес
+		host, _, _ := net.SplitHostPort(p.url.Host)
+		relay, err = net.ResolveUDPAddr("udp", net.JoinHostPort(host, strconv.Itoa(relay.Port)))
+		if err != nil {
+			control.Close()
+			return nil, err
+		}
+	}
+
+	pc, err := net.ListenPacket("udp", ":0")
+	if err != nil {
+		control.Close()
+		return nil, err
+	}
+	return &udpConn{PacketConn: pc, control: control, relay: relay}, nil
+}
+
+// Отправка датаграммы через ретранслятор
+func (c *udpConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	encoded, err := encodeAddr(addr.String())
+	if err != nil {
+		return 0, err
+	}
+	packet := append([]byte{0x00, 0x00, 0x00}, encoded...) // RSV, FRAG = 0
+	packet = append(packet, b...)
+	_, err = c.PacketConn.WriteTo(packet, c.relay)
+	if err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Считывание датаграммы с разбором заголовка ретранслятора
+func (c *udpConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	buf := make([]byte, len(b)+262) // Максимальный размер заголовка SOCKS5 UDP
+	for {
+		n, _, err := c.PacketConn.ReadFrom(buf)
+		if err != nil {
+			return 0, nil, err
+		}
+		if n < 4 || buf[2] != 0x00 { // Фрагментированные датаграммы не поддерживаются
+			continue
+		}
+		r := bytes.NewReader(buf[3:n])
+		from, err := readAddr(r)
+		if err != nil {
+			continue
+		}
+		var addr net.Addr = HostAddr(from) // Ответ от имени узла остается без разрешения имени
+		host, port, _ := net.SplitHostPort(from)
+		if ip := net.ParseIP(host); ip != nil {
+			p, _ := strconv.Atoi(port)
+			addr = &net.UDPAddr{IP: ip, Port: p}
+		}
+		return copy(b, buf[n-r.Len():n]), addr, nil
+	}
+}
+
+// Закрытие сокета и ассоциации
+func (c *udpConn) Close() error {
+	c.control.Close()
+	return c.PacketConn.Close()
+}
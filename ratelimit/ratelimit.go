@@ -0,0 +1,162 @@
+package ratelimit
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// Наибольшая порция байт, за которую ожидается разрешение за один раз
+const chunkSize = 16 * 1024
+
+// Ограничитель скорости по алгоритму token bucket. Нулевая скорость - без ограничения.
+// Методы безопасны для nil-ограничителя
+type Limiter struct {
+	mu     sync.Mutex
+	rate   int64   // Байт в секунду
+	tokens float64 // Доступные байты
+	last   time.Time
+
+	total       int64     // Всего прошло байт
+	window      time.Time // Начало текущего интервала измерения скорости
+	windowBytes int64     // Байт за текущий интервал
+	speed       float64   // Скорость за последний полный интервал
+}
+
+// Создание ограничителя со скоростью rate байт в секунду
+func New(rate int64) *Limiter {
+	now := time.Now()
+	return &Limiter{rate: rate, last: now, window: now}
+}
+
+// Изменение скорости. Действует и на уже ожидающие вызовы Wait
+func (l *Limiter) SetRate(rate int64) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rate = rate
+	l.tokens = 0
+	l.last = time.Now()
+}
+
+// Текущая скорость в байтах в секунду
+func (l *Limiter) Rate() int64 {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate
+}
+
+// Завершение интервала измерения скорости, если прошло не меньше секунды
+func (l *Limiter) roll(now time.Time) {
+	elapsed := now.Sub(l.window)
+	if elapsed < time.Second {
+		return
+	}
+	l.speed = float64(l.windowBytes) / elapsed.Seconds()
+	l.window = now
+	l.windowBytes = 0
+}
+
+// Учет прошедших байт
+func (l *Limiter) record(n int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.roll(time.Now())
+	l.total += int64(n)
+	l.windowBytes += int64(n)
+}
+
+// Измеренная скорость в байтах в секунду
+func (l *Limiter) Speed() int64 {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.roll(time.Now())
+	return int64(l.speed)
+}
+
+// Количество байт, прошедших через ограничитель
+func (l *Limiter) Total() int64 {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.total
+}
+
+// Попытка взять n байт. Возвращает время, которое нужно подождать при неудаче
+func (l *Limiter) take(n int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate <= 0 {
+		return 0
+	}
+
+	now := time.Now()
+	burst := float64(max(l.rate, chunkSize)) // Запас не больше секунды передачи
+	l.tokens = min(burst, l.tokens+now.Sub(l.last).Seconds()*float64(l.rate))
+	l.last = now
+	if l.tokens >= float64(n) {
+		l.tokens -= float64(n)
+		return 0
+	}
+	wait := time.Duration((float64(n) - l.tokens) / float64(l.rate) * float64(time.Second))
+	return min(wait, 100*time.Millisecond) // Короткие паузы, чтобы замечать смену скорости
+}
+
+// Ожидание разрешения на передачу n байт
+func (l *Limiter) Wait(n int) {
+	if l == nil {
+		return
+	}
+	l.record(n)
+	for n > 0 {
+		chunk := min(n, chunkSize)
+		for {
+			wait := l.take(chunk)
+			if wait == 0 {
+				break
+			}
+			time.Sleep(wait)
+		}
+		n -= chunk
+	}
+}
+
+// Соединение с ограничением скорости приема и отправки
+type conn struct {
+	net.Conn
+	read, write []*Limiter
+}
+
+func (c *conn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	for _, l := range c.read { // Принятые данные оплачиваются после чтения
+		l.Wait(n)
+	}
+	return n, err
+}
+
+func (c *conn) Write(p []byte) (int, error) {
+	for _, l := range c.write {
+		l.Wait(len(p))
+	}
+	return c.Conn.Write(p)
+}
+
+// Обертка соединения ограничителями приема read и отправки write.
+// Каждый из ограничителей может быть nil
+func Conn(nc net.Conn, read, write []*Limiter) net.Conn {
+	if len(read) == 0 && len(write) == 0 {
+		return nc
+	}
+	return &conn{Conn: nc, read: read, write: write}
+}
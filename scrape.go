@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/swesdek/gotorrent-client/proxy"
+	"github.com/swesdek/gotorrent-client/torrentfile"
+)
+
+// Команда scrape: статистика торрента на трекере
+func scrape(args []string) error {
+	fs := flag.NewFlagSet("scrape", flag.ExitOnError)
+	tracker := fs.String("tracker", "", "announce URL to use instead of the tracker in the torrent")
+	asJSON := fs.Bool("json", false, "print the result as JSON")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: gotorrent-client scrape [flags] file.torrent")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return usageError(fs, "Expected exactly one .torrent file")
+	}
+
+	tf, err := openTorrent(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	overrideTracker(&tf, *tracker)
+	p, err := proxy.FromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	res, err := tf.Scrape(torrentfile.TrackerOptions{Proxy: p})
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return json.NewEncoder(os.Stdout).Encode(map[string]int{
+			"seeders":   res.Seeders,
+			"leechers":  res.Leechers,
+			"completed": res.Completed,
+		})
+	}
+	fmt.Printf("Seeders: %d\nLeechers: %d\nCompleted: %d\n", res.Seeders, res.Leechers, res.Completed)
+	return nil
+}
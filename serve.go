@@ -0,0 +1,226 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/swesdek/gotorrent-client/api"
+	"github.com/swesdek/gotorrent-client/config"
+	"github.com/swesdek/gotorrent-client/session"
+)
+
+// Интервал вывода состояния торрентов сессии
+const statusInterval = 10 * time.Second
+
+// Интервал проверки изменения файла конфигурации
+const reloadInterval = 5 * time.Second
+
+// Флаги команд serve и daemon
+type sessionFlags struct {
+	*peerFlags
+	dataDir      string
+	downloadDir  string
+	incomplete   string
+	apiAddr      string
+	apiToken     string
+	watch        string
+	watchArchive string
+}
+
+// Регистрация флагов сессии
+func addSessionFlags(fs *flag.FlagSet) *sessionFlags {
+	d := config.Default()
+	f := &sessionFlags{peerFlags: addPeerFlags(fs)}
+	fs.StringVar(&f.dataDir, "data", d.Session.DataDir, "directory for session state")
+	fs.StringVar(&f.downloadDir, "o", d.Session.DownloadDir, "directory to download torrents into")
+	fs.StringVar(&f.incomplete, "incomplete-dir", d.Session.IncompleteDir, "directory for unfinished downloads, moved into -o when complete")
+	fs.StringVar(&f.apiAddr, "api", d.Session.API, "address of the HTTP API, host:port or unix:/path (empty to disable)")
+	fs.StringVar(&f.apiToken, "api-token", d.Session.APIToken, "token required by the HTTP API (Authorization: Bearer <token>)")
+	fs.StringVar(&f.watch, "watch", d.Session.Watch, "directory to add .torrent and .magnet files from")
+	fs.StringVar(&f.watchArchive, "watch-archive", d.Session.WatchArchive, "directory to move added files into (default: <watch>/added)")
+	return f
+}
+
+// Загрузка настроек с флагами сессии поверх файла конфигурации
+func (f *sessionFlags) load(fs *flag.FlagSet) (*config.Config, error) {
+	cfg, err := f.peerFlags.load(fs)
+	if err != nil {
+		return nil, err
+	}
+	fs.Visit(func(fl *flag.Flag) {
+		switch fl.Name {
+		case "data":
+			cfg.Session.DataDir = f.dataDir
+		case "o":
+			cfg.Session.DownloadDir = f.downloadDir
+		case "incomplete-dir":
+			cfg.Session.IncompleteDir = f.incomplete
+		case "api":
+			cfg.Session.API = f.apiAddr
+		case "api-token":
+			cfg.Session.APIToken = f.apiToken
+		case "watch":
+			cfg.Session.Watch = f.watch
+		case "watch-archive":
+			cfg.Session.WatchArchive = f.watchArchive
+		}
+	})
+	return cfg, cfg.Validate()
+}
+
+// Повторное чтение файла конфигурации и применение новых лимитов скорости.
+// При ошибке в файле сессия продолжает работать со старыми лимитами
+func (f *sessionFlags) reload(fs *flag.FlagSet, s *session.Session, cfg *config.Config) *config.Config {
+	next, err := f.load(fs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Keeping the previous configuration: %v\n", err)
+		return cfg
+	}
+	if next.Limits != cfg.Limits {
+		s.SetRateLimits(next.Limits.DownloadRate, next.Limits.UploadRate)
+		if !f.quiet {
+			fmt.Printf("Rate limits changed: download %d B/s, upload %d B/s\n", next.Limits.DownloadRate, next.Limits.UploadRate)
+		}
+	}
+	return next
+}
+
+// Время изменения файла конфигурации (нулевое, если файла нет)
+func modTime(path string) time.Time {
+	if path == "" {
+		return time.Time{}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// Вывод состояния всех торрентов сессии
+func printStatus(s *session.Session) {
+	for _, t := range s.Torrents() {
+		stats := t.Stats()
+		line := fmt.Sprintf("%x  %-11s %6.2f%%  %s", stats.InfoHash, stats.Status, stats.Progress()*100, stats.Name)
+		if stats.Error != "" {
+			line += "  (" + stats.Error + ")"
+		}
+		fmt.Println(line)
+	}
+}
+
+// Команда serve: долгоживущая сессия с HTTP API, скачивающая несколько торрентов
+func serve(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	sf := addSessionFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: gotorrent-client serve [flags] [file.torrent...]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	err := sf.validate(fs)
+	if err != nil {
+		return err
+	}
+	cfg, err := sf.load(fs)
+	if err != nil {
+		return err
+	}
+	peerID, err := cfg.PeerID()
+	if err != nil {
+		return err
+	}
+
+	sessionConfig := cfg.SessionConfig(peerID)
+	sessionConfig.Verbose = sf.verbose
+	s, err := session.New(sessionConfig)
+	if err != nil {
+		return err
+	}
+	for _, path := range fs.Args() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			s.Close()
+			return err
+		}
+		t, err := s.Add(data)
+		if errors.Is(err, session.ErrExists) { // Торрент уже добавлен при прошлом запуске
+			continue
+		}
+		if err != nil {
+			s.Close()
+			return &exitCodeError{exitInvalid, fmt.Errorf("%s: %v", path, err)}
+		}
+		if !sf.quiet {
+			fmt.Printf("Added %s (%x)\n", t.Meta().Name, t.InfoHash())
+		}
+	}
+
+	if cfg.Session.Watch != "" {
+		err = s.Watch(cfg.Session.Watch, cfg.WatchArchiveDir(), session.DefaultWatchInterval)
+		if err != nil {
+			s.Close()
+			return err
+		}
+		if !sf.quiet {
+			fmt.Printf("Watching %s\n", cfg.Session.Watch)
+		}
+	}
+
+	if cfg.Session.API != "" {
+		l, err := api.Listen(cfg.Session.API, cfg.Session.APIToken)
+		if err != nil {
+			s.Close()
+			return err
+		}
+		server := &http.Server{Handler: api.New(s, cfg.Session.APIToken)}
+		go server.Serve(l)
+		defer server.Close()
+		if !sf.quiet {
+			fmt.Printf("Serving API on %s\n", cfg.Session.API)
+		}
+	}
+
+	// Лимиты скорости перечитываются по SIGHUP и при изменении файла конфигурации
+	configPath := sf.configFile()
+	configTime := modTime(configPath)
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	reloadTicker := time.NewTicker(reloadInterval)
+	defer reloadTicker.Stop()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	ticker := time.NewTicker(statusInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if !sf.quiet {
+				printStatus(s)
+			}
+		case <-hangups:
+			configPath = sf.configFile()
+			configTime = modTime(configPath)
+			cfg = sf.reload(fs, s, cfg)
+		case <-reloadTicker.C:
+			t := modTime(configPath)
+			if t.Equal(configTime) {
+				continue
+			}
+			configTime = t
+			cfg = sf.reload(fs, s, cfg)
+		case <-signals:
+			if !sf.quiet {
+				fmt.Println("Shutting down")
+			}
+			return s.Close()
+		}
+	}
+}
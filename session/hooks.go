@@ -0,0 +1,100 @@
+package session
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/swesdek/gotorrent-client/torrentfile"
+)
+
+// Таймауты обработчиков завершения
+const (
+	commandTimeout = 10 * time.Minute // Выполнение команды, после чего она завершается
+	webhookTimeout = 30 * time.Second // Запрос к webhook
+)
+
+// Сведения о завершенном скачивании, передаваемые обработчикам
+type Completion struct {
+	Name     string `json:"name"`
+	InfoHash string `json:"info_hash"`
+	Path     string `json:"path"` // Файл или директория с данными торрента
+}
+
+// Сведения о завершенном скачивании торрента meta с данными по пути path
+func NewCompletion(meta *torrentfile.TorrentFile, path string) Completion {
+	return Completion{Name: meta.Name, InfoHash: hex.EncodeToString(meta.InfoHash[:]), Path: path}
+}
+
+// Обработчики завершения скачивания
+type Hooks struct {
+	// Команда, которой в аргументах передаются имя торрента, хеш и путь к данным,
+	// а на stdin - те же сведения в JSON. Аргументы самой команды разделяются
+	// пробелами, кавычки не поддерживаются
+	Command string
+
+	// Адрес, на который POST запросом отправляются сведения в JSON
+	Webhook string
+}
+
+// Запуск обработчиков по очереди. Ошибка обработчика не мешает запуску
+// следующего. Отмена ctx прерывает работающий обработчик
+func (h Hooks) Run(ctx context.Context, c Completion) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	if h.Command != "" {
+		err = runCommand(ctx, h.Command, c, data)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("Completion command failed: %w", err))
+		}
+	}
+	if h.Webhook != "" {
+		err = postWebhook(ctx, h.Webhook, data)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("Completion webhook failed: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Запуск команды со сведениями о скачивании с ожиданием ее завершения, но не дольше commandTimeout
+func runCommand(ctx context.Context, command string, c Completion, data []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, commandTimeout)
+	defer cancel()
+	args := append(strings.Fields(command), c.Name, c.InfoHash, c.Path)
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// Отправка сведений о скачивании на webhook. Ответ с кодом не 2xx считается ошибкой
+func postWebhook(ctx context.Context, url string, data []byte) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := http.Client{Timeout: webhookTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("Unexpected response status %s", resp.Status)
+	}
+	return nil
+}
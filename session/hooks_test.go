@@ -0,0 +1,122 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Сервер webhook, отвечающий кодом status и сохраняющий полученные сведения
+func webhookServer(t *testing.T, status int) (*httptest.Server, *atomic.Int32, chan Completion) {
+	t.Helper()
+	var calls atomic.Int32
+	got := make(chan Completion, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		var c Completion
+		if json.NewDecoder(r.Body).Decode(&c) == nil {
+			got <- c
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(ts.Close)
+	return ts, &calls, got
+}
+
+func TestHooksRun(t *testing.T) {
+	c := Completion{Name: "file.bin", InfoHash: "00ff", Path: "/data/file.bin"}
+	ctx := context.Background()
+	ok, _, got := webhookServer(t, http.StatusNoContent)
+	if err := (Hooks{Webhook: ok.URL}).Run(ctx, c); err != nil {
+		t.Fatal(err)
+	}
+	if sent := <-got; sent != c {
+		t.Errorf("webhook got %+v, want %+v", sent, c)
+	}
+
+	failing, _, _ := webhookServer(t, http.StatusInternalServerError)
+	err := Hooks{Webhook: failing.URL}.Run(ctx, c)
+	if err == nil || !strings.HasPrefix(err.Error(), "Completion webhook failed: ") {
+		t.Errorf("failing webhook: %v", err)
+	}
+
+	if _, lookErr := exec.LookPath("false"); lookErr == nil {
+		err = Hooks{Command: "false", Webhook: ok.URL}.Run(ctx, c)
+		if err == nil || !strings.HasPrefix(err.Error(), "Completion command failed: ") {
+			t.Errorf("failing command: %v", err)
+		}
+		if sent := <-got; sent != c { // Webhook запускается и после ошибки команды
+			t.Errorf("webhook after failed command got %+v", sent)
+		}
+	}
+}
+
+func TestHookErrorKeepsCompletedStatus(t *testing.T) {
+	dir := t.TempDir()
+	hook, calls, _ := webhookServer(t, http.StatusBadGateway)
+	s := newTestSession(t, dir, Config{Hooks: Hooks{Webhook: hook.URL}})
+	data := testTorrent(t, filepath.Join(dir, "downloads"), "file.bin", 50000)
+
+	tor, err := s.Add(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitStatus(t, tor, StatusCompleted)
+	deadline := time.Now().Add(10 * time.Second)
+	for !strings.Contains(tor.Stats().Error, "Completion webhook failed") {
+		if time.Now().After(deadline) {
+			t.Fatalf("hook error not reported: %+v", tor.Stats())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if stats := tor.Stats(); stats.Status != StatusCompleted {
+		t.Errorf("status %v after failed hook, want completed", stats.Status)
+	}
+
+	if err = tor.finish(); err != nil || calls.Load() != 1 { // Повторно обработчики не запускаются
+		t.Errorf("second finish: %v, %d webhook calls", err, calls.Load())
+	}
+}
+
+func TestCloseStopsHooks(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh not found")
+	}
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	t.Setenv("HOME", dir)
+	script := filepath.Join(dir, "hook.sh")
+	err = os.WriteFile(script, []byte("exec sleep 60\n"), 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := New(Config{
+		DataDir:     filepath.Join(dir, "state"),
+		DownloadDir: filepath.Join(dir, "downloads"),
+		Hooks:       Hooks{Command: sh + " " + script},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tor, err := s.Add(testTorrent(t, filepath.Join(dir, "downloads"), "file.bin", 50000))
+	if err != nil {
+		s.Close()
+		t.Fatal(err)
+	}
+	waitStatus(t, tor, StatusCompleted) // Команда еще работает и не мешает завершению
+
+	start := time.Now()
+	s.Close()
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("Close waited %v for the completion command", elapsed)
+	}
+}
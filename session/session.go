@@ -0,0 +1,686 @@
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/swesdek/gotorrent-client/banlist"
+	"github.com/swesdek/gotorrent-client/client"
+	"github.com/swesdek/gotorrent-client/dht"
+	"github.com/swesdek/gotorrent-client/download"
+	"github.com/swesdek/gotorrent-client/magnet"
+	"github.com/swesdek/gotorrent-client/peers"
+	"github.com/swesdek/gotorrent-client/ratelimit"
+	"github.com/swesdek/gotorrent-client/storage"
+	"github.com/swesdek/gotorrent-client/torrentfile"
+	"github.com/swesdek/gotorrent-client/utp"
+)
+
+// Интервал сохранения изменившегося состояния сессии
+const saveInterval = 10 * time.Second
+
+// Интервал обновления таблицы маршрутизации DHT
+const dhtRefreshInterval = 15 * time.Minute
+
+// Ошибка добавления торрента, который уже есть в сессии
+var ErrExists = errors.New("Torrent is already added")
+
+// Ошибка обращения к торренту, которого нет в сессии
+var ErrNotFound = errors.New("Torrent not found")
+
+// Ошибка добавления magnet-ссылки, для которой не удалось получить метаданные
+var ErrNoMetadata = errors.New("Magnet URI has no exact source (xs) to fetch metadata from")
+
+// Наибольший размер .torrent файла, скачиваемого по ссылке
+const maxTorrentSize = 16 << 20
+
+// Параметры сессии
+type Config struct {
+	DataDir        string           // Директория состояния: метаданные торрентов и session.json
+	DownloadDir    string           // Директория, в которую скачиваются данные
+	IncompleteDir  string           // Директория незавершенных скачиваний, откуда данные переносятся в DownloadDir (только без Storage)
+	Hooks          Hooks            // Обработчики завершения скачивания
+	Port           uint16           // Общий порт входящих TCP и uTP соединений (0 - не принимать)
+	DHT            bool             // Узел DHT на UDP порту Port, общий для торрентов (только при Port != 0)
+	DHTBootstrap   []string         // Начальные узлы DHT (nil - dht.DefaultBootstrap)
+	MaxConnections int              // Общий лимит соединений с пирами (0 - без лимита)
+	DownloadRate   int64            // Общий лимит скорости приема, байт/с (0 - без лимита)
+	UploadRate     int64            // Общий лимит скорости отправки, байт/с
+	Client         client.Options   // Шифрование, транспорт, прокси и таймауты соединений с пирами
+	TrackerTimeout time.Duration    // Таймаут HTTP запроса к трекеру (0 - по умолчанию)
+	MaxBacklog     int              // Неудовлетворенных запросов к пиру (0 - по умолчанию)
+	BlockSize      int              // Длина запрашиваемого блока (0 - по умолчанию)
+	PieceTimeout   time.Duration    // Ожидание части от пира (0 - по умолчанию)
+	HashWorkers    int              // Горутин общего для всех торрентов пула проверки хешей (0 - по числу процессоров)
+	Storage        storage.Storage  // Хранилище данных торрентов (nil - файлы в DownloadDir)
+	Prealloc       storage.Prealloc // Выделение места под файлы, если Storage не задано
+	CacheSize      int64            // Размер общего кэша частей, байт (0 - без кэша)
+	PeerID         [20]byte         // Собственный PeerID (нулевой - случайный)
+	Verbose        bool             // Вывод сообщений о пирах и веб-сидах
+}
+
+// Директория состояния по умолчанию
+func DefaultDataDir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "gotorrent-client"
+	}
+	return filepath.Join(dir, "gotorrent-client")
+}
+
+// Сессия: набор торрентов с общим портом, лимитами и сохраняемым состоянием
+type Session struct {
+	cfg      Config
+	peerID   [20]byte
+	bans     *banlist.BanList
+	download *ratelimit.Limiter
+	upload   *ratelimit.Limiter
+	conns    chan struct{}      // Общий лимит соединений (nil - без лимита)
+	cache    *storage.Cache     // Общий кэш частей (nil - без кэша)
+	hashes   *download.HashPool // Общий пул проверки хешей частей
+	dht      *dht.Server        // Общий узел DHT (nil - DHT выключен)
+
+	mu        sync.Mutex
+	torrents  map[[20]byte]*Torrent
+	listeners []net.Listener
+	dirty     atomic.Bool // Состояние изменилось с момента последнего сохранения
+	closed    chan struct{}
+	wg        sync.WaitGroup
+}
+
+// Создание сессии: загрузка сохраненного состояния, открытие порта и запуск торрентов
+func New(cfg Config) (*Session, error) {
+	if cfg.DataDir == "" {
+		cfg.DataDir = DefaultDataDir()
+	}
+	if cfg.DownloadDir == "" {
+		cfg.DownloadDir = "."
+	}
+	err := os.MkdirAll(filepath.Join(cfg.DataDir, "torrents"), 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Session{
+		cfg:      cfg,
+		download: ratelimit.New(cfg.DownloadRate),
+		upload:   ratelimit.New(cfg.UploadRate),
+		torrents: make(map[[20]byte]*Torrent),
+		closed:   make(chan struct{}),
+	}
+	s.peerID = cfg.PeerID
+	if s.peerID == [20]byte{} {
+		_, err = rand.Read(s.peerID[:])
+		if err != nil {
+			return nil, err
+		}
+	}
+	if cfg.MaxConnections > 0 {
+		s.conns = make(chan struct{}, cfg.MaxConnections)
+	}
+	if cfg.CacheSize > 0 {
+		s.cache = storage.NewCache(cfg.CacheSize)
+	}
+	s.bans, err = banlist.Load(banlist.DefaultPath())
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.loadState()
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Port != 0 {
+		err = s.listen()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	s.hashes = download.NewHashPool(cfg.HashWorkers)
+	s.wg.Add(1)
+	go s.persist()
+	for _, t := range s.torrents {
+		if !t.paused {
+			t.start()
+		}
+	}
+	return s, nil
+}
+
+// Открытие общего порта для TCP и uTP
+func (s *Session) listen() error {
+	addr := fmt.Sprintf(":%d", s.cfg.Port)
+	tcp, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	s.listeners = append(s.listeners, tcp)
+	u, err := utp.Listen(addr)
+	if err != nil {
+		tcp.Close()
+		return err
+	}
+	s.listeners = append(s.listeners, u)
+	if s.cfg.DHT { // Пакеты DHT идут через тот же UDP сокет, что и uTP
+		s.dht, err = dht.New(u.PacketConn(), dht.Options{Bootstrap: s.cfg.DHTBootstrap})
+		if err != nil {
+			tcp.Close()
+			u.Close()
+			return err
+		}
+		s.wg.Add(1)
+		go s.refreshDHT()
+	}
+
+	for _, l := range s.listeners {
+		s.wg.Add(1)
+		go s.acceptLoop(l)
+	}
+	return nil
+}
+
+// Заполнение таблицы маршрутизации DHT при запуске и ее периодическое обновление
+func (s *Session) refreshDHT() {
+	defer s.wg.Done()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-s.closed
+		cancel()
+	}()
+	ticker := time.NewTicker(dhtRefreshInterval)
+	defer ticker.Stop()
+	for {
+		err := s.dht.Bootstrap(ctx)
+		if err != nil && ctx.Err() == nil && s.cfg.Verbose {
+			fmt.Printf("Couldnt bootstrap DHT: %v\n", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Прием входящих соединений
+func (s *Session) acceptLoop(l net.Listener) {
+	defer s.wg.Done()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return // Слушатель закрыт
+		}
+		go s.handleIncoming(conn)
+	}
+}
+
+// Хендшейк входящего соединения и передача его торренту
+func (s *Session) handleIncoming(conn net.Conn) {
+	s.mu.Lock()
+	var skeys [][20]byte
+	for hash, t := range s.torrents {
+		if t.active() {
+			skeys = append(skeys, hash)
+		}
+	}
+	s.mu.Unlock()
+
+	lookup := func(hash [20]byte) (int, bool) {
+		t, ok := s.Get(hash)
+		if !ok || !t.active() {
+			return 0, false
+		}
+		return t.meta.NumPieces(), true
+	}
+	c, err := client.Accept(conn, s.peerID, skeys, lookup, s.clientOptions(nil))
+	if err != nil {
+		return
+	}
+	t, ok := s.Get(c.InfoHash)
+	if !ok {
+		c.Conn.Close()
+		return
+	}
+	t.deliver(c)
+}
+
+// Параметры соединений с пирами. Для торрента t добавляются его собственные лимиты
+func (s *Session) clientOptions(t *Torrent) client.Options {
+	opts := s.cfg.Client
+	opts.DownloadLimits = []*ratelimit.Limiter{s.download}
+	opts.UploadLimits = []*ratelimit.Limiter{s.upload}
+	if t != nil {
+		opts.DownloadLimits = append(opts.DownloadLimits, t.download)
+		opts.UploadLimits = append(opts.UploadLimits, t.upload)
+	}
+	return opts
+}
+
+// Параметры, с которыми создана сессия
+func (s *Session) Config() Config {
+	return s.cfg
+}
+
+// Параметры обращения к трекерам
+func (s *Session) trackerOptions() torrentfile.TrackerOptions {
+	return torrentfile.TrackerOptions{
+		PeerID:  s.peerID,
+		Port:    s.cfg.Port,
+		Proxy:   s.cfg.Client.Proxy,
+		Timeout: s.cfg.TrackerTimeout,
+	}
+}
+
+// Отметка о необходимости сохранить состояние
+func (s *Session) markDirty() {
+	s.dirty.Store(true)
+}
+
+// Периодическое сохранение изменившегося состояния
+func (s *Session) persist() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(saveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if s.dirty.Swap(false) {
+				err := s.saveState()
+				if err != nil {
+					fmt.Printf("Couldnt save session state: %v\n", err)
+					s.markDirty()
+				}
+			}
+		case <-s.closed:
+			return
+		}
+	}
+}
+
+// Путь к сохраненным метаданным торрента
+func (s *Session) torrentPath(hash [20]byte) string {
+	return filepath.Join(s.cfg.DataDir, "torrents", hex.EncodeToString(hash[:])+".torrent")
+}
+
+// Скачиваются ли данные в IncompleteDir с переносом после завершения
+func (s *Session) moveCompleted() bool {
+	return s.cfg.IncompleteDir != "" && s.cfg.Storage == nil
+}
+
+// Создание торрента сессии из метаданных
+func (s *Session) newTorrent(meta torrentfile.TorrentFile, path string) *Torrent {
+	return &Torrent{
+		session:  s,
+		meta:     meta,
+		path:     path,
+		download: ratelimit.New(0),
+		upload:   ratelimit.New(0),
+		incoming: make(chan *client.Client, 8),
+		added:    time.Now(),
+		peers:    make(map[string]PeerInfo),
+	}
+}
+
+// Добавление торрента по содержимому .torrent файла и запуск скачивания
+func (s *Session) Add(data []byte) (*Torrent, error) {
+	return s.AddWithOptions(data, AddOptions{})
+}
+
+// Параметры добавления торрента
+type AddOptions struct {
+	Paused      bool   // Торрент не запускается и не обращается к сети до Resume
+	DownloadDir string // Директория данных торрента (пустая - DownloadDir или IncompleteDir сессии)
+
+	selectOnly []int        // Скачиваются только файлы с этими номерами (nil - все)
+	peers      []peers.Peer // Пиры в дополнение к полученным от трекера
+}
+
+// Добавление торрента по содержимому .torrent файла с параметрами opts
+func (s *Session) AddWithOptions(data []byte, opts AddOptions) (*Torrent, error) {
+	meta, err := torrentfile.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	return s.add(meta, data, opts)
+}
+
+// Пиры из параметров x.pe magnet-ссылки. Неразборчивые адреса пропускаются
+func magnetPeers(m magnet.Magnet) []peers.Peer {
+	var found []peers.Peer
+	for _, addr := range m.Peers {
+		peer, err := peers.ParseAddr(addr, peers.SourceMagnet)
+		if err == nil {
+			found = append(found, peer)
+		}
+	}
+	return found
+}
+
+// Добавление разобранного торрента
+func (s *Session) add(meta torrentfile.TorrentFile, data []byte, opts AddOptions) (*Torrent, error) {
+	var err error
+	if opts.DownloadDir != "" && s.cfg.Storage != nil {
+		return nil, errors.New("Download directory can't be chosen for torrents in custom storage")
+	}
+
+	s.mu.Lock()
+	if t, ok := s.torrents[meta.InfoHash]; ok {
+		s.mu.Unlock()
+		return t, ErrExists
+	}
+	err = os.WriteFile(s.torrentPath(meta.InfoHash), data, 0o644)
+	if err != nil {
+		s.mu.Unlock()
+		return nil, err
+	}
+	dir := s.cfg.DownloadDir
+	switch {
+	case opts.DownloadDir != "": // Данные сразу скачиваются в выбранную директорию
+		dir = opts.DownloadDir
+	case s.moveCompleted():
+		dir = s.cfg.IncompleteDir
+	}
+	t := s.newTorrent(meta, filepath.Join(dir, meta.Name))
+	t.paused = opts.Paused
+	t.extra = opts.peers
+	if opts.selectOnly != nil && len(meta.Files) > 0 {
+		t.files = make([]Priority, len(meta.Files))
+		for i := range t.files {
+			t.files[i] = PrioritySkip
+		}
+		for _, i := range opts.selectOnly {
+			if i < len(t.files) {
+				t.files[i] = PriorityNormal
+			}
+		}
+	}
+	s.torrents[meta.InfoHash] = t
+	s.mu.Unlock()
+
+	err = s.saveState()
+	if err != nil {
+		return nil, err
+	}
+	if !opts.Paused {
+		t.start()
+	}
+	return t, nil
+}
+
+// Добавление торрента из .torrent файла
+func (s *Session) AddFile(path string) (*Torrent, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return s.Add(data)
+}
+
+// Добавление торрента по ссылке на .torrent файл
+func (s *Session) AddURL(ctx context.Context, url string) (*Torrent, error) {
+	return s.AddURLWithOptions(ctx, url, AddOptions{})
+}
+
+// Добавление торрента по ссылке на .torrent файл с параметрами opts
+func (s *Session) AddURLWithOptions(ctx context.Context, url string, opts AddOptions) (*Torrent, error) {
+	data, err := s.fetchTorrent(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	return s.AddWithOptions(data, opts)
+}
+
+// Добавление торрента по magnet-ссылке. Метаданные берутся по ссылкам
+// из параметра xs; получение метаданных от пиров не поддерживается
+func (s *Session) AddMagnet(ctx context.Context, uri string) (*Torrent, error) {
+	return s.AddMagnetWithOptions(ctx, uri, AddOptions{})
+}
+
+// Добавление торрента по magnet-ссылке с параметрами opts
+func (s *Session) AddMagnetWithOptions(ctx context.Context, uri string, opts AddOptions) (*Torrent, error) {
+	m, err := magnet.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	if t, ok := s.Get(m.InfoHash); ok && m.HasInfoHash {
+		return t, ErrExists
+	}
+
+	err = ErrNoMetadata
+	for _, source := range m.Sources {
+		data, fetchErr := s.fetchTorrent(ctx, source)
+		if fetchErr != nil {
+			err = fetchErr
+			continue
+		}
+		meta, parseErr := torrentfile.Parse(data)
+		if parseErr != nil {
+			err = parseErr
+			continue
+		}
+		if (m.HasInfoHash && meta.InfoHash != m.InfoHash) || (m.HasInfoHashV2 && meta.InfoHashV2 != m.InfoHashV2) {
+			err = fmt.Errorf("Torrent from %s does not match magnet info hash", source)
+			continue
+		}
+		opts.selectOnly = m.SelectOnly
+		opts.peers = magnetPeers(m)
+		return s.add(meta, data, opts)
+	}
+	return nil, err
+}
+
+// Скачивание .torrent файла по HTTP
+func (s *Session) fetchTorrent(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	c := &http.Client{Timeout: 30 * time.Second, Transport: s.cfg.Client.Proxy.Transport()}
+	res, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Fetching %s failed: %s", url, res.Status)
+	}
+	return io.ReadAll(io.LimitReader(res.Body, maxTorrentSize))
+}
+
+// Торрент по хешу
+func (s *Session) Get(hash [20]byte) (*Torrent, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.torrents[hash]
+	return t, ok
+}
+
+// Все торренты в порядке добавления
+func (s *Session) Torrents() []*Torrent {
+	s.mu.Lock()
+	list := make([]*Torrent, 0, len(s.torrents))
+	for _, t := range s.torrents {
+		list = append(list, t)
+	}
+	s.mu.Unlock()
+
+	sort.Slice(list, func(a, b int) bool {
+		return list[a].Stats().Added.Before(list[b].Stats().Added)
+	})
+	return list
+}
+
+// Удаление торрента из сессии. При deleteData удаляются и скачанные данные
+func (s *Session) Remove(hash [20]byte, deleteData bool) error {
+	s.mu.Lock()
+	t, ok := s.torrents[hash]
+	delete(s.torrents, hash)
+	s.mu.Unlock()
+	if !ok {
+		return ErrNotFound
+	}
+
+	t.stop()
+	err := os.Remove(s.torrentPath(hash))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if deleteData { // Данные удаляет хранилище, в котором они лежат
+		err = storage.Remove(t.storage(), t.meta.StorageInfo())
+		if err != nil {
+			return err
+		}
+	}
+	return s.saveState()
+}
+
+// Приостановка скачивания торрента
+func (s *Session) Pause(hash [20]byte) error {
+	t, ok := s.Get(hash)
+	if !ok {
+		return ErrNotFound
+	}
+	t.mu.Lock()
+	t.paused = true
+	t.mu.Unlock()
+	t.stop()
+	t.setStatus(StatusPaused) // Торрент, остановленный ошибкой, тоже становится приостановленным
+	return s.saveState()
+}
+
+// Возобновление скачивания торрента
+func (s *Session) Resume(hash [20]byte) error {
+	t, ok := s.Get(hash)
+	if !ok {
+		return ErrNotFound
+	}
+	t.mu.Lock()
+	t.paused = false
+	t.mu.Unlock()
+	t.start()
+	return s.saveState()
+}
+
+// Повторная проверка данных торрента на диске. Приостановленный торрент
+// будет проверен при возобновлении
+func (s *Session) Recheck(hash [20]byte) error {
+	t, ok := s.Get(hash)
+	if !ok {
+		return ErrNotFound
+	}
+	t.stop()
+	t.mu.Lock()
+	t.have = nil
+	t.mu.Unlock()
+	t.restart()
+	return s.saveState()
+}
+
+// Изменение приоритетов файлов торрента: номер файла в Meta().Files -> приоритет
+func (s *Session) SetFilePriorities(hash [20]byte, priorities map[int]Priority) error {
+	t, ok := s.Get(hash)
+	if !ok {
+		return ErrNotFound
+	}
+	t.mu.Lock()
+	for i := range priorities {
+		if i < 0 || i >= len(t.meta.Files) {
+			t.mu.Unlock()
+			return fmt.Errorf("File index %d out of range", i)
+		}
+	}
+	if t.files == nil {
+		t.files = make([]Priority, len(t.meta.Files))
+	}
+	for i, p := range priorities {
+		t.files[i] = p
+	}
+	t.mu.Unlock()
+
+	t.restart() // Очередь частей строится при запуске скачивания
+	return s.saveState()
+}
+
+// Изменение собственных лимитов скорости торрента
+func (s *Session) SetTorrentRateLimits(hash [20]byte, download, upload int64) error {
+	t, ok := s.Get(hash)
+	if !ok {
+		return ErrNotFound
+	}
+	t.download.SetRate(download)
+	t.upload.SetRate(upload)
+	return s.saveState()
+}
+
+// Изменение общих лимитов скорости
+func (s *Session) SetRateLimits(download, upload int64) {
+	s.download.SetRate(download)
+	s.upload.SetRate(upload)
+}
+
+// Сводка о сессии
+type SessionStats struct {
+	Torrents      int
+	DownloadRate  int64 // Общая скорость приема от пиров, байт/с
+	UploadRate    int64
+	Downloaded    int64 // Всего принято от пиров за время работы сессии, байт
+	Uploaded      int64
+	DownloadLimit int64 // Общие лимиты скорости (0 - без лимита)
+	UploadLimit   int64
+	Cache         storage.CacheStats // Статистика кэша частей (нулевая без кэша)
+	DHTNodes      int                // Узлов в таблице маршрутизации DHT
+}
+
+// Текущая сводка о сессии
+func (s *Session) Stats() SessionStats {
+	s.mu.Lock()
+	torrents := len(s.torrents)
+	s.mu.Unlock()
+	stats := SessionStats{
+		Torrents:      torrents,
+		DownloadRate:  s.download.Speed(),
+		UploadRate:    s.upload.Speed(),
+		Downloaded:    s.download.Total(),
+		Uploaded:      s.upload.Total(),
+		DownloadLimit: s.download.Rate(),
+		UploadLimit:   s.upload.Rate(),
+	}
+	if s.cache != nil {
+		stats.Cache = s.cache.Stats()
+	}
+	if s.dht != nil {
+		stats.DHTNodes = s.dht.Nodes()
+	}
+	return stats
+}
+
+// Остановка всех торрентов, закрытие порта и сохранение состояния
+func (s *Session) Close() error {
+	close(s.closed)
+	if s.dht != nil {
+		s.dht.Close()
+	}
+	for _, l := range s.listeners {
+		l.Close()
+	}
+	for _, t := range s.Torrents() {
+		t.stop()
+	}
+	s.wg.Wait()
+	s.hashes.Close()
+	return s.saveState()
+}
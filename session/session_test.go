@@ -0,0 +1,269 @@
+package session
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/swesdek/gotorrent-client/storage"
+	"github.com/swesdek/gotorrent-client/torrentfile"
+)
+
+// Сессия во временной директории с параметрами cfg. Пустые DataDir и
+// DownloadDir заменяются поддиректориями dir
+func newTestSession(t *testing.T, dir string, cfg Config) *Session {
+	t.Helper()
+	t.Setenv("XDG_CONFIG_HOME", dir) // Список блокировки берется из директории пользователя
+	t.Setenv("HOME", dir)
+	if cfg.DataDir == "" {
+		cfg.DataDir = filepath.Join(dir, "state")
+	}
+	if cfg.DownloadDir == "" {
+		cfg.DownloadDir = filepath.Join(dir, "downloads")
+	}
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// Файл dir/name длиной length и содержимое .torrent файла для него.
+// Трекер недоступен, поэтому у пиров торрент не скачивается
+func testTorrent(t *testing.T, dir, name string, length int) []byte {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	err := os.MkdirAll(dir, 0o755)
+	if err == nil {
+		err = os.WriteFile(path, bytes.Repeat([]byte("x"), length), 0o644)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	_, err = torrentfile.Create(torrentfile.CreateOptions{Path: path, Announce: "http://127.0.0.1:1/announce"}, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// Ожидание состояния status у торрента
+func waitStatus(t *testing.T, tor *Torrent, status Status) Stats {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		stats := tor.Stats()
+		if stats.Status == status {
+			return stats
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("status %v (%s), want %v", stats.Status, stats.Error, status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSessionCacheStats(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestSession(t, dir, Config{CacheSize: 1 << 20})
+	data := testTorrent(t, filepath.Join(dir, "downloads"), "file.bin", 100000)
+
+	tor, err := s.Add(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats := waitStatus(t, tor, StatusCompleted) // Данные уже на диске и только проверяются
+	if stats.Completed != stats.Pieces {
+		t.Fatalf("%d of %d pieces verified", stats.Completed, stats.Pieces)
+	}
+	cache := s.Stats().Cache
+	if cache.ReadMisses < int64(stats.Pieces) {
+		t.Errorf("%d cache misses for %d pieces read by the check", cache.ReadMisses, stats.Pieces)
+	}
+}
+
+func TestRemoveDeletesThroughStorage(t *testing.T) {
+	dir := t.TempDir()
+	downloads := filepath.Join(dir, "downloads")
+	data := testTorrent(t, downloads, "file.bin", 50000) // Файл по пути данных не принадлежит хранилищу
+
+	mem := storage.NewMemory()
+	s := newTestSession(t, dir, Config{Storage: mem})
+	tor, err := s.AddWithOptions(data, AddOptions{Paused: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := tor.Meta().StorageInfo()
+	st, _ := mem.OpenTorrent(info)
+	_, err = st.Piece(0).WriteAt([]byte("data"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.Remove(tor.InfoHash(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(filepath.Join(downloads, "file.bin")); err != nil {
+		t.Errorf("file outside the storage removed: %v", err)
+	}
+	st, _ = mem.OpenTorrent(info)
+	buf := make([]byte, 4)
+	st.Piece(0).ReadAt(buf, 0)
+	if string(buf) == "data" {
+		t.Error("data left in the memory storage")
+	}
+}
+
+func TestRemoveDeletesFiles(t *testing.T) {
+	dir := t.TempDir()
+	downloads := filepath.Join(dir, "downloads")
+	data := testTorrent(t, downloads, "file.bin", 50000)
+	s := newTestSession(t, dir, Config{})
+	tor, err := s.AddWithOptions(data, AddOptions{Paused: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Remove(tor.InfoHash(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(filepath.Join(downloads, "file.bin")); !os.IsNotExist(err) {
+		t.Errorf("data file not removed: %v", err)
+	}
+}
+
+// Свободный порт для TCP и UDP на 127.0.0.1
+func freePort(t *testing.T) uint16 {
+	t.Helper()
+	for i := 0; i < 10; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		port := l.Addr().(*net.TCPAddr).Port
+		l.Close()
+		pc, err := net.ListenPacket("udp", fmt.Sprintf(":%d", port))
+		if err == nil {
+			pc.Close()
+			return uint16(port)
+		}
+	}
+	t.Fatal("no free port")
+	return 0
+}
+
+func TestSessionDHT(t *testing.T) {
+	dir := t.TempDir()
+	portA, portB := freePort(t), freePort(t)
+	a := newTestSession(t, filepath.Join(dir, "a"), Config{Port: portA, DHT: true, DHTBootstrap: []string{}})
+	b := newTestSession(t, filepath.Join(dir, "b"), Config{
+		Port:         portB,
+		DHT:          true,
+		DHTBootstrap: []string{fmt.Sprintf("127.0.0.1:%d", portA)}, // Узел A через общий с uTP сокет
+	})
+	data := testTorrent(t, filepath.Join(dir, "src"), "file.bin", 50000) // Данных нет в download_dir
+
+	tor, err := b.Add(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	want := fmt.Sprintf("127.0.0.1:%d", portB)
+	for {
+		found, _ := a.dht.GetPeers(ctx, tor.InfoHash())
+		if len(found) == 1 && found[0].String() == want {
+			break
+		}
+		if ctx.Err() != nil {
+			t.Fatalf("torrent not announced to DHT, found %v", found)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if nodes := b.Stats().DHTNodes; nodes != 1 {
+		t.Errorf("session B knows %d DHT nodes, want 1", nodes)
+	}
+}
+
+// Хранилище в памяти, запись в которое завершается ENOSPC, пока установлен full
+type fullStorage struct {
+	*storage.Memory
+	full *atomic.Bool
+}
+
+func (s fullStorage) OpenTorrent(info storage.Info) (storage.Torrent, error) {
+	tor, err := s.Memory.OpenTorrent(info)
+	return fullTorrent{tor, s.full}, err
+}
+
+type fullTorrent struct {
+	storage.Torrent
+	full *atomic.Bool
+}
+
+func (t fullTorrent) Piece(index int) storage.Piece {
+	return fullPiece{t.Torrent.Piece(index), t.full}
+}
+
+type fullPiece struct {
+	storage.Piece
+	full *atomic.Bool
+}
+
+func (p fullPiece) WriteAt(buf []byte, off int64) (int, error) {
+	if p.full.Load() {
+		return 0, &os.PathError{Op: "write", Path: "data.bin", Err: syscall.ENOSPC}
+	}
+	return p.Piece.WriteAt(buf, off)
+}
+
+func TestNoSpacePausesTorrent(t *testing.T) {
+	dir := t.TempDir()
+	seed := filepath.Join(dir, "seed")
+	testTorrent(t, seed, "file.bin", 100000)
+	ts := httptest.NewServer(http.FileServer(http.Dir(seed)))
+	defer ts.Close()
+	var buf bytes.Buffer
+	_, err := torrentfile.Create(torrentfile.CreateOptions{
+		Path:     filepath.Join(seed, "file.bin"),
+		Announce: "http://127.0.0.1:1/announce",
+		WebSeeds: []string{ts.URL + "/"}, // Данные скачиваются только с веб-сида
+	}, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	full := new(atomic.Bool)
+	full.Store(true)
+	s := newTestSession(t, dir, Config{Storage: fullStorage{storage.NewMemory(), full}})
+	tor, err := s.Add(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats := waitStatus(t, tor, StatusPaused)
+	if !strings.Contains(stats.Error, "no space") {
+		t.Errorf("paused with error %q, want no space", stats.Error)
+	}
+
+	// После освобождения места торрент продолжается вызовом Resume
+	full.Store(false)
+	if err = s.Resume(tor.InfoHash()); err != nil {
+		t.Fatal(err)
+	}
+	if stats = waitStatus(t, tor, StatusCompleted); stats.Completed != stats.Pieces || stats.Error != "" {
+		t.Errorf("%d of %d pieces, error %q after resume", stats.Completed, stats.Pieces, stats.Error)
+	}
+}
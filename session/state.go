@@ -0,0 +1,114 @@
+package session
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/swesdek/gotorrent-client/bitfields"
+	"github.com/swesdek/gotorrent-client/torrentfile"
+)
+
+// Сохраненное состояние торрента
+type torrentState struct {
+	InfoHash string    `json:"info_hash"`
+	Path     string    `json:"path"`
+	Paused   bool      `json:"paused"`
+	Finished bool      `json:"finished,omitempty"` // Завершение скачивания обработано
+	Have     []byte    `json:"have,omitempty"`     // Поле проверенных частей (отсутствует - нужна проверка)
+	Added    time.Time `json:"added"`
+
+	Files         []Priority `json:"files,omitempty"` // Приоритеты файлов (отсутствует - все обычные)
+	DownloadLimit int64      `json:"download_limit,omitempty"`
+	UploadLimit   int64      `json:"upload_limit,omitempty"`
+}
+
+// Сохраненное состояние сессии
+type sessionState struct {
+	Torrents []torrentState `json:"torrents"`
+}
+
+// Путь к файлу состояния сессии
+func (s *Session) statePath() string {
+	return filepath.Join(s.cfg.DataDir, "session.json")
+}
+
+// Запись состояния сессии через временный файл
+func (s *Session) saveState() error {
+	state := sessionState{Torrents: []torrentState{}}
+	for _, t := range s.Torrents() {
+		t.mu.Lock()
+		state.Torrents = append(state.Torrents, torrentState{
+			InfoHash: hex.EncodeToString(t.meta.InfoHash[:]),
+			Path:     t.path,
+			Paused:   t.paused,
+			Finished: t.finished,
+			Have:     append([]byte(nil), t.have...),
+			Added:    t.added,
+
+			Files:         append([]Priority(nil), t.files...),
+			DownloadLimit: t.download.Rate(),
+			UploadLimit:   t.upload.Rate(),
+		})
+		t.mu.Unlock()
+	}
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.statePath() + ".tmp"
+	err = os.WriteFile(tmp, data, 0o644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.statePath())
+}
+
+// Загрузка торрентов из сохраненного состояния
+func (s *Session) loadState() error {
+	data, err := os.ReadFile(s.statePath())
+	if errors.Is(err, os.ErrNotExist) { // Первый запуск
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var state sessionState
+	err = json.Unmarshal(data, &state)
+	if err != nil {
+		return fmt.Errorf("Malformed session state %s: %v", s.statePath(), err)
+	}
+
+	for _, ts := range state.Torrents {
+		var hash [20]byte
+		_, err := hex.Decode(hash[:], []byte(ts.InfoHash))
+		if err != nil {
+			return fmt.Errorf("Malformed info hash %q in session state", ts.InfoHash)
+		}
+		meta, err := torrentfile.Open(s.torrentPath(hash))
+		if err != nil {
+			fmt.Printf("Skipping torrent %s: %v\n", ts.InfoHash, err)
+			continue
+		}
+
+		t := s.newTorrent(meta, ts.Path)
+		t.paused = ts.Paused
+		t.finished = ts.Finished
+		t.added = ts.Added
+		if len(ts.Have) == len(bitfields.New(meta.NumPieces())) { // Поле другой длины не доверяется
+			t.have = ts.Have
+		}
+		if len(ts.Files) == len(meta.Files) {
+			t.files = ts.Files
+		}
+		t.download.SetRate(ts.DownloadLimit)
+		t.upload.SetRate(ts.UploadLimit)
+		s.torrents[hash] = t
+	}
+	return nil
+}
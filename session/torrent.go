@@ -0,0 +1,568 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/swesdek/gotorrent-client/bitfields"
+	"github.com/swesdek/gotorrent-client/client"
+	"github.com/swesdek/gotorrent-client/peers"
+	"github.com/swesdek/gotorrent-client/ratelimit"
+	"github.com/swesdek/gotorrent-client/storage"
+	"github.com/swesdek/gotorrent-client/torrentfile"
+)
+
+// Интервалы повторного запроса пиров у трекера и в DHT
+const (
+	announceInterval    = 30 * time.Minute
+	dhtAnnounceInterval = 15 * time.Minute
+)
+
+// Состояние торрента в сессии
+type Status int
+
+const (
+	StatusPaused      Status = iota // Остановлен пользователем
+	StatusChecking                  // Проверка данных на диске
+	StatusDownloading               // Скачивание
+	StatusCompleted                 // Все части скачаны и проверены
+	StatusError                     // Скачивание остановлено ошибкой
+)
+
+func (s Status) String() string {
+	switch s {
+	case StatusPaused:
+		return "paused"
+	case StatusChecking:
+		return "checking"
+	case StatusDownloading:
+		return "downloading"
+	case StatusCompleted:
+		return "completed"
+	case StatusError:
+		return "error"
+	}
+	return fmt.Sprintf("status(%d)", int(s))
+}
+
+// Приоритет файла торрента
+type Priority int
+
+const (
+	PrioritySkip   Priority = -1 // Файл не скачивается
+	PriorityNormal Priority = 0
+	PriorityHigh   Priority = 1 // Файл скачивается раньше остальных
+)
+
+func (p Priority) String() string {
+	switch p {
+	case PrioritySkip:
+		return "skip"
+	case PriorityNormal:
+		return "normal"
+	case PriorityHigh:
+		return "high"
+	}
+	return fmt.Sprintf("priority(%d)", int(p))
+}
+
+// Разбор приоритета из строки, возвращаемой String
+func ParsePriority(s string) (Priority, error) {
+	for _, p := range []Priority{PrioritySkip, PriorityNormal, PriorityHigh} {
+		if p.String() == s {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown priority %q", s)
+}
+
+// Подключенный пир торрента
+type PeerInfo struct {
+	Address   string
+	Source    peers.Source
+	Fast      bool // Пир поддерживает Fast Extension
+	Connected time.Time
+}
+
+// Торрент, управляемый сессией
+type Torrent struct {
+	session  *Session
+	meta     torrentfile.TorrentFile
+	path     string             // Файл или директория с данными торрента
+	download *ratelimit.Limiter // Собственные ограничения скорости торрента
+	upload   *ratelimit.Limiter
+	incoming chan *client.Client // Входящие соединения для работающего скачивания
+	extra    []peers.Peer        // Пиры из magnet-ссылки (x.pe), не сохраняются между запусками
+
+	mu       sync.Mutex
+	status   Status
+	err      error
+	have     bitfields.Bitfield // Проверенные части (nil - данные на диске еще не проверялись)
+	paused   bool
+	finished bool // Завершение скачивания обработано: данные перенесены, обработчики запущены
+	added    time.Time
+	files    []Priority          // Приоритеты файлов из meta.Files (nil - все обычные)
+	peers    map[string]PeerInfo // Подключенные пиры по адресу
+	cancel   context.CancelFunc
+	stopped  chan struct{} // Закрывается по завершении горутины скачивания
+}
+
+// Сводка о торренте
+type Stats struct {
+	InfoHash    [20]byte
+	Name        string
+	Path        string
+	Status      Status
+	Error       string
+	Length      int
+	PieceLength int
+	Pieces      int
+	Completed   int // Количество скачанных частей
+	Added       time.Time
+
+	Peers         int   // Количество подключенных пиров
+	DownloadRate  int64 // Скорость приема от пиров, байт/с
+	UploadRate    int64 // Скорость отправки пирам, байт/с
+	Downloaded    int64 // Всего принято от пиров за время работы сессии, байт
+	Uploaded      int64
+	DownloadLimit int64 // Собственные лимиты скорости торрента (0 - без лимита)
+	UploadLimit   int64
+}
+
+// Доля скачанных частей от 0 до 1
+func (s Stats) Progress() float64 {
+	if s.Pieces == 0 {
+		return 0
+	}
+	return float64(s.Completed) / float64(s.Pieces)
+}
+
+// Хеш торрента
+func (t *Torrent) InfoHash() [20]byte {
+	return t.meta.InfoHash
+}
+
+// Метаданные торрента
+func (t *Torrent) Meta() *torrentfile.TorrentFile {
+	return &t.meta
+}
+
+// Количество частей, отмеченных в поле have
+func countPieces(have bitfields.Bitfield, numPieces int) int {
+	n := 0
+	for i := 0; i < numPieces; i++ {
+		if have.HasPiece(i) {
+			n++
+		}
+	}
+	return n
+}
+
+// Текущая сводка о торренте
+func (t *Torrent) Stats() Stats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	stats := Stats{
+		InfoHash:    t.meta.InfoHash,
+		Name:        t.meta.Name,
+		Path:        t.path,
+		Status:      t.status,
+		Length:      t.meta.Length,
+		PieceLength: t.meta.PieceLength,
+		Pieces:      t.meta.NumPieces(),
+		Completed:   countPieces(t.have, t.meta.NumPieces()),
+		Added:       t.added,
+
+		Peers:         len(t.peers),
+		DownloadRate:  t.download.Speed(),
+		UploadRate:    t.upload.Speed(),
+		Downloaded:    t.download.Total(),
+		Uploaded:      t.upload.Total(),
+		DownloadLimit: t.download.Rate(),
+		UploadLimit:   t.upload.Rate(),
+	}
+	if t.err != nil {
+		stats.Error = t.err.Error()
+	}
+	return stats
+}
+
+func (t *Torrent) setStatus(status Status) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.status = status
+}
+
+// Запуск скачивания, если оно еще не запущено
+func (t *Torrent) start() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.cancel != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.cancel = cancel
+	t.stopped = make(chan struct{})
+	t.status = StatusChecking // Пока горутина скачивания не определила состояние
+	t.err = nil
+	go t.run(ctx, t.stopped)
+}
+
+// Остановка скачивания с ожиданием завершения горутины
+func (t *Torrent) stop() {
+	t.mu.Lock()
+	cancel, stopped := t.cancel, t.stopped
+	t.mu.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-stopped
+}
+
+// Перезапуск скачивания с новыми параметрами. Приостановленный торрент не запускается
+func (t *Torrent) restart() {
+	t.stop()
+	t.mu.Lock()
+	paused := t.paused
+	t.mu.Unlock()
+	if !paused {
+		t.start()
+	}
+}
+
+// Горутина скачивания: проверка данных, запрос пиров и скачивание недостающих частей
+func (t *Torrent) run(ctx context.Context, stopped chan struct{}) {
+	defer close(stopped)
+	err := t.fetch(ctx)
+	if err == nil && ctx.Err() == nil {
+		err = t.finish()
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.cancel = nil
+	switch {
+	case ctx.Err() != nil: // Остановлено вызовом stop
+		t.status = StatusPaused
+	case storage.IsNoSpace(err): // Торрент продолжится после освобождения места и Resume
+		t.status = StatusPaused
+		t.paused = true
+		t.err = err
+	case err != nil:
+		t.status = StatusError
+		t.err = err
+	default:
+		t.status = StatusCompleted
+	}
+	t.session.markDirty()
+}
+
+// Поле проверенных частей (nil - данные еще не проверены)
+func (t *Torrent) Have() bitfields.Bitfield {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append(bitfields.Bitfield(nil), t.have...)
+}
+
+// Подключенные пиры в порядке подключения
+func (t *Torrent) Peers() []PeerInfo {
+	t.mu.Lock()
+	list := make([]PeerInfo, 0, len(t.peers))
+	for _, info := range t.peers {
+		list = append(list, info)
+	}
+	t.mu.Unlock()
+
+	sort.Slice(list, func(a, b int) bool {
+		return list[a].Connected.Before(list[b].Connected)
+	})
+	return list
+}
+
+// Учет подключения и отключения пира
+func (t *Torrent) trackPeer(c *client.Client, connected bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	addr := c.Peer().String()
+	if !connected {
+		delete(t.peers, addr)
+		return
+	}
+	t.peers[addr] = PeerInfo{
+		Address:   addr,
+		Source:    c.Peer().Source,
+		Fast:      c.Fast,
+		Connected: time.Now(),
+	}
+}
+
+// Приоритеты файлов в порядке meta.Files
+func (t *Torrent) FilePriorities() []Priority {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	priorities := make([]Priority, len(t.meta.Files))
+	copy(priorities, t.files)
+	return priorities
+}
+
+// Приоритеты частей по приоритетам файлов: часть получает наибольший
+// приоритет среди файлов, в которые попадают ее данные
+func (t *Torrent) piecePriorities() []int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.files == nil {
+		return nil
+	}
+	priorities := make([]int, t.meta.NumPieces())
+	for index := range priorities {
+		files := t.meta.PieceFiles(index)
+		if len(files) == 0 {
+			continue
+		}
+		priorities[index] = int(PrioritySkip)
+		for _, i := range files {
+			priorities[index] = max(priorities[index], int(t.files[i]))
+		}
+	}
+	return priorities
+}
+
+// Отметка проверенной части
+func (t *Torrent) setPiece(index int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.have.SetPiece(index)
+}
+
+// Хранилище данных торрента без кэша
+func (t *Torrent) storage() storage.Storage {
+	if t.session.cfg.Storage != nil {
+		return t.session.cfg.Storage
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return &storage.FileStorage{Path: t.path, Prealloc: t.session.cfg.Prealloc}
+}
+
+// Открытие хранилища данных торрента
+func (t *Torrent) openStorage() (storage.Torrent, error) {
+	s := t.storage()
+	if t.session.cache != nil {
+		s = t.session.cache.Wrap(s)
+	}
+	return s.OpenTorrent(t.meta.StorageInfo())
+}
+
+func (t *Torrent) fetch(ctx context.Context) error {
+	st, err := t.openStorage()
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	t.mu.Lock()
+	have := t.have
+	t.mu.Unlock()
+
+	if have == nil { // Первый запуск или перепроверка
+		t.setStatus(StatusChecking)
+		checked, err := t.meta.CheckStorage(ctx, st)
+		if err != nil {
+			return err
+		}
+		t.mu.Lock()
+		t.have = checked
+		t.mu.Unlock()
+		t.session.markDirty()
+		have = checked
+	}
+	priorities := t.piecePriorities()
+	wanted := false // Остались ли нескачанные части, которые нужно скачать
+	for index := 0; index < t.meta.NumPieces(); index++ {
+		if !have.HasPiece(index) && (priorities == nil || priorities[index] >= 0) {
+			wanted = true
+			break
+		}
+	}
+	if !wanted {
+		return nil
+	}
+
+	t.mu.Lock()
+	t.status = StatusDownloading
+	t.finished = false // После скачивания недостающих частей завершение обрабатывается заново
+	t.mu.Unlock()
+	s := t.session
+	useDHT := s.dht != nil && !t.meta.Private // Приватный торрент получает пиров только от трекеров
+	found, err := t.meta.RequestPeers(s.trackerOptions())
+	if err != nil && len(t.meta.WebSeeds) == 0 && len(t.extra) == 0 && !useDHT {
+		return err
+	}
+	found = append(found, t.extra...) // У приватного торрента отбрасываются при подключении
+	newPeers := make(chan peers.Peer)
+	go t.announce(ctx, newPeers)
+	if useDHT {
+		go t.announceDHT(ctx, newPeers)
+	}
+
+	dl := t.meta.NewDownload(s.peerID, found, s.bans, s.clientOptions(t))
+	dl.Done = append(bitfields.Bitfield(nil), have...)
+	dl.NewPeers = newPeers
+	dl.Incoming = t.incoming
+	dl.Connections = s.conns
+	dl.Priorities = priorities
+	dl.OnPeer = t.trackPeer
+	dl.Verbose = s.cfg.Verbose
+	dl.MaxBacklog = s.cfg.MaxBacklog
+	dl.BlockSize = s.cfg.BlockSize
+	dl.PieceTimeout = s.cfg.PieceTimeout
+	dl.HashPool = s.hashes
+	if s.cache != nil { // Блоки накапливаются в кэше и записываются вместе с проверенной частью
+		dl.OnBlock = func(index, begin int, block []byte) error {
+			_, err := st.Piece(index).WriteAt(block, int64(begin))
+			return err
+		}
+	}
+	return dl.Run(ctx, func(index int, buf []byte) error {
+		piece := st.Piece(index)
+		_, err := piece.WriteAt(buf, 0)
+		if err != nil {
+			return err
+		}
+		err = piece.MarkComplete()
+		if err != nil {
+			return err
+		}
+		t.setPiece(index)
+		s.markDirty()
+		return nil
+	})
+}
+
+// Обработка завершения скачивания всех частей: перенос данных из
+// IncompleteDir в DownloadDir и запуск обработчиков в фоне. Торрент, часть
+// файлов которого пропущена, не считается завершенным
+func (t *Torrent) finish() error {
+	t.mu.Lock()
+	done := t.finished || countPieces(t.have, t.meta.NumPieces()) < t.meta.NumPieces()
+	path := t.path
+	t.mu.Unlock()
+	if done {
+		return nil
+	}
+
+	s := t.session
+	if s.moveCompleted() && filepath.Dir(path) == filepath.Clean(s.cfg.IncompleteDir) {
+		dst := uniquePath(filepath.Join(s.cfg.DownloadDir, filepath.Base(path)))
+		err := storage.Move(path, dst)
+		if err != nil {
+			return fmt.Errorf("Couldnt move completed download: %w", err)
+		}
+		path = dst
+	}
+	t.mu.Lock()
+	t.path = path
+	t.finished = true
+	t.mu.Unlock()
+	s.markDirty()
+
+	s.wg.Add(1)
+	go t.runHooks(NewCompletion(&t.meta, path))
+	return nil
+}
+
+// Запуск обработчиков завершения. Закрытие сессии прерывает их, а ошибка
+// сохраняется у торрента без изменения его состояния; повторно обработчики
+// не запускаются
+func (t *Torrent) runHooks(c Completion) {
+	s := t.session
+	defer s.wg.Done()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-s.closed:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	err := s.cfg.Hooks.Run(ctx, c)
+	if err != nil {
+		fmt.Printf("Couldnt run completion hooks for %s: %v\n", c.Name, err)
+		t.mu.Lock()
+		t.err = err
+		t.mu.Unlock()
+	}
+}
+
+// Периодический повторный запрос пиров у трекера
+func (t *Torrent) announce(ctx context.Context, newPeers chan<- peers.Peer) {
+	ticker := time.NewTicker(announceInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		found, err := t.meta.RequestPeers(t.session.trackerOptions())
+		if err != nil {
+			continue
+		}
+		for _, peer := range found {
+			select {
+			case newPeers <- peer:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// Поиск пиров и анонс торрента в DHT сразу после запуска и затем периодически
+func (t *Torrent) announceDHT(ctx context.Context, newPeers chan<- peers.Peer) {
+	s := t.session
+	ticker := time.NewTicker(dhtAnnounceInterval)
+	defer ticker.Stop()
+	for {
+		found, err := s.dht.Announce(ctx, t.meta.InfoHash, int(s.cfg.Port))
+		if err != nil && ctx.Err() == nil && s.cfg.Verbose {
+			fmt.Printf("Couldnt announce %s to DHT: %v\n", t.meta.Name, err)
+		}
+		for _, peer := range found {
+			select {
+			case newPeers <- peer:
+			case <-ctx.Done():
+				return
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Передача входящего соединения работающему скачиванию
+func (t *Torrent) deliver(c *client.Client) {
+	c.Conn = ratelimit.Conn(c.Conn, []*ratelimit.Limiter{t.download}, []*ratelimit.Limiter{t.upload})
+	select {
+	case t.incoming <- c:
+	default: // Скачивание не успевает принимать соединения
+		c.Conn.Close()
+	}
+}
+
+// Принимает ли торрент входящие соединения
+func (t *Torrent) active() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.status == StatusDownloading
+}
@@ -0,0 +1,143 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Интервал опроса директории наблюдения
+const DefaultWatchInterval = 5 * time.Second
+
+// Расширение файла с причиной, по которой файл не удалось добавить
+const watchErrorExt = ".error"
+
+// Размер и время изменения файла при прошлом опросе
+type watchedFile struct {
+	size    int64
+	modTime time.Time
+}
+
+// Наблюдение за директорией dir: новые .torrent и .magnet файлы (в последнем -
+// magnet-ссылка) добавляются в сессию и переносятся в archive. Если файл добавить
+// не удалось, рядом с ним создается файл <имя>.error с причиной, и файл
+// пропускается, пока .error не удален. Файл обрабатывается, когда его размер
+// и время изменения не меняются между двумя опросами, чтобы не читать его
+// во время записи. Наблюдение останавливается при закрытии сессии
+func (s *Session) Watch(dir, archive string, interval time.Duration) error {
+	if interval <= 0 {
+		interval = DefaultWatchInterval
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	err = os.MkdirAll(archive, 0o755)
+	if err != nil {
+		return err
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		seen := make(map[string]watchedFile)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			s.scanWatchDir(dir, archive, seen)
+			select {
+			case <-ticker.C:
+			case <-s.closed:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// Один опрос директории наблюдения
+func (s *Session) scanWatchDir(dir, archive string, seen map[string]watchedFile) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		fmt.Printf("Couldnt read watch directory: %v\n", err)
+		return
+	}
+
+	present := make(map[string]bool)
+	for _, e := range entries {
+		name := e.Name()
+		ext := strings.ToLower(filepath.Ext(name))
+		if !e.Type().IsRegular() || (ext != ".torrent" && ext != ".magnet") {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path + watchErrorExt); err == nil { // Уже не удалось добавить
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		present[name] = true
+
+		current := watchedFile{size: info.Size(), modTime: info.ModTime()}
+		if prev, ok := seen[name]; !ok || prev != current { // Файл еще может дописываться
+			seen[name] = current
+			continue
+		}
+		delete(seen, name)
+		s.addWatched(path, ext, archive)
+	}
+	for name := range seen {
+		if !present[name] {
+			delete(seen, name)
+		}
+	}
+}
+
+// Добавление файла из директории наблюдения и перенос его в архив
+func (s *Session) addWatched(path, ext, archive string) {
+	var err error
+	if ext == ".magnet" {
+		var data []byte
+		data, err = os.ReadFile(path)
+		if err == nil {
+			_, err = s.AddMagnet(context.Background(), strings.TrimSpace(string(data)))
+		}
+	} else {
+		_, err = s.AddFile(path)
+	}
+	if err != nil && !errors.Is(err, ErrExists) {
+		fmt.Printf("Couldnt add %s: %v\n", path, err)
+		writeErr := os.WriteFile(path+watchErrorExt, []byte(err.Error()+"\n"), 0o644)
+		if writeErr != nil {
+			fmt.Printf("Couldnt record failure of %s: %v\n", path, writeErr)
+		}
+		return
+	}
+
+	err = os.Rename(path, uniquePath(filepath.Join(archive, filepath.Base(path))))
+	if err != nil {
+		fmt.Printf("Couldnt archive %s: %v\n", path, err)
+	}
+}
+
+// Путь, не занятый существующим файлом: path или path с добавленным номером
+func uniquePath(path string) string {
+	candidate := path
+	for i := 1; ; i++ {
+		_, err := os.Lstat(candidate)
+		if errors.Is(err, os.ErrNotExist) {
+			return candidate
+		}
+		candidate = path + "." + strconv.Itoa(i)
+	}
+}
@@ -0,0 +1,132 @@
+package session
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Сессия и директории наблюдения и архива в dir
+func watchSession(t *testing.T, dir string) (*Session, string, string) {
+	t.Helper()
+	watch, archive := filepath.Join(dir, "watch"), filepath.Join(dir, "archive")
+	for _, d := range []string{watch, archive} {
+		if err := os.MkdirAll(d, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return newTestSession(t, dir, Config{}), watch, archive
+}
+
+// Файлы директории dir
+func listDir(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+func TestWatchWaitsForStableSize(t *testing.T) {
+	dir := t.TempDir()
+	s, watch, archive := watchSession(t, dir)
+	data := testTorrent(t, filepath.Join(dir, "downloads"), "file.bin", 50000)
+	seen := make(map[string]watchedFile)
+	path := filepath.Join(watch, "a.torrent")
+
+	// Файл дописывается между опросами
+	if err := os.WriteFile(path, data[:len(data)/2], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s.scanWatchDir(watch, archive, seen)
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(data[len(data)/2:])
+	f.Close()
+	s.scanWatchDir(watch, archive, seen)
+	if len(s.Torrents()) != 0 || len(listDir(t, archive)) != 0 {
+		t.Fatal("file added while it was still growing")
+	}
+
+	s.scanWatchDir(watch, archive, seen) // Размер не изменился с прошлого опроса
+	if len(s.Torrents()) != 1 {
+		t.Fatalf("%d torrents after the file settled", len(s.Torrents()))
+	}
+	if names := listDir(t, watch); len(names) != 0 {
+		t.Errorf("watch directory still has %v", names)
+	}
+
+	// Повторно добавленный торрент тоже переносится, не затирая архив
+	if err = os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s.scanWatchDir(watch, archive, seen)
+	s.scanWatchDir(watch, archive, seen)
+	if names := listDir(t, archive); strings.Join(names, " ") != "a.torrent a.torrent.1" {
+		t.Errorf("archive has %v", names)
+	}
+	if len(s.Torrents()) != 1 {
+		t.Errorf("%d torrents after adding a duplicate", len(s.Torrents()))
+	}
+}
+
+func TestWatchRecordsErrors(t *testing.T) {
+	dir := t.TempDir()
+	s, watch, archive := watchSession(t, dir)
+	seen := make(map[string]watchedFile)
+	bad := filepath.Join(watch, "bad.torrent")
+	magnet := filepath.Join(watch, "bad.magnet")
+	os.WriteFile(bad, []byte("not a torrent"), 0o644)
+	os.WriteFile(magnet, []byte("http://example.com/\n"), 0o644)
+	os.WriteFile(filepath.Join(watch, "notes.txt"), []byte("ignored"), 0o644)
+
+	s.scanWatchDir(watch, archive, seen)
+	s.scanWatchDir(watch, archive, seen)
+	want := "bad.magnet bad.magnet.error bad.torrent bad.torrent.error notes.txt"
+	if names := listDir(t, watch); strings.Join(names, " ") != want {
+		t.Fatalf("watch directory has %v, want %s", names, want)
+	}
+	reason, err := os.ReadFile(bad + watchErrorExt)
+	if err != nil || len(strings.TrimSpace(string(reason))) == 0 {
+		t.Errorf("reason %q, %v", reason, err)
+	}
+
+	// Пока .error не удален, файл пропускается
+	os.WriteFile(bad+watchErrorExt, []byte("checked\n"), 0o644)
+	s.scanWatchDir(watch, archive, seen)
+	s.scanWatchDir(watch, archive, seen)
+	if reason, _ = os.ReadFile(bad + watchErrorExt); string(reason) != "checked\n" {
+		t.Errorf("failed file retried: reason %q", reason)
+	}
+
+	// После исправления файла и удаления .error файл добавляется
+	data := testTorrent(t, filepath.Join(dir, "downloads"), "file.bin", 50000)
+	os.WriteFile(bad, data, 0o644)
+	os.Remove(bad + watchErrorExt)
+	s.scanWatchDir(watch, archive, seen)
+	s.scanWatchDir(watch, archive, seen)
+	if len(s.Torrents()) != 1 || strings.Join(listDir(t, archive), " ") != "bad.torrent" {
+		t.Errorf("%d torrents, archive %v after fixing the file", len(s.Torrents()), listDir(t, archive))
+	}
+}
+
+func TestWatchRejectsFile(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestSession(t, dir, Config{})
+	path := filepath.Join(dir, "file")
+	os.WriteFile(path, nil, 0o644)
+	if err := s.Watch(path, filepath.Join(dir, "archive"), 0); err == nil {
+		t.Error("watching a regular file")
+	}
+	if err := s.Watch(filepath.Join(dir, "missing"), filepath.Join(dir, "archive"), 0); err == nil {
+		t.Error("watching a missing directory")
+	}
+}
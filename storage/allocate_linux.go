@@ -0,0 +1,11 @@
+package storage
+
+import (
+	"os"
+	"syscall"
+)
+
+// Выделение места под первые length байт файла без изменения данных
+func allocate(f *os.File, length int64) error {
+	return syscall.Fallocate(int(f.Fd()), 0, 0, length)
+}
@@ -0,0 +1,24 @@
+//go:build !linux
+
+package storage
+
+import "os"
+
+// Выделение места под первые length байт файла: без fallocate файл
+// дописывается нулями до нужной длины
+func allocate(f *os.File, length int64) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	zeros := make([]byte, 1<<20)
+	for off := info.Size(); off < length; {
+		n := min(int64(len(zeros)), length-off)
+		_, err = f.WriteAt(zeros[:n], off)
+		if err != nil {
+			return err
+		}
+		off += n
+	}
+	return nil
+}
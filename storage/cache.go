@@ -0,0 +1,315 @@
+package storage
+
+import (
+	"container/list"
+	"sort"
+	"sync"
+)
+
+// Статистика кэша
+type CacheStats struct {
+	ReadHits   int64 // Чтения, обслуженные из кэша
+	ReadMisses int64 // Чтения, потребовавшие обращения к хранилищу
+	Writes     int64 // Записи в кэш
+	Flushes    int64 // Записи накопленных данных в хранилище
+	Cached     int64 // Байт частей в кэше чтения
+	Dirty      int64 // Байт, еще не записанных в хранилище
+}
+
+// Кэш между движком скачивания и хранилищем. Записи блоков накапливаются
+// и попадают в хранилище одной записью, когда часть отмечена проверенной,
+// читается или вытесняется из кэша. Проверенные части, прочитанные из
+// хранилища, хранятся в памяти и вытесняются по давности использования.
+// Кэш общий для всех торрентов, открытых через Wrap
+type Cache struct {
+	size int64 // Предел байт в кэше чтения и накопленных записей вместе
+
+	mu    sync.Mutex
+	lru   *list.List                 // Элементы *cachedRead, недавние в начале
+	reads map[pieceKey]*list.Element // Кэш чтения по части
+	dirty map[pieceKey]*dirtyPiece   // Накопленные записи по части
+	stats CacheStats
+}
+
+// Часть конкретного открытого торрента
+type pieceKey struct {
+	t     *cachedTorrent
+	index int
+}
+
+// Проверенная часть в кэше чтения
+type cachedRead struct {
+	key  pieceKey
+	data []byte
+}
+
+// Накопленные записи в часть
+type dirtyPiece struct {
+	buf    []byte
+	ranges [][2]int64 // Записанные участки buf, не пересекаются
+	bytes  int64      // Сумма длин ranges
+}
+
+// Создание кэша размером size байт
+func NewCache(size int64) *Cache {
+	return &Cache{
+		size:  size,
+		lru:   list.New(),
+		reads: make(map[pieceKey]*list.Element),
+		dirty: make(map[pieceKey]*dirtyPiece),
+	}
+}
+
+// Хранилище s, торренты которого открываются через кэш
+func (c *Cache) Wrap(s Storage) Storage {
+	return cachedStorage{c, s}
+}
+
+// Текущая статистика кэша
+func (c *Cache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+type cachedStorage struct {
+	cache *Cache
+	s     Storage
+}
+
+func (s cachedStorage) OpenTorrent(info Info) (Torrent, error) {
+	t, err := s.s.OpenTorrent(info)
+	if err != nil {
+		return nil, err
+	}
+	return &cachedTorrent{cache: s.cache, info: info, t: t}, nil
+}
+
+func (s cachedStorage) Remove(info Info) error {
+	return Remove(s.s, info)
+}
+
+// Торрент, открытый через кэш
+type cachedTorrent struct {
+	cache *Cache
+	info  Info
+	t     Torrent
+}
+
+func (t *cachedTorrent) Piece(index int) Piece {
+	return &cachedPiece{t: t, index: index, p: t.t.Piece(index)}
+}
+
+// Запись накопленных данных и освобождение частей торрента в кэше
+func (t *cachedTorrent) Close() error {
+	c := t.cache
+	c.mu.Lock()
+	var indexes []int
+	for key := range c.dirty {
+		if key.t == t {
+			indexes = append(indexes, key.index)
+		}
+	}
+	for key, e := range c.reads {
+		if key.t == t {
+			c.dropRead(e)
+		}
+	}
+	c.mu.Unlock()
+
+	sort.Ints(indexes)
+	var firstErr error
+	for _, index := range indexes {
+		if err := c.flush(pieceKey{t, index}); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if err := t.t.Close(); err != nil && firstErr == nil {
+		firstErr = err
+	}
+	return firstErr
+}
+
+// Часть торрента, открытого через кэш
+type cachedPiece struct {
+	t     *cachedTorrent
+	index int
+	p     Piece
+}
+
+func (p *cachedPiece) key() pieceKey {
+	return pieceKey{p.t, p.index}
+}
+
+func (p *cachedPiece) ReadAt(buf []byte, off int64) (int, error) {
+	c := p.t.cache
+	key := p.key()
+	c.mu.Lock()
+	if e, ok := c.reads[key]; ok {
+		c.lru.MoveToFront(e)
+		c.stats.ReadHits++
+		data := e.Value.(*cachedRead).data
+		c.mu.Unlock()
+		return readFrom(data, buf, off)
+	}
+	c.stats.ReadMisses++
+	_, dirty := c.dirty[key]
+	c.mu.Unlock()
+
+	if dirty { // Накопленные записи сначала попадают в хранилище
+		err := c.flush(key)
+		if err != nil {
+			return 0, err
+		}
+	}
+	length := p.t.info.pieceLength(p.index)
+	if !p.p.Completed() || length <= 0 || int64(length) > c.size {
+		return p.p.ReadAt(buf, off) // Непроверенные части могут измениться и не кэшируются
+	}
+
+	data := make([]byte, length)
+	_, err := p.p.ReadAt(data, 0)
+	if err != nil {
+		return p.p.ReadAt(buf, off)
+	}
+	c.mu.Lock()
+	if _, ok := c.reads[key]; !ok {
+		c.reads[key] = c.lru.PushFront(&cachedRead{key: key, data: data})
+		c.stats.Cached += int64(length)
+		c.evict()
+	}
+	c.mu.Unlock()
+	return readFrom(data, buf, off)
+}
+
+func (p *cachedPiece) WriteAt(buf []byte, off int64) (int, error) {
+	c := p.t.cache
+	key := p.key()
+	length := int64(p.t.info.pieceLength(p.index))
+	if off < 0 || off+int64(len(buf)) > length || length > c.size {
+		return p.p.WriteAt(buf, off) // Такие записи не накапливаются
+	}
+
+	c.mu.Lock()
+	if e, ok := c.reads[key]; ok { // Кэш чтения больше не соответствует данным
+		c.dropRead(e)
+	}
+	d, ok := c.dirty[key]
+	if !ok && off == 0 && int64(len(buf)) == length { // Часть целиком пишется без копирования
+		c.stats.Writes++
+		c.stats.Flushes++
+		c.mu.Unlock()
+		return p.p.WriteAt(buf, off)
+	}
+	if !ok {
+		d = &dirtyPiece{buf: make([]byte, length)}
+		c.dirty[key] = d
+	}
+	copy(d.buf[off:], buf)
+	before := d.bytes
+	d.add(off, off+int64(len(buf)))
+	c.stats.Dirty += d.bytes - before
+	c.stats.Writes++
+	c.evict()
+	victims := c.flushVictims(key)
+	c.mu.Unlock()
+
+	for _, victim := range victims { // Вытеснение накопленных записей других частей
+		err := c.flush(victim)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(buf), nil
+}
+
+func (p *cachedPiece) MarkComplete() error {
+	err := p.t.cache.flush(p.key())
+	if err != nil {
+		return err
+	}
+	return p.p.MarkComplete()
+}
+
+func (p *cachedPiece) Completed() bool {
+	return p.p.Completed()
+}
+
+// Запись накопленных данных части в хранилище
+func (c *Cache) flush(key pieceKey) error {
+	c.mu.Lock()
+	d, ok := c.dirty[key]
+	if ok {
+		delete(c.dirty, key)
+		c.stats.Dirty -= d.bytes
+		c.stats.Flushes++
+	}
+	c.mu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	piece := key.t.t.Piece(key.index)
+	for _, r := range d.ranges {
+		_, err := piece.WriteAt(d.buf[r[0]:r[1]], r[0])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Вытеснение частей из кэша чтения, пока кэш не уложится в размер
+func (c *Cache) evict() {
+	for c.stats.Cached+c.stats.Dirty > c.size && c.lru.Len() > 0 {
+		c.dropRead(c.lru.Back())
+	}
+}
+
+// Части с накопленными записями, которые нужно записать, чтобы уложиться
+// в размер (кроме части except)
+func (c *Cache) flushVictims(except pieceKey) []pieceKey {
+	var victims []pieceKey
+	over := c.stats.Dirty - c.size
+	for key, d := range c.dirty {
+		if over <= 0 {
+			break
+		}
+		if key != except {
+			victims = append(victims, key)
+			over -= d.bytes
+		}
+	}
+	return victims
+}
+
+// Удаление части из кэша чтения
+func (c *Cache) dropRead(e *list.Element) {
+	r := c.lru.Remove(e).(*cachedRead)
+	delete(c.reads, r.key)
+	c.stats.Cached -= int64(len(r.data))
+}
+
+// Добавление записанного участка [from, to) с объединением соседних
+func (d *dirtyPiece) add(from, to int64) {
+	ranges := [][2]int64{}
+	for _, r := range d.ranges {
+		if r[1] < from || r[0] > to {
+			ranges = append(ranges, r)
+			continue
+		}
+		from, to = min(from, r[0]), max(to, r[1])
+	}
+	ranges = append(ranges, [2]int64{from, to})
+	sort.Slice(ranges, func(a, b int) bool { return ranges[a][0] < ranges[b][0] })
+	d.ranges = ranges
+	d.bytes = 0
+	for _, r := range ranges {
+		d.bytes += r[1] - r[0]
+	}
+}
+
+// Чтение из data со смещения off
+func readFrom(data, buf []byte, off int64) (int, error) {
+	return byteData(data).ReadAt(buf, off)
+}
@@ -0,0 +1,245 @@
+package storage
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+)
+
+// Хранилище, считающее обращения к частям
+type countingStorage struct {
+	s Storage
+
+	mu     sync.Mutex
+	writes map[int]int
+	reads  map[int]int
+}
+
+func newCountingStorage(s Storage) *countingStorage {
+	return &countingStorage{s: s, writes: make(map[int]int), reads: make(map[int]int)}
+}
+
+func (s *countingStorage) OpenTorrent(info Info) (Torrent, error) {
+	t, err := s.s.OpenTorrent(info)
+	if err != nil {
+		return nil, err
+	}
+	return countingTorrent{t, s}, nil
+}
+
+func (s *countingStorage) count(m map[int]int, index int) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return m[index]
+}
+
+type countingTorrent struct {
+	Torrent
+	s *countingStorage
+}
+
+func (t countingTorrent) Piece(index int) Piece {
+	return countingPiece{t.Torrent.Piece(index), t.s, index}
+}
+
+type countingPiece struct {
+	Piece
+	s     *countingStorage
+	index int
+}
+
+func (p countingPiece) WriteAt(buf []byte, off int64) (int, error) {
+	p.s.mu.Lock()
+	p.s.writes[p.index]++
+	p.s.mu.Unlock()
+	return p.Piece.WriteAt(buf, off)
+}
+
+func (p countingPiece) ReadAt(buf []byte, off int64) (int, error) {
+	p.s.mu.Lock()
+	p.s.reads[p.index]++
+	p.s.mu.Unlock()
+	return p.Piece.ReadAt(buf, off)
+}
+
+// Торрент из numPieces частей по pieceLength байт, открытый через кэш размером size
+func openCached(t *testing.T, size int64, pieceLength, numPieces int) (*Cache, *countingStorage, Torrent, *Memory) {
+	t.Helper()
+	mem := NewMemory()
+	counting := newCountingStorage(mem)
+	cache := NewCache(size)
+	info := Info{Name: "t", PieceLength: pieceLength, Length: pieceLength * numPieces, NumPieces: numPieces}
+	tor, err := cache.Wrap(counting).OpenTorrent(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cache, counting, tor, mem
+}
+
+// Данные части index, отличающиеся от данных других частей
+func pieceData(index, length int) []byte {
+	return bytes.Repeat([]byte{byte(index + 1)}, length)
+}
+
+func TestCacheCoalescesBlocks(t *testing.T) {
+	const block = 16384
+	cache, counting, tor, _ := openCached(t, 1<<20, 4*block, 2)
+	piece := tor.Piece(0)
+	data := pieceData(0, 4*block)
+	for _, i := range []int{2, 0, 3, 1} { // Блоки приходят не по порядку
+		_, err := piece.WriteAt(data[i*block:(i+1)*block], int64(i*block))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := counting.count(counting.writes, 0); n != 0 {
+		t.Fatalf("%d writes reached storage before the piece was verified", n)
+	}
+	if stats := cache.Stats(); stats.Writes != 4 || stats.Dirty != 4*block {
+		t.Errorf("stats after blocks = %+v", stats)
+	}
+
+	_, err := piece.WriteAt(data, 0) // Проверенная часть целиком, как ее передает движок
+	if err == nil {
+		err = piece.MarkComplete()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := counting.count(counting.writes, 0); n != 1 {
+		t.Errorf("piece reached storage in %d writes, want 1", n)
+	}
+	if stats := cache.Stats(); stats.Dirty != 0 || stats.Flushes != 1 {
+		t.Errorf("stats after MarkComplete = %+v", stats)
+	}
+
+	got := make([]byte, len(data))
+	_, err = tor.Piece(0).ReadAt(got, 0)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Errorf("read back: %v", err)
+	}
+}
+
+func TestCacheWholePieceWrite(t *testing.T) {
+	cache, counting, tor, _ := openCached(t, 1<<20, 1000, 2)
+	_, err := tor.Piece(1).WriteAt(pieceData(1, 1000), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := counting.count(counting.writes, 1); n != 1 {
+		t.Errorf("whole piece written in %d writes, want 1", n)
+	}
+	if stats := cache.Stats(); stats.Dirty != 0 {
+		t.Errorf("whole piece left %d dirty bytes", stats.Dirty)
+	}
+}
+
+func TestCacheReadLRU(t *testing.T) {
+	const length = 1000
+	cache, counting, tor, _ := openCached(t, 2*length, length, 4)
+	for i := 0; i < 4; i++ {
+		piece := tor.Piece(i)
+		_, err := piece.WriteAt(pieceData(i, length), 0)
+		if err == nil {
+			err = piece.MarkComplete()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	read := func(index int) {
+		t.Helper()
+		buf := make([]byte, 10)
+		_, err := tor.Piece(index).ReadAt(buf, 500)
+		if err != nil || !bytes.Equal(buf, pieceData(index, 10)) {
+			t.Fatalf("read piece %d: %q, %v", index, buf, err)
+		}
+	}
+	read(0) // Промах
+	read(0) // Попадание
+	read(1) // Промах
+	read(0) // Попадание, 0 становится недавней
+	read(2) // Промах, вытесняет 1
+	read(0) // Попадание
+	read(1) // Промах
+	stats := cache.Stats()
+	if stats.ReadHits != 3 || stats.ReadMisses != 4 {
+		t.Errorf("hits %d, misses %d, want 3 and 4", stats.ReadHits, stats.ReadMisses)
+	}
+	if stats.Cached > 2*length {
+		t.Errorf("%d bytes cached, limit %d", stats.Cached, 2*length)
+	}
+	if n := counting.count(counting.reads, 0); n != 1 {
+		t.Errorf("piece 0 read from storage %d times, want 1", n)
+	}
+
+	// Запись в часть делает кэш чтения недействительным
+	_, err := tor.Piece(0).WriteAt([]byte("xx"), 500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 2)
+	_, err = tor.Piece(0).ReadAt(buf, 500)
+	if err != nil || string(buf) != "xx" {
+		t.Errorf("read after write = %q, %v", buf, err)
+	}
+}
+
+func TestCacheUnverifiedPiecesNotCached(t *testing.T) {
+	cache, _, tor, _ := openCached(t, 1<<20, 1000, 1)
+	_, err := tor.Piece(0).WriteAt(pieceData(0, 1000), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 10)
+	for i := 0; i < 2; i++ {
+		_, err = tor.Piece(0).ReadAt(buf, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if stats := cache.Stats(); stats.ReadHits != 0 || stats.Cached != 0 {
+		t.Errorf("unverified piece cached: %+v", stats)
+	}
+}
+
+func TestCacheEvictsDirtyPieces(t *testing.T) {
+	const length = 1000
+	cache, counting, tor, _ := openCached(t, 1500, length, 3)
+	_, err := tor.Piece(0).WriteAt(pieceData(0, 800), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = tor.Piece(1).WriteAt(pieceData(1, 800), 0) // Кэш переполнен, часть 0 записывается
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := counting.count(counting.writes, 0); n != 1 {
+		t.Errorf("piece 0 flushed in %d writes, want 1", n)
+	}
+	if stats := cache.Stats(); stats.Dirty > 1500 {
+		t.Errorf("%d dirty bytes, limit 1500", stats.Dirty)
+	}
+}
+
+func TestCacheCloseFlushes(t *testing.T) {
+	_, counting, tor, mem := openCached(t, 1<<20, 1000, 2)
+	_, err := tor.Piece(1).WriteAt([]byte("tail"), 996)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tor.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := counting.count(counting.writes, 1); n != 1 {
+		t.Fatalf("Close flushed piece in %d writes, want 1", n)
+	}
+	reopened, _ := mem.OpenTorrent(Info{Name: "t", PieceLength: 1000, Length: 2000, NumPieces: 2})
+	buf := make([]byte, 4)
+	_, err = reopened.Piece(1).ReadAt(buf, 996)
+	if err != nil || string(buf) != "tail" {
+		t.Errorf("data after Close = %q, %v", buf, err)
+	}
+}
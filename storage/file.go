@@ -0,0 +1,203 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Хранилище в обычных файлах: однофайловый торрент - один файл,
+// многофайловый - директория с файлами торрента
+type FileStorage struct {
+	Dir      string   // Директория, в которой данные торрента хранятся под его именем
+	Path     string   // Путь к данным торрента вместо Dir/<имя> (для одного торрента)
+	ReadOnly bool     // Файлы не создаются, запись возвращает ошибку
+	Prealloc Prealloc // Выделение места под файлы при открытии
+}
+
+// Хранилище в файлах внутри dir
+func NewFile(dir string) *FileStorage {
+	return &FileStorage{Dir: dir}
+}
+
+// Хранилище одного торрента по пути path
+func NewFileAt(path string) *FileStorage {
+	return &FileStorage{Path: path}
+}
+
+// Путь к данным торрента
+func (s *FileStorage) root(info Info) string {
+	if s.Path != "" {
+		return s.Path
+	}
+	return filepath.Join(s.Dir, info.Name)
+}
+
+// Удаление файла или директории с данными торрента
+func (s *FileStorage) Remove(info Info) error {
+	if s.ReadOnly {
+		return errors.New("Storage is read-only")
+	}
+	return os.RemoveAll(s.root(info))
+}
+
+// Открытие торрента. Сначала проверяется, что на диске хватит места для всех
+// файлов (иначе ошибка ErrNoSpace). Без Prealloc файлы создаются при первой
+// записи в них, а файлы нулевой длины - сразу
+func (s *FileStorage) OpenTorrent(info Info) (Torrent, error) {
+	root := s.root(info)
+	d := &fileData{readOnly: s.ReadOnly, handles: make(map[int]*os.File)}
+	if len(info.Files) == 0 {
+		d.files = []File{{Length: info.Length}}
+		d.paths = []string{root}
+	} else {
+		d.files = info.Files
+		for _, f := range info.Files {
+			d.paths = append(d.paths, filepath.Join(append([]string{root}, f.Path...)...))
+		}
+	}
+
+	if s.ReadOnly {
+		return newTorrent(info, d), nil
+	}
+
+	var paths []string
+	var lengths []int
+	for i, f := range d.files {
+		if !f.Padding {
+			paths = append(paths, d.paths[i])
+			lengths = append(lengths, f.Length)
+		}
+	}
+	err := checkSpace(paths, lengths)
+	if err != nil {
+		return nil, err
+	}
+	for i, f := range d.files {
+		if f.Padding || (f.Length > 0 && s.Prealloc == PreallocNone) {
+			continue
+		}
+		h, err := d.open(i, true)
+		if err == nil {
+			err = preallocate(h, int64(f.Length), s.Prealloc)
+		}
+		if err != nil {
+			d.Close()
+			return nil, err
+		}
+	}
+	return newTorrent(info, d), nil
+}
+
+// Поток данных, разбитый по файлам на диске
+type fileData struct {
+	files    []File
+	paths    []string
+	readOnly bool
+
+	mu      sync.Mutex
+	handles map[int]*os.File // Открытые файлы по номеру в files
+}
+
+// Открытие файла i. Отсутствующий файл создается только для записи
+func (d *fileData) open(i int, write bool) (*os.File, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if h, ok := d.handles[i]; ok {
+		return h, nil
+	}
+
+	flag := os.O_RDWR
+	switch {
+	case d.readOnly:
+		flag = os.O_RDONLY
+	case write:
+		flag |= os.O_CREATE
+		err := os.MkdirAll(filepath.Dir(d.paths[i]), 0o755)
+		if err != nil {
+			return nil, err
+		}
+	}
+	h, err := os.OpenFile(d.paths[i], flag, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	d.handles[i] = h
+	return h, nil
+}
+
+// Обход участков файлов, на которые приходится len(buf) байт со смещения off.
+// Файлы выравнивания передаются с номером -1
+func (d *fileData) forEachSpan(buf []byte, off int64, fn func(i int, offset int64, data []byte) error) (int, error) {
+	done := 0
+	for i, f := range d.files {
+		from := max(off, int64(f.Offset))
+		to := min(off+int64(len(buf)), int64(f.Offset+f.Length))
+		if from >= to {
+			continue
+		}
+		index := i
+		if f.Padding {
+			index = -1
+		}
+		err := fn(index, from-int64(f.Offset), buf[from-off:to-off])
+		if err != nil {
+			return done, err
+		}
+		done += int(to - from)
+	}
+	if done < len(buf) { // Запрошенный участок выходит за конец данных
+		return done, io.EOF
+	}
+	return done, nil
+}
+
+func (d *fileData) ReadAt(buf []byte, off int64) (int, error) {
+	return d.forEachSpan(buf, off, func(i int, offset int64, data []byte) error {
+		if i < 0 { // Файлы выравнивания заполнены нулями
+			clear(data)
+			return nil
+		}
+		h, err := d.open(i, false)
+		if err != nil {
+			return err
+		}
+		n, err := h.ReadAt(data, offset)
+		if n < len(data) && err == nil {
+			err = io.EOF
+		}
+		return err
+	})
+}
+
+func (d *fileData) WriteAt(buf []byte, off int64) (int, error) {
+	if d.readOnly {
+		return 0, errors.New("Storage is read-only")
+	}
+	return d.forEachSpan(buf, off, func(i int, offset int64, data []byte) error {
+		if i < 0 {
+			return nil
+		}
+		h, err := d.open(i, true)
+		if err != nil {
+			return err
+		}
+		_, err = h.WriteAt(data, offset)
+		return err
+	})
+}
+
+func (d *fileData) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var firstErr error
+	for i, h := range d.handles {
+		if err := h.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(d.handles, i)
+	}
+	return firstErr
+}
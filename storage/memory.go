@@ -0,0 +1,43 @@
+package storage
+
+import "sync"
+
+// Хранилище в памяти. Данные торрента сохраняются между закрытием и повторным
+// открытием, пока существует само хранилище
+type Memory struct {
+	mu       sync.Mutex
+	torrents map[[20]byte]*torrent
+}
+
+// Создание пустого хранилища в памяти
+func NewMemory() *Memory {
+	return &Memory{torrents: make(map[[20]byte]*torrent)}
+}
+
+func (m *Memory) OpenTorrent(info Info) (Torrent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if t, ok := m.torrents[info.InfoHash]; ok {
+		return t, nil
+	}
+	t := newTorrent(info, memoryData{make(byteData, info.Length)})
+	m.torrents[info.InfoHash] = t
+	return t, nil
+}
+
+// Освобождение данных торрента
+func (m *Memory) Remove(info Info) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.torrents, info.InfoHash)
+	return nil
+}
+
+// Данные в памяти, закрытие их не освобождает
+type memoryData struct {
+	byteData
+}
+
+func (memoryData) Close() error {
+	return nil
+}
@@ -0,0 +1,20 @@
+//go:build !unix
+
+package storage
+
+import "errors"
+
+// Хранилище однофайловых торрентов в файлах, отображенных в память.
+// На этой платформе не поддерживается
+type Mmap struct {
+	Dir string
+}
+
+// Хранилище с отображением в память файлов внутри dir
+func NewMmap(dir string) *Mmap {
+	return &Mmap{Dir: dir}
+}
+
+func (m *Mmap) OpenTorrent(info Info) (Torrent, error) {
+	return nil, errors.New("Mmap storage is not supported on this platform")
+}
@@ -0,0 +1,79 @@
+//go:build unix
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// Хранилище однофайловых торрентов в файлах, отображенных в память.
+// Запись части - копирование в память, сброс на диск выполняет ОС
+type Mmap struct {
+	Dir string // Директория, в которой файл торрента хранится под его именем
+}
+
+// Хранилище с отображением в память файлов внутри dir
+func NewMmap(dir string) *Mmap {
+	return &Mmap{Dir: dir}
+}
+
+func (m *Mmap) OpenTorrent(info Info) (Torrent, error) {
+	if len(info.Files) > 0 {
+		return nil, fmt.Errorf("Mmap storage supports only single-file torrents, %s has %d files", info.Name, len(info.Files))
+	}
+	if info.Length == 0 {
+		return newTorrent(info, memoryData{}), nil // Пустой файл отобразить нельзя
+	}
+
+	path := filepath.Join(m.Dir, info.Name)
+	err := checkSpace([]string{path}, []int{info.Length})
+	if err != nil {
+		return nil, err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	err = f.Truncate(int64(info.Length))
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	mem, err := syscall.Mmap(int(f.Fd()), 0, info.Length, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return newTorrent(info, &mmapData{byteData: mem, file: f}), nil
+}
+
+// Удаление файла торрента
+func (m *Mmap) Remove(info Info) error {
+	err := os.Remove(filepath.Join(m.Dir, info.Name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// Отображенный в память файл
+type mmapData struct {
+	byteData
+	file *os.File
+}
+
+func (d *mmapData) Close() error {
+	err := syscall.Munmap(d.byteData)
+	if closeErr := d.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,17 @@
+//go:build unix
+
+package storage
+
+import "testing"
+
+func TestMmapStorage(t *testing.T) {
+	testBackend(t, NewMmap(t.TempDir()))
+}
+
+func TestMmapRejectsMultiFile(t *testing.T) {
+	info, _ := testInfo("dir", 20)
+	info.Files = []File{{Path: []string{"a"}, Length: 10}, {Path: []string{"b"}, Length: 10, Offset: 10}}
+	if _, err := NewMmap(t.TempDir()).OpenTorrent(info); err == nil {
+		t.Error("multi-file torrent opened in mmap storage")
+	}
+}
@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// Ошибка нехватки места на диске: при открытии торрента или при записи
+var ErrNoSpace = errors.New("Not enough free disk space")
+
+// Нехватка места на диске, обнаруженная заранее или при записи (ENOSPC)
+func IsNoSpace(err error) bool {
+	return errors.Is(err, ErrNoSpace) || errors.Is(err, syscall.ENOSPC)
+}
+
+// Режим выделения места под файлы торрента
+type Prealloc int
+
+const (
+	// Файлы растут по мере записи частей
+	PreallocNone Prealloc = iota
+
+	// Файлы сразу получают полную длину, но место выделяется при записи (разреженные файлы)
+	PreallocSparse
+
+	// Место под файлы выделяется сразу (fallocate)
+	PreallocFull
+)
+
+func (p Prealloc) String() string {
+	switch p {
+	case PreallocNone:
+		return "none"
+	case PreallocSparse:
+		return "sparse"
+	case PreallocFull:
+		return "full"
+	}
+	return fmt.Sprintf("Prealloc(%d)", int(p))
+}
+
+// Разбор режима выделения места: none, sparse или full
+func ParsePrealloc(s string) (Prealloc, error) {
+	for _, p := range []Prealloc{PreallocNone, PreallocSparse, PreallocFull} {
+		if p.String() == s {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown preallocation mode %q (expected none, sparse or full)", s)
+}
+
+// Проверка, что для дописывания файлов paths до длин lengths хватит места.
+// Уже выделенное под файлы место не учитывается. Если свободное место
+// узнать нельзя, проверка пропускается
+func checkSpace(paths []string, lengths []int) error {
+	if len(paths) == 0 {
+		return nil
+	}
+	var need int64
+	for i, path := range paths {
+		var have int64
+		info, err := os.Stat(path)
+		if err == nil {
+			have = allocated(info)
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		need += max(0, int64(lengths[i])-have)
+	}
+	if need == 0 {
+		return nil
+	}
+
+	dir := existingDir(filepath.Dir(paths[0]))
+	free, err := freeSpace(dir)
+	if err != nil || free < 0 {
+		return err
+	}
+	if free < need {
+		return fmt.Errorf("%w: %d bytes needed in %s, %d available", ErrNoSpace, need, dir, free)
+	}
+	return nil
+}
+
+// Ближайшая существующая директория на пути к dir
+func existingDir(dir string) string {
+	for {
+		info, err := os.Stat(dir)
+		if err == nil && info.IsDir() {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return dir
+		}
+		dir = parent
+	}
+}
+
+// Выделение места под файл f длиной length в режиме mode. Файлы длиннее
+// length не укорачиваются
+func preallocate(f *os.File, length int64, mode Prealloc) error {
+	if mode == PreallocNone || length == 0 {
+		return nil
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if mode == PreallocFull {
+		return allocate(f, length)
+	}
+	if info.Size() < length {
+		return f.Truncate(length)
+	}
+	return nil
+}
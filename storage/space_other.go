@@ -0,0 +1,15 @@
+//go:build !(linux || darwin || freebsd)
+
+package storage
+
+import "os"
+
+// Свободное место на этой платформе не определяется (-1 - неизвестно)
+func freeSpace(dir string) (int64, error) {
+	return -1, nil
+}
+
+// Место, занятое файлом
+func allocated(info os.FileInfo) int64 {
+	return info.Size()
+}
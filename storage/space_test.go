@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestCheckSpace(t *testing.T) {
+	dir := t.TempDir()
+	if free, _ := freeSpace(dir); free < 0 {
+		t.Skip("free space is unknown on this platform")
+	}
+	path := filepath.Join(dir, "sub", "data.bin") // Директории еще нет
+	if err := checkSpace([]string{path}, []int{1}); err != nil {
+		t.Errorf("one byte: %v", err)
+	}
+	err := checkSpace([]string{path}, []int{math.MaxInt})
+	if !errors.Is(err, ErrNoSpace) || !IsNoSpace(err) {
+		t.Errorf("huge file: %v, want ErrNoSpace", err)
+	}
+
+	// Уже записанная часть файла не требует места повторно
+	written := filepath.Join(dir, "written.bin")
+	if err = os.WriteFile(written, make([]byte, 1<<16), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err = checkSpace([]string{written}, []int{1 << 16}); err != nil {
+		t.Errorf("existing file: %v", err)
+	}
+
+	info, _ := testInfo("huge.bin", 16)
+	info.Length = math.MaxInt
+	if _, err = NewFile(dir).OpenTorrent(info); !errors.Is(err, ErrNoSpace) {
+		t.Errorf("OpenTorrent: %v, want ErrNoSpace", err)
+	}
+}
+
+func TestIsNoSpace(t *testing.T) {
+	write := &os.PathError{Op: "write", Path: "data.bin", Err: syscall.ENOSPC}
+	if !IsNoSpace(write) || !IsNoSpace(fmt.Errorf("Couldnt write piece: %w", write)) {
+		t.Error("ENOSPC not recognized")
+	}
+	if IsNoSpace(&os.PathError{Op: "write", Path: "data.bin", Err: syscall.EIO}) || IsNoSpace(nil) {
+		t.Error("other error reported as no space")
+	}
+}
+
+// Размер и выделенное место файла после открытия торрента в режиме mode
+func openPrealloc(t *testing.T, mode Prealloc, length int) (os.FileInfo, error) {
+	t.Helper()
+	dir := t.TempDir()
+	info, _ := testInfo("data.bin", length)
+	s := NewFile(dir)
+	s.Prealloc = mode
+	tor, err := s.OpenTorrent(info)
+	if err != nil {
+		return nil, err
+	}
+	tor.Close()
+	return os.Stat(filepath.Join(dir, "data.bin"))
+}
+
+func TestPrealloc(t *testing.T) {
+	const length = 4 << 20
+
+	if st, err := openPrealloc(t, PreallocNone, length); err == nil {
+		t.Errorf("file of %d bytes created without preallocation", st.Size())
+	} else if !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+
+	st, err := openPrealloc(t, PreallocSparse, length)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Без statfs занятое место не отличить от размера файла
+	blocks, _ := freeSpace(t.TempDir())
+	if st.Size() != length || (blocks >= 0 && allocated(st) >= length) {
+		t.Errorf("sparse: size %d, allocated %d", st.Size(), allocated(st))
+	}
+
+	st, err = openPrealloc(t, PreallocFull, length)
+	if errors.Is(err, syscall.EOPNOTSUPP) {
+		t.Skip("fallocate is not supported by the file system")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Size() != length || allocated(st) < length {
+		t.Errorf("full: size %d, allocated %d", st.Size(), allocated(st))
+	}
+}
+
+func TestParsePrealloc(t *testing.T) {
+	for _, p := range []Prealloc{PreallocNone, PreallocSparse, PreallocFull} {
+		got, err := ParsePrealloc(p.String())
+		if err != nil || got != p {
+			t.Errorf("ParsePrealloc(%q) = %v, %v", p, got, err)
+		}
+	}
+	if _, err := ParsePrealloc("fast"); err == nil {
+		t.Error("unknown mode accepted")
+	}
+}
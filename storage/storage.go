@@ -0,0 +1,164 @@
+// Пакет storage: хранилища данных торрентов. Движок скачивания пишет
+// проверенные части через интерфейс Torrent, не зная, где лежат данные:
+// в обычных файлах (File), в памяти (Memory) или в отображенном в память файле (Mmap)
+package storage
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/swesdek/gotorrent-client/bitfields"
+)
+
+// Описание данных торрента, достаточное для их размещения
+type Info struct {
+	InfoHash     [20]byte
+	Name         string
+	PieceLength  int
+	Length       int    // Длина общего потока данных, включая файлы выравнивания
+	Files        []File // Файлы многофайлового торрента (nil - однофайловый)
+	NumPieces    int
+	PieceLengths []int // Длины частей, если они не равны PieceLength (части v2 выровнены по файлам)
+}
+
+// Длина части index
+func (info Info) pieceLength(index int) int {
+	if info.PieceLengths != nil {
+		return info.PieceLengths[index]
+	}
+	return min(info.PieceLength, info.Length-index*info.PieceLength)
+}
+
+// Файл многофайлового торрента
+type File struct {
+	Path    []string // Путь относительно корневой директории торрента
+	Length  int
+	Offset  int  // Смещение начала файла в общем потоке данных
+	Padding bool // Файл выравнивания (BEP 47), не хранится
+}
+
+// Хранилище, в котором открываются торренты. Реализация может выбирать
+// размещение данных для каждого торрента по его Info
+type Storage interface {
+	OpenTorrent(info Info) (Torrent, error)
+}
+
+// Хранилище, выбираемое для каждого торрента функцией
+type Selector func(info Info) Storage
+
+func (f Selector) OpenTorrent(info Info) (Torrent, error) {
+	return f(info).OpenTorrent(info)
+}
+
+func (f Selector) Remove(info Info) error {
+	return Remove(f(info), info)
+}
+
+// Хранилище, из которого можно удалить данные закрытого торрента
+type Remover interface {
+	Remove(info Info) error
+}
+
+// Удаление данных торрента из хранилища s. Хранилище без Remover возвращает ошибку
+func Remove(s Storage, info Info) error {
+	r, ok := s.(Remover)
+	if !ok {
+		return fmt.Errorf("Storage %T cannot delete torrent data", s)
+	}
+	return r.Remove(info)
+}
+
+// Данные одного открытого торрента
+type Torrent interface {
+	Piece(index int) Piece
+	Close() error
+}
+
+// Часть торрента. Смещения ReadAt и WriteAt отсчитываются от начала части
+type Piece interface {
+	io.ReaderAt
+	io.WriterAt
+	MarkComplete() error // Отметка части, прошедшей проверку
+	Completed() bool
+}
+
+// Данные торрента как единый поток байт
+type data interface {
+	io.ReaderAt
+	io.WriterAt
+	io.Closer
+}
+
+// Торрент поверх потока данных с отметками о проверенных частях в памяти
+type torrent struct {
+	info Info
+	data data
+
+	mu        sync.Mutex
+	completed bitfields.Bitfield
+}
+
+func newTorrent(info Info, d data) *torrent {
+	return &torrent{info: info, data: d, completed: bitfields.New(info.NumPieces)}
+}
+
+func (t *torrent) Piece(index int) Piece {
+	return &piece{t: t, index: index, begin: int64(index) * int64(t.info.PieceLength)}
+}
+
+func (t *torrent) Close() error {
+	return t.data.Close()
+}
+
+// Часть торрента, отображенная на участок потока данных
+type piece struct {
+	t     *torrent
+	index int
+	begin int64 // Смещение части в потоке данных
+}
+
+func (p *piece) ReadAt(buf []byte, off int64) (int, error) {
+	return p.t.data.ReadAt(buf, p.begin+off)
+}
+
+func (p *piece) WriteAt(buf []byte, off int64) (int, error) {
+	return p.t.data.WriteAt(buf, p.begin+off)
+}
+
+func (p *piece) MarkComplete() error {
+	if p.index < 0 || p.index >= p.t.info.NumPieces {
+		return fmt.Errorf("Piece index %d out of range", p.index)
+	}
+	p.t.mu.Lock()
+	defer p.t.mu.Unlock()
+	p.t.completed.SetPiece(p.index)
+	return nil
+}
+
+func (p *piece) Completed() bool {
+	p.t.mu.Lock()
+	defer p.t.mu.Unlock()
+	return p.t.completed.HasPiece(p.index)
+}
+
+// Поток данных в срезе байт
+type byteData []byte
+
+func (b byteData) ReadAt(buf []byte, off int64) (int, error) {
+	if off < 0 || off > int64(len(b)) {
+		return 0, io.EOF
+	}
+	n := copy(buf, b[off:])
+	if n < len(buf) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (b byteData) WriteAt(buf []byte, off int64) (int, error) {
+	if off < 0 || off+int64(len(buf)) > int64(len(b)) {
+		return 0, fmt.Errorf("Write of %d bytes at offset %d is past the end of torrent data", len(buf), off)
+	}
+	return copy(b[off:], buf), nil
+}
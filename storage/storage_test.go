@@ -0,0 +1,173 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Данные торрента и описание с частями по 16 байт
+func testInfo(name string, length int) (Info, []byte) {
+	data := make([]byte, length)
+	for i := range data {
+		data[i] = byte('a' + i%26)
+	}
+	info := Info{InfoHash: [20]byte{byte(length)}, Name: name, PieceLength: 16, Length: length}
+	info.NumPieces = (length + info.PieceLength - 1) / info.PieceLength
+	return info, data
+}
+
+// Запись всех частей, чтение их обратно и отметка проверенных
+func writePieces(t *testing.T, tor Torrent, info Info, data []byte) {
+	t.Helper()
+	for index := 0; index < info.NumPieces; index++ {
+		piece := data[index*info.PieceLength : min((index+1)*info.PieceLength, len(data))]
+		_, err := tor.Piece(index).WriteAt(piece, 0)
+		if err != nil {
+			t.Fatalf("write piece %d: %v", index, err)
+		}
+		if err = tor.Piece(index).MarkComplete(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// Проверка данных всех частей
+func checkPieces(t *testing.T, tor Torrent, info Info, data []byte) {
+	t.Helper()
+	for index := 0; index < info.NumPieces; index++ {
+		want := data[index*info.PieceLength : min((index+1)*info.PieceLength, len(data))]
+		got := make([]byte, len(want))
+		_, err := tor.Piece(index).ReadAt(got, 0)
+		if err != nil && err != io.EOF {
+			t.Fatalf("read piece %d: %v", index, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("piece %d = %q, want %q", index, got, want)
+		}
+	}
+}
+
+// Запись, чтение, повторное открытие и удаление однофайлового торрента в хранилище s
+func testBackend(t *testing.T, s Storage) {
+	t.Helper()
+	info, data := testInfo("data.bin", 100) // Последняя часть короче остальных
+	tor, err := s.OpenTorrent(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePieces(t, tor, info, data)
+	checkPieces(t, tor, info, data)
+	if !tor.Piece(6).Completed() || tor.Piece(7).MarkComplete() == nil {
+		t.Error("piece marks out of range")
+	}
+	if _, err = tor.Piece(6).WriteAt(append(data[96:], make([]byte, 12)...), 0); err == nil {
+		t.Error("write past the end of data accepted")
+	}
+	if err = tor.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Данные сохраняются после закрытия
+	tor, err = s.OpenTorrent(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPieces(t, tor, info, data)
+	tor.Close()
+
+	if err = Remove(s, info); err != nil {
+		t.Fatal(err)
+	}
+	tor, err = s.OpenTorrent(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make([]byte, 4)
+	tor.Piece(0).ReadAt(got, 0)
+	if bytes.Equal(got, data[:4]) {
+		t.Error("data left after Remove")
+	}
+	tor.Close()
+}
+
+func TestFileStorage(t *testing.T) {
+	testBackend(t, NewFile(t.TempDir()))
+}
+
+func TestMemoryStorage(t *testing.T) {
+	testBackend(t, NewMemory())
+}
+
+func TestFileStorageMultiFile(t *testing.T) {
+	dir := t.TempDir()
+	info, data := testInfo("dir", 60)
+	info.Files = []File{
+		{Path: []string{"a.txt"}, Length: 10, Offset: 0},
+		{Path: []string{".pad", "6"}, Length: 6, Offset: 10, Padding: true},
+		{Path: []string{"sub", "b.txt"}, Length: 40, Offset: 16},
+		{Path: []string{"empty"}, Length: 0, Offset: 56},
+		{Path: []string{"c.txt"}, Length: 4, Offset: 56},
+	}
+	copy(data[10:16], make([]byte, 6)) // Файл выравнивания читается нулями
+
+	s := NewFile(dir)
+	tor, err := s.OpenTorrent(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "dir", "empty")); err != nil {
+		t.Errorf("empty file not created: %v", err)
+	}
+	writePieces(t, tor, info, data)
+	checkPieces(t, tor, info, data)
+	tor.Close()
+
+	for path, want := range map[string][]byte{"a.txt": data[:10], "sub/b.txt": data[16:56], "c.txt": data[56:]} {
+		got, err := os.ReadFile(filepath.Join(dir, "dir", filepath.FromSlash(path)))
+		if err != nil || !bytes.Equal(got, want) {
+			t.Errorf("%s = %q, %v; want %q", path, got, err, want)
+		}
+	}
+	if _, err = os.Stat(filepath.Join(dir, "dir", ".pad")); !os.IsNotExist(err) {
+		t.Errorf("padding file stored: %v", err)
+	}
+
+	ro := &FileStorage{Dir: dir, ReadOnly: true}
+	tor, err = ro.OpenTorrent(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tor.Close()
+	checkPieces(t, tor, info, data)
+	if _, err = tor.Piece(0).WriteAt([]byte("x"), 0); err == nil {
+		t.Error("write to read-only storage accepted")
+	}
+	if err = Remove(ro, info); err == nil {
+		t.Error("read-only storage removed data")
+	}
+}
+
+func TestSelector(t *testing.T) {
+	small, large := NewMemory(), NewMemory()
+	s := Selector(func(info Info) Storage {
+		if info.Length < 50 {
+			return small
+		}
+		return large
+	})
+	info, data := testInfo("small", 20)
+	tor, err := s.OpenTorrent(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePieces(t, tor, info, data)
+	if len(small.torrents) != 1 || len(large.torrents) != 0 {
+		t.Errorf("torrent placed in the wrong storage")
+	}
+	if err = s.Remove(info); err != nil || len(small.torrents) != 0 {
+		t.Errorf("Remove through selector: %v", err)
+	}
+}
@@ -0,0 +1,251 @@
+package torrentfile
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/swesdek/gotorrent-client/bencode"
+)
+
+// Границы автоматически выбираемой длины части
+const (
+	minPieceLength   = 16 * 1024
+	maxPieceLength   = 16 * 1024 * 1024
+	targetPieceCount = 1500 // Желаемое количество частей в торренте
+)
+
+// Параметры создания .torrent файла
+type CreateOptions struct {
+	Path         string     // Файл или директория с данными
+	Announce     string     // Основной трекер
+	AnnounceList [][]string // Уровни трекеров (BEP 12)
+	Comment      string
+	CreatedBy    string
+	CreationDate time.Time // Нулевое значение - текущее время
+	PieceLength  int       // 0 - длина выбирается автоматически
+	Private      bool      // Приватный торрент (BEP 27)
+	WebSeeds     []string  // Ссылки на веб-сиды (BEP 19)
+}
+
+// Метаданные создаваемого торрента
+type bencodeCreatedTorrent struct {
+	Announce     string      `bencode:"announce,omitempty"`
+	AnnounceList [][]string  `bencode:"announce-list,omitempty"`
+	Comment      string      `bencode:"comment,omitempty"`
+	CreatedBy    string      `bencode:"created by,omitempty"`
+	CreationDate int64       `bencode:"creation date,omitempty"`
+	URLList      []string    `bencode:"url-list,omitempty"`
+	Info         bencodeInfo `bencode:"info"`
+}
+
+// Автоматический выбор длины части: степень двойки, дающая около targetPieceCount частей
+func choosePieceLength(total int) int {
+	length := minPieceLength
+	for length < maxPieceLength && total/length > targetPieceCount {
+		length *= 2
+	}
+	return length
+}
+
+// Сбор списка файлов в директории root в лексикографическом порядке.
+// Файл skip (nil - не задан) пропускается
+func collectFiles(root string, skip fs.FileInfo) ([]File, error) {
+	var files []File
+	offset := 0
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() { // Директории и символические ссылки пропускаются
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if skip != nil && os.SameFile(info, skip) { // Создаваемый .torrent файл
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, File{
+			Path:   strings.Split(filepath.ToSlash(rel), "/"),
+			Length: int(info.Size()),
+			Offset: offset,
+		})
+		offset += int(info.Size())
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("Directory %s contains no files", root)
+	}
+	return files, nil
+}
+
+// Параллельное вычисление хешей частей данных
+func hashPieces(r io.ReaderAt, total, pieceLength int) ([]byte, error) {
+	numPieces := (total + pieceLength - 1) / pieceLength
+	hashes := make([]byte, numPieces*20)
+
+	indexes := make(chan int, numPieces)
+	for i := 0; i < numPieces; i++ {
+		indexes <- i
+	}
+	close(indexes)
+
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+	for w := 0; w < runtime.NumCPU(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, pieceLength)
+			for i := range indexes {
+				length := min(pieceLength, total-i*pieceLength)
+				_, err := r.ReadAt(buf[:length], int64(i*pieceLength))
+				if err != nil {
+					once.Do(func() { firstErr = err })
+					return
+				}
+				h := sha1.Sum(buf[:length])
+				copy(hashes[i*20:], h[:])
+			}
+		}()
+	}
+	wg.Wait()
+
+	return hashes, firstErr
+}
+
+// Создание метаданных торрента и запись их в w
+func Create(opts CreateOptions, w io.Writer) (TorrentFile, error) {
+	return create(opts, w, nil)
+}
+
+// Создание метаданных торрента без файла skip (nil - не задан) в данных
+func create(opts CreateOptions, w io.Writer, skip fs.FileInfo) (TorrentFile, error) {
+	path, err := filepath.Abs(opts.Path) // Имя берется и у путей вида "." и ".."
+	if err != nil {
+		return TorrentFile{}, err
+	}
+	stat, err := os.Stat(path)
+	if err != nil {
+		return TorrentFile{}, err
+	}
+
+	info := bencodeInfo{Name: filepath.Base(path)}
+	var files []File
+	if stat.IsDir() {
+		files, err = collectFiles(path, skip)
+		if err != nil {
+			return TorrentFile{}, err
+		}
+		for _, f := range files {
+			info.Files = append(info.Files, bencodeFile{Length: f.Length, Path: f.Path})
+			info.Length += f.Length
+		}
+	} else {
+		files = []File{{Length: int(stat.Size())}} // Путь пустой: файл и есть opts.Path
+		info.Length = int(stat.Size())
+	}
+	total := info.Length
+	if total == 0 {
+		return TorrentFile{}, fmt.Errorf("Cannot create torrent from empty data")
+	}
+
+	info.PieceLength = opts.PieceLength
+	if info.PieceLength == 0 {
+		info.PieceLength = choosePieceLength(total)
+	}
+	if info.PieceLength < minPieceLength || info.PieceLength&(info.PieceLength-1) != 0 {
+		return TorrentFile{}, fmt.Errorf("Piece length must be a power of two >= %d, got %d", minPieceLength, info.PieceLength)
+	}
+	if opts.Private {
+		info.Private = 1
+	}
+
+	r, err := openFiles(path, files)
+	if err != nil {
+		return TorrentFile{}, err
+	}
+	defer r.Close()
+	hashes, err := hashPieces(r, total, info.PieceLength)
+	if err != nil {
+		return TorrentFile{}, err
+	}
+	info.Pieces = string(hashes)
+	if stat.IsDir() {
+		info.Length = 0 // В многофайловом торренте длина задается для каждого файла
+	}
+
+	created := opts.CreationDate
+	if created.IsZero() {
+		created = time.Now()
+	}
+	bto := bencodeCreatedTorrent{
+		Announce:     opts.Announce,
+		AnnounceList: opts.AnnounceList,
+		Comment:      opts.Comment,
+		CreatedBy:    opts.CreatedBy,
+		CreationDate: created.Unix(),
+		URLList:      opts.WebSeeds,
+		Info:         info,
+	}
+	if bto.Announce == "" && len(bto.AnnounceList) > 0 && len(bto.AnnounceList[0]) > 0 {
+		bto.Announce = bto.AnnounceList[0][0] // Для клиентов без поддержки announce-list
+	}
+
+	data, err := bencode.Marshal(bto)
+	if err != nil {
+		return TorrentFile{}, err
+	}
+	t, err := Parse(data)
+	if err != nil {
+		return TorrentFile{}, err
+	}
+	_, err = w.Write(data)
+	return t, err
+}
+
+// Создание .torrent файла по пути outPath. Если outPath находится в
+// директории с данными, он не включается в торрент
+func CreateFile(opts CreateOptions, outPath string) (TorrentFile, error) {
+	if out, err := os.Stat(outPath); err == nil {
+		data, err := os.Stat(opts.Path)
+		if err == nil && os.SameFile(out, data) {
+			return TorrentFile{}, fmt.Errorf("Output file %s is the data of the torrent", outPath)
+		}
+	}
+	file, err := os.Create(outPath)
+	if err != nil {
+		return TorrentFile{}, err
+	}
+	skip, err := file.Stat()
+	if err != nil {
+		file.Close()
+		os.Remove(outPath)
+		return TorrentFile{}, err
+	}
+
+	t, err := create(opts, file, skip)
+	if err != nil {
+		file.Close()
+		os.Remove(outPath)
+		return TorrentFile{}, err
+	}
+	return t, file.Close()
+}
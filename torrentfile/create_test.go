@@ -0,0 +1,126 @@
+package torrentfile
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Директория dir с файлами заданных длин
+func writeFiles(t *testing.T, dir string, lengths map[string]int) {
+	t.Helper()
+	for name, length := range lengths {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		err := os.MkdirAll(filepath.Dir(path), 0o755)
+		if err == nil {
+			err = os.WriteFile(path, bytes.Repeat([]byte(name[:1]), length), 0o644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCreateRoundTrip(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "data")
+	writeFiles(t, dir, map[string]int{"a.bin": 20000, "sub/b.bin": 5, "sub/c.bin": 40000})
+
+	var buf bytes.Buffer
+	opts := CreateOptions{
+		Path:         dir,
+		AnnounceList: [][]string{{"http://t1/announce"}, {"udp://t2:80"}},
+		Comment:      "test",
+		CreationDate: time.Unix(1700000000, 0),
+		WebSeeds:     []string{"http://seed/"},
+		Private:      true,
+	}
+	created, err := Create(opts, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tf, err := Parse(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(tf, created) {
+		t.Errorf("parsed torrent differs from the created one")
+	}
+	if tf.Name != "data" || tf.Length != 60005 || len(tf.Files) != 3 || !tf.Private {
+		t.Errorf("got name %q, length %d, %d files, private %v", tf.Name, tf.Length, len(tf.Files), tf.Private)
+	}
+	if tf.Announce != "http://t1/announce" || !reflect.DeepEqual(tf.Trackers(), opts.AnnounceList) {
+		t.Errorf("trackers %q, %q", tf.Announce, tf.Trackers())
+	}
+
+	report, err := tf.Verify(context.Background(), dir, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() {
+		t.Errorf("created data does not verify: bad pieces %v", report.BadPieces())
+	}
+
+	err = os.WriteFile(filepath.Join(dir, "sub", "c.bin"), bytes.Repeat([]byte("x"), 40000), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err = tf.Verify(context.Background(), dir, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.OK() {
+		t.Error("changed data passed verification")
+	}
+}
+
+func TestCreateDotPath(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "project")
+	writeFiles(t, dir, map[string]int{"file.txt": 100})
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	tf, err := Create(CreateOptions{Path: "."}, new(bytes.Buffer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tf.Name != "project" {
+		t.Errorf("name %q, want %q", tf.Name, "project")
+	}
+}
+
+func TestCreateFileSkipsOutput(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]int{"a.bin": 1000})
+	out := filepath.Join(dir, "out.torrent")
+
+	tf, err := CreateFile(CreateOptions{Path: dir}, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tf.Files) != 1 || tf.Files[0].Path[0] != "a.bin" {
+		t.Errorf("files %+v, want only a.bin", tf.Files)
+	}
+	if _, err = Open(out); err != nil {
+		t.Errorf("written file: %v", err)
+	}
+
+	// Запись .torrent файла поверх данных уничтожила бы их
+	_, err = CreateFile(CreateOptions{Path: filepath.Join(dir, "a.bin")}, filepath.Join(dir, "a.bin"))
+	if err == nil {
+		t.Error("data file overwritten by the torrent")
+	}
+	if info, _ := os.Stat(filepath.Join(dir, "a.bin")); info == nil || info.Size() != 1000 {
+		t.Error("data file changed")
+	}
+}
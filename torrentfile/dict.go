@@ -0,0 +1,60 @@
+package torrentfile
+
+// Словарь с проверкой типов значений при извлечении
+type dict map[string]interface{}
+
+// Строковое значение ключа
+func (d dict) str(key string) (string, bool) {
+	s, ok := d[key].(string)
+	return s, ok
+}
+
+// Целочисленное значение ключа
+func (d dict) int(key string) (int, bool) {
+	switch v := d[key].(type) {
+	case int64:
+		return int(v), true
+	}
+	return 0, false
+}
+
+// Вложенный словарь
+func (d dict) dict(key string) (dict, bool) {
+	m, ok := d[key].(map[string]interface{})
+	return dict(m), ok
+}
+
+// Список строк. Нестроковые элементы пропускаются
+func (d dict) strings(key string) []string {
+	return stringsOf(d[key])
+}
+
+// Строки из списка value
+func stringsOf(value interface{}) []string {
+	list, _ := value.([]interface{})
+	var res []string
+	for _, item := range list {
+		if s, ok := item.(string); ok {
+			res = append(res, s)
+		}
+	}
+	return res
+}
+
+// Ключи, не входящие в known
+func (d dict) unknown(known ...string) map[string]interface{} {
+	res := make(map[string]interface{})
+	for key, value := range d {
+		isKnown := false
+		for _, k := range known {
+			if key == k {
+				isKnown = true
+				break
+			}
+		}
+		if !isKnown {
+			res[key] = value
+		}
+	}
+	return res
+}
@@ -0,0 +1,87 @@
+package torrentfile
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Файл внутри многофайлового торрента
+type File struct {
+	Path       []string // Путь относительно корневой директории торрента
+	Length     int
+	Offset     int      // Смещение начала файла в общем потоке данных торрента
+	Padding    bool     // Файл выравнивания (BEP 47), не сохраняется на диск
+	PiecesRoot [32]byte // Корень дерева Меркла файла (BitTorrent v2)
+}
+
+// Описание файла в словаре info
+type bencodeFile struct {
+	Length int      `bencode:"length"`
+	Path   []string `bencode:"path"`
+	Attr   string   `bencode:"attr,omitempty"` // Атрибуты файла, "p" - файл выравнивания
+}
+
+// Путь к файлу на диске при корневой директории root
+func (f *File) DiskPath(root string) string {
+	return filepath.Join(append([]string{root}, f.Path...)...)
+}
+
+// Чтение данных торрента, разбитых по нескольким файлам, как единого потока
+type multiFileReader struct {
+	files   []File
+	handles []*os.File
+}
+
+// Открытие всех файлов торрента на чтение
+func openFiles(root string, files []File) (*multiFileReader, error) {
+	r := &multiFileReader{files: files}
+	for i := range files {
+		f, err := os.Open(files[i].DiskPath(root))
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+		r.handles = append(r.handles, f)
+	}
+	return r, nil
+}
+
+// Считывание len(buf) байт начиная со смещения off в общем потоке
+func (r *multiFileReader) ReadAt(buf []byte, off int64) (int, error) {
+	read := 0
+	for i, f := range r.files {
+		if read == len(buf) {
+			break
+		}
+		cur := off + int64(read) // Текущая позиция в общем потоке
+		start, end := int64(f.Offset), int64(f.Offset+f.Length)
+		if cur < start || cur >= end {
+			continue
+		}
+		want := min(int64(len(buf)-read), end-cur)
+		n, err := r.handles[i].ReadAt(buf[read:read+int(want)], cur-start)
+		read += n
+		if int64(n) < want { // Файл на диске короче, чем указано в торренте
+			if err == nil || err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return read, err
+		}
+	}
+	if read < len(buf) {
+		return read, io.EOF
+	}
+	return read, nil
+}
+
+// Закрытие всех файлов
+func (r *multiFileReader) Close() error {
+	var firstErr error
+	for _, f := range r.handles {
+		if err := f.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,22 @@
+package torrentfile
+
+import (
+	"github.com/swesdek/gotorrent-client/magnet"
+)
+
+// Magnet-ссылка на торрент
+func (t *TorrentFile) Magnet() string {
+	m := magnet.Magnet{
+		HasInfoHash:   len(t.PieceHashes) > 0, // У торрента только v2 хеш v1 не используется
+		InfoHash:      t.InfoHash,
+		HasInfoHashV2: t.MetaVersion == 2,
+		InfoHashV2:    t.InfoHashV2,
+		Name:          t.Name,
+		Length:        int64(t.Length),
+		WebSeeds:      t.WebSeeds,
+	}
+	for _, tier := range t.Trackers() {
+		m.Trackers = append(m.Trackers, tier...)
+	}
+	return m.String()
+}
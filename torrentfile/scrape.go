@@ -0,0 +1,130 @@
+package torrentfile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/swesdek/gotorrent-client/bencode"
+	"github.com/swesdek/gotorrent-client/proxy"
+)
+
+// Статистика торрента на трекере
+type ScrapeResult struct {
+	Seeders   int // Пиры со всеми частями
+	Leechers  int // Пиры, которые еще скачивают
+	Completed int // Сколько раз торрент был скачан полностью
+}
+
+// Статистика торрента в ответе HTTP трекера
+type bencodeScrapeFile struct {
+	Complete   int `bencode:"complete"`
+	Incomplete int `bencode:"incomplete"`
+	Downloaded int `bencode:"downloaded"`
+}
+
+// Ответ HTTP трекера на scrape запрос
+type bencodeScrapeResp struct {
+	Files         map[string]bencodeScrapeFile `bencode:"files"` // Ключ - info hash в двоичном виде
+	FailureReason string                       `bencode:"failure reason"`
+}
+
+// Ссылка на scrape по ссылке announce: последний элемент пути,
+// начинающийся с "announce", заменяется на "scrape"
+func scrapeURL(announce string) (*url.URL, error) {
+	u, err := url.Parse(announce)
+	if err != nil {
+		return nil, err
+	}
+	slash := strings.LastIndex(u.Path, "/")
+	if !strings.HasPrefix(u.Path[slash+1:], "announce") {
+		return nil, fmt.Errorf("Tracker does not support scrape")
+	}
+	u.Path = u.Path[:slash+1] + "scrape" + strings.TrimPrefix(u.Path[slash+1:], "announce")
+	return u, nil
+}
+
+// Запрос статистики торрента у трекера
+func (t *TorrentFile) Scrape(opts TrackerOptions) (ScrapeResult, error) {
+	res, err := t.scrape(opts)
+	if err != nil {
+		return ScrapeResult{}, &TrackerError{URL: t.Announce, Err: err}
+	}
+	return res, nil
+}
+
+func (t *TorrentFile) scrape(opts TrackerOptions) (ScrapeResult, error) {
+	announce, err := url.Parse(t.Announce)
+	if err != nil {
+		return ScrapeResult{}, err
+	}
+	if announce.Scheme == "udp" {
+		return t.scrapeUDP(announce, opts.Proxy)
+	}
+
+	u, err := scrapeURL(t.Announce)
+	if err != nil {
+		return ScrapeResult{}, err
+	}
+	params := u.Query()
+	params.Set("info_hash", string(t.InfoHash[:]))
+	u.RawQuery = params.Encode()
+
+	res, err := opts.httpClient().Get(u.String())
+	if err != nil {
+		return ScrapeResult{}, err
+	}
+	defer res.Body.Close()
+
+	scrapeRes := bencodeScrapeResp{}
+	err = bencode.NewDecoder(res.Body).Decode(&scrapeRes)
+	if err != nil {
+		return ScrapeResult{}, err
+	}
+	if scrapeRes.FailureReason != "" {
+		return ScrapeResult{}, fmt.Errorf("Tracker returned error: %s", scrapeRes.FailureReason)
+	}
+	file, ok := scrapeRes.Files[string(t.InfoHash[:])]
+	if !ok {
+		return ScrapeResult{}, fmt.Errorf("Tracker has no statistics for %x", t.InfoHash)
+	}
+	return ScrapeResult{Seeders: file.Complete, Leechers: file.Incomplete, Completed: file.Downloaded}, nil
+}
+
+// Запрос статистики торрента у UDP трекера
+func (t *TorrentFile) scrapeUDP(u *url.URL, p *proxy.Proxy) (ScrapeResult, error) {
+	pc, addr, err := openUDPTracker(u, p)
+	if err != nil {
+		return ScrapeResult{}, err
+	}
+	defer pc.Close()
+
+	connectionID, err := udpConnect(pc, addr)
+	if err != nil {
+		return ScrapeResult{}, err
+	}
+	tid, err := newTransactionID()
+	if err != nil {
+		return ScrapeResult{}, err
+	}
+	var req bytes.Buffer
+	req.Write(connectionID)
+	binary.Write(&req, binary.BigEndian, udpActionScrape)
+	req.Write(tid)
+	req.Write(t.InfoHash[:])
+
+	res, err := udpRoundTrip(pc, addr, req.Bytes(), udpActionScrape)
+	if err != nil {
+		return ScrapeResult{}, err
+	}
+	if len(res) < 20 {
+		return ScrapeResult{}, fmt.Errorf("Scrape response is too short: %d", len(res))
+	}
+	return ScrapeResult{
+		Seeders:   int(binary.BigEndian.Uint32(res[8:12])),
+		Completed: int(binary.BigEndian.Uint32(res[12:16])),
+		Leechers:  int(binary.BigEndian.Uint32(res[16:20])),
+	}, nil
+}
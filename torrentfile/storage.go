@@ -0,0 +1,116 @@
+package torrentfile
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+
+	"github.com/swesdek/gotorrent-client/bitfields"
+	"github.com/swesdek/gotorrent-client/merkle"
+	"github.com/swesdek/gotorrent-client/storage"
+)
+
+// Смещение и длина части в общем потоке данных торрента
+func (t *TorrentFile) PieceSpan(index int) (int, int) {
+	begin := index * t.PieceLength
+	if len(t.PiecesV2) > 0 { // Части v2 выровнены по файлам
+		return begin, t.PiecesV2[index].Length
+	}
+	return begin, min(t.PieceLength, t.Length-begin)
+}
+
+// Файлы торрента, хранящиеся на диске. Для однофайлового торрента путь - сам path
+func (t *TorrentFile) diskFiles(path string) ([]File, []string) {
+	if len(t.Files) == 0 {
+		return []File{{Length: t.Length}}, []string{path}
+	}
+	var files []File
+	var paths []string
+	for _, f := range t.Files {
+		if !f.Padding { // Файлы выравнивания на диск не записываются
+			files = append(files, f)
+			paths = append(paths, f.DiskPath(path))
+		}
+	}
+	return files, paths
+}
+
+// Номера файлов в Files, данные которых попадают в часть index.
+// Файлы выравнивания не учитываются; для однофайлового торрента - nil
+func (t *TorrentFile) PieceFiles(index int) []int {
+	begin, length := t.PieceSpan(index)
+	var files []int
+	for i, f := range t.Files {
+		if !f.Padding && max(begin, f.Offset) < min(begin+length, f.Offset+f.Length) {
+			files = append(files, i)
+		}
+	}
+	return files
+}
+
+// Описание данных торрента для хранилища
+func (t *TorrentFile) StorageInfo() storage.Info {
+	info := storage.Info{
+		InfoHash:    t.InfoHash,
+		Name:        t.Name,
+		PieceLength: t.PieceLength,
+		Length:      t.Length,
+		NumPieces:   t.NumPieces(),
+	}
+	for _, f := range t.Files {
+		info.Files = append(info.Files, storage.File{Path: f.Path, Length: f.Length, Offset: f.Offset, Padding: f.Padding})
+	}
+	for _, p := range t.PiecesV2 {
+		info.PieceLengths = append(info.PieceLengths, p.Length)
+	}
+	return info
+}
+
+// Чтение части index из хранилища
+func (t *TorrentFile) readPiece(st storage.Torrent, index int) ([]byte, error) {
+	_, length := t.PieceSpan(index)
+	buf := make([]byte, length)
+	_, err := st.Piece(index).ReadAt(buf, 0)
+	return buf, err
+}
+
+// Проверка данных части по хешу из метаданных
+func (t *TorrentFile) VerifyPiece(index int, buf []byte) bool {
+	if len(t.PiecesV2) > 0 {
+		return merkle.DataRoot(buf, t.PiecesV2[index].Leaves) == t.PiecesV2[index].Root
+	}
+	hash := sha1.Sum(buf)
+	return bytes.Equal(hash[:], t.PieceHashes[index][:])
+}
+
+// Проверка данных на диске. Возвращает поле с частями, прошедшими проверку;
+// отсутствующие и недописанные файлы не считаются ошибкой
+func (t *TorrentFile) Check(ctx context.Context, path string) (bitfields.Bitfield, error) {
+	st, err := (&storage.FileStorage{Path: path, ReadOnly: true}).OpenTorrent(t.StorageInfo())
+	if err != nil {
+		return nil, err
+	}
+	defer st.Close()
+	return t.CheckStorage(ctx, st)
+}
+
+// Проверка данных в хранилище. Части, уже отмеченные в хранилище как
+// проверенные, не перечитываются; прошедшие проверку отмечаются
+func (t *TorrentFile) CheckStorage(ctx context.Context, st storage.Torrent) (bitfields.Bitfield, error) {
+	statuses, err := t.verifyPieces(ctx, st, 0)
+	if err != nil {
+		return nil, err
+	}
+	have := bitfields.New(t.NumPieces())
+	for index, status := range statuses {
+		if status != PieceOK {
+			continue
+		}
+		have.SetPiece(index)
+		err = st.Piece(index).MarkComplete()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return have, nil
+}
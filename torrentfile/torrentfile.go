@@ -1,113 +1,356 @@
 package torrentfile
 
 import (
-	"bytes"
+	"context"
 	"crypto/sha1"
 	"fmt"
 	"math/rand"
 	"os"
+	"strings"
+	"time"
 
-	"github.com/jackpal/bencode-go"
+	"github.com/schollz/progressbar/v3"
+	"github.com/swesdek/gotorrent-client/banlist"
+	"github.com/swesdek/gotorrent-client/bencode"
+	"github.com/swesdek/gotorrent-client/bitfields"
+	"github.com/swesdek/gotorrent-client/client"
 	"github.com/swesdek/gotorrent-client/download"
+	"github.com/swesdek/gotorrent-client/mse"
+	"github.com/swesdek/gotorrent-client/peers"
+	"github.com/swesdek/gotorrent-client/proxy"
+	"github.com/swesdek/gotorrent-client/storage"
 )
 
 // Порт клиента
 const Port uint16 = 5919
 
+// Режим шифрования соединений с пирами
+const Encryption = mse.PolicyPreferred
+
+// Транспорт соединений с пирами
+const Transport = client.TransportPreferUTP
+
 // Объект с информацией о файле
 type bencodeInfo struct { // Пример данных:
-	Pieces      string `bencode:"pieces"`       // (Блок хешей каждой части файла)
-	PieceLength int    `bencode:"piece length"` // i262144e
-	Length      int    `bencode:"length"`       // i351272960e
-	Name        string `bencode:"name"`         // debian-10.2.0-amd64-netinst.iso
+	Pieces      string        `bencode:"pieces"`                 // (Блок хешей каждой части файла)
+	PieceLength int           `bencode:"piece length"`           // i262144e
+	Length      int           `bencode:"length,omitempty"`       // i351272960e
+	Files       []bencodeFile `bencode:"files,omitempty"`        // Список файлов многофайлового торрента
+	Name        string        `bencode:"name"`                   // debian-10.2.0-amd64-netinst.iso
+	Private     int           `bencode:"private,omitempty"`      // i1e
+	MetaVersion int           `bencode:"meta version,omitempty"` // i2e для BitTorrent v2
 }
 
 // Объект с данными трекера и bencodeInfo
 type bencodeTorrent struct { // Пример данных:
-	Announce string      `bencode:"announce"` // http://bttracker.debian.org:6969
-	Info     bencodeInfo `bencode:"info"`
+	Announce     string      `bencode:"announce"` // http://bttracker.debian.org:6969
+	AnnounceList [][]string  `bencode:"announce-list,omitempty"`
+	Comment      string      `bencode:"comment,omitempty"`       // "Debian CD from cdimage.debian.org"
+	CreatedBy    string      `bencode:"created by,omitempty"`    // mktorrent 1.1
+	CreationDate int64       `bencode:"creation date,omitempty"` // i1573903810e
+	URLList      []string    `bencode:"url-list,omitempty"`      // Веб-сиды, в файле может быть одной строкой
+	Info         bencodeInfo `bencode:"info"`
 }
 
 // Объект со всеми данными торрент файла
 type TorrentFile struct {
-	Announce    string
-	InfoHash    [20]byte
-	PieceHashes [][20]byte
-	PieceLength int
-	Length      int
-	Name        string
+	Announce     string
+	AnnounceList [][]string // Уровни трекеров (BEP 12)
+	InfoHash     [20]byte
+	PieceHashes  [][20]byte
+	PieceLength  int
+	Length       int
+	Name         string
+	Private      bool     // Приватный торрент (BEP 27)
+	WebSeeds     []string // Ссылки на веб-сиды (BEP 19)
+	Comment      string
+	CreatedBy    string
+	CreationDate time.Time // Нулевое значение, если дата не указана
+	Files        []File    // Файлы многофайлового торрента (nil для однофайлового)
+	MetaVersion  int       // 2 для торрентов BitTorrent v2 и гибридных, иначе 0
+	InfoHashV2   [32]byte
+	PiecesV2     []download.PieceV2     // Части торрента только v2
+	Extra        map[string]interface{} // Неизвестные ключи корневого словаря
+	ExtraInfo    map[string]interface{} // Неизвестные ключи словаря info
+}
+
+// Ключи, разбираемые в TorrentFile
+var (
+	knownKeys     = []string{"announce", "announce-list", "comment", "created by", "creation date", "url-list", "info", "piece layers"}
+	knownInfoKeys = []string{"pieces", "piece length", "length", "files", "name", "private", "meta version", "file tree"}
+)
+
+// Параметры скачивания торрента в файл
+type DownloadOptions struct {
+	Tracker        TrackerOptions // Параметры обращения к трекеру (нулевой PeerID - случайный)
+	Client         client.Options // Параметры соединений с пирами
+	MaxConnections int            // Лимит соединений с пирами (0 - без лимита)
+	MaxBacklog     int            // Неудовлетворенных запросов к пиру (0 - по умолчанию)
+	BlockSize      int            // Длина запрашиваемого блока (0 - по умолчанию)
+	PieceTimeout   time.Duration  // Ожидание части от пира (0 - по умолчанию)
+	HashWorkers    int            // Горутин проверки хешей частей (0 - по числу процессоров)
+	WriteBlocks    bool           // Запись блоков в хранилище по мере получения (для хранилища с кэшем)
+	Verbose        bool           // Вывод сообщений о пирах и веб-сидах
+	Quiet          bool           // Без индикатора загрузки и предупреждений
+}
+
+// Параметры скачивания по умолчанию
+func DefaultDownloadOptions() (DownloadOptions, error) {
+	p, err := proxy.FromEnvironment() // Прокси из переменной окружения ALL_PROXY
+	if err != nil {
+		return DownloadOptions{}, err
+	}
+	return DownloadOptions{
+		Tracker: TrackerOptions{Port: Port, Proxy: p},
+		Client:  client.Options{Encryption: Encryption, Transport: Transport, Proxy: p},
+	}, nil
 }
 
 // Функция для скачивания данных и упаковки их в файл
 func (t *TorrentFile) DownloadToFile(path string) error {
-	var peerID [20]byte
-	_, err := rand.Read(peerID[:]) // В качестве собственного PeerID генерируется массив из 20 случайных байт
+	opts, err := DefaultDownloadOptions()
+	if err != nil {
+		return err
+	}
+	return t.DownloadTo(path, opts)
+}
+
+// Скачивание в path (файл для однофайлового торрента, директория для многофайлового).
+// Части, уже записанные в path, проверяются и повторно не скачиваются
+func (t *TorrentFile) DownloadTo(path string, opts DownloadOptions) error {
+	return t.DownloadToStorage(storage.NewFileAt(path), opts)
+}
+
+// Скачивание в хранилище s. Части, уже имеющиеся в хранилище, проверяются
+// и повторно не скачиваются
+func (t *TorrentFile) DownloadToStorage(s storage.Storage, opts DownloadOptions) (err error) {
+	st, err := s.OpenTorrent(t.StorageInfo())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := st.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	if opts.Tracker.PeerID == [20]byte{} {
+		_, err := rand.Read(opts.Tracker.PeerID[:]) // В качестве собственного PeerID генерируется массив из 20 случайных байт
+		if err != nil {
+			return err
+		}
+	}
+
+	have, err := t.CheckStorage(context.Background(), st) // Данные, оставшиеся от прошлого запуска
 	if err != nil {
 		return err
 	}
+	if countPieces(have, t.NumPieces()) == t.NumPieces() {
+		return nil
+	}
+
+	peers, err := t.RequestPeers(opts.Tracker) // Запрос пиров у торрент трекера
+	if err != nil && len(t.WebSeeds) == 0 {
+		return err
+	}
+	if err != nil && !opts.Quiet { // Данные можно получить и у одних веб-сидов
+		fmt.Printf("%v. Downloading from web seeds only\n", err)
+	}
 
-	peers, err := t.requestPeers(peerID, Port) // Запрос пиров у торрент трекера
+	bans, err := banlist.Load(banlist.DefaultPath()) // Список пиров, заблокированных в прошлых запусках
 	if err != nil {
 		return err
 	}
 
-	torrent := download.Torrent{ // Объект со всей информацией нужной для скачивания
+	torrent := t.NewDownload(opts.Tracker.PeerID, peers, bans, opts.Client) // Объект со всей информацией нужной для скачивания
+	torrent.Done = have
+	torrent.Verbose = opts.Verbose
+	torrent.MaxBacklog = opts.MaxBacklog
+	torrent.BlockSize = opts.BlockSize
+	torrent.PieceTimeout = opts.PieceTimeout
+	torrent.HashWorkers = opts.HashWorkers
+	if opts.WriteBlocks {
+		torrent.OnBlock = func(index, begin int, block []byte) error {
+			_, err := st.Piece(index).WriteAt(block, int64(begin))
+			return err
+		}
+	}
+	if opts.MaxConnections > 0 {
+		torrent.Connections = make(chan struct{}, opts.MaxConnections)
+	}
+
+	var bar *progressbar.ProgressBar
+	if !opts.Quiet {
+		fmt.Printf("Starting download for %s\n", t.Name)
+		bar = progressbar.Default(int64(t.NumPieces())) // Создание индикатора загрузки
+		bar.Set(countPieces(have, t.NumPieces()))
+	}
+	return torrent.Run(context.Background(), func(index int, buf []byte) error {
+		piece := st.Piece(index)
+		_, err := piece.WriteAt(buf, 0) // Запись части сразу в хранилище
+		if err != nil {
+			return err
+		}
+		err = piece.MarkComplete()
+		if err != nil {
+			return err
+		}
+		if bar != nil {
+			bar.Add(1)
+		}
+		return nil
+	})
+}
+
+// Количество частей, отмеченных в поле have
+func countPieces(have bitfields.Bitfield, numPieces int) int {
+	n := 0
+	for i := 0; i < numPieces; i++ {
+		if have.HasPiece(i) {
+			n++
+		}
+	}
+	return n
+}
+
+// Параметры скачивания торрента для движка download
+func (t *TorrentFile) NewDownload(peerID [20]byte, peers []peers.Peer, bans *banlist.BanList, opts client.Options) download.Torrent {
+	torrent := download.Torrent{
 		Peers:       peers,
 		PeerID:      peerID,
 		InfoHash:    t.InfoHash,
 		PieceHashes: t.PieceHashes,
+		PiecesV2:    t.PiecesV2,
 		PieceLength: t.PieceLength,
 		Length:      t.Length,
 		Name:        t.Name,
+		BanList:     bans,
+		Client:      opts,
+		WebSeeds:    t.WebSeeds,
+		Private:     t.Private,
+	}
+	for _, f := range t.Files {
+		if !f.Padding { // Файлов выравнивания у веб-сидов нет
+			torrent.Files = append(torrent.Files, download.File{Path: f.Path, Offset: f.Offset, Length: f.Length})
+		}
 	}
+	return torrent
+}
 
-	buf, err := torrent.Download() // Скачивание данных
+// Функция для превращения данных из .torrent файла в объект TorrentFile
+func Open(path string) (TorrentFile, error) {
+	data, err := os.ReadFile(path) // Считывание файла
 	if err != nil {
-		return err
+		return TorrentFile{}, err
 	}
+	return Parse(data)
+}
 
-	outFile, err := os.Create(path) // Создание файла
+// Разбор закодированного торрента. InfoHash вычисляется по точным байтам словаря info
+func Parse(data []byte) (TorrentFile, error) {
+	var raw struct {
+		Info bencode.RawMessage `bencode:"info"` // Байты info в том виде, в каком они записаны в файле
+	}
+	err := bencode.Unmarshal(data, &raw)
 	if err != nil {
-		return err
+		return TorrentFile{}, err
 	}
-	defer outFile.Close()
-	_, err = outFile.Write(buf) // Запись данных в файл
+	infoRaw := raw.Info
+
+	var top map[string]interface{} // Обобщенное представление торрента
+	err = bencode.Unmarshal(data, &top)
 	if err != nil {
-		return err
+		return TorrentFile{}, err
 	}
-	return nil
-}
 
-// Функция для превращения данных из .torrent файла в объект TorrentFile
-func Open(path string) (TorrentFile, error) {
-	file, err := os.Open(path) // Открытие файла
+	bto, err := decodeTorrent(dict(top))
 	if err != nil {
 		return TorrentFile{}, err
 	}
-	defer file.Close()
 
-	bto := bencodeTorrent{}             // Инициализация обьекта для записи
-	err = bencode.Unmarshal(file, &bto) // Форматирование и запись в обьект
+	t, err := bto.toTorrentFile(infoRaw) // Форматирование bencodeTorrent в TorrentFile
 	if err != nil {
 		return TorrentFile{}, err
 	}
+	info, _ := dict(top).dict("info")
+	t.Extra = dict(top).unknown(knownKeys...)
+	t.ExtraInfo = info.unknown(knownInfoKeys...)
+
+	switch bto.Info.MetaVersion {
+	case 0, 1:
+	case 2: // Дерево файлов и слои хешей v2
+		err = t.parseV2(dict(top), infoRaw)
+		if err != nil {
+			return TorrentFile{}, err
+		}
+	default:
+		return TorrentFile{}, fmt.Errorf("Unsupported meta version %d", bto.Info.MetaVersion)
+	}
 
-	return bto.toTorrentFile() // Форматирование bencodeTorrent в TorrentFile
+	return t, nil
 }
 
-// Функция вычисления InfoHash
-func (i *bencodeInfo) hash() ([20]byte, error) {
-	var buf bytes.Buffer
+// Извлечение известных полей из обобщенного представления.
+// Необязательные поля неожиданного типа пропускаются
+func decodeTorrent(top dict) (bencodeTorrent, error) {
+	info, ok := top.dict("info")
+	if !ok {
+		return bencodeTorrent{}, fmt.Errorf("Torrent has no info dictionary")
+	}
 
-	err := bencode.Marshal(&buf, *i) // Превращение данных из обьекта в байтовый массив
+	bto := bencodeTorrent{}
+	bto.Announce, _ = top.str("announce")
+	tiers, _ := top["announce-list"].([]interface{})
+	for _, tier := range tiers {
+		trackers := stringsOf(tier)
+		if len(trackers) > 0 {
+			bto.AnnounceList = append(bto.AnnounceList, trackers)
+		}
+	}
+	bto.Comment, _ = top.str("comment")
+	bto.CreatedBy, _ = top.str("created by")
+	if date, ok := top["creation date"].(int64); ok {
+		bto.CreationDate = date
+	}
+	if url, ok := top.str("url-list"); ok { // Один веб-сид может быть записан строкой
+		bto.URLList = []string{url}
+	} else {
+		bto.URLList = top.strings("url-list")
+	}
 
-	if err != nil {
-		return [20]byte{}, err
+	bto.Info.Name, ok = info.str("name")
+	if !ok {
+		return bencodeTorrent{}, fmt.Errorf("Torrent has no name")
+	}
+	bto.Info.PieceLength, ok = info.int("piece length")
+	if !ok || bto.Info.PieceLength <= 0 {
+		return bencodeTorrent{}, fmt.Errorf("Torrent has invalid piece length")
+	}
+	bto.Info.Pieces, _ = info.str("pieces")
+	bto.Info.Length, _ = info.int("length")
+	if bto.Info.Length < 0 {
+		return bencodeTorrent{}, fmt.Errorf("Torrent has negative length %d", bto.Info.Length)
+	}
+	bto.Info.Private, _ = info.int("private")
+	bto.Info.MetaVersion, _ = info.int("meta version")
+	if bto.Info.MetaVersion != 2 && bto.Info.Pieces == "" {
+		return bencodeTorrent{}, fmt.Errorf("Torrent has no piece hashes")
 	}
 
-	h := sha1.Sum(buf.Bytes()) // Вычисление хеша
+	files, _ := info["files"].([]interface{})
+	for i, item := range files {
+		m, _ := item.(map[string]interface{})
+		f := dict(m)
+		length, ok := f.int("length")
+		path := f.strings("path")
+		if !ok || length < 0 || path == nil {
+			return bencodeTorrent{}, fmt.Errorf("Malformed file entry %d", i)
+		}
+		attr, _ := f.str("attr")
+		bto.Info.Files = append(bto.Info.Files, bencodeFile{Length: length, Path: path, Attr: attr})
+	}
 
-	return h, nil
+	return bto, nil
 }
 
 // Разделение Pieces на хеши частей файла
@@ -131,10 +374,12 @@ func (i *bencodeInfo) splitPieceHashes() ([][20]byte, error) {
 }
 
 // Конвертация bencodeTorrent в TorrentFile
-func (bto *bencodeTorrent) toTorrentFile() (TorrentFile, error) {
-	infoHash, err := bto.Info.hash() // Хеш данных о файле
-	if err != nil {
-		return TorrentFile{}, err
+func (bto *bencodeTorrent) toTorrentFile(infoRaw []byte) (TorrentFile, error) {
+	infoHash := sha1.Sum(infoRaw) // Хеш данных о файле
+
+	// Имя - файл или директория внутри директории скачивания
+	if validatePath([]string{bto.Info.Name}) != nil {
+		return TorrentFile{}, fmt.Errorf("Invalid torrent name %q", bto.Info.Name)
 	}
 
 	pieceHashes, err := bto.Info.splitPieceHashes() // Хеши каждой части файла
@@ -143,13 +388,77 @@ func (bto *bencodeTorrent) toTorrentFile() (TorrentFile, error) {
 	}
 
 	t := TorrentFile{
-		Announce:    bto.Announce,
-		InfoHash:    infoHash,
-		PieceHashes: pieceHashes,
-		PieceLength: bto.Info.PieceLength,
-		Length:      bto.Info.Length,
-		Name:        bto.Info.Name,
+		Announce:     bto.Announce,
+		AnnounceList: bto.AnnounceList,
+		InfoHash:     infoHash,
+		PieceHashes:  pieceHashes,
+		PieceLength:  bto.Info.PieceLength,
+		Length:       bto.Info.Length,
+		Name:         bto.Info.Name,
+		Private:      bto.Info.Private == 1,
+		Comment:      bto.Comment,
+		CreatedBy:    bto.CreatedBy,
+	}
+	for _, url := range bto.URLList {
+		if url != "" {
+			t.WebSeeds = append(t.WebSeeds, url)
+		}
+	}
+	if bto.CreationDate > 0 {
+		t.CreationDate = time.Unix(bto.CreationDate, 0)
 	}
 
+	for _, f := range bto.Info.Files { // Файлы идут в общем потоке данных друг за другом
+		err := validatePath(f.Path)
+		if err != nil {
+			return TorrentFile{}, err
+		}
+		t.Files = append(t.Files, File{
+			Path:    f.Path,
+			Length:  f.Length,
+			Offset:  t.Length,
+			Padding: strings.Contains(f.Attr, "p"),
+		})
+		t.Length += f.Length
+	}
+
+	if len(t.PieceHashes) > 0 { // У торрента v2 без v1 хешей части считаются по дереву файлов
+		want := (t.Length + t.PieceLength - 1) / t.PieceLength
+		if len(t.PieceHashes) != want {
+			return TorrentFile{}, fmt.Errorf("Torrent has %d piece hashes, expected %d for length %d", len(t.PieceHashes), want, t.Length)
+		}
+	}
 	return t, nil
 }
+
+// Все трекеры торрента по уровням: announce-list, а при его отсутствии announce
+func (t *TorrentFile) Trackers() [][]string {
+	if len(t.AnnounceList) > 0 {
+		return t.AnnounceList
+	}
+	if t.Announce != "" {
+		return [][]string{{t.Announce}}
+	}
+	return nil
+}
+
+// Количество частей торрента
+func (t *TorrentFile) NumPieces() int {
+	if len(t.PiecesV2) > 0 {
+		return len(t.PiecesV2)
+	}
+	return len(t.PieceHashes)
+}
+
+// Проверка, что путь файла не выходит за пределы директории торрента
+func validatePath(path []string) error {
+	if len(path) == 0 {
+		return fmt.Errorf("File path is empty")
+	}
+	for _, part := range path {
+		if part == "" || part == "." || part == ".." || strings.ContainsAny(part, `/\`) {
+			return fmt.Errorf("Invalid file path component %q", part)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package torrentfile
+
+import (
+	"crypto/sha1"
+	"strings"
+	"testing"
+
+	"github.com/swesdek/gotorrent-client/bencode"
+)
+
+// Закодированный торрент со словарем info
+func encodeTorrent(t *testing.T, info map[string]interface{}) []byte {
+	t.Helper()
+	data, err := bencode.Marshal(map[string]interface{}{
+		"announce": "http://tracker.example/announce",
+		"info":     info,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// Словарь info однофайлового торрента с numHashes хешами частей
+func singleFileInfo(name string, length, pieceLength, numHashes int) map[string]interface{} {
+	return map[string]interface{}{
+		"name":         name,
+		"length":       length,
+		"piece length": pieceLength,
+		"pieces":       strings.Repeat("x", 20*numHashes),
+	}
+}
+
+func TestParseValid(t *testing.T) {
+	tf, err := Parse(encodeTorrent(t, singleFileInfo("file.bin", 40000, 16384, 3)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tf.Name != "file.bin" || tf.Length != 40000 || tf.NumPieces() != 3 {
+		t.Errorf("got name %q, length %d, %d pieces", tf.Name, tf.Length, tf.NumPieces())
+	}
+}
+
+func TestParseRejectsMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		info map[string]interface{}
+		want string
+	}{
+		{"too many hashes", singleFileInfo("f", 10, 16384, 2), "piece hashes"},
+		{"too few hashes", singleFileInfo("f", 40000, 16384, 1), "piece hashes"},
+		{"no hashes for data", singleFileInfo("f", 10, 16384, 0), "no piece hashes"},
+		{"negative length", singleFileInfo("f", -10, 16384, 1), "negative length"},
+		{"zero piece length", singleFileInfo("f", 10, 0, 1), "piece length"},
+		{"negative piece length", singleFileInfo("f", 10, -16384, 1), "piece length"},
+		{"empty name", singleFileInfo("", 10, 16384, 1), "torrent name"},
+		{"dot name", singleFileInfo(".", 10, 16384, 1), "torrent name"},
+		{"parent name", singleFileInfo("..", 10, 16384, 1), "torrent name"},
+		{"name with slash", singleFileInfo("../etc/passwd", 10, 16384, 1), "torrent name"},
+		{"name with backslash", singleFileInfo(`a\b`, 10, 16384, 1), "torrent name"},
+		{"file path escapes", map[string]interface{}{
+			"name":         "dir",
+			"piece length": 16384,
+			"pieces":       strings.Repeat("x", 20),
+			"files": []interface{}{
+				map[string]interface{}{"length": 1, "path": []interface{}{"..", "x"}},
+			},
+		}, "file path"},
+		{"multi-file hash mismatch", map[string]interface{}{
+			"name":         "dir",
+			"piece length": 16384,
+			"pieces":       strings.Repeat("x", 20),
+			"files": []interface{}{
+				map[string]interface{}{"length": 16384, "path": []interface{}{"a"}},
+				map[string]interface{}{"length": 1, "path": []interface{}{"b"}},
+			},
+		}, "piece hashes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(encodeTorrent(t, tt.info))
+			if err == nil {
+				t.Fatal("Parse accepted malformed torrent")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error %q does not mention %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestInfoHashKeepsUnknownKeys(t *testing.T) {
+	// Словарь info в том виде, в каком его записал другой клиент: с ключами
+	// source, private и неизвестным ключом, которых нет в bencodeInfo
+	rawInfo := "d6:lengthi40000e4:name8:file.bin12:piece lengthi16384e6:pieces60:" + strings.Repeat("x", 60) +
+		"7:privatei1e6:source6:SOURCE9:x-unknownli1ei2eee"
+	data := []byte("d8:announce31:http://tracker.example/announce4:info" + rawInfo + "e")
+
+	tf, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := sha1.Sum([]byte(rawInfo)); tf.InfoHash != want {
+		t.Errorf("info hash %x, want %x", tf.InfoHash, want)
+	}
+	if !tf.Private {
+		t.Error("private flag lost")
+	}
+	if tf.ExtraInfo["source"] != "SOURCE" || len(tf.ExtraInfo) != 2 {
+		t.Errorf("unknown info keys %v", tf.ExtraInfo)
+	}
+
+	known, err := bencode.Marshal(bencodeInfo{Pieces: strings.Repeat("x", 60), PieceLength: 16384, Length: 40000, Name: "file.bin", Private: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tf.InfoHash == sha1.Sum(known) {
+		t.Error("info hash computed from known keys only")
+	}
+}
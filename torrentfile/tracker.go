@@ -1,26 +1,50 @@
 package torrentfile
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
 	"net/url"
 	"strconv"
 	"time"
 
-	"github.com/jackpal/bencode-go"
+	"github.com/swesdek/gotorrent-client/bencode"
 	"github.com/swesdek/gotorrent-client/peers"
+	"github.com/swesdek/gotorrent-client/proxy"
 )
 
 // Объект данных из ответа трекера на запрос
 type bencodeTrackerResp struct {
-	Interval int    `bencode:"interval"`
-	Peers    string `bencode:"peers"`
+	Interval      int    `bencode:"interval"`
+	Peers         string `bencode:"peers"`
+	FailureReason string `bencode:"failure reason"`
 }
 
-// Создание ссылки для запроса на трекер
-func (t *TorrentFile) buildTrackerURL(peerID [20]byte, port uint16) (string, error) {
-	base, err := url.Parse(t.Announce) // Считывание ссылки на трекер из Announce
+// Таймаут HTTP запроса к трекеру по умолчанию
+const DefaultTrackerTimeout = 15 * time.Second
+
+// Параметры обращения к трекеру
+type TrackerOptions struct {
+	PeerID  [20]byte
+	Port    uint16        // Порт входящих соединений, сообщаемый трекеру
+	Proxy   *proxy.Proxy  // Прокси для запросов (nil - прямое соединение)
+	Timeout time.Duration // Таймаут HTTP запроса (0 - DefaultTrackerTimeout)
+}
+
+// HTTP клиент для запросов к трекеру
+func (o TrackerOptions) httpClient() *http.Client {
+	timeout := o.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTrackerTimeout
+	}
+	return &http.Client{Timeout: timeout, Transport: o.Proxy.Transport()}
+}
+
+// Создание ссылки для запроса на трекер announce
+func (t *TorrentFile) buildTrackerURL(announce string, peerID [20]byte, port uint16) (string, error) {
+	base, err := url.Parse(announce)
 	if err != nil {
-		return "", nil
+		return "", err
 	}
 	params := url.Values{ // Параметры для запроса
 		"info_hash":  []string{string(t.InfoHash[:])},
@@ -36,16 +60,61 @@ func (t *TorrentFile) buildTrackerURL(peerID [20]byte, port uint16) (string, err
 	return base.String(), nil
 }
 
-// Запрос пиров у трекера
-func (t *TorrentFile) requestPeers(peerID [20]byte, port uint16) ([]peers.Peer, error) {
-	url, err := t.buildTrackerURL(peerID, port)
+// Ошибка обращения к трекеру
+type TrackerError struct {
+	URL string
+	Err error
+}
+
+func (e *TrackerError) Error() string {
+	return fmt.Sprintf("Tracker %s: %v", e.URL, e.Err)
+}
+
+func (e *TrackerError) Unwrap() error {
+	return e.Err
+}
+
+// Запрос пиров у трекеров по уровням (BEP 12). Трекеры уровня опрашиваются
+// по порядку до первого ответившего, следующий уровень - только если не
+// ответил ни один трекер предыдущего. Если не ответил никто, возвращаются
+// ошибки всех трекеров
+func (t *TorrentFile) RequestPeers(opts TrackerOptions) ([]peers.Peer, error) {
+	var errs []error
+	for _, tier := range t.Trackers() {
+		for _, announce := range tier {
+			found, err := t.requestPeers(announce, opts)
+			if err != nil {
+				errs = append(errs, &TrackerError{URL: announce, Err: err})
+				continue
+			}
+			for i := range found {
+				found[i].Source = peers.SourceTracker
+			}
+			return found, nil
+		}
+	}
+	if len(errs) == 0 {
+		return nil, fmt.Errorf("Torrent has no trackers")
+	}
+	return nil, errors.Join(errs...)
+}
+
+// Запрос пиров у трекера announce
+func (t *TorrentFile) requestPeers(announce string, opts TrackerOptions) ([]peers.Peer, error) {
+	u, err := url.Parse(announce)
 	if err != nil {
 		return nil, err
 	}
+	if u.Scheme == "udp" {
+		return t.requestPeersUDP(u, opts.PeerID, opts.Port, opts.Proxy)
+	}
 
-	c := &http.Client{Timeout: 15 * time.Second} // Создание http клиента
+	url, err := t.buildTrackerURL(announce, opts.PeerID, opts.Port)
+	if err != nil {
+		return nil, err
+	}
 
-	res, err := c.Get(url) // Запрос на трекер
+	res, err := opts.httpClient().Get(url) // Запрос на трекер
 	if err != nil {
 		return nil, err
 	}
@@ -53,10 +122,13 @@ func (t *TorrentFile) requestPeers(peerID [20]byte, port uint16) ([]peers.Peer,
 
 	trackerRes := bencodeTrackerResp{}
 
-	err = bencode.Unmarshal(res.Body, &trackerRes) // Запись ответа трекера
+	err = bencode.NewDecoder(res.Body).Decode(&trackerRes) // Запись ответа трекера
 	if err != nil {
 		return nil, err
 	}
+	if trackerRes.FailureReason != "" { // Отказ трекера, как и недоступность, переводит к следующему
+		return nil, fmt.Errorf("Tracker returned error: %s", trackerRes.FailureReason)
+	}
 
 	return peers.Unmarshal([]byte(trackerRes.Peers))
 }
@@ -0,0 +1,113 @@
+package torrentfile
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/swesdek/gotorrent-client/bencode"
+	"github.com/swesdek/gotorrent-client/proxy"
+)
+
+// Трекер, отвечающий одним пиром с портом port или отказом при port = 0.
+// В calls записываются пути запросов
+func testTracker(t *testing.T, port byte, calls *[]string, mu *sync.Mutex) string {
+	t.Helper()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		*calls = append(*calls, r.URL.Path)
+		mu.Unlock()
+		res := map[string]interface{}{"failure reason": "unregistered torrent"}
+		if port != 0 {
+			res = map[string]interface{}{"interval": 1800, "peers": string([]byte{10, 0, 0, 1, 0, port})}
+		}
+		data, _ := bencode.Marshal(res)
+		w.Write(data)
+	}))
+	t.Cleanup(ts.Close)
+	return ts.URL
+}
+
+func TestRequestPeersTiers(t *testing.T) {
+	var calls []string
+	var mu sync.Mutex
+	refused := testTracker(t, 0, &calls, &mu)
+	first := testTracker(t, 1, &calls, &mu)
+	second := testTracker(t, 2, &calls, &mu)
+	dead := "http://127.0.0.1:1/announce"
+
+	tf := TorrentFile{
+		Announce: refused + "/a0",
+		AnnounceList: [][]string{
+			{dead, refused + "/a1", first + "/a2", second + "/a3"},
+			{second + "/b1"},
+		},
+	}
+	found, err := tf.RequestPeers(TrackerOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 || found[0].String() != "10.0.0.1:1" {
+		t.Errorf("found %v, want the peer of the first answering tracker", found)
+	}
+	if strings.Join(calls, " ") != "/a1 /a2" {
+		t.Errorf("trackers asked %v", calls)
+	}
+
+	// Следующий уровень опрашивается, когда не ответил ни один трекер уровня
+	calls = nil
+	tf.AnnounceList[0] = []string{dead, refused + "/a1"}
+	found, err = tf.RequestPeers(TrackerOptions{})
+	if err != nil || len(found) != 1 || found[0].String() != "10.0.0.1:2" {
+		t.Fatalf("found %v, %v from the second tier", found, err)
+	}
+	if strings.Join(calls, " ") != "/a1 /b1" {
+		t.Errorf("trackers asked %v", calls)
+	}
+}
+
+func TestRequestPeersAllFail(t *testing.T) {
+	var calls []string
+	var mu sync.Mutex
+	refused := testTracker(t, 0, &calls, &mu)
+	tf := TorrentFile{AnnounceList: [][]string{{"http://127.0.0.1:1/announce"}, {refused + "/announce"}}}
+
+	_, err := tf.RequestPeers(TrackerOptions{})
+	var trackerErr *TrackerError
+	if !errors.As(err, &trackerErr) || trackerErr.URL != "http://127.0.0.1:1/announce" {
+		t.Fatalf("error %v, want a tracker error", err)
+	}
+	if !strings.Contains(err.Error(), "unregistered torrent") {
+		t.Errorf("error %q does not mention the second tracker", err)
+	}
+
+	_, err = (&TorrentFile{}).RequestPeers(TrackerOptions{})
+	if err == nil || !strings.Contains(err.Error(), "no trackers") {
+		t.Errorf("torrent without trackers: %v", err)
+	}
+}
+
+func TestUDPTrackerAddrThroughProxy(t *testing.T) {
+	p, err := proxy.Parse("socks5h://127.0.0.1:1080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, err := udpTrackerAddr("tracker.invalid:6969", p) // Имя не разрешается локально
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr != proxy.HostAddr("tracker.invalid:6969") {
+		t.Errorf("address %#v, want the host name for the proxy", addr)
+	}
+	if _, err = udpTrackerAddr("tracker.invalid", p); err == nil {
+		t.Error("address without port accepted")
+	}
+
+	addr, err = udpTrackerAddr("127.0.0.1:6969", nil)
+	if err != nil || addr.String() != "127.0.0.1:6969" {
+		t.Errorf("direct address %v, %v", addr, err)
+	}
+}
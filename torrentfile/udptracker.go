@@ -0,0 +1,165 @@
+package torrentfile
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/swesdek/gotorrent-client/peers"
+	"github.com/swesdek/gotorrent-client/proxy"
+)
+
+// Действия протокола UDP трекера (BEP 15)
+const (
+	udpActionConnect  uint32 = 0
+	udpActionAnnounce uint32 = 1
+	udpActionScrape   uint32 = 2
+	udpActionError    uint32 = 3
+)
+
+const udpProtocolID uint64 = 0x41727101980 // Магическая константа запроса на соединение
+const udpTrackerRetries = 3                // Количество попыток на каждый запрос
+
+// Отправка запроса UDP трекеру и ожидание ответа с тем же transaction id
+func udpRoundTrip(pc net.PacketConn, addr net.Addr, req []byte, action uint32) ([]byte, error) {
+	transactionID := binary.BigEndian.Uint32(req[12:16])
+	buf := make([]byte, 2048)
+
+	timeout := 5 * time.Second
+	for attempt := 0; attempt < udpTrackerRetries; attempt++ {
+		_, err := pc.WriteTo(req, addr)
+		if err != nil {
+			return nil, err
+		}
+		pc.SetReadDeadline(time.Now().Add(timeout))
+
+		for {
+			n, _, err := pc.ReadFrom(buf)
+			if err != nil {
+				if ne, ok := err.(net.Error); ok && ne.Timeout() {
+					break // Повтор запроса с увеличенным таймаутом
+				}
+				return nil, err
+			}
+			if n < 8 || binary.BigEndian.Uint32(buf[4:8]) != transactionID {
+				continue // Ответ на другой запрос
+			}
+			gotAction := binary.BigEndian.Uint32(buf[0:4])
+			if gotAction == udpActionError {
+				return nil, fmt.Errorf("Tracker returned error: %s", buf[8:n])
+			}
+			if gotAction != action {
+				return nil, fmt.Errorf("Expected tracker action %d, but got %d", action, gotAction)
+			}
+			return append([]byte(nil), buf[:n]...), nil
+		}
+		timeout *= 2
+	}
+	return nil, fmt.Errorf("UDP tracker %s did not respond", addr)
+}
+
+// Новый случайный transaction id
+func newTransactionID() ([]byte, error) {
+	id := make([]byte, 4)
+	_, err := rand.Read(id)
+	return id, err
+}
+
+// Адрес UDP трекера host:port. Через прокси имя передается прокси-серверу
+// без разрешения, чтобы DNS запрос не уходил мимо прокси
+func udpTrackerAddr(host string, p *proxy.Proxy) (net.Addr, error) {
+	if p != nil {
+		_, _, err := net.SplitHostPort(host)
+		if err != nil {
+			return nil, err
+		}
+		return proxy.HostAddr(host), nil
+	}
+	return net.ResolveUDPAddr("udp", host)
+}
+
+// Открытие UDP сокета для обращения к трекеру u (через SOCKS5, если задан прокси)
+func openUDPTracker(u *url.URL, p *proxy.Proxy) (net.PacketConn, net.Addr, error) {
+	addr, err := udpTrackerAddr(u.Host, p)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var pc net.PacketConn
+	if p != nil { // Через прокси UDP передается только по SOCKS5
+		pc, err = p.ListenUDP()
+	} else {
+		pc, err = net.ListenPacket("udp", ":0")
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return pc, addr, nil
+}
+
+// Получение connection id у UDP трекера
+func udpConnect(pc net.PacketConn, addr net.Addr) ([]byte, error) {
+	tid, err := newTransactionID()
+	if err != nil {
+		return nil, err
+	}
+	req := make([]byte, 16) // Запрос на соединение
+	binary.BigEndian.PutUint64(req[0:8], udpProtocolID)
+	binary.BigEndian.PutUint32(req[8:12], udpActionConnect)
+	copy(req[12:16], tid)
+	res, err := udpRoundTrip(pc, addr, req, udpActionConnect)
+	if err != nil {
+		return nil, err
+	}
+	if len(res) < 16 {
+		return nil, fmt.Errorf("Connect response is too short: %d", len(res))
+	}
+	return res[8:16], nil
+}
+
+// Запрос пиров у UDP трекера
+func (t *TorrentFile) requestPeersUDP(u *url.URL, peerID [20]byte, port uint16, p *proxy.Proxy) ([]peers.Peer, error) {
+	pc, addr, err := openUDPTracker(u, p)
+	if err != nil {
+		return nil, err
+	}
+	defer pc.Close()
+
+	connectionID, err := udpConnect(pc, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	tid, err := newTransactionID()
+	if err != nil {
+		return nil, err
+	}
+	var announce bytes.Buffer // Запрос на получение пиров
+	announce.Write(connectionID)
+	binary.Write(&announce, binary.BigEndian, udpActionAnnounce)
+	announce.Write(tid)
+	announce.Write(t.InfoHash[:])
+	announce.Write(peerID[:])
+	binary.Write(&announce, binary.BigEndian, uint64(0))        // downloaded
+	binary.Write(&announce, binary.BigEndian, uint64(t.Length)) // left
+	binary.Write(&announce, binary.BigEndian, uint64(0))        // uploaded
+	binary.Write(&announce, binary.BigEndian, uint32(0))        // event
+	binary.Write(&announce, binary.BigEndian, uint32(0))        // IP адрес по умолчанию
+	binary.Write(&announce, binary.BigEndian, tid)              // key
+	binary.Write(&announce, binary.BigEndian, int32(-1))        // num_want по умолчанию
+	binary.Write(&announce, binary.BigEndian, port)
+
+	res, err := udpRoundTrip(pc, addr, announce.Bytes(), udpActionAnnounce)
+	if err != nil {
+		return nil, err
+	}
+	if len(res) < 20 {
+		return nil, fmt.Errorf("Announce response is too short: %d", len(res))
+	}
+
+	return peers.Unmarshal(res[20:])
+}
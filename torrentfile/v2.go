@@ -0,0 +1,164 @@
+package torrentfile
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sort"
+
+	"github.com/swesdek/gotorrent-client/download"
+	"github.com/swesdek/gotorrent-client/merkle"
+)
+
+// Файл из дерева файлов v2 торрента
+type fileV2 struct {
+	path       []string
+	length     int
+	piecesRoot [32]byte
+}
+
+// Обход дерева файлов ("file tree") в порядке сортировки имен
+func walkFileTree(node map[string]interface{}, path []string, files []fileV2) ([]fileV2, error) {
+	names := make([]string, 0, len(node))
+	for name := range node {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		child, ok := node[name].(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("Malformed file tree entry %q", name)
+		}
+		childPath := append(append([]string(nil), path...), name)
+		err := validatePath(childPath)
+		if err != nil {
+			return nil, err
+		}
+
+		props, isFile := child[""].(map[string]interface{}) // Файл обозначается ключом ""
+		if !isFile {
+			files, err = walkFileTree(child, childPath, files)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		length, ok := props["length"].(int64)
+		if !ok || length < 0 {
+			return nil, fmt.Errorf("File %v has invalid length", childPath)
+		}
+		f := fileV2{path: childPath, length: int(length)}
+		if length > 0 {
+			root, ok := props["pieces root"].(string)
+			if !ok || len(root) != 32 {
+				return nil, fmt.Errorf("File %v has invalid pieces root", childPath)
+			}
+			copy(f.piecesRoot[:], root)
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// Части файла v2 по его слою хешей частей из "piece layers"
+func filePieces(f fileV2, pieceLength int, layers map[string]interface{}) ([]download.PieceV2, error) {
+	if f.length <= pieceLength { // Для файла из одной части хеш части совпадает с корнем
+		return []download.PieceV2{{
+			Length: f.length,
+			Root:   f.piecesRoot,
+			Leaves: merkle.LeafCount(f.length),
+		}}, nil
+	}
+
+	layer, ok := layers[string(f.piecesRoot[:])].(string)
+	numPieces := (f.length + pieceLength - 1) / pieceLength
+	if !ok || len(layer) != numPieces*32 {
+		return nil, fmt.Errorf("Missing or malformed piece layer for file %v", f.path)
+	}
+
+	hashes := make([][32]byte, numPieces)
+	for i := range hashes {
+		copy(hashes[i][:], layer[i*32:(i+1)*32])
+	}
+	leaves := pieceLength / merkle.BlockSize
+	if merkle.RootFromLayer(hashes, leaves) != f.piecesRoot { // Слой должен сходиться к корню файла
+		return nil, fmt.Errorf("Piece layer of file %v does not match its pieces root", f.path)
+	}
+
+	pieces := make([]download.PieceV2, numPieces)
+	for i := range pieces {
+		pieces[i] = download.PieceV2{
+			Length: min(pieceLength, f.length-i*pieceLength),
+			Root:   hashes[i],
+			Leaves: leaves,
+		}
+	}
+	return pieces, nil
+}
+
+// Дополнение TorrentFile данными BitTorrent v2 (BEP 52) из декодированного торрента
+func (t *TorrentFile) parseV2(top dict, infoRaw []byte) error {
+	info, ok := top.dict("info")
+	if !ok {
+		return fmt.Errorf("Torrent has no info dictionary")
+	}
+	if t.PieceLength < merkle.BlockSize || t.PieceLength&(t.PieceLength-1) != 0 {
+		return fmt.Errorf("Piece length of v2 torrent must be a power of two >= %d", merkle.BlockSize)
+	}
+
+	t.MetaVersion = 2
+	t.InfoHashV2 = sha256.Sum256(infoRaw)
+	if len(t.PieceHashes) == 0 { // У гибридного торрента остается v1 хеш
+		copy(t.InfoHash[:], t.InfoHashV2[:20]) // В хендшейке и на трекере v2 хеш усекается до 20 байт
+	}
+
+	tree, ok := info.dict("file tree")
+	if !ok {
+		return fmt.Errorf("v2 torrent has no file tree")
+	}
+	files, err := walkFileTree(tree, nil, nil)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("v2 torrent has no files")
+	}
+	layers, _ := top.dict("piece layers")
+
+	var pieces []download.PieceV2
+	for _, f := range files {
+		if f.length == 0 {
+			continue
+		}
+		filePieces, err := filePieces(f, t.PieceLength, layers)
+		if err != nil {
+			return err
+		}
+		pieces = append(pieces, filePieces...)
+	}
+
+	if len(t.PieceHashes) > 0 { // Скачивание гибридного торрента идет по v1 частям
+		roots := make(map[string][32]byte, len(files))
+		for _, f := range files {
+			roots[fmt.Sprint(f.path)] = f.piecesRoot
+		}
+		for i := range t.Files {
+			t.Files[i].PiecesRoot = roots[fmt.Sprint(t.Files[i].Path)]
+		}
+		return nil
+	}
+
+	t.PiecesV2 = pieces
+	single := len(files) == 1 && len(files[0].path) == 1 && files[0].path[0] == t.Name
+	offset := 0 // Каждый файл начинается с границы части
+	t.Files = nil
+	for _, f := range files {
+		if !single {
+			t.Files = append(t.Files, File{Path: f.path, Length: f.length, Offset: offset, PiecesRoot: f.piecesRoot})
+		}
+		t.Length = offset + f.length
+		offset += (f.length + t.PieceLength - 1) / t.PieceLength * t.PieceLength
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package torrentfile
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"strings"
+	"testing"
+
+	"github.com/swesdek/gotorrent-client/bencode"
+	"github.com/swesdek/gotorrent-client/merkle"
+)
+
+// Словарь info и слой хешей частей v2 торрента с одним файлом из data
+func v2Info(data []byte, pieceLength int) (map[string]interface{}, string) {
+	root := merkle.DataRoot(data, merkle.LeafCount(len(data)))
+	var layer []byte
+	for begin := 0; begin < len(data); begin += pieceLength {
+		end := min(begin+pieceLength, len(data))
+		h := merkle.DataRoot(data[begin:end], pieceLength/merkle.BlockSize)
+		layer = append(layer, h[:]...)
+	}
+	info := map[string]interface{}{
+		"name":         "file.bin",
+		"piece length": pieceLength,
+		"meta version": 2,
+		"file tree": map[string]interface{}{
+			"file.bin": map[string]interface{}{
+				"": map[string]interface{}{"length": len(data), "pieces root": string(root[:])},
+			},
+		},
+	}
+	return info, string(layer)
+}
+
+// Закодированный v2 торрент и точные байты его словаря info
+func encodeV2(t *testing.T, info map[string]interface{}, layers map[string]interface{}) ([]byte, []byte) {
+	t.Helper()
+	rawInfo, err := bencode.Marshal(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	top := map[string]interface{}{"announce": "http://tracker.example/announce", "info": info}
+	if layers != nil {
+		top["piece layers"] = layers
+	}
+	data, err := bencode.Marshal(top)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data, rawInfo
+}
+
+// Данные из нескольких частей, последняя часть неполная
+func v2Data() []byte {
+	return []byte(strings.Repeat("0123456789abcdef", 5*merkle.BlockSize/16+100))
+}
+
+// Корень файла из словаря info, построенного v2Info
+func piecesRoot(info map[string]interface{}) string {
+	file := info["file tree"].(map[string]interface{})["file.bin"].(map[string]interface{})
+	return file[""].(map[string]interface{})["pieces root"].(string)
+}
+
+func TestParseV2(t *testing.T) {
+	data := v2Data()
+	pieceLength := 2 * merkle.BlockSize
+	info, layer := v2Info(data, pieceLength)
+	encoded, rawInfo := encodeV2(t, info, map[string]interface{}{piecesRoot(info): layer})
+
+	tf, err := Parse(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tf.MetaVersion != 2 || tf.Length != len(data) || tf.Files != nil {
+		t.Errorf("meta version %d, length %d, files %v", tf.MetaVersion, tf.Length, tf.Files)
+	}
+	v2Hash := sha256.Sum256(rawInfo)
+	if tf.InfoHashV2 != v2Hash || tf.InfoHash != [20]byte(v2Hash[:20]) {
+		t.Errorf("info hashes %x, %x; want %x truncated", tf.InfoHash, tf.InfoHashV2, v2Hash)
+	}
+	if len(tf.PiecesV2) != 3 {
+		t.Fatalf("%d v2 pieces, want 3", len(tf.PiecesV2))
+	}
+	last := tf.PiecesV2[2]
+	if last.Length != len(data)-2*pieceLength || last.Leaves != 2 || string(last.Root[:]) != layer[64:] {
+		t.Errorf("last piece %+v", last)
+	}
+}
+
+func TestParseV2SinglePiece(t *testing.T) {
+	data := []byte("small file")
+	info, _ := v2Info(data, merkle.BlockSize)
+	encoded, _ := encodeV2(t, info, nil) // Слой не нужен для файла из одной части
+	tf, err := Parse(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tf.PiecesV2) != 1 || tf.PiecesV2[0].Root != merkle.DataRoot(data, 1) {
+		t.Errorf("pieces %+v", tf.PiecesV2)
+	}
+}
+
+func TestParseHybrid(t *testing.T) {
+	data := v2Data()
+	pieceLength := 2 * merkle.BlockSize
+	info, layer := v2Info(data, pieceLength)
+	info["length"] = len(data)
+	var pieces []byte
+	for begin := 0; begin < len(data); begin += pieceLength {
+		h := sha1.Sum(data[begin:min(begin+pieceLength, len(data))])
+		pieces = append(pieces, h[:]...)
+	}
+	info["pieces"] = string(pieces)
+	encoded, rawInfo := encodeV2(t, info, map[string]interface{}{piecesRoot(info): layer})
+
+	tf, err := Parse(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tf.InfoHash != sha1.Sum(rawInfo) || tf.InfoHashV2 != sha256.Sum256(rawInfo) {
+		t.Errorf("hybrid info hashes %x, %x", tf.InfoHash, tf.InfoHashV2)
+	}
+	if tf.PiecesV2 != nil || tf.NumPieces() != 3 {
+		t.Errorf("hybrid torrent has %d v2 and %d v1 pieces", len(tf.PiecesV2), tf.NumPieces())
+	}
+}
+
+func TestParseV2RejectsMalformed(t *testing.T) {
+	data := v2Data()
+	pieceLength := 2 * merkle.BlockSize
+	tests := []struct {
+		name   string
+		modify func(info map[string]interface{}, layers map[string]interface{})
+		want   string
+	}{
+		{"layer does not match root", func(info, layers map[string]interface{}) {
+			layer := []byte(layers[piecesRoot(info)].(string))
+			layer[0] ^= 1
+			layers[piecesRoot(info)] = string(layer)
+		}, "does not match"},
+		{"missing layer", func(info, layers map[string]interface{}) {
+			delete(layers, piecesRoot(info))
+		}, "piece layer"},
+		{"truncated layer", func(info, layers map[string]interface{}) {
+			layers[piecesRoot(info)] = layers[piecesRoot(info)].(string)[32:]
+		}, "piece layer"},
+		{"short pieces root", func(info, layers map[string]interface{}) {
+			file := info["file tree"].(map[string]interface{})["file.bin"].(map[string]interface{})
+			file[""] = map[string]interface{}{"length": len(data), "pieces root": piecesRoot(info)[:20]}
+		}, "pieces root"},
+		{"piece length not a power of two", func(info, layers map[string]interface{}) {
+			info["piece length"] = 3 * merkle.BlockSize
+		}, "power of two"},
+		{"no file tree", func(info, layers map[string]interface{}) {
+			delete(info, "file tree")
+		}, "file tree"},
+		{"escaping path", func(info, layers map[string]interface{}) {
+			info["file tree"] = map[string]interface{}{"..": info["file tree"]}
+		}, "path"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, layer := v2Info(data, pieceLength)
+			layers := map[string]interface{}{piecesRoot(info): layer}
+			tt.modify(info, layers)
+			encoded, _ := encodeV2(t, info, layers)
+			_, err := Parse(encoded)
+			if err == nil {
+				t.Fatal("Parse accepted malformed v2 torrent")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error %q does not mention %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package torrentfile
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"runtime"
+	"sync"
+
+	"github.com/swesdek/gotorrent-client/storage"
+)
+
+// Результат проверки части
+type PieceStatus int
+
+const (
+	// Данные части совпадают с хешем
+	PieceOK PieceStatus = iota
+
+	// Файл части отсутствует или короче нужного
+	PieceMissing
+
+	// Данные части не совпадают с хешем
+	PieceCorrupt
+)
+
+func (s PieceStatus) String() string {
+	switch s {
+	case PieceOK:
+		return "ok"
+	case PieceMissing:
+		return "missing"
+	case PieceCorrupt:
+		return "corrupt"
+	}
+	return "unknown"
+}
+
+// Результат проверки одного файла торрента
+type FileReport struct {
+	Path      string // Путь к файлу на диске
+	Length    int    // Длина файла по метаданным
+	Size      int64  // Длина файла на диске (-1 - файла нет)
+	BadPieces []int  // Части файла, не прошедшие проверку
+}
+
+// Файл отсутствует на диске
+func (r FileReport) Missing() bool {
+	return r.Size < 0
+}
+
+// Файл прошел проверку
+func (r FileReport) OK() bool {
+	return r.Size == int64(r.Length) && len(r.BadPieces) == 0
+}
+
+// Результат проверки данных торрента на диске
+type VerifyReport struct {
+	Pieces []PieceStatus // Результат по каждой части
+	Files  []FileReport  // Результат по каждому файлу, кроме файлов выравнивания
+}
+
+// Все части и файлы прошли проверку
+func (r *VerifyReport) OK() bool {
+	for _, f := range r.Files {
+		if !f.OK() {
+			return false
+		}
+	}
+	return len(r.BadPieces()) == 0
+}
+
+// Номера частей, не прошедших проверку
+func (r *VerifyReport) BadPieces() []int {
+	var bad []int
+	for index, status := range r.Pieces {
+		if status != PieceOK {
+			bad = append(bad, index)
+		}
+	}
+	return bad
+}
+
+// Проверка данных по пути path (файл для однофайлового торрента, директория
+// для многофайлового) без обращения к сети. Части хешируются параллельно
+// в workers горутинах (0 - по числу процессоров)
+func (t *TorrentFile) Verify(ctx context.Context, path string, workers int) (*VerifyReport, error) {
+	st, err := (&storage.FileStorage{Path: path, ReadOnly: true}).OpenTorrent(t.StorageInfo())
+	if err != nil {
+		return nil, err
+	}
+	defer st.Close()
+	statuses, err := t.verifyPieces(ctx, st, workers)
+	if err != nil {
+		return nil, err
+	}
+	report := &VerifyReport{Pieces: statuses}
+
+	files, paths := t.diskFiles(path)
+	for i, f := range files {
+		fr := FileReport{Path: paths[i], Length: f.Length, Size: -1}
+		info, err := os.Stat(paths[i])
+		if err == nil {
+			fr.Size = info.Size()
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		if f.Length > 0 {
+			for index := f.Offset / t.PieceLength; index <= (f.Offset+f.Length-1)/t.PieceLength; index++ {
+				if report.Pieces[index] != PieceOK {
+					fr.BadPieces = append(fr.BadPieces, index)
+				}
+			}
+		}
+		report.Files = append(report.Files, fr)
+	}
+	return report, nil
+}
+
+// Параллельная проверка всех частей в хранилище
+func (t *TorrentFile) verifyPieces(ctx context.Context, st storage.Torrent, workers int) ([]PieceStatus, error) {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	statuses := make([]PieceStatus, t.NumPieces())
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	var once sync.Once
+	var failure error
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indexes {
+				status, err := t.verifyPiece(st, index)
+				if err != nil {
+					once.Do(func() { failure = err })
+					cancel()
+					continue
+				}
+				statuses[index] = status // Каждый индекс пишется одной горутиной
+			}
+		}()
+	}
+
+feed:
+	for index := 0; index < t.NumPieces(); index++ {
+		select {
+		case indexes <- index:
+		case <-ctx.Done(): // Проверку большого торрента можно прервать
+			break feed
+		}
+	}
+	close(indexes)
+	wg.Wait()
+	if failure != nil {
+		return nil, failure
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return statuses, nil
+}
+
+// Чтение и проверка одной части. Часть, отмеченная в хранилище, не перечитывается
+func (t *TorrentFile) verifyPiece(st storage.Torrent, index int) (PieceStatus, error) {
+	if st.Piece(index).Completed() {
+		return PieceOK, nil
+	}
+	buf, err := t.readPiece(st, index)
+	if errors.Is(err, fs.ErrNotExist) || errors.Is(err, io.EOF) {
+		return PieceMissing, nil
+	}
+	if err != nil {
+		return PieceMissing, err
+	}
+	if !t.VerifyPiece(index, buf) {
+		return PieceCorrupt, nil
+	}
+	return PieceOK, nil
+}
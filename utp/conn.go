@@ -0,0 +1,543 @@
+package utp
+
+import (
+	"io"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	maxPayload     = 1200           // Максимальный размер данных в одном пакете
+	recvWindow     = 1 << 20        // Размер приемного буфера
+	minWindow      = 2 * maxPayload // Минимальное окно перегрузки
+	targetDelay    = 100000         // Целевая задержка LEDBAT в микросекундах
+	maxWindowGain  = 3000           // Максимальный рост окна за RTT в байтах
+	minRTO         = 500 * time.Millisecond
+	maxResends     = 8               // Количество повторных отправок, после которого соединение разрывается
+	baseDelayReset = 2 * time.Minute // Период обновления базовой задержки
+	closeTimeout   = 5 * time.Second // Ожидание подтверждения FIN при закрытии
+	tickInterval   = 50 * time.Millisecond
+)
+
+// Состояния соединения
+const (
+	stateConnecting = iota
+	stateConnected
+	stateFinSent
+	stateClosed
+)
+
+// Отправленный, но еще не подтвержденный пакет
+type outPacket struct {
+	seq     uint16
+	typ     uint8
+	payload []byte
+	sentAt  time.Time
+	resends int
+	acked   bool
+}
+
+// Соединение uTP, реализующее net.Conn
+type Conn struct {
+	sock   *socket
+	raddr  net.Addr
+	recvID uint16
+	sendID uint16
+
+	mu    sync.Mutex
+	state int
+	err   error // Причина разрыва соединения
+
+	seq      uint16 // Номер следующего пакета с данными
+	ackNr    uint16 // Последний пакет, полученный по порядку
+	lastAck  uint16 // Последнее подтверждение от пира
+	dupAcks  int
+	inflight []*outPacket
+	inBytes  int // Объем неподтвержденных данных
+
+	cwnd       float64 // Окно перегрузки LEDBAT
+	peerWnd    uint32
+	baseDelay  uint32
+	baseReset  time.Time
+	replyMicro uint32 // Задержка последнего пакета пира, отправляется в timestampDiff
+	rtt        time.Duration
+	rttVar     time.Duration
+	rto        time.Duration
+
+	recvBuf []byte
+	ooo     map[uint16][]byte // Пакеты, пришедшие не по порядку
+	finSeq  uint16
+	gotFin  bool
+	eof     bool
+
+	readDeadline  time.Time
+	writeDeadline time.Time
+	readNotify    chan struct{}
+	writeNotify   chan struct{}
+	connected     chan struct{}
+	done          chan struct{}
+	closeOnce     sync.Once
+}
+
+// Инициализатор соединения
+func newConn(s *socket, raddr net.Addr, recvID, sendID uint16) *Conn {
+	c := &Conn{
+		sock:        s,
+		raddr:       raddr,
+		recvID:      recvID,
+		sendID:      sendID,
+		seq:         1,
+		cwnd:        minWindow,
+		peerWnd:     recvWindow,
+		rto:         time.Second,
+		baseReset:   time.Now(),
+		ooo:         make(map[uint16][]byte),
+		readNotify:  make(chan struct{}, 1),
+		writeNotify: make(chan struct{}, 1),
+		connected:   make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	if s.accept != nil { // Принимающая сторона выбирает случайный начальный номер
+		c.seq = randomID()
+	}
+	go c.timerLoop()
+	return c
+}
+
+// Неблокирующее оповещение ожидающих горутин
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// Свободное место в приемном буфере
+func (c *Conn) window() uint32 {
+	if len(c.recvBuf) >= recvWindow {
+		return 0
+	}
+	return uint32(recvWindow - len(c.recvBuf))
+}
+
+// Отправка пакета пиру. Вызывается под мьютексом
+func (c *Conn) send(typ uint8, seq uint16, payload []byte, sack []byte) {
+	h := header{
+		typ:           typ,
+		connID:        c.sendID,
+		timestamp:     now(),
+		timestampDiff: c.replyMicro,
+		wnd:           c.window(),
+		seq:           seq,
+		ack:           c.ackNr,
+		sack:          sack,
+	}
+	if typ == stSyn {
+		h.connID = c.recvID
+	}
+	c.sock.pc.WriteTo(h.marshal(payload), c.raddr)
+}
+
+// Отправка пакета, требующего подтверждения. Вызывается под мьютексом
+func (c *Conn) sendData(typ uint8, payload []byte) {
+	p := &outPacket{seq: c.seq, typ: typ, payload: payload, sentAt: time.Now()}
+	c.seq++
+	c.inflight = append(c.inflight, p)
+	c.inBytes += len(payload)
+	c.send(typ, p.seq, payload, nil)
+}
+
+// Отправка подтверждения с выборочной маской. Вызывается под мьютексом
+func (c *Conn) sendState() {
+	var sack []byte
+	if len(c.ooo) > 0 {
+		sack = make([]byte, 4)
+		for seq := range c.ooo {
+			bit := int(seq - c.ackNr - 2)
+			if bit < 0 || bit >= 8*32 {
+				continue
+			}
+			for bit >= len(sack)*8 {
+				sack = append(sack, 0, 0, 0, 0) // Маска кратна 4 байтам
+			}
+			sack[bit/8] |= 1 << (bit % 8)
+		}
+	}
+	c.send(stState, c.seq, nil, sack)
+}
+
+// Обработка входящего пакета
+func (c *Conn) handle(h header, payload []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.state == stateClosed {
+		return
+	}
+	if h.typ == stReset {
+		c.fail(syscall.ECONNRESET)
+		return
+	}
+
+	c.replyMicro = now() - h.timestamp
+	c.peerWnd = h.wnd
+
+	if c.state == stateConnecting && h.typ == stState {
+		c.ackNr = h.seq - 1 // Данные пира начнутся с его текущего номера
+		c.state = stateConnected
+		close(c.connected)
+	}
+
+	c.processAck(h)
+
+	switch h.typ {
+	case stData:
+		c.receive(h.seq, payload)
+		c.sendState()
+	case stFin:
+		c.gotFin = true
+		c.finSeq = h.seq
+		c.receive(h.seq, nil)
+		c.sendState()
+	}
+
+	if c.state == stateFinSent && len(c.inflight) == 0 {
+		c.fail(net.ErrClosed)
+	}
+}
+
+// Прием пакета с данными с учетом порядка. Вызывается под мьютексом
+func (c *Conn) receive(seq uint16, payload []byte) {
+	switch {
+	case seq == c.ackNr+1:
+		c.deliver(seq, payload)
+		for { // Доставка накопленных пакетов, ставших последовательными
+			next, ok := c.ooo[c.ackNr+1]
+			if !ok {
+				break
+			}
+			delete(c.ooo, c.ackNr+1)
+			c.deliver(c.ackNr+1, next)
+		}
+		notify(c.readNotify)
+	case seqLess(c.ackNr, seq) && seq-c.ackNr < 8*32:
+		c.ooo[seq] = payload
+	}
+}
+
+// Доставка пакета в буфер чтения. Вызывается под мьютексом
+func (c *Conn) deliver(seq uint16, payload []byte) {
+	c.ackNr = seq
+	if c.gotFin && seq == c.finSeq {
+		c.eof = true
+		return
+	}
+	c.recvBuf = append(c.recvBuf, payload...)
+}
+
+// Обработка подтверждений пира. Вызывается под мьютексом
+func (c *Conn) processAck(h header) {
+	if len(c.inflight) == 0 {
+		c.lastAck = h.ack
+		return
+	}
+
+	ackedBytes := 0
+	now := time.Now()
+	for _, p := range c.inflight {
+		if p.acked {
+			continue
+		}
+		acked := !seqLess(h.ack, p.seq) // Накопительное подтверждение
+		if !acked && len(h.sack) > 0 {
+			bit := int(p.seq - h.ack - 2)
+			acked = bit >= 0 && bit < len(h.sack)*8 && h.sack[bit/8]&(1<<(bit%8)) != 0
+		}
+		if !acked {
+			continue
+		}
+		p.acked = true
+		ackedBytes += len(p.payload)
+		if p.resends == 0 {
+			c.updateRTT(now.Sub(p.sentAt))
+		}
+	}
+
+	remaining := c.inflight[:0] // Удаление подтвержденных пакетов
+	for _, p := range c.inflight {
+		if p.acked {
+			c.inBytes -= len(p.payload)
+			continue
+		}
+		remaining = append(remaining, p)
+	}
+	c.inflight = remaining
+
+	if ackedBytes > 0 {
+		c.dupAcks = 0
+		c.updateWindow(h.timestampDiff, ackedBytes)
+		notify(c.writeNotify)
+	} else if h.typ == stState && h.ack == c.lastAck {
+		c.dupAcks++
+	}
+	c.lastAck = h.ack
+
+	if len(c.inflight) == 0 {
+		return
+	}
+
+	sacked := 0 // Количество пакетов, подтвержденных после первого потерянного
+	for i := 0; i < len(h.sack)*8; i++ {
+		if h.sack[i/8]&(1<<(i%8)) != 0 {
+			sacked++
+		}
+	}
+	if c.dupAcks == 3 || (sacked >= 3 && c.inflight[0].resends == 0) {
+		c.retransmit(c.inflight[0])
+		c.cwnd /= 2 // Быстрая повторная отправка считается потерей
+		if c.cwnd < minWindow {
+			c.cwnd = minWindow
+		}
+	}
+}
+
+// Обновление оценки RTT и таймаута повторной отправки
+func (c *Conn) updateRTT(sample time.Duration) {
+	if c.rtt == 0 {
+		c.rtt = sample
+		c.rttVar = sample / 2
+	} else {
+		delta := c.rtt - sample
+		if delta < 0 {
+			delta = -delta
+		}
+		c.rttVar += (delta - c.rttVar) / 4
+		c.rtt += (sample - c.rtt) / 8
+	}
+	c.rto = c.rtt + 4*c.rttVar
+	if c.rto < minRTO {
+		c.rto = minRTO
+	}
+}
+
+// Изменение окна перегрузки по алгоритму LEDBAT
+func (c *Conn) updateWindow(delaySample uint32, ackedBytes int) {
+	if delaySample == 0 {
+		return // Пир еще не знает задержку наших пакетов
+	}
+	if time.Since(c.baseReset) > baseDelayReset || c.baseDelay == 0 || delaySample < c.baseDelay {
+		c.baseDelay = delaySample
+		c.baseReset = time.Now()
+	}
+
+	ourDelay := float64(delaySample - c.baseDelay)
+	delayFactor := (targetDelay - ourDelay) / targetDelay
+	windowFactor := float64(ackedBytes) / c.cwnd
+	c.cwnd += maxWindowGain * delayFactor * windowFactor
+	if c.cwnd < minWindow {
+		c.cwnd = minWindow
+	}
+}
+
+// Повторная отправка пакета. Вызывается под мьютексом
+func (c *Conn) retransmit(p *outPacket) {
+	p.resends++
+	p.sentAt = time.Now()
+	c.send(p.typ, p.seq, p.payload, nil)
+}
+
+// Проверка таймаутов повторной отправки
+func (c *Conn) timerLoop() {
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-c.sock.closed:
+			c.teardown(net.ErrClosed)
+			return
+		case <-ticker.C:
+		}
+
+		c.mu.Lock()
+		if len(c.inflight) > 0 && time.Since(c.inflight[0].sentAt) > c.rto {
+			p := c.inflight[0]
+			if p.resends >= maxResends {
+				c.fail(os.ErrDeadlineExceeded)
+			} else {
+				c.retransmit(p)
+				c.rto *= 2 // Экспоненциальное увеличение таймаута
+				c.cwnd = minWindow
+			}
+		}
+		c.mu.Unlock()
+	}
+}
+
+// Разрыв соединения с ошибкой. Вызывается под мьютексом
+func (c *Conn) fail(err error) {
+	if c.state == stateClosed {
+		return
+	}
+	c.state = stateClosed
+	c.err = err
+	c.closeOnce.Do(func() { close(c.done) })
+	go c.sock.remove(c)
+}
+
+// Разрыв соединения без мьютекса
+func (c *Conn) teardown(err error) {
+	c.mu.Lock()
+	c.fail(err)
+	c.mu.Unlock()
+}
+
+// Ожидание события с учетом дедлайна
+func (c *Conn) wait(ch chan struct{}, deadline time.Time) error {
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		d := time.Until(deadline)
+		if d <= 0 {
+			return os.ErrDeadlineExceeded
+		}
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case <-ch:
+		return nil
+	case <-c.done:
+		return nil
+	case <-timeout:
+		return os.ErrDeadlineExceeded
+	}
+}
+
+// Считывание данных
+func (c *Conn) Read(p []byte) (int, error) {
+	for {
+		c.mu.Lock()
+		if len(c.recvBuf) > 0 {
+			n := copy(p, c.recvBuf)
+			c.recvBuf = c.recvBuf[n:]
+			if len(c.recvBuf) == 0 {
+				c.recvBuf = nil
+			}
+			c.mu.Unlock()
+			return n, nil
+		}
+		if c.eof {
+			c.mu.Unlock()
+			return 0, io.EOF
+		}
+		if c.state == stateClosed || c.state == stateFinSent {
+			err := c.err
+			if err == nil {
+				err = net.ErrClosed
+			}
+			c.mu.Unlock()
+			return 0, err
+		}
+		deadline := c.readDeadline
+		c.mu.Unlock()
+
+		err := c.wait(c.readNotify, deadline)
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Отправка данных с учетом окна перегрузки
+func (c *Conn) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		c.mu.Lock()
+		if c.state != stateConnected {
+			err := c.err
+			if err == nil {
+				err = net.ErrClosed
+			}
+			c.mu.Unlock()
+			return written, err
+		}
+
+		size := len(p) - written
+		if size > maxPayload {
+			size = maxPayload
+		}
+		limit := int(c.cwnd)
+		if int(c.peerWnd) < limit {
+			limit = int(c.peerWnd)
+		}
+		if c.inBytes == 0 || c.inBytes+size <= limit {
+			payload := append([]byte(nil), p[written:written+size]...)
+			c.sendData(stData, payload)
+			written += size
+			c.mu.Unlock()
+			continue
+		}
+		deadline := c.writeDeadline
+		c.mu.Unlock()
+
+		err := c.wait(c.writeNotify, deadline) // Ожидание подтверждений
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// Закрытие соединения. FIN подтверждается пиром в фоне
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state != stateConnected {
+		c.fail(net.ErrClosed)
+		return nil
+	}
+	c.sendData(stFin, nil)
+	c.state = stateFinSent
+	notify(c.readNotify)
+	time.AfterFunc(closeTimeout, func() { c.teardown(net.ErrClosed) })
+	return nil
+}
+
+// Локальный адрес соединения
+func (c *Conn) LocalAddr() net.Addr {
+	return c.sock.pc.LocalAddr()
+}
+
+// Адрес пира
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.raddr
+}
+
+// Установка дедлайна на чтение и запись
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.SetReadDeadline(t)
+	return c.SetWriteDeadline(t)
+}
+
+// Установка дедлайна на чтение
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.readDeadline = t
+	c.mu.Unlock()
+	notify(c.readNotify) // Ожидающее чтение перечитывает дедлайн
+	return nil
+}
+
+// Установка дедлайна на запись
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.writeDeadline = t
+	c.mu.Unlock()
+	notify(c.writeNotify)
+	return nil
+}
@@ -0,0 +1,107 @@
+package utp
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Типы пакетов uTP
+const (
+	stData  uint8 = 0 // Данные
+	stFin   uint8 = 1 // Завершение соединения
+	stState uint8 = 2 // Подтверждение без данных
+	stReset uint8 = 3 // Принудительный разрыв соединения
+	stSyn   uint8 = 4 // Открытие соединения
+)
+
+const (
+	version         = 1
+	headerSize      = 20
+	extSelectiveAck = 1
+)
+
+// Заголовок пакета uTP
+type header struct {
+	typ           uint8
+	connID        uint16
+	timestamp     uint32 // Время отправки в микросекундах
+	timestampDiff uint32 // Задержка последнего полученного пакета в микросекундах
+	wnd           uint32 // Свободное место в приемном буфере
+	seq           uint16
+	ack           uint16
+	sack          []byte // Битовая маска выборочного подтверждения, начиная с ack+2
+}
+
+// Сериализация пакета
+func (h *header) marshal(payload []byte) []byte {
+	size := headerSize + len(payload)
+	if len(h.sack) > 0 {
+		size += 2 + len(h.sack)
+	}
+	buf := make([]byte, size)
+	buf[0] = h.typ<<4 | version
+	binary.BigEndian.PutUint16(buf[2:4], h.connID)
+	binary.BigEndian.PutUint32(buf[4:8], h.timestamp)
+	binary.BigEndian.PutUint32(buf[8:12], h.timestampDiff)
+	binary.BigEndian.PutUint32(buf[12:16], h.wnd)
+	binary.BigEndian.PutUint16(buf[16:18], h.seq)
+	binary.BigEndian.PutUint16(buf[18:20], h.ack)
+
+	curr := headerSize
+	if len(h.sack) > 0 {
+		buf[1] = extSelectiveAck
+		buf[curr] = 0 // Следующего расширения нет
+		buf[curr+1] = byte(len(h.sack))
+		curr += 2
+		curr += copy(buf[curr:], h.sack)
+	}
+	copy(buf[curr:], payload)
+	return buf
+}
+
+// Разбор пакета на заголовок и данные
+func parsePacket(buf []byte) (header, []byte, error) {
+	if len(buf) < headerSize {
+		return header{}, nil, fmt.Errorf("Packet is too short: %d", len(buf))
+	}
+	if buf[0]&0x0f != version {
+		return header{}, nil, fmt.Errorf("Unsupported uTP version %d", buf[0]&0x0f)
+	}
+	h := header{
+		typ:           buf[0] >> 4,
+		connID:        binary.BigEndian.Uint16(buf[2:4]),
+		timestamp:     binary.BigEndian.Uint32(buf[4:8]),
+		timestampDiff: binary.BigEndian.Uint32(buf[8:12]),
+		wnd:           binary.BigEndian.Uint32(buf[12:16]),
+		seq:           binary.BigEndian.Uint16(buf[16:18]),
+		ack:           binary.BigEndian.Uint16(buf[18:20]),
+	}
+	if h.typ > stSyn {
+		return header{}, nil, fmt.Errorf("Unknown packet type %d", h.typ)
+	}
+
+	ext := buf[1] // Цепочка расширений
+	curr := headerSize
+	for ext != 0 {
+		if curr+2 > len(buf) {
+			return header{}, nil, fmt.Errorf("Truncated extension header")
+		}
+		next, length := buf[curr], int(buf[curr+1])
+		curr += 2
+		if curr+length > len(buf) {
+			return header{}, nil, fmt.Errorf("Truncated extension %d", ext)
+		}
+		if ext == extSelectiveAck {
+			h.sack = buf[curr : curr+length]
+		}
+		curr += length
+		ext = next
+	}
+
+	return h, buf[curr:], nil
+}
+
+// Сравнение порядковых номеров с учетом переполнения
+func seqLess(a, b uint16) bool {
+	return int16(a-b) < 0
+}
@@ -0,0 +1,79 @@
+package utp
+
+import (
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Пакет другого протокола, полученный uTP сокетом
+type packet struct {
+	data []byte
+	addr net.Addr
+}
+
+// Сокет поверх Listener для пакетов, которые не относятся к uTP
+type packetConn struct {
+	sock     *socket
+	mu       sync.Mutex
+	deadline time.Time // Срок чтения (нулевой - без срока)
+	closed   chan struct{}
+	once     sync.Once
+}
+
+func (c *packetConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	c.mu.Lock()
+	deadline := c.deadline
+	c.mu.Unlock()
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case pkt := <-c.sock.other:
+		return copy(p, pkt.data), pkt.addr, nil
+	case <-c.closed:
+		return 0, nil, net.ErrClosed
+	case <-c.sock.closed:
+		return 0, nil, net.ErrClosed
+	case <-timeout:
+		return 0, nil, os.ErrDeadlineExceeded
+	}
+}
+
+func (c *packetConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	select {
+	case <-c.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+	return c.sock.pc.WriteTo(p, addr)
+}
+
+func (c *packetConn) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return nil
+}
+
+func (c *packetConn) LocalAddr() net.Addr {
+	return c.sock.pc.LocalAddr()
+}
+
+func (c *packetConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+func (c *packetConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deadline = t
+	return nil
+}
+
+// Запись в UDP сокет не блокируется, срок записи не используется
+func (c *packetConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
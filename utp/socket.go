@@ -0,0 +1,268 @@
+package utp
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Ключ соединения внутри UDP сокета
+type connKey struct {
+	addr string
+	id   uint16
+}
+
+// UDP сокет, через который идут пакеты одного или нескольких соединений
+type socket struct {
+	pc     net.PacketConn
+	mu     sync.Mutex
+	conns  map[connKey]*Conn
+	accept chan *Conn  // Входящие соединения (nil, если сокет не принимает соединения)
+	other  chan packet // Пакеты других протоколов (nil, если сокет не принимает соединения)
+	owned  bool        // Сокет создан для одного исходящего соединения и закрывается вместе с ним
+	closed chan struct{}
+	once   sync.Once
+}
+
+// Создание сокета и запуск чтения пакетов
+func newSocket(pc net.PacketConn, listen bool) *socket {
+	s := &socket{
+		pc:     pc,
+		conns:  make(map[connKey]*Conn),
+		owned:  !listen,
+		closed: make(chan struct{}),
+	}
+	if listen {
+		s.accept = make(chan *Conn, 16)
+		s.other = make(chan packet, 64)
+	}
+	go s.readLoop()
+	return s
+}
+
+// Чтение пакетов и распределение их по соединениям
+func (s *socket) readLoop() {
+	buf := make([]byte, 65536)
+	for {
+		n, addr, err := s.pc.ReadFrom(buf)
+		if err != nil {
+			s.close()
+			return
+		}
+		h, payload, err := parsePacket(buf[:n])
+		if err != nil { // Посторонние пакеты передаются через PacketConn или игнорируются
+			s.passOther(buf[:n], addr)
+			continue
+		}
+		payload = append([]byte(nil), payload...) // Буфер чтения переиспользуется
+
+		if h.typ == stSyn {
+			s.handleSyn(addr, h)
+			continue
+		}
+
+		s.mu.Lock()
+		c := s.conns[connKey{addr.String(), h.connID}]
+		s.mu.Unlock()
+		if c == nil {
+			if h.typ != stReset {
+				s.sendReset(addr, h)
+			}
+			continue
+		}
+		c.handle(h, payload)
+	}
+}
+
+// Передача пакета другого протокола. Пакеты, которые не успевают
+// прочитать, отбрасываются, как и при переполнении сокета
+func (s *socket) passOther(data []byte, addr net.Addr) {
+	if s.other == nil {
+		return
+	}
+	select {
+	case s.other <- packet{append([]byte(nil), data...), addr}:
+	default:
+	}
+}
+
+// Обработка запроса на открытие соединения
+func (s *socket) handleSyn(addr net.Addr, h header) {
+	key := connKey{addr.String(), h.connID + 1}
+
+	s.mu.Lock()
+	c, exists := s.conns[key]
+	if !exists && s.accept != nil {
+		c = newConn(s, addr, h.connID+1, h.connID)
+		c.ackNr = h.seq
+		c.state = stateConnected
+		close(c.connected)
+		s.conns[key] = c
+	}
+	s.mu.Unlock()
+
+	if c == nil {
+		s.sendReset(addr, h)
+		return
+	}
+
+	c.mu.Lock()
+	c.replyMicro = now() - h.timestamp
+	c.sendState() // Повторный SYN получает повторный ответ
+	c.mu.Unlock()
+
+	if !exists {
+		select {
+		case s.accept <- c:
+		default: // Очередь переполнена, соединение сбрасывается
+			c.teardown(os.ErrDeadlineExceeded)
+		}
+	}
+}
+
+// Отправка RESET на пакет неизвестного соединения
+func (s *socket) sendReset(addr net.Addr, h header) {
+	reset := header{typ: stReset, connID: h.connID, timestamp: now(), ack: h.seq}
+	s.pc.WriteTo(reset.marshal(nil), addr)
+}
+
+// Регистрация нового исходящего соединения
+func (s *socket) register(c *Conn) {
+	s.mu.Lock()
+	s.conns[connKey{c.raddr.String(), c.recvID}] = c
+	s.mu.Unlock()
+}
+
+// Удаление соединения из сокета
+func (s *socket) remove(c *Conn) {
+	s.mu.Lock()
+	delete(s.conns, connKey{c.raddr.String(), c.recvID})
+	empty := len(s.conns) == 0
+	s.mu.Unlock()
+	if s.owned && empty {
+		s.close()
+	}
+}
+
+// Закрытие сокета
+func (s *socket) close() {
+	s.once.Do(func() {
+		close(s.closed)
+		s.pc.Close()
+	})
+}
+
+// Случайный идентификатор соединения
+func randomID() uint16 {
+	var b [2]byte
+	rand.Read(b[:])
+	return binary.BigEndian.Uint16(b[:])
+}
+
+// Время для заголовков пакетов в микросекундах
+var epoch = time.Now()
+
+func now() uint32 {
+	return uint32(time.Since(epoch).Microseconds())
+}
+
+// Установление uTP соединения с адресом addr
+func Dial(addr string, timeout time.Duration) (net.Conn, error) {
+	raddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	pc, err := net.ListenPacket("udp", ":0")
+	if err != nil {
+		return nil, err
+	}
+	return dialOver(newSocket(pc, false), raddr, timeout)
+}
+
+// Установление uTP соединения через переданный UDP сокет (например, через прокси).
+// Сокет закрывается вместе с соединением
+func DialPacketConn(pc net.PacketConn, addr string, timeout time.Duration) (net.Conn, error) {
+	raddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		pc.Close()
+		return nil, err
+	}
+	return dialOver(newSocket(pc, false), raddr, timeout)
+}
+
+// Установление соединения через уже открытый сокет
+func dialOver(s *socket, raddr net.Addr, timeout time.Duration) (net.Conn, error) {
+	recvID := randomID()
+	c := newConn(s, raddr, recvID, recvID+1)
+	s.register(c)
+
+	c.mu.Lock()
+	c.sendData(stSyn, nil) // SYN получает порядковый номер 1
+	c.mu.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-c.connected:
+		return c, nil
+	case <-c.done:
+		return nil, c.err
+	case <-timer.C:
+		c.teardown(os.ErrDeadlineExceeded)
+		return nil, os.ErrDeadlineExceeded
+	}
+}
+
+// Прием входящих uTP соединений
+type Listener struct {
+	sock *socket
+}
+
+// Открытие UDP сокета для приема соединений
+func Listen(addr string) (*Listener, error) {
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &Listener{sock: newSocket(pc, true)}, nil
+}
+
+// Ожидание следующего входящего соединения
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.sock.accept:
+		return c, nil
+	case <-l.sock.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// Исходящее соединение с того же сокета, что и у Listener
+func (l *Listener) Dial(addr string, timeout time.Duration) (net.Conn, error) {
+	raddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return dialOver(l.sock, raddr, timeout)
+}
+
+// Закрытие сокета и всех соединений на нем
+func (l *Listener) Close() error {
+	l.sock.close()
+	return nil
+}
+
+// Адрес, на котором принимаются соединения
+func (l *Listener) Addr() net.Addr {
+	return l.sock.pc.LocalAddr()
+}
+
+// Сокет для пакетов другого протокола на том же порту, например DHT.
+// В него попадают пакеты, которые не разбираются как uTP. Закрытие
+// PacketConn не закрывает Listener
+func (l *Listener) PacketConn() net.PacketConn {
+	return &packetConn{sock: l.sock, closed: make(chan struct{})}
+}
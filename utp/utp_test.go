@@ -0,0 +1,258 @@
+package utp
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Сокет, теряющий и переставляющий исходящие пакеты с данными. Первая
+// отправка каждого 13-го пакета теряется, каждого 7-го - задерживается
+// и приходит после следующих пакетов. Повторные отправки доходят
+type lossyConn struct {
+	net.PacketConn
+	mu      sync.Mutex
+	sent    map[uint16]int // Количество отправок пакета по номеру
+	dropped int
+	resends int
+	sacks   int // Полученные подтверждения с выборочной маской
+	closed  chan struct{}
+	once    sync.Once
+}
+
+func newLossyConn(t *testing.T) *lossyConn {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &lossyConn{PacketConn: pc, sent: make(map[uint16]int), closed: make(chan struct{})}
+}
+
+func (c *lossyConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	h, _, err := parsePacket(p)
+	if err != nil || h.typ != stData {
+		return c.PacketConn.WriteTo(p, addr)
+	}
+	c.mu.Lock()
+	first := c.sent[h.seq] == 0
+	c.sent[h.seq]++
+	if !first {
+		c.resends++
+	}
+	drop := first && h.seq%13 == 0
+	if drop {
+		c.dropped++
+	}
+	c.mu.Unlock()
+
+	switch {
+	case drop:
+		return len(p), nil
+	case first && h.seq%7 == 0:
+		data := append([]byte(nil), p...)
+		time.AfterFunc(30*time.Millisecond, func() { c.PacketConn.WriteTo(data, addr) })
+		return len(p), nil
+	}
+	return c.PacketConn.WriteTo(p, addr)
+}
+
+func (c *lossyConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	n, addr, err := c.PacketConn.ReadFrom(p)
+	if err == nil {
+		h, _, perr := parsePacket(p[:n])
+		if perr == nil && len(h.sack) > 0 {
+			c.mu.Lock()
+			c.sacks++
+			c.mu.Unlock()
+		}
+	}
+	return n, addr, err
+}
+
+func (c *lossyConn) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return c.PacketConn.Close()
+}
+
+// Listener на случайном порту 127.0.0.1
+func newTestListener(t *testing.T) *Listener {
+	t.Helper()
+	l, err := Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+// Прием одного соединения в фоне
+func acceptOne(l *Listener) <-chan net.Conn {
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			close(accepted)
+			return
+		}
+		accepted <- c
+	}()
+	return accepted
+}
+
+func TestDialAccept(t *testing.T) {
+	l := newTestListener(t)
+	accepted := acceptOne(l)
+	client, err := Dial(l.Addr().String(), 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	server, ok := <-accepted
+	if !ok {
+		t.Fatal("Accept failed")
+	}
+	defer server.Close()
+
+	if got, want := server.RemoteAddr().(*net.UDPAddr).Port, client.LocalAddr().(*net.UDPAddr).Port; got != want {
+		t.Errorf("accepted connection from port %d, want %d", got, want)
+	}
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	server.SetDeadline(time.Now().Add(5 * time.Second))
+
+	_, err = client.Write([]byte("ping"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err = io.ReadFull(server, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("server read %q, %v", buf, err)
+	}
+	_, err = server.Write([]byte("pong"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = io.ReadFull(client, buf); err != nil || string(buf) != "pong" {
+		t.Fatalf("client read %q, %v", buf, err)
+	}
+
+	server.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, err = server.Read(buf); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("read past deadline: %v", err)
+	}
+}
+
+func TestDialTimeout(t *testing.T) {
+	silent, err := net.ListenPacket("udp", "127.0.0.1:0") // Никто не отвечает на SYN
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+	_, err = Dial(silent.LocalAddr().String(), 200*time.Millisecond)
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("dial to a silent port: %v", err)
+	}
+}
+
+func TestLossAndReordering(t *testing.T) {
+	l := newTestListener(t)
+	accepted := acceptOne(l)
+	lossy := newLossyConn(t)
+	client, err := DialPacketConn(lossy, l.Addr().String(), 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, ok := <-accepted
+	if !ok {
+		t.Fatal("Accept failed")
+	}
+	defer server.Close()
+
+	data := make([]byte, 300*1024)
+	rand.Read(data)
+	written := make(chan error, 1)
+	go func() {
+		client.SetWriteDeadline(time.Now().Add(20 * time.Second))
+		_, err := client.Write(data)
+		client.Close()
+		written <- err
+	}()
+
+	server.SetReadDeadline(time.Now().Add(20 * time.Second))
+	received, err := io.ReadAll(server) // Чтение до FIN
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = <-written; err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(received, data) {
+		t.Fatalf("received %d bytes differ from %d sent", len(received), len(data))
+	}
+
+	lossy.mu.Lock()
+	dropped, resends, sacks := lossy.dropped, lossy.resends, lossy.sacks
+	lossy.mu.Unlock()
+	if dropped == 0 || resends < dropped {
+		t.Errorf("%d packets dropped, %d resent", dropped, resends)
+	}
+	if sacks == 0 {
+		t.Error("no selective acks for out of order packets")
+	}
+
+	// Подтвержденный FIN освобождает сокет раньше таймаута закрытия
+	select {
+	case <-lossy.closed:
+	case <-time.After(closeTimeout / 2):
+		t.Error("socket not released after FIN")
+	}
+	if _, err = client.Read(make([]byte, 1)); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("read after close: %v", err)
+	}
+	if _, err = client.Write([]byte("x")); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("write after close: %v", err)
+	}
+}
+
+func TestPacketConnDemux(t *testing.T) {
+	l := newTestListener(t)
+	pc := l.PacketConn()
+	defer pc.Close()
+
+	other, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	_, err = other.WriteTo([]byte("d1:y1:qe"), l.Addr()) // Пакет DHT не разбирается как uTP
+	if err != nil {
+		t.Fatal(err)
+	}
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 64)
+	n, addr, err := pc.ReadFrom(buf)
+	if err != nil || string(buf[:n]) != "d1:y1:qe" || addr.String() != other.LocalAddr().String() {
+		t.Fatalf("read %q from %v: %v", buf[:n], addr, err)
+	}
+
+	// Закрытие PacketConn не мешает uTP соединениям
+	pc.Close()
+	if _, _, err = pc.ReadFrom(buf); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("read from closed PacketConn: %v", err)
+	}
+	accepted := acceptOne(l)
+	client, err := Dial(l.Addr().String(), 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+	if _, ok := <-accepted; !ok {
+		t.Error("Accept failed after PacketConn closed")
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"path/filepath"
+)
+
+// Команда verify: проверка скачанных данных по хешам торрента без обращения к сети
+func verify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	dir := fs.String("d", ".", "directory containing the downloaded data")
+	quiet := fs.Bool("q", false, "print nothing, report the result with the exit code only")
+	workers := fs.Int("j", 0, "number of pieces hashed in parallel (default: number of CPUs)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: gotorrent-client verify [flags] file.torrent")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return usageError(fs, "Expected exactly one .torrent file")
+	}
+	if *workers < 0 {
+		return usageError(fs, "Invalid number of workers %d", *workers)
+	}
+
+	tf, err := openTorrent(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(*dir, tf.Name)
+	report, err := tf.Verify(context.Background(), path, *workers)
+	if err != nil {
+		return err
+	}
+
+	bad := report.BadPieces()
+	if !*quiet {
+		fmt.Printf("%s: %d of %d pieces OK\n", path, tf.NumPieces()-len(bad), tf.NumPieces())
+		for _, index := range bad {
+			fmt.Printf("Piece %d is %s\n", index, report.Pieces[index])
+		}
+		for _, f := range report.Files {
+			switch {
+			case f.Missing():
+				fmt.Printf("File %s is missing\n", f.Path)
+			case f.Size != int64(f.Length):
+				fmt.Printf("File %s has %d bytes instead of %d\n", f.Path, f.Size, f.Length)
+			case len(f.BadPieces) > 0:
+				fmt.Printf("File %s has %d bad pieces\n", f.Path, len(f.BadPieces))
+			}
+		}
+	}
+	if len(bad) == 0 && !report.OK() { // Части целы, но у файлов лишние байты
+		return &exitCodeError{exitMismatch, fmt.Errorf("File sizes do not match the torrent")}
+	}
+	if len(bad) > 0 {
+		return &exitCodeError{exitMismatch, fmt.Errorf("%d pieces failed verification", len(bad))}
+	}
+	return nil
+}